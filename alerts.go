@@ -0,0 +1,157 @@
+package infinias
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/notify"
+)
+
+// Alert conditions
+const (
+	// AlertRetryExhausted is sent when webhook delivery or the service itself gives up retrying
+	AlertRetryExhausted = "retry_exhausted"
+	// AlertSyncFailed is sent when a directory sync fails or has errors
+	AlertSyncFailed = "sync_failed"
+	// AlertCredentialConflict is sent when a credential is rejected because it belongs to another person
+	AlertCredentialConflict = "credential_conflict"
+	// AlertDoorEvent is sent for access events matching a rule's EventTypes, e.g. door forced open
+	AlertDoorEvent = "door_event"
+	// AlertAuthLockout is sent when a source IP is banned after repeated authorization failures
+	AlertAuthLockout = "auth_lockout"
+)
+
+const DefaultAlertTimeout = 30 * time.Second
+
+// AlertRule sends alerts for the given conditions with Notifier.
+// For AlertDoorEvent, EventTypes lists the event types (case-insensitive) that trigger the alert
+type AlertRule struct {
+	Notifier   notify.Notifier
+	Conditions []string
+	EventTypes []string
+}
+
+func (r *AlertRule) wants(condition string) bool {
+	for _, c := range r.Conditions {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *AlertRule) wantsEvent(typ string) bool {
+	for _, t := range r.EventTypes {
+		if strings.EqualFold(t, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+// Alerts sends notifications for configured conditions
+type Alerts struct {
+	Rules   []*AlertRule
+	Timeout time.Duration
+	Log     Logger
+}
+
+// NewAlerts returns a new Alerts
+func NewAlerts(rules []*AlertRule, log Logger) *Alerts {
+	if log == nil {
+		log = NopLogger
+	}
+	return &Alerts{Rules: rules, Timeout: DefaultAlertTimeout, Log: log}
+}
+
+// Send asynchronously sends m to every rule interested in condition
+func (a *Alerts) Send(condition string, m *notify.Message) {
+	if a == nil {
+		return
+	}
+	for _, r := range a.Rules {
+		if r.wants(condition) {
+			go a.send(r, condition, m)
+		}
+	}
+}
+
+// SendSync sends m to every rule interested in condition and waits for them to finish
+func (a *Alerts) SendSync(condition string, m *notify.Message) {
+	if a == nil {
+		return
+	}
+	for _, r := range a.Rules {
+		if r.wants(condition) {
+			a.send(r, condition, m)
+		}
+	}
+}
+
+func (a *Alerts) send(r *AlertRule, condition string, m *notify.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.Timeout)
+	defer cancel()
+	if err := r.Notifier.Notify(ctx, m); err != nil {
+		a.Log.Warn("could not send alert", "condition", condition, "title", m.Title, "error", err)
+	}
+}
+
+// WatchEvents sends AlertDoorEvent alerts for events from stream until stop is called.
+// It does nothing if no rule has EventTypes
+func (a *Alerts) WatchEvents(stream *EventStream) (stop func()) {
+	if a == nil {
+		return func() {}
+	}
+	watching := false
+	for _, r := range a.Rules {
+		if r.wants(AlertDoorEvent) && len(r.EventTypes) > 0 {
+			watching = true
+		}
+	}
+	if !watching || stream == nil {
+		return func() {}
+	}
+
+	ch, unsubscribe := stream.Subscribe()
+	go func() {
+		for e := range ch {
+			a.sendEvent(e)
+		}
+	}()
+
+	return unsubscribe
+}
+
+func (a *Alerts) sendEvent(e *Event) {
+	m := &notify.Message{
+		Title: "Access event: " + e.Type,
+		Text:  e.Description,
+		Fields: map[string]string{
+			"event_id": strconv.FormatInt(e.ID, 10),
+			"time":     e.Time.Format(time.RFC3339),
+		},
+	}
+	if e.Door != "" {
+		m.Fields["door"] = e.Door
+	}
+	if e.PersonID != 0 {
+		m.Fields["person_id"] = strconv.Itoa(e.PersonID)
+	}
+
+	for _, r := range a.Rules {
+		if r.wants(AlertDoorEvent) && r.wantsEvent(e.Type) {
+			go a.send(r, AlertDoorEvent, m)
+		}
+	}
+}
+
+func (s *Service) alert(condition, title, format string, args ...interface{}) {
+	s.alertFields(condition, title, nil, format, args...)
+}
+
+func (s *Service) alertFields(condition, title string, fields map[string]string, format string, args ...interface{}) {
+	s.Alerts.Send(condition, &notify.Message{Title: title, Text: fmt.Sprintf(format, args...), Fields: fields})
+}
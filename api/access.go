@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// AccessPrivilege grants the members of a group access to a door during a schedule
+type AccessPrivilege struct {
+	ID         int
+	GroupID    int
+	Group      string
+	DoorID     int
+	Door       string
+	ScheduleID int
+	Schedule   string
+}
+
+// ListAccessPrivileges returns the access privilege assignments of all groups
+func (c *Conn) ListAccessPrivileges() ([]*AccessPrivilege, error) {
+	type data struct {
+		Count int `json:"Count"`
+		Items []*struct {
+			ID           int    `json:"Id"`
+			GroupID      int    `json:"GroupId"`
+			GroupName    string `json:"GroupName"`
+			DoorID       int    `json:"DoorId"`
+			DoorName     string `json:"DoorName"`
+			ScheduleID   int    `json:"ScheduleId"`
+			ScheduleName string `json:"ScheduleName"`
+		} `json:"Items"`
+	}
+
+	u := c.url()
+	u.Path += "/infinias/ia/accessprivileges"
+	q := u.Query()
+	q.Set(formKeyUsername, c.username)
+	q.Set(formKeyPassword, c.password)
+
+	var privileges []*AccessPrivilege
+	total := 1
+	count := 0
+	for count < total {
+		q.Set("Start", strconv.Itoa(count))
+		u.RawQuery = q.Encode()
+
+		r, err := c.get(u.String())
+		if err != nil {
+			return nil, fmt.Errorf("could not GET access privileges: %w", err)
+		}
+		defer r.Body.Close()
+
+		resp := new(Response)
+		d := new(data)
+		resp.Data = d
+		if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+			return nil, fmt.Errorf("could not decode response body: %w", err)
+		}
+
+		if err = resp.Error(); err != nil {
+			return nil, err
+		}
+
+		for _, p := range d.Items {
+			privileges = append(privileges, &AccessPrivilege{
+				ID:         p.ID,
+				GroupID:    p.GroupID,
+				Group:      p.GroupName,
+				DoorID:     p.DoorID,
+				Door:       p.DoorName,
+				ScheduleID: p.ScheduleID,
+				Schedule:   p.ScheduleName,
+			})
+		}
+
+		total = d.Count
+		count = len(privileges)
+	}
+
+	return privileges, nil
+}
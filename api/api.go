@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -22,30 +23,41 @@ const (
 	formKeySiteCode      = "badgeInfo.SiteCode"
 	formKeyCardIssueCode = "badgeInfo.CardIssueCode"
 	formKeyAddGroups     = "groupInfo.AddGroups"
+	formKeyRemoveGroups  = "groupInfo.RemoveGroups"
+	formKeyName          = "Name"
+	formKeyDescription   = "Description"
 )
 
-var cardRegexp = regexp.MustCompile(`^(\d+)-(\d+)$`)
+// cardRegexp matches card numbers as site-card, or as only a card number for formats without a site code
+var cardRegexp = regexp.MustCompile(`^(?:(\d+)-)?(\d+)$`)
 
 type Person struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	EmployeeID  string
-	Department  string
-	SiteCode    int
-	CardCode    int
-	GroupsToAdd []int
+	ID             int
+	FirstName      string
+	LastName       string
+	EmployeeID     string
+	Department     string
+	SiteCode       int
+	CardCode       int
+	Groups         []*Group
+	GroupsToAdd    []int
+	GroupsToRemove []int
 }
 
 type Group struct {
-	ID   int
-	Name string
+	ID          int
+	Name        string
+	Description string
 }
 
 type Conn struct {
+	// Client is used to make requests. If nil, http.DefaultClient is used
+	Client *http.Client
+
 	urlPrefix *url.URL
 	username  string
 	password  string
+	ctx       context.Context
 }
 
 func (c *Conn) url() *url.URL {
@@ -53,6 +65,53 @@ func (c *Conn) url() *url.URL {
 	return &u
 }
 
+// WithContext returns a shallow copy of c that makes requests with ctx
+func (c *Conn) WithContext(ctx context.Context) *Conn {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+func (c *Conn) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *Conn) do(req *http.Request) (*http.Response, error) {
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req.WithContext(c.context()))
+}
+
+func (c *Conn) get(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
+func (c *Conn) postForm(u string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+func joinInts(ints []int) string {
+	strs := make([]string, len(ints))
+	for idx, i := range ints {
+		strs[idx] = strconv.Itoa(i)
+	}
+	return strings.Join(strs, ",")
+}
+
 func NewConn(urlPrefix, username, password string) (*Conn, error) {
 	u, err := url.Parse(urlPrefix)
 	if err != nil {
@@ -108,14 +167,10 @@ func (c *Conn) CreatePerson(p *Person) (id int, err error) {
 		form.Set(formKeyCardIssueCode, strconv.Itoa(p.CardCode))
 	}
 	if len(p.GroupsToAdd) > 0 {
-		groups := make([]string, len(p.GroupsToAdd))
-		for idx, g := range p.GroupsToAdd {
-			groups[idx] = strconv.Itoa(g)
-		}
-		form.Set(formKeyAddGroups, strings.Join(groups, ","))
+		form.Set(formKeyAddGroups, joinInts(p.GroupsToAdd))
 	}
 
-	r, err := http.PostForm(u.String(), form)
+	r, err := c.postForm(u.String(), form)
 	if err != nil {
 		return 0, fmt.Errorf("could not POST person: %w", err)
 	}
@@ -135,6 +190,14 @@ func (c *Conn) CreatePerson(p *Person) (id int, err error) {
 	return resp.ID, nil
 }
 
+// parseCode parses a site or card code. Empty codes, e.g. the site code of a credential format without one, are 0
+func parseCode(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
 func (c *Conn) ReadPerson(id int) (*Person, error) {
 	type data struct {
 		ID           int `json:"Id"`
@@ -148,6 +211,12 @@ func (c *Conn) ReadPerson(id int) (*Person, error) {
 			SiteCode string `json:"SiteCode"`
 			CardCode string `json:"CardIssueCode"`
 		} `json:"BadgeInfo"`
+		GroupInfo struct {
+			Groups []*struct {
+				ID   int    `json:"Id"`
+				Name string `json:"Name"`
+			} `json:"Groups"`
+		} `json:"GroupInfo"`
 	}
 
 	u := c.url()
@@ -158,7 +227,7 @@ func (c *Conn) ReadPerson(id int) (*Person, error) {
 	q.Set(formKeyID, strconv.Itoa(id))
 	u.RawQuery = q.Encode()
 
-	r, err := http.Get(u.String())
+	r, err := c.get(u.String())
 	if err != nil {
 		return nil, fmt.Errorf("could not GET person: %w", err)
 	}
@@ -183,15 +252,20 @@ func (c *Conn) ReadPerson(id int) (*Person, error) {
 		return nil, fmt.Errorf("could not decode response body: %w", err)
 	}
 
-	sc, err := strconv.Atoi(resp.BadgeInfo.SiteCode)
+	sc, err := parseCode(resp.BadgeInfo.SiteCode)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse site code: %w", err)
 	}
-	cc, err := strconv.Atoi(resp.BadgeInfo.CardCode)
+	cc, err := parseCode(resp.BadgeInfo.CardCode)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse card code: %w", err)
 	}
 
+	groups := make([]*Group, len(resp.GroupInfo.Groups))
+	for idx, g := range resp.GroupInfo.Groups {
+		groups[idx] = &Group{ID: g.ID, Name: g.Name}
+	}
+
 	return &Person{
 		ID:         resp.ID,
 		FirstName:  resp.PersonalInfo.FirstName,
@@ -200,6 +274,7 @@ func (c *Conn) ReadPerson(id int) (*Person, error) {
 		Department: resp.PersonalInfo.Department,
 		SiteCode:   sc,
 		CardCode:   cc,
+		Groups:     groups,
 	}, nil
 }
 
@@ -230,11 +305,10 @@ func (c *Conn) UpdatePerson(p *Person) error {
 		form.Set(formKeyCardIssueCode, strconv.Itoa(p.CardCode))
 	}
 	if len(p.GroupsToAdd) > 0 {
-		groups := make([]string, len(p.GroupsToAdd))
-		for idx, g := range p.GroupsToAdd {
-			groups[idx] = strconv.Itoa(g)
-		}
-		form.Set(formKeyAddGroups, strings.Join(groups, ","))
+		form.Set(formKeyAddGroups, joinInts(p.GroupsToAdd))
+	}
+	if len(p.GroupsToRemove) > 0 {
+		form.Set(formKeyRemoveGroups, joinInts(p.GroupsToRemove))
 	}
 
 	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewBufferString(form.Encode()))
@@ -243,7 +317,7 @@ func (c *Conn) UpdatePerson(p *Person) error {
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	r, err := http.DefaultClient.Do(req)
+	r, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("could not PUT person: %w", err)
 	}
@@ -275,7 +349,7 @@ func (c *Conn) DeletePerson(id int) error {
 		return fmt.Errorf("could not create DELETE request: %w", err)
 	}
 
-	r, err := http.DefaultClient.Do(req)
+	r, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("could not DELETE person: %w", err)
 	}
@@ -319,7 +393,7 @@ func (c *Conn) ListPeople() ([]*Person, error) {
 		q.Set("Start", strconv.Itoa(count))
 		u.RawQuery = q.Encode()
 
-		r, err := http.Get(u.String())
+		r, err := c.get(u.String())
 		if err != nil {
 			return nil, fmt.Errorf("could not GET people: %w", err)
 		}
@@ -369,8 +443,9 @@ func (c *Conn) ListGroups() ([]*Group, error) {
 	type data struct {
 		Count int `json:"Count"`
 		Items []*struct {
-			ID   int    `json:"Id"`
-			Name string `json:"Name"`
+			ID          int    `json:"Id"`
+			Name        string `json:"Name"`
+			Description string `json:"Description"`
 		} `json:"Items"`
 	}
 
@@ -387,7 +462,7 @@ func (c *Conn) ListGroups() ([]*Group, error) {
 		q.Set("Start", strconv.Itoa(count))
 		u.RawQuery = q.Encode()
 
-		r, err := http.Get(u.String())
+		r, err := c.get(u.String())
 		if err != nil {
 			return nil, fmt.Errorf("could not GET groups: %w", err)
 		}
@@ -406,8 +481,9 @@ func (c *Conn) ListGroups() ([]*Group, error) {
 
 		for _, p := range d.Items {
 			groups = append(groups, &Group{
-				ID:   p.ID,
-				Name: p.Name,
+				ID:          p.ID,
+				Name:        p.Name,
+				Description: p.Description,
 			})
 		}
 
@@ -417,3 +493,106 @@ func (c *Conn) ListGroups() ([]*Group, error) {
 
 	return groups, nil
 }
+
+func (c *Conn) CreateGroup(g *Group) (id int, err error) {
+	u := c.url()
+	u.Path += "/infinias/ia/groups"
+
+	form := make(url.Values)
+	form.Set(formKeyUsername, c.username)
+	form.Set(formKeyPassword, c.password)
+	form.Set(formKeyName, g.Name)
+	if g.Description != "" {
+		form.Set(formKeyDescription, g.Description)
+	}
+
+	r, err := c.postForm(u.String(), form)
+	if err != nil {
+		return 0, fmt.Errorf("could not POST group: %w", err)
+	}
+	defer r.Body.Close()
+
+	resp := new(Response)
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return 0, fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	if err = resp.Error(); err != nil {
+		return 0, err
+	}
+
+	g.ID = resp.ID
+
+	return resp.ID, nil
+}
+
+func (c *Conn) UpdateGroup(g *Group) error {
+	u := c.url()
+	u.Path += "/infinias/ia/groups"
+
+	form := make(url.Values)
+	form.Set(formKeyUsername, c.username)
+	form.Set(formKeyPassword, c.password)
+	form.Set(formKeyID, strconv.Itoa(g.ID))
+	if g.Name != "" {
+		form.Set(formKeyName, g.Name)
+	}
+	if g.Description != "" {
+		form.Set(formKeyDescription, g.Description)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("could not create PUT request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	r, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("could not PUT group: %w", err)
+	}
+	defer r.Body.Close()
+
+	resp := new(Response)
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	if err = resp.Error(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Conn) DeleteGroup(id int) error {
+	u := c.url()
+	u.Path += "/infinias/ia/groups"
+	q := u.Query()
+	q.Set(formKeyUsername, c.username)
+	q.Set(formKeyPassword, c.password)
+	q.Set(formKeyID, strconv.Itoa(id))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("could not create DELETE request: %w", err)
+	}
+
+	r, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("could not DELETE group: %w", err)
+	}
+	defer r.Body.Close()
+
+	resp := new(Response)
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	if err = resp.Error(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,603 @@
+// Package apitest provides a fake Infinias API server for testing.
+// It implements the people and groups endpoints used by api.Conn with in-memory state, and can inject errors
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/api"
+)
+
+const (
+	DefaultUsername = "admin"
+	DefaultPassword = "password"
+	DefaultPageSize = 50
+
+	pathPeople        = "/infinias/ia/people"
+	pathPersonDetails = "/infinias/ia/people/details"
+	pathGroups        = "/infinias/ia/groups"
+
+	msgNotFound    = "NotFound"
+	msgBadgeExists = "Badge credential could not be created because it already exists"
+)
+
+// Fault is an error injected into matching requests
+type Fault struct {
+	// Method and Path match requests, e.g. http.MethodPut and "/infinias/ia/people". Empty values match all requests
+	Method string
+	Path   string
+	// StatusCode is the response status. Defaults to http.StatusOK, which is how Infinias reports most errors
+	StatusCode int
+	// Errors are returned in the response body. If empty, the response is unsuccessful with no errors
+	Errors api.Errors
+	// Delay is how long to wait before responding. If StatusCode is 0 and Errors is empty, the request is handled normally after Delay
+	Delay time.Duration
+	// Times is how many requests fail before the fault is removed. If 0, the fault stays until ClearFaults is called
+	Times int
+}
+
+func (f *Fault) matches(r *http.Request) bool {
+	return (f.Method == "" || f.Method == r.Method) && (f.Path == "" || f.Path == r.URL.Path)
+}
+
+// Server is a fake Infinias API server
+type Server struct {
+	*httptest.Server
+	Username string
+	Password string
+	PageSize int
+
+	mu       sync.Mutex
+	people   map[int]*api.Person
+	groups   map[int]*api.Group
+	nextID   int
+	faults   []*Fault
+	requests map[string]int
+}
+
+// NewServer returns a new, started Server using DefaultUsername and DefaultPassword. The caller should call Close when finished
+func NewServer() *Server {
+	s := &Server{
+		Username: DefaultUsername,
+		Password: DefaultPassword,
+		PageSize: DefaultPageSize,
+		people:   make(map[int]*api.Person),
+		groups:   make(map[int]*api.Group),
+		nextID:   1,
+		requests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Conn returns an api.Conn for s
+func (s *Server) Conn() *api.Conn {
+	conn, err := api.NewConn(s.URL, s.Username, s.Password)
+	if err != nil {
+		panic(fmt.Errorf("could not create conn: %w", err))
+	}
+	conn.Client = s.Client()
+	return conn
+}
+
+// AddPerson adds p, returning its new id. p.GroupsToAdd are added to the person's groups
+func (s *Server) AddPerson(p *api.Person) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p2 := &api.Person{FirstName: p.FirstName, LastName: p.LastName, EmployeeID: p.EmployeeID, Department: p.Department, SiteCode: p.SiteCode, CardCode: p.CardCode}
+	p2.ID = s.newID()
+	s.addGroups(p2, p.GroupsToAdd)
+	s.people[p2.ID] = p2
+	return p2.ID
+}
+
+// AddGroup adds g, returning its new id
+func (s *Server) AddGroup(g *api.Group) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g2 := &api.Group{ID: s.newID(), Name: g.Name, Description: g.Description}
+	s.groups[g2.ID] = g2
+	return g2.ID
+}
+
+// Person returns a copy of the person with the given id, or nil if it doesn't exist
+func (s *Server) Person(id int) *api.Person {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.people[id]
+	if !ok {
+		return nil
+	}
+	return copyPerson(p)
+}
+
+// People returns copies of all people, sorted by id
+func (s *Server) People() []*api.Person {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	people := make([]*api.Person, 0, len(s.people))
+	for _, p := range s.people {
+		people = append(people, copyPerson(p))
+	}
+	sort.Slice(people, func(i, j int) bool { return people[i].ID < people[j].ID })
+	return people
+}
+
+// Groups returns copies of all groups, sorted by id
+func (s *Server) Groups() []*api.Group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := make([]*api.Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		g2 := *g
+		groups = append(groups, &g2)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups
+}
+
+// Inject adds a fault. Faults are checked in the order they were added
+func (s *Server) Inject(f *Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f2 := *f
+	s.faults = append(s.faults, &f2)
+}
+
+// ClearFaults removes all faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the number of requests received with the given method and path
+func (s *Server) Requests(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method+" "+path]
+}
+
+func copyPerson(p *api.Person) *api.Person {
+	p2 := *p
+	p2.Groups = make([]*api.Group, len(p.Groups))
+	for idx, g := range p.Groups {
+		g2 := *g
+		p2.Groups[idx] = &g2
+	}
+	p2.GroupsToAdd, p2.GroupsToRemove = nil, nil
+	return &p2
+}
+
+// newID returns a new id. The caller must hold s.mu
+func (s *Server) newID() int {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+// addGroups adds the groups with the given ids to p, ignoring unknown or existing groups. The caller must hold s.mu
+func (s *Server) addGroups(p *api.Person, ids []int) {
+	for _, id := range ids {
+		g, ok := s.groups[id]
+		if !ok {
+			continue
+		}
+		exists := false
+		for _, pg := range p.Groups {
+			if pg.ID == id {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			p.Groups = append(p.Groups, &api.Group{ID: g.ID, Name: g.Name})
+		}
+	}
+}
+
+// badgeExists returns true if another person has the given badge. The caller must hold s.mu
+func (s *Server) badgeExists(id, site, card int) bool {
+	if site == 0 && card == 0 {
+		return false
+	}
+	for _, p := range s.people {
+		if p.ID != id && p.SiteCode == site && p.CardCode == card {
+			return true
+		}
+	}
+	return false
+}
+
+// fault returns the first fault matching r, removing it if it's used up. The caller must hold s.mu
+func (s *Server) fault(r *http.Request) *Fault {
+	for idx, f := range s.faults {
+		if !f.matches(r) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:idx:idx], s.faults[idx+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+type response struct {
+	Success bool        `json:"success"`
+	ID      int         `json:"RecordId,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Errors  api.Errors  `json:"errors,omitempty"`
+}
+
+type page struct {
+	Count int         `json:"Count"`
+	Items interface{} `json:"Items"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, &response{Errors: api.Errors{&api.Error{Msg: msg}}})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[r.Method+" "+r.URL.Path]++
+	f := s.fault(r)
+	s.mu.Unlock()
+
+	if f != nil {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.StatusCode != 0 || len(f.Errors) > 0 || f.Delay == 0 {
+			code := f.StatusCode
+			if code == 0 {
+				code = http.StatusOK
+			}
+			writeJSON(w, code, &response{Errors: f.Errors})
+			return
+		}
+	}
+
+	if r.Form.Get("username") != s.Username || r.Form.Get("password") != s.Password {
+		writeError(w, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path + " " + r.Method {
+	case pathPeople + " " + http.MethodGet:
+		s.listPeople(w, r)
+	case pathPeople + " " + http.MethodPost:
+		s.createPerson(w, r)
+	case pathPeople + " " + http.MethodPut:
+		s.updatePerson(w, r)
+	case pathPeople + " " + http.MethodDelete:
+		s.deletePerson(w, r)
+	case pathPersonDetails + " " + http.MethodGet:
+		s.readPerson(w, r)
+	case pathGroups + " " + http.MethodGet:
+		s.listGroups(w, r)
+	case pathGroups + " " + http.MethodPost:
+		s.createGroup(w, r)
+	case pathGroups + " " + http.MethodPut:
+		s.updateGroup(w, r)
+	case pathGroups + " " + http.MethodDelete:
+		s.deleteGroup(w, r)
+	default:
+		writeError(w, http.StatusNotFound, msgNotFound)
+	}
+}
+
+func formInt(r *http.Request, key string) (int, error) {
+	str := r.Form.Get(key)
+	if str == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", key, str)
+	}
+	return i, nil
+}
+
+func formInts(r *http.Request, key string) ([]int, error) {
+	str := r.Form.Get(key)
+	if str == "" {
+		return nil, nil
+	}
+	var ints []int
+	for _, s := range strings.Split(str, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", key, str)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// readPersonForm updates p with the values in r's form
+func readPersonForm(r *http.Request, p *api.Person) error {
+	for key, dst := range map[string]*string{
+		"personalInfo.FirstName":  &p.FirstName,
+		"personalInfo.LastName":   &p.LastName,
+		"personalInfo.employeeId": &p.EmployeeID,
+		"personalInfo.department": &p.Department,
+	} {
+		if v := r.Form.Get(key); v != "" {
+			*dst = v
+		}
+	}
+
+	for key, dst := range map[string]*int{
+		"badgeInfo.SiteCode":      &p.SiteCode,
+		"badgeInfo.CardIssueCode": &p.CardCode,
+	} {
+		i, err := formInt(r, key)
+		if err != nil {
+			return err
+		}
+		if i != 0 {
+			*dst = i
+		}
+	}
+
+	var err error
+	if p.GroupsToAdd, err = formInts(r, "groupInfo.AddGroups"); err != nil {
+		return err
+	}
+	if p.GroupsToRemove, err = formInts(r, "groupInfo.RemoveGroups"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) paginate(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	start, err := formInt(r, "Start")
+	if err != nil || start < 0 {
+		writeError(w, http.StatusBadRequest, "invalid Start")
+		return
+	}
+	if start > len(items) {
+		start = len(items)
+	}
+	end := len(items)
+	if s.PageSize > 0 && start+s.PageSize < end {
+		end = start + s.PageSize
+	}
+	writeJSON(w, http.StatusOK, &response{Success: true, Data: &page{Count: len(items), Items: items[start:end]}})
+}
+
+func (s *Server) sortedPeople() []*api.Person {
+	people := make([]*api.Person, 0, len(s.people))
+	for _, p := range s.people {
+		people = append(people, p)
+	}
+	sort.Slice(people, func(i, j int) bool { return people[i].ID < people[j].ID })
+	return people
+}
+
+func (s *Server) listPeople(w http.ResponseWriter, r *http.Request) {
+	var items []interface{}
+	for _, p := range s.sortedPeople() {
+		var card string
+		if p.SiteCode != 0 || p.CardCode != 0 {
+			card = fmt.Sprintf("%d-%d", p.SiteCode, p.CardCode)
+		}
+		items = append(items, map[string]interface{}{
+			"Id":         p.ID,
+			"FirstName":  p.FirstName,
+			"LastName":   p.LastName,
+			"EmployeeID": p.EmployeeID,
+			"Department": p.Department,
+			"CardNumber": card,
+		})
+	}
+	s.paginate(w, r, items)
+}
+
+func (s *Server) readPerson(w http.ResponseWriter, r *http.Request) {
+	id, err := formInt(r, "Id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, ok := s.people[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, msgNotFound)
+		return
+	}
+
+	groups := make([]map[string]interface{}, len(p.Groups))
+	for idx, g := range p.Groups {
+		groups[idx] = map[string]interface{}{"Id": g.ID, "Name": g.Name}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"Id": p.ID,
+		"PersonalInfo": map[string]interface{}{
+			"FirstName":  p.FirstName,
+			"LastName":   p.LastName,
+			"EmployeeId": p.EmployeeID,
+			"Department": p.Department,
+		},
+		"BadgeInfo": map[string]interface{}{
+			"SiteCode":      strconv.Itoa(p.SiteCode),
+			"CardIssueCode": strconv.Itoa(p.CardCode),
+		},
+		"GroupInfo": map[string]interface{}{"Groups": groups},
+	})
+}
+
+func (s *Server) createPerson(w http.ResponseWriter, r *http.Request) {
+	p := new(api.Person)
+	if err := readPersonForm(r, p); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.badgeExists(0, p.SiteCode, p.CardCode) {
+		writeError(w, http.StatusOK, msgBadgeExists)
+		return
+	}
+
+	p.ID = s.newID()
+	s.addGroups(p, p.GroupsToAdd)
+	p.GroupsToAdd, p.GroupsToRemove = nil, nil
+	s.people[p.ID] = p
+
+	writeJSON(w, http.StatusOK, &response{Success: true, ID: p.ID})
+}
+
+func (s *Server) updatePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := formInt(r, "Id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	existing, ok := s.people[id]
+	if !ok {
+		writeError(w, http.StatusOK, msgNotFound)
+		return
+	}
+
+	p := copyPerson(existing)
+	if err = readPersonForm(r, p); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if (p.SiteCode != existing.SiteCode || p.CardCode != existing.CardCode) && s.badgeExists(id, p.SiteCode, p.CardCode) {
+		writeError(w, http.StatusOK, "Badge credential could not be updated because it already exists")
+		return
+	}
+
+	s.addGroups(p, p.GroupsToAdd)
+	for _, gid := range p.GroupsToRemove {
+		for idx, g := range p.Groups {
+			if g.ID == gid {
+				p.Groups = append(p.Groups[:idx], p.Groups[idx+1:]...)
+				break
+			}
+		}
+	}
+	p.GroupsToAdd, p.GroupsToRemove = nil, nil
+	s.people[id] = p
+
+	writeJSON(w, http.StatusOK, &response{Success: true, ID: id})
+}
+
+func (s *Server) deletePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := formInt(r, "Id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := s.people[id]; !ok {
+		writeError(w, http.StatusOK, msgNotFound)
+		return
+	}
+	delete(s.people, id)
+	writeJSON(w, http.StatusOK, &response{Success: true, ID: id})
+}
+
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups := make([]*api.Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+
+	var items []interface{}
+	for _, g := range groups {
+		items = append(items, map[string]interface{}{"Id": g.ID, "Name": g.Name, "Description": g.Description})
+	}
+	s.paginate(w, r, items)
+}
+
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request) {
+	name := r.Form.Get("Name")
+	if name == "" {
+		writeError(w, http.StatusOK, "Name is required")
+		return
+	}
+	g := &api.Group{ID: s.newID(), Name: name, Description: r.Form.Get("Description")}
+	s.groups[g.ID] = g
+	writeJSON(w, http.StatusOK, &response{Success: true, ID: g.ID})
+}
+
+func (s *Server) updateGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := formInt(r, "Id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	g, ok := s.groups[id]
+	if !ok {
+		writeError(w, http.StatusOK, msgNotFound)
+		return
+	}
+	if name := r.Form.Get("Name"); name != "" {
+		g.Name = name
+		for _, p := range s.people {
+			for _, pg := range p.Groups {
+				if pg.ID == id {
+					pg.Name = name
+				}
+			}
+		}
+	}
+	if desc := r.Form.Get("Description"); desc != "" {
+		g.Description = desc
+	}
+	writeJSON(w, http.StatusOK, &response{Success: true, ID: id})
+}
+
+func (s *Server) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := formInt(r, "Id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := s.groups[id]; !ok {
+		writeError(w, http.StatusOK, msgNotFound)
+		return
+	}
+	delete(s.groups, id)
+	for _, p := range s.people {
+		for idx, g := range p.Groups {
+			if g.ID == id {
+				p.Groups = append(p.Groups[:idx], p.Groups[idx+1:]...)
+				break
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, &response{Success: true, ID: id})
+}
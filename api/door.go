@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const formKeyLockStatus = "LockStatus"
+
+// DoorCommand is a lock status sent to a door
+type DoorCommand string
+
+const (
+	DoorCommandLock   DoorCommand = "Locked"
+	DoorCommandUnlock DoorCommand = "Unlocked"
+	// DoorCommandPulse momentarily unlocks the door for its configured unlock time
+	DoorCommandPulse DoorCommand = "Pulse"
+)
+
+type Door struct {
+	ID   int
+	Name string
+}
+
+func (c *Conn) ListDoors() ([]*Door, error) {
+	type data struct {
+		Count int `json:"Count"`
+		Items []*struct {
+			ID   int    `json:"Id"`
+			Name string `json:"Name"`
+		} `json:"Items"`
+	}
+
+	u := c.url()
+	u.Path += "/infinias/ia/doors"
+	q := u.Query()
+	q.Set(formKeyUsername, c.username)
+	q.Set(formKeyPassword, c.password)
+
+	var doors []*Door
+	total := 1
+	count := 0
+	for count < total {
+		q.Set("Start", strconv.Itoa(count))
+		u.RawQuery = q.Encode()
+
+		r, err := c.get(u.String())
+		if err != nil {
+			return nil, fmt.Errorf("could not GET doors: %w", err)
+		}
+		defer r.Body.Close()
+
+		resp := new(Response)
+		d := new(data)
+		resp.Data = d
+		if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+			return nil, fmt.Errorf("could not decode response body: %w", err)
+		}
+
+		if err = resp.Error(); err != nil {
+			return nil, err
+		}
+
+		for _, door := range d.Items {
+			doors = append(doors, &Door{ID: door.ID, Name: door.Name})
+		}
+
+		total = d.Count
+		count = len(doors)
+	}
+
+	return doors, nil
+}
+
+// SendDoorCommand sets the lock status of the door with the given id
+func (c *Conn) SendDoorCommand(id int, cmd DoorCommand) error {
+	u := c.url()
+	u.Path += "/infinias/ia/doors"
+
+	form := make(url.Values)
+	form.Set(formKeyUsername, c.username)
+	form.Set(formKeyPassword, c.password)
+	form.Set(formKeyID, strconv.Itoa(id))
+	form.Set(formKeyLockStatus, string(cmd))
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("could not create PUT request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	r, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("could not PUT door: %w", err)
+	}
+	defer r.Body.Close()
+
+	resp := new(Response)
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	if err = resp.Error(); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package infinias
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnsupportedKeyHash = errors.New("unsupported api key hash")
+	ErrInvalidKeyID       = errors.New("invalid api key id")
+)
+
+// argon2id parameters used by HashAPIKey
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// maxHashVerifications is how many api key hashes can be computed at once. Each argon2 hash uses argon2Memory KiB
+const maxHashVerifications = 4
+
+// hashVerifications limits concurrent hash computations to maxHashVerifications
+var hashVerifications = make(chan struct{}, maxHashVerifications)
+
+// splitAPIKey splits a hashed key presented as <id>.<key>
+func splitAPIKey(token string) (id, key string, ok bool) {
+	idx := strings.IndexByte(token, '.')
+	if idx <= 0 {
+		return "", "", false
+	}
+	return token[:idx], token[idx+1:], true
+}
+
+// ValidateAPIKeyID returns an error if id can't prefix a hashed key
+func ValidateAPIKeyID(id string) error {
+	if id == "" || strings.ContainsAny(id, ". ") {
+		return fmt.Errorf("%w: %q must be set and can't contain '.' or spaces", ErrInvalidKeyID, id)
+	}
+	return nil
+}
+
+// HashAPIKey returns an argon2id hash of key in PHC string format, suitable for APIKey.Hash
+func HashAPIKey(key string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate salt: %w", err)
+	}
+	hash := argon2.IDKey([]byte(key), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// argon2Hash is a parsed argon2 PHC string
+type argon2Hash struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+func parseArgon2Hash(s string) (*argon2Hash, error) {
+	// $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return nil, fmt.Errorf("%w: malformed argon2 hash", ErrUnsupportedKeyHash)
+	}
+
+	h := &argon2Hash{variant: parts[1]}
+	if h.variant != "argon2id" && h.variant != "argon2i" {
+		return nil, fmt.Errorf("%w: unknown argon2 variant %q", ErrUnsupportedKeyHash, h.variant)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("%w: unsupported argon2 version %q", ErrUnsupportedKeyHash, parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("%w: could not parse argon2 parameters: %v", ErrUnsupportedKeyHash, err)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("%w: could not decode argon2 salt: %v", ErrUnsupportedKeyHash, err)
+	}
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.hash) == 0 {
+		return nil, fmt.Errorf("%w: could not decode argon2 hash", ErrUnsupportedKeyHash)
+	}
+
+	return h, nil
+}
+
+func (h *argon2Hash) matches(key string) bool {
+	var hash []byte
+	if h.variant == "argon2id" {
+		hash = argon2.IDKey([]byte(key), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	} else {
+		hash = argon2.Key([]byte(key), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	}
+	return subtle.ConstantTimeCompare(hash, h.hash) == 1
+}
+
+func isBcryptHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+// ValidateAPIKeyHash returns an error if hash isn't a bcrypt or argon2 hash
+func ValidateAPIKeyHash(hash string) error {
+	if isBcryptHash(hash) {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedKeyHash, err)
+		}
+		return nil
+	}
+	_, err := parseArgon2Hash(hash)
+	return err
+}
+
+// verifyHash returns true if key matches k.Hash
+func (k *APIKey) verifyHash(key string) bool {
+	if isBcryptHash(k.Hash) {
+		return bcrypt.CompareHashAndPassword([]byte(k.Hash), []byte(key)) == nil
+	}
+	if h, err := parseArgon2Hash(k.Hash); err == nil {
+		return h.matches(key)
+	}
+	return false
+}
+
+// remember records that the presented key, <k.ID>.<key>, matches k.Hash
+func (k *APIKey) remember(key string) {
+	digest := sha256.Sum256([]byte(key))
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.verified == nil {
+		k.verified = make(map[[sha256.Size]byte]struct{})
+	}
+	k.verified[digest] = struct{}{}
+}
+
+// hasVerified returns true if the presented key has already been verified against k.Hash
+func (k *APIKey) hasVerified(key string) bool {
+	digest := sha256.Sum256([]byte(key))
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, ok := k.verified[digest]
+	return ok
+}
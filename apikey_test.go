@@ -0,0 +1,156 @@
+package infinias
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSplitAPIKey(t *testing.T) {
+	tests := []struct {
+		token string
+		id    string
+		key   string
+		ok    bool
+	}{
+		{"ci.secret", "ci", "secret", true},
+		{"ci.secret.with.dots", "ci", "secret.with.dots", true},
+		{"ci.", "ci", "", true},
+		{".secret", "", "", false},
+		{"secret", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.token, func(t *testing.T) {
+			id, key, ok := splitAPIKey(test.token)
+			if id != test.id || key != test.key || ok != test.ok {
+				t.Errorf("want %q, %q, %t, have %q, %q, %t", test.id, test.key, test.ok, id, key, ok)
+			}
+		})
+	}
+}
+
+func TestValidateAPIKeyID(t *testing.T) {
+	for _, id := range []string{"ci", "ci-2", "CI_bot"} {
+		if err := ValidateAPIKeyID(id); err != nil {
+			t.Errorf("%q: want no error, have %v", id, err)
+		}
+	}
+	for _, id := range []string{"", "c.i", "c i"} {
+		if err := ValidateAPIKeyID(id); !errors.Is(err, ErrInvalidKeyID) {
+			t.Errorf("%q: want %v, have %v", id, ErrInvalidKeyID, err)
+		}
+	}
+}
+
+func TestValidateAPIKeyHash(t *testing.T) {
+	argon2id, err := HashAPIKey("secret")
+	if err != nil {
+		t.Fatalf("could not hash key: %v", err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash key: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		ok   bool
+	}{
+		{"argon2id", argon2id, true},
+		{"bcrypt", string(bcryptHash), true},
+		{"truncated bcrypt", string(bcryptHash[:20]), false},
+		{"plaintext", "secret", false},
+		{"unknown hash type", "$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA", false},
+		{"unknown argon2 variant", "$argon2d$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA", false},
+		{"unsupported argon2 version", "$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$aGFzaA", false},
+		{"bad argon2 parameters", "$argon2id$v=19$m=a,t=3,p=4$c2FsdA$aGFzaA", false},
+		{"empty argon2 hash", "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateAPIKeyHash(test.hash)
+			if test.ok && err != nil {
+				t.Errorf("want no error, have %v", err)
+			} else if !test.ok && !errors.Is(err, ErrUnsupportedKeyHash) {
+				t.Errorf("want %v, have %v", ErrUnsupportedKeyHash, err)
+			}
+		})
+	}
+}
+
+func TestLookupAPIKey(t *testing.T) {
+	argon2id, err := HashAPIKey("argon2id-secret")
+	if err != nil {
+		t.Fatalf("could not hash key: %v", err)
+	}
+	// argon2i isn't created by HashAPIKey, but is accepted
+	salt := []byte("0123456789abcdef")
+	argon2i := fmt.Sprintf("$argon2i$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version, base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.Key([]byte("argon2i-secret"), salt, 1, 1024, 1, 32)))
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash key: %v", err)
+	}
+
+	plain := &APIKey{Name: "plain", Key: "plain-secret"}
+	dotted := &APIKey{Name: "dotted", Key: "legacy.secret"}
+	a2id := &APIKey{Name: "argon2id", ID: "argon2id", Hash: argon2id}
+	a2i := &APIKey{Name: "argon2i", ID: "argon2i", Hash: argon2i}
+	bc := &APIKey{Name: "bcrypt", ID: "bcrypt", Hash: string(bcryptHash)}
+	unknown := &APIKey{Name: "unknown", ID: "unknown", Hash: "$scrypt$ln=16,r=8,p=1$c2FsdA$aGFzaA"}
+	noID := &APIKey{Name: "noid", Hash: string(bcryptHash)}
+	s := &Service{APIKeys: []*APIKey{plain, dotted, a2id, a2i, bc, unknown, noID}}
+
+	tests := []struct {
+		name  string
+		token string
+		want  *APIKey
+	}{
+		{"plaintext", "plain-secret", plain},
+		{"plaintext with a dot", "legacy.secret", dotted},
+		{"argon2id", "argon2id.argon2id-secret", a2id},
+		{"argon2i", "argon2i.argon2i-secret", a2i},
+		{"bcrypt", "bcrypt.bcrypt-secret", bc},
+		{"wrong plaintext key", "plain-secret-2", nil},
+		{"wrong argon2id key", "argon2id.argon2id-secret-2", nil},
+		{"wrong argon2i key", "argon2i.wrong", nil},
+		{"wrong bcrypt key", "bcrypt.wrong", nil},
+		{"another key's id", "argon2i.bcrypt-secret", nil},
+		{"missing id", "bcrypt-secret", nil},
+		{"empty id", ".bcrypt-secret", nil},
+		{"unknown id", "other.bcrypt-secret", nil},
+		{"unknown hash type", "unknown.secret", nil},
+		{"empty", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// verified keys are remembered, so each is looked up twice
+			for i := 0; i < 2; i++ {
+				have, err := s.lookupAPIKey(test.token)
+				if err != nil {
+					t.Fatalf("could not look up key: %v", err)
+				}
+				if have != test.want {
+					t.Errorf("want %v, have %v", keyName(test.want), keyName(have))
+				}
+			}
+		})
+	}
+
+	if !a2id.hasVerified("argon2id.argon2id-secret") || a2id.hasVerified("argon2id.argon2id-secret-2") {
+		t.Error("want only matching keys remembered")
+	}
+}
+
+func keyName(k *APIKey) string {
+	if k == nil {
+		return "<nil>"
+	}
+	return k.Name
+}
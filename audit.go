@@ -0,0 +1,436 @@
+package infinias
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAuditQueryLimit    = 100
+	DefaultAuditPruneInterval = time.Hour
+)
+
+var ErrAuditPruneUnsupported = errors.New("audit log doesn't support pruning")
+
+// auditSystemActor is the actor of audit entries recorded by background tasks
+const auditSystemActor = "system"
+
+// AuditEntry records a single mutation made through the HTTP API
+type AuditEntry struct {
+	ID        string      `json:"id"`
+	Time      time.Time   `json:"time"`
+	Actor     string      `json:"actor"`
+	RequestID string      `json:"request_id,omitempty"`
+	Action    string      `json:"action"`
+	PersonID  int         `json:"person_id,omitempty"`
+	Old       interface{} `json:"old,omitempty"`
+	New       interface{} `json:"new,omitempty"`
+}
+
+// AuditFilter limits the entries returned by AuditLog.Query. Zero values match everything
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	PersonID int
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (f *AuditFilter) matches(e *AuditEntry) bool {
+	if f.Actor != "" && f.Actor != e.Actor {
+		return false
+	}
+	if f.Action != "" && f.Action != e.Action {
+		return false
+	}
+	if f.PersonID != 0 && f.PersonID != e.PersonID {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditLog stores and queries audit entries
+type AuditLog interface {
+	Record(e *AuditEntry) error
+	// Query returns matching entries, newest first
+	Query(f *AuditFilter) ([]*AuditEntry, error)
+}
+
+// AuditPruner is implemented by AuditLogs that can delete old entries
+type AuditPruner interface {
+	// Prune deletes entries recorded before t and returns how many were deleted
+	Prune(before time.Time) (int, error)
+}
+
+// FileAuditLog is an AuditLog stored as JSON lines in a file
+type FileAuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileAuditLog returns a new FileAuditLog at path, creating the file if it doesn't exist
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	f.Close()
+	return &FileAuditLog{path: path}, nil
+}
+
+func (l *FileAuditLog) Record(e *AuditEntry) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode entry: %w", err)
+	}
+	buf = append(buf, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(buf); err != nil {
+		return fmt.Errorf("could not write entry: %w", err)
+	}
+
+	return f.Close()
+}
+
+func (l *FileAuditLog) Query(filter *AuditFilter) ([]*AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		e := new(AuditEntry)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("could not decode entry: %w", err)
+		}
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read audit log: %w", err)
+	}
+
+	// reverse to newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	if entries == nil {
+		entries = make([]*AuditEntry, 0)
+	}
+
+	return entries, nil
+}
+
+// Prune rewrites the log without entries recorded before t
+func (l *FileAuditLog) Prune(before time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return 0, fmt.Errorf("could not open audit log: %w", err)
+	}
+	defer f.Close()
+
+	tmp, err := os.OpenFile(l.path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("could not create audit log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := bufio.NewWriter(tmp)
+	deleted := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e struct {
+			Time time.Time `json:"time"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return 0, fmt.Errorf("could not decode entry: %w", err)
+		}
+		if e.Time.Before(before) {
+			deleted++
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("could not read audit log: %w", err)
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	if err = w.Flush(); err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.path)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not write audit log: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// AuditPrune is the result of PruneAudit
+type AuditPrune struct {
+	Before  time.Time `json:"before"`
+	Deleted int       `json:"deleted"`
+}
+
+// PruneAudit deletes audit entries older than s.AuditRetention
+func (s *Service) PruneAudit() (*AuditPrune, error) {
+	if s.Audit == nil || s.AuditRetention <= 0 {
+		return &AuditPrune{}, nil
+	}
+	pruner, ok := s.Audit.(AuditPruner)
+	if !ok {
+		return nil, ErrAuditPruneUnsupported
+	}
+
+	res := &AuditPrune{Before: time.Now().Add(-s.AuditRetention).UTC()}
+	n, err := pruner.Prune(res.Before)
+	if err != nil {
+		return nil, fmt.Errorf("could not prune audit log: %w", err)
+	}
+	res.Deleted = n
+	return res, nil
+}
+
+// PruneAuditTask deletes audit entries older than s.AuditRetention with PruneAudit
+func (s *Service) PruneAuditTask(ctx context.Context) (interface{}, error) {
+	return s.PruneAudit()
+}
+
+// WatchAuditRetention runs PruneAudit now and every interval until stop is called
+func (s *Service) WatchAuditRetention(interval time.Duration) (stop func()) {
+	if s.Audit == nil || s.AuditRetention <= 0 {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultAuditPruneInterval
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			res, err := s.PruneAudit()
+			if err != nil {
+				s.logger().Error("could not prune audit log", "error", err)
+			} else if res.Deleted > 0 {
+				s.logger().Info("pruned audit log", "deleted", res.Deleted, "before", res.Before)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// audit records a mutation made by the request's principal. Errors are logged, since the mutation has already happened
+func (s *Service) audit(r *http.Request, action string, personID int, before, after interface{}) {
+	if s.Audit == nil {
+		return
+	}
+
+	actor := "anonymous"
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		actor = p.Name
+	}
+
+	e := &AuditEntry{
+		ID:        newID(),
+		Time:      time.Now().UTC(),
+		Actor:     actor,
+		RequestID: RequestIDFromContext(r.Context()),
+		Action:    action,
+		PersonID:  personID,
+		Old:       before,
+		New:       after,
+	}
+
+	if err := s.Audit.Record(e); err != nil {
+		s.requestLogger(r).Error("could not record audit entry", "action", action, "person_id", personID, "error", err)
+	}
+}
+
+// auditSystem records a mutation made by a background task instead of a request
+func (s *Service) auditSystem(action string, personID int, before, after interface{}) {
+	if s.Audit == nil {
+		return
+	}
+
+	e := &AuditEntry{
+		ID:       newID(),
+		Time:     time.Now().UTC(),
+		Actor:    auditSystemActor,
+		Action:   action,
+		PersonID: personID,
+		Old:      before,
+		New:      after,
+	}
+
+	if err := s.Audit.Record(e); err != nil {
+		s.logger().Error("could not record audit entry", "action", action, "person_id", personID, "error", err)
+	}
+}
+
+// auditPerson returns the current state of a person for the audit log, or nil if auditing is disabled or the person can't be read
+func (s *Service) auditPerson(r *http.Request, id int) interface{} {
+	if s.Audit == nil {
+		return nil
+	}
+
+	p, err := s.ReadPerson(id)
+	if err != nil {
+		s.requestLogger(r).Warn("could not read person for audit log", "person_id", id, "error", err)
+		return nil
+	}
+
+	return newPersonEvent(p)
+}
+
+// auditCredential returns the current state of a credential for the audit log, or nil if auditing is disabled or the credential can't be read
+func (s *Service) auditCredential(r *http.Request, id, credID int) interface{} {
+	if s.Audit == nil {
+		return nil
+	}
+
+	creds, err := s.ListCredentials(id)
+	if err != nil {
+		s.requestLogger(r).Warn("could not read credentials for audit log", "person_id", id, "error", err)
+		return nil
+	}
+
+	for _, c := range creds {
+		if c.ID == credID {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// auditGroup returns the current state of a group for the audit log, or nil if auditing is disabled or the group can't be read
+func (s *Service) auditGroup(r *http.Request, id int) interface{} {
+	if s.Audit == nil {
+		return nil
+	}
+
+	groups, err := s.ListGroups()
+	if err != nil {
+		s.requestLogger(r).Warn("could not read groups for audit log", "group_id", id, "error", err)
+		return nil
+	}
+
+	for _, g := range groups {
+		if g.ID == id {
+			return g
+		}
+	}
+
+	return nil
+}
+
+// QueryAuditHandler returns audit entries, newest first. Entries can be filtered by actor, action, person_id, and an
+// RFC 3339 since/until time range, and limited with limit
+func (s *Service) QueryAuditHandler(r *http.Request) (interface{}, error) {
+	if s.Audit == nil {
+		return make([]*AuditEntry, 0), nil
+	}
+
+	q := r.URL.Query()
+	filter := &AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Limit:  DefaultAuditQueryLimit,
+	}
+
+	if str := q.Get("person_id"); str != "" {
+		id, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read person_id: %w", err)}
+		}
+		filter.PersonID = id
+	}
+
+	if str := q.Get("since"); str != "" {
+		t, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read since: %w", err)}
+		}
+		filter.Since = t
+	}
+
+	if str := q.Get("until"); str != "" {
+		t, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read until: %w", err)}
+		}
+		filter.Until = t
+	}
+
+	if str := q.Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read limit: %w", err)}
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := s.Audit.Query(filter)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not query audit log: %w", err)}
+	}
+
+	return entries, nil
+}
@@ -1,36 +1,258 @@
 package infinias
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/subtle"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
-var ErrInvalidAuthorization = errors.New("invalid authorization")
+var (
+	ErrInvalidAuthorization = errors.New("invalid authorization")
+	ErrInsufficientScope    = errors.New("insufficient scope")
+	ErrAuthBusy             = errors.New("too many api keys being verified")
+)
+
+const (
+	ScopeReadOnly         = "read-only"
+	ScopePeopleWrite      = "people:write"
+	ScopeCredentialsWrite = "credentials:write"
+	// ScopeDoorsControl allows locking and unlocking doors
+	ScopeDoorsControl = "doors:control"
+	ScopeAdmin        = "admin"
+)
+
+// APIKey is a named key with a set of scopes. Either Key or Hash, a bcrypt or argon2 hash of the key, must be set
+type APIKey struct {
+	Name string
+	Key  string
+	Hash string
+	// ID identifies a hashed key without revealing it. Hashed keys are presented as <ID>.<key>,
+	// so only the hash of the key with a matching ID is computed
+	ID      string
+	Scopes  []string
+	Created time.Time
+	// Expires is when the key stops working. If nil, it never does
+	Expires *time.Time
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+func (k *APIKey) expired(now time.Time) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.Expires != nil && !now.Before(*k.Expires)
+}
+
+// ClientCert maps a TLS client certificate common name to a set of scopes
+type ClientCert struct {
+	CommonName string
+	Scopes     []string
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// HasScope returns true if the principal is allowed to use routes requiring scope.
+// admin allows everything, and any write scope allows reading
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == ScopeAdmin || s == scope {
+			return true
+		}
+		if scope == ScopeReadOnly && (s == ScopePeopleWrite || s == ScopeCredentialsWrite || s == ScopeDoorsControl) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey int
+
+const (
+	contextKeyPrincipal contextKey = iota
+	contextKeyRequestID
+	contextKeyAPIVersion
+	contextKeyIdempotency
+)
+
+// PrincipalFromContext returns the authenticated Principal for the request context, or nil if authentication is disabled
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKeyPrincipal).(*Principal)
+	return p
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	header := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(header) != 2 || header[0] != "Bearer" {
+		return "", false
+	}
+	return header[1], true
+}
+
+// lookupAPIKey returns the key matching token, or nil if none does. At most one hash is computed per call,
+// for the hashed key whose ID prefixes token. It returns ErrAuthBusy if too many hashes are already being computed
+func (s *Service) lookupAPIKey(token string) (*APIKey, error) {
+	var found, hashed *APIKey
+	presented := []byte(token)
+	id, secret, _ := splitAPIKey(token)
+	// check every key so timing doesn't reveal which key matched
+	for _, k := range s.activeAPIKeys() {
+		if k.Hash != "" {
+			if k.ID == "" || k.ID != id {
+				continue
+			}
+			if k.hasVerified(token) {
+				found = k
+			} else if hashed == nil {
+				hashed = k
+			}
+			continue
+		}
+		key := []byte(k.Key)
+		if subtle.ConstantTimeEq(int32(len(key)), int32(len(presented))) == 1 && subtle.ConstantTimeCompare(key, presented) == 1 {
+			found = k
+		}
+	}
+	if found != nil || hashed == nil {
+		return found, nil
+	}
+
+	select {
+	case hashVerifications <- struct{}{}:
+		defer func() { <-hashVerifications }()
+	default:
+		return nil, ErrAuthBusy
+	}
+	if hashed.verifyHash(secret) {
+		hashed.remember(token)
+		return hashed, nil
+	}
+	return nil, nil
+}
+
+func (s *Service) authenticate(r *http.Request, token string) (*Principal, error) {
+	if s.OIDC != nil && isJWT(token) {
+		p, err := s.OIDC.Principal(token)
+		if err != nil {
+			s.requestLogger(r).Warn("could not validate bearer token", "error", err)
+			return nil, nil
+		}
+		return p, nil
+	}
+
+	key, err := s.lookupAPIKey(token)
+	if key == nil {
+		return nil, err
+	}
+	return &Principal{Name: key.Name, Scopes: key.Scopes}, nil
+}
+
+// clientCertPrincipal returns the Principal for a verified TLS client certificate, or nil if none matches
+func (s *Service) clientCertPrincipal(r *http.Request) *Principal {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, c := range s.ClientCerts {
+		if c.CommonName == cn {
+			return &Principal{Name: cn, Scopes: c.Scopes}
+		}
+	}
+
+	return nil
+}
 
 func (s *Service) WithAuth(next http.Handler) http.Handler {
-	if s.APIKey == "" {
+	if len(s.APIKeys) == 0 && s.Keys == nil && s.OIDC == nil && len(s.ClientCerts) == 0 {
 		return next
 	}
-	key := []byte(s.APIKey)
-	keylen := int32(len(key))
 	errHandler := s.HandleJSON(func(r *http.Request) (interface{}, error) {
 		return nil, &HTTPError{StatusCode: http.StatusUnauthorized, Err: ErrInvalidAuthorization}
 	})
+	lockedHandler := s.HandleJSON(func(r *http.Request) (interface{}, error) {
+		return nil, &HTTPError{StatusCode: http.StatusTooManyRequests, Err: ErrAuthLocked}
+	})
+	busyHandler := s.HandleJSON(func(r *http.Request) (interface{}, error) {
+		return nil, &HTTPError{StatusCode: http.StatusServiceUnavailable, Err: ErrAuthBusy}
+	})
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		header := strings.Split(r.Header.Get("Authorization"), " ")
-		if len(header) != 2 || header[0] != "Bearer" {
-			errHandler.ServeHTTP(w, r)
+		ip := clientIP(r)
+		if d := s.AuthLimiter.banned(ip); d > 0 {
+			w.Header().Set("Retry-After", retryAfter(d))
+			lockedHandler.ServeHTTP(w, r)
 			return
 		}
 
-		if subtle.ConstantTimeEq(keylen, int32(len([]byte(header[1])))) != 1 {
+		var p *Principal
+		if token, ok := bearerToken(r); ok {
+			var err error
+			if p, err = s.authenticate(r, token); err != nil {
+				w.Header().Set("Retry-After", "1")
+				busyHandler.ServeHTTP(w, r)
+				return
+			}
+		} else {
+			p = s.clientCertPrincipal(r)
+		}
+
+		if p == nil {
+			// only attempts with credentials count as failures
+			if r.Header.Get("Authorization") != "" {
+				s.authFailed(r, ip)
+			}
 			errHandler.ServeHTTP(w, r)
 			return
 		}
+		s.AuthLimiter.succeed(ip)
+
+		ctx := context.WithValue(r.Context(), contextKeyPrincipal, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireCredentialsWrite returns a 403 *HTTPError if p has additional credentials and the request's principal can't
+// create credentials with ScopeCredentialsWrite or a role, so people:write can't be used to get around it
+func (s *Service) requireCredentialsWrite(r *http.Request, p *Person) error {
+	if len(p.Credentials) == 0 {
+		return nil
+	}
+	pr := PrincipalFromContext(r.Context())
+	if pr == nil || pr.HasScope(ScopeCredentialsWrite) {
+		return nil
+	}
+	for _, role := range s.principalRoles(pr) {
+		if role.allows(http.MethodPost, "/people/{id}/credentials") {
+			return nil
+		}
+	}
+	return &HTTPError{StatusCode: http.StatusForbidden, Err: fmt.Errorf("%w: credentials require the %s scope", ErrInsufficientScope, ScopeCredentialsWrite)}
+}
+
+// WithScope only allows requests from principals with the given scope, or with a role that allows the route
+func (s *Service) WithScope(scope string, next http.Handler) http.Handler {
+	errHandler := s.HandleJSON(func(r *http.Request) (interface{}, error) {
+		return nil, &HTTPError{StatusCode: http.StatusForbidden, Err: ErrInsufficientScope}
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// authentication is disabled
+		p := PrincipalFromContext(r.Context())
+		if p == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		if subtle.ConstantTimeCompare(key, []byte(header[1])) != 1 {
+		if !p.HasScope(scope) && !s.roleAllows(r, p) {
 			errHandler.ServeHTTP(w, r)
 			return
 		}
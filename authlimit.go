@@ -0,0 +1,174 @@
+package infinias
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAuthMaxFailures = 10
+	DefaultAuthBaseDelay   = 250 * time.Millisecond
+	DefaultAuthMaxDelay    = 5 * time.Second
+	DefaultAuthBanDuration = 15 * time.Minute
+)
+
+var ErrAuthLocked = errors.New("too many failed authorization attempts")
+
+// AuthLimiter throttles failed authorization attempts per source IP. Each failure is delayed exponentially
+// from BaseDelay up to MaxDelay, and a source is banned for BanDuration after MaxFailures consecutive failures.
+// A source's failures are forgotten after BanDuration without one. A nil *AuthLimiter doesn't limit anything
+type AuthLimiter struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	BanDuration time.Duration
+
+	mu        sync.Mutex
+	sources   map[string]*authSource
+	lastPrune time.Time
+}
+
+type authSource struct {
+	failures    int
+	last        time.Time
+	bannedUntil time.Time
+}
+
+// NewAuthLimiter returns a new AuthLimiter. Zero values use the defaults
+func NewAuthLimiter(maxFailures int, baseDelay, maxDelay, banDuration time.Duration) *AuthLimiter {
+	l := &AuthLimiter{sources: make(map[string]*authSource)}
+	l.SetLimits(maxFailures, baseDelay, maxDelay, banDuration)
+	return l
+}
+
+// SetLimits changes l's limits, keeping the failures and bans already recorded. Zero values use the defaults
+func (l *AuthLimiter) SetLimits(maxFailures int, baseDelay, maxDelay, banDuration time.Duration) {
+	if maxFailures <= 0 {
+		maxFailures = DefaultAuthMaxFailures
+	}
+	if baseDelay <= 0 {
+		baseDelay = DefaultAuthBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultAuthMaxDelay
+	}
+	if banDuration <= 0 {
+		banDuration = DefaultAuthBanDuration
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.MaxFailures, l.BaseDelay, l.MaxDelay, l.BanDuration = maxFailures, baseDelay, maxDelay, banDuration
+}
+
+// limits returns l's ban threshold and duration
+func (l *AuthLimiter) limits() (maxFailures int, banDuration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.MaxFailures, l.BanDuration
+}
+
+// banned returns how long ip remains banned, or zero if it isn't
+func (l *AuthLimiter) banned(ip string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if src, ok := l.sources[ip]; ok {
+		if d := time.Until(src.bannedUntil); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// fail records a failed attempt from ip, returning how long to delay the response and whether ip was just banned
+func (l *AuthLimiter) fail(ip string) (delay time.Duration, banned bool) {
+	if l == nil {
+		return 0, false
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	src, ok := l.sources[ip]
+	if !ok {
+		src = new(authSource)
+		l.sources[ip] = src
+	}
+	src.failures++
+	src.last = now
+
+	if src.failures >= l.MaxFailures {
+		src.failures = 0
+		src.bannedUntil = now.Add(l.BanDuration)
+		return 0, true
+	}
+
+	delay = l.BaseDelay << uint(src.failures-1)
+	if delay > l.MaxDelay || delay <= 0 {
+		delay = l.MaxDelay
+	}
+	return delay, false
+}
+
+// succeed forgets ip's failures
+func (l *AuthLimiter) succeed(ip string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if src, ok := l.sources[ip]; ok && time.Now().After(src.bannedUntil) {
+		delete(l.sources, ip)
+	}
+}
+
+// prune removes sources that haven't failed or been banned recently. The caller must hold l.mu
+func (l *AuthLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.BanDuration {
+		return
+	}
+	l.lastPrune = now
+	for ip, src := range l.sources {
+		if now.Sub(src.last) > l.BanDuration && now.After(src.bannedUntil) {
+			delete(l.sources, ip)
+		}
+	}
+}
+
+// authFailed records a failed authorization attempt from ip, delaying the response or banning ip
+func (s *Service) authFailed(r *http.Request, ip string) {
+	delay, banned := s.AuthLimiter.fail(ip)
+	if banned {
+		maxFailures, banDuration := s.AuthLimiter.limits()
+		s.requestLogger(r).Warn("banned source after repeated authorization failures", "ip", ip, "duration", banDuration)
+		s.alertFields(AlertAuthLockout, "Authorization lockout", map[string]string{"ip": ip},
+			"%s was banned for %s after %d failed authorization attempts", ip, banDuration, maxFailures)
+		return
+	}
+
+	s.requestLogger(r).Info("authorization failed", "ip", ip, "delay", delay)
+	if delay == 0 {
+		return
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
+
+// clientIP returns the IP address of the client that sent r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,262 @@
+package infinias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+// SnapshotVersion is the version of the Snapshot format written by Export
+const SnapshotVersion = 1
+
+const (
+	ImportMatchEmployeeID = "employee_id"
+	ImportMatchID         = "id"
+)
+
+// SnapshotPerson is a person and the ids of their groups in a Snapshot
+type SnapshotPerson struct {
+	*Person
+	Groups []int `json:"groups"`
+}
+
+// Snapshot is a complete copy of people, credentials, and group memberships
+type Snapshot struct {
+	Version int               `json:"version"`
+	Created time.Time         `json:"created"`
+	Groups  []*Group          `json:"groups"`
+	People  []*SnapshotPerson `json:"people"`
+}
+
+// Export returns a Snapshot of all people and groups. If photos is true, people's pictures are included
+func (s *Service) Export(photos bool) (*Snapshot, error) {
+	groups, err := s.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	people, err := s.ListPeople()
+	if err != nil {
+		return nil, err
+	}
+
+	memberships, err := s.DBConn.ListGroupMemberships()
+	if err != nil {
+		return nil, fmt.Errorf("could not list group memberships: %w", err)
+	}
+
+	snap := &Snapshot{Version: SnapshotVersion, Created: time.Now().UTC(), Groups: groups, People: make([]*SnapshotPerson, len(people))}
+	for idx, p := range people {
+		if photos && p.HasImage {
+			if p.Image, err = s.pictures().Read(p.ID); err != nil && !errors.Is(err, photo.ErrNotFound) {
+				return nil, fmt.Errorf("could not read picture for %d: %w", p.ID, err)
+			}
+		}
+		groups := memberships[p.ID]
+		if groups == nil {
+			groups = make([]int, 0)
+		}
+		snap.People[idx] = &SnapshotPerson{Person: p, Groups: groups}
+	}
+
+	return snap, nil
+}
+
+// ImportResult is the result of Import
+type ImportResult struct {
+	GroupsCreated int      `json:"groups_created"`
+	PeopleCreated int      `json:"people_created"`
+	PeopleUpdated int      `json:"people_updated"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// Import restores snap. Groups are matched by name and created if missing.
+// People are matched by employee id or, if match is ImportMatchID, by id; unmatched people are created.
+// Errors for individual people are collected in the result instead of stopping the import
+func (s *Service) Import(ctx context.Context, snap *Snapshot, match string) (*ImportResult, error) {
+	s = s.WithContext(ctx)
+	res := new(ImportResult)
+
+	existingGroups, err := s.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	groupsByName := make(map[string]int)
+	for _, g := range existingGroups {
+		groupsByName[strings.ToLower(g.Name)] = g.ID
+	}
+
+	// map snapshot group ids to ids on this server
+	groupIDs := make(map[int]int)
+	for _, g := range snap.Groups {
+		if id, ok := groupsByName[strings.ToLower(g.Name)]; ok {
+			groupIDs[g.ID] = id
+			continue
+		}
+		id, err := s.CreateGroup(&Group{Name: g.Name, Description: g.Description})
+		if err != nil {
+			return nil, fmt.Errorf("could not create group %s: %w", g.Name, err)
+		}
+		groupsByName[strings.ToLower(g.Name)] = id
+		groupIDs[g.ID] = id
+		res.GroupsCreated++
+	}
+
+	people, err := s.ListPeople()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*Person)
+	for _, p := range people {
+		if key := importKey(p, match); key != "" {
+			existing[key] = p
+		}
+	}
+
+	for idx, sp := range snap.People {
+		ReportProgress(ctx, idx, len(snap.People))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if sp.Person == nil {
+			continue
+		}
+
+		var current *Person
+		if key := importKey(sp.Person, match); key != "" {
+			current = existing[key]
+		}
+
+		created, err := s.importPerson(sp, current, groupIDs)
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("person %d (%s %s): %v", sp.ID, sp.FirstName, sp.LastName, err))
+			continue
+		}
+		if created {
+			res.PeopleCreated++
+		} else {
+			res.PeopleUpdated++
+		}
+	}
+	ReportProgress(ctx, len(snap.People), len(snap.People))
+
+	return res, nil
+}
+
+func importKey(p *Person, match string) string {
+	if match == ImportMatchID {
+		return fmt.Sprintf("%d", p.ID)
+	}
+	return normalizeEmployeeID(p.EmployeeID)
+}
+
+// importPerson creates or updates current to match sp, returning true if the person was created
+func (s *Service) importPerson(sp *SnapshotPerson, current *Person, groupIDs map[int]int) (bool, error) {
+	p := &Person{
+		FirstName:  sp.FirstName,
+		LastName:   sp.LastName,
+		EmployeeID: sp.EmployeeID,
+		Department: sp.Department,
+		SiteCode:   sp.SiteCode,
+		CardCode:   sp.CardCode,
+	}
+
+	currentGroups := make(map[int]struct{})
+	if current != nil {
+		groups, err := s.ListPersonGroups(current.ID)
+		if err != nil {
+			return false, err
+		}
+		for _, g := range groups {
+			currentGroups[g.ID] = struct{}{}
+		}
+	}
+	for _, id := range sp.Groups {
+		newID, ok := groupIDs[id]
+		if !ok {
+			return false, fmt.Errorf("unknown group %d", id)
+		}
+		if _, ok := currentGroups[newID]; !ok {
+			p.GroupsToAdd = append(p.GroupsToAdd, newID)
+		}
+	}
+
+	created := current == nil
+	if created {
+		id, err := s.CreatePerson(p)
+		if err != nil {
+			return false, err
+		}
+		p.ID = id
+	} else {
+		p.ID = current.ID
+		if err := s.UpdatePerson(p); err != nil {
+			return false, err
+		}
+	}
+
+	for _, cred := range sp.Credentials {
+		if cred.SiteCode == sp.SiteCode && cred.CardCode == sp.CardCode {
+			continue
+		}
+		c := *cred
+		c.ID = 0
+		if _, err := s.CreateCredential(p.ID, &c); err != nil {
+			return created, fmt.Errorf("could not create credential (%d-%d): %w", c.SiteCode, c.CardCode, err)
+		}
+	}
+
+	if len(sp.Image) > 0 {
+		if err := s.pictures().Write(p.ID, sp.Image); err != nil {
+			return created, fmt.Errorf("could not update picture: %w", err)
+		}
+		s.Thumbnails.Invalidate(p.ID)
+		s.notify(EventPictureUpdated, &pictureEvent{PersonID: p.ID})
+	}
+
+	return created, nil
+}
+
+// ExportHandler starts a job creating a Snapshot of all people and groups. Pictures are included with photos=true
+func (s *Service) ExportHandler(r *http.Request) (interface{}, error) {
+	photos, err := readBoolQuery(r, "photos")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.submitJob(r, "export", func(ctx context.Context) (interface{}, error) {
+		snap, err := s.WithContext(ctx).Export(photos)
+		if err != nil {
+			return nil, fmt.Errorf("could not export: %w", err)
+		}
+		return snap, nil
+	})
+}
+
+// ImportHandler starts a job restoring a Snapshot. People are matched by employee_id (the default) or id with match=
+func (s *Service) ImportHandler(r *http.Request) (interface{}, error) {
+	match := r.URL.Query().Get("match")
+	if match == "" {
+		match = ImportMatchEmployeeID
+	}
+	if match != ImportMatchEmployeeID && match != ImportMatchID {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read match: must be employee_id or id: %q", match)}
+	}
+
+	snap := new(Snapshot)
+	if err := readJSON(r, snap); err != nil {
+		return nil, err
+	}
+	if snap.Version != SnapshotVersion {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read snapshot: unsupported version: %d", snap.Version)}
+	}
+
+	return s.submitJob(r, "import", func(ctx context.Context) (interface{}, error) {
+		return s.Import(ctx, snap, match)
+	})
+}
@@ -0,0 +1,204 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+var ErrBreakerOpen = errors.New("infinias api circuit breaker is open")
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// Breaker is a circuit breaker for Infinias API requests. It opens after Threshold consecutive failures,
+// failing requests immediately with ErrBreakerOpen until Cooldown has passed. A single request is then let through,
+// closing the breaker if it succeeds and reopening it if it fails. A nil *Breaker is valid and always closed
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	lastErr  string
+	probing  bool
+
+	cacheMu sync.RWMutex
+	cache   map[string]interface{}
+}
+
+// NewBreaker returns a new, closed Breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	return &Breaker{Threshold: threshold, Cooldown: cooldown, state: BreakerClosed, cache: make(map[string]interface{})}
+}
+
+// allow returns ErrBreakerOpen if a request shouldn't be made
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return ErrBreakerOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrBreakerOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record records the result of a request. If err is nil, the request succeeded
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.state, b.failures, b.lastErr = BreakerClosed, 0, ""
+		return
+	}
+
+	b.failures++
+	b.lastErr = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
+
+// release ends a request that neither succeeded nor failed, e.g. because it was canceled
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// BreakerStatus is the current state of a Breaker
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	// RetryAfter is when the next request will be let through if the breaker is open
+	RetryAfter *time.Time `json:"retry_after,omitempty"`
+}
+
+// Status returns the current state of b
+func (b *Breaker) Status() *BreakerStatus {
+	if b == nil {
+		return &BreakerStatus{State: BreakerClosed}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := &BreakerStatus{State: b.state, ConsecutiveFailures: b.failures, LastError: b.lastErr}
+	if b.state != BreakerClosed {
+		opened := b.openedAt.UTC()
+		retry := opened.Add(b.Cooldown)
+		status.OpenedAt, status.RetryAfter = &opened, &retry
+	}
+	return status
+}
+
+// Transport returns an http.RoundTripper that sends requests through b to next.
+// Transport errors and 5xx responses are failures. If next is nil, http.DefaultTransport is used
+func (b *Breaker) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if b == nil {
+		return next
+	}
+	return &breakerTransport{b: b, next: next}
+}
+
+type breakerTransport struct {
+	b    *Breaker
+	next http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.b.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	switch {
+	case err != nil && r.Context().Err() != nil:
+		// the caller gave up, which says nothing about the api
+		t.b.release()
+	case err != nil:
+		t.b.record(err)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		t.b.record(fmt.Errorf("unexpected status: %s", resp.Status))
+	default:
+		t.b.record(nil)
+	}
+	return resp, err
+}
+
+// remember caches v as the latest result for key, to be served by recall while b is open
+func (b *Breaker) remember(key string, v interface{}) {
+	if b == nil {
+		return
+	}
+	b.cacheMu.Lock()
+	defer b.cacheMu.Unlock()
+	b.cache[key] = v
+}
+
+// recall returns the latest result for key if err is because b is open
+func (b *Breaker) recall(key string, err error) (interface{}, bool) {
+	if b == nil || !errors.Is(err, ErrBreakerOpen) {
+		return nil, false
+	}
+	b.cacheMu.RLock()
+	defer b.cacheMu.RUnlock()
+	v, ok := b.cache[key]
+	return v, ok
+}
+
+// Health is the result of HealthHandler
+type Health struct {
+	// Status is ok, or degraded if the Infinias API is unavailable
+	Status       string         `json:"status"`
+	InfiniasAPI  *BreakerStatus `json:"infinias_api"`
+	SlowRequests uint64         `json:"slow_requests"`
+	SlowQueries  uint64         `json:"slow_queries"`
+	// Watchdog is omitted if the watchdog is disabled
+	Watchdog *WatchdogStatus `json:"watchdog,omitempty"`
+	// APIBudget is omitted if Infinias API calls aren't limited
+	APIBudget *APIBudgetStatus `json:"api_budget,omitempty"`
+}
+
+// HealthHandler returns the service's Health
+func (s *Service) HealthHandler(r *http.Request) (interface{}, error) {
+	h := &Health{Status: "ok", InfiniasAPI: s.Breaker.Status(), Watchdog: s.Watchdog.Status(), APIBudget: s.APIBudget.Status()}
+	h.SlowRequests, h.SlowQueries = s.SlowLog.Counts()
+	if h.InfiniasAPI.State != BreakerClosed {
+		h.Status = "degraded"
+	}
+	return h, nil
+}
@@ -0,0 +1,175 @@
+package infinias
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// APIBudget limits how hard the service calls the Infinias API. Calls over either limit wait for their turn
+// instead of failing, until their request is canceled. Zero limits are unlimited. A nil *APIBudget doesn't limit anything
+type APIBudget struct {
+	// MaxConcurrent is the most calls in flight at once. A call is in flight until its response body is closed
+	MaxConcurrent int
+	// CallsPerMinute is the sustained rate calls are started at
+	CallsPerMinute int
+	// Burst is how many calls can start at once after the API has been idle. Defaults to 1
+	Burst int
+
+	sem chan struct{}
+
+	mu  sync.Mutex
+	tat time.Time
+
+	waiting   int64
+	throttled uint64
+}
+
+// NewAPIBudget returns a new APIBudget, or nil if both maxConcurrent and callsPerMinute are zero
+func NewAPIBudget(maxConcurrent, callsPerMinute, burst int) *APIBudget {
+	if maxConcurrent <= 0 && callsPerMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	b := &APIBudget{MaxConcurrent: maxConcurrent, CallsPerMinute: callsPerMinute, Burst: burst}
+	if maxConcurrent > 0 {
+		b.sem = make(chan struct{}, maxConcurrent)
+	}
+	return b
+}
+
+// reserve returns how long to wait before starting a call to stay under CallsPerMinute
+func (b *APIBudget) reserve(now time.Time) time.Duration {
+	if b.CallsPerMinute <= 0 {
+		return 0
+	}
+	interval := time.Minute / time.Duration(b.CallsPerMinute)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// tat is when the next call would start if calls were evenly spaced; Burst calls may start before it
+	start := b.tat.Add(-time.Duration(b.Burst-1) * interval)
+	if start.Before(now) {
+		start = now
+	}
+	if b.tat.Before(start) {
+		b.tat = start
+	}
+	b.tat = b.tat.Add(interval)
+	return start.Sub(now)
+}
+
+// acquire waits until a call may start or r is canceled
+func (b *APIBudget) acquire(r *http.Request) error {
+	atomic.AddInt64(&b.waiting, 1)
+	defer atomic.AddInt64(&b.waiting, -1)
+
+	throttled := false
+	if b.sem != nil {
+		select {
+		case b.sem <- struct{}{}:
+		default:
+			throttled = true
+			select {
+			case b.sem <- struct{}{}:
+			case <-r.Context().Done():
+				return r.Context().Err()
+			}
+		}
+	}
+
+	if wait := b.reserve(time.Now()); wait > 0 {
+		throttled = true
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			b.release()
+			return r.Context().Err()
+		}
+	}
+
+	if throttled {
+		atomic.AddUint64(&b.throttled, 1)
+	}
+	return nil
+}
+
+func (b *APIBudget) release() {
+	if b.sem != nil {
+		<-b.sem
+	}
+}
+
+// APIBudgetStatus is the current state of an APIBudget
+type APIBudgetStatus struct {
+	MaxConcurrent  int `json:"max_concurrent,omitempty"`
+	CallsPerMinute int `json:"calls_per_minute,omitempty"`
+	InFlight       int `json:"in_flight"`
+	Waiting        int `json:"waiting"`
+	// Throttled is the number of calls that had to wait since the service started
+	Throttled uint64 `json:"throttled"`
+}
+
+// Status returns the current state of b, or nil if b is nil
+func (b *APIBudget) Status() *APIBudgetStatus {
+	if b == nil {
+		return nil
+	}
+	return &APIBudgetStatus{
+		MaxConcurrent:  b.MaxConcurrent,
+		CallsPerMinute: b.CallsPerMinute,
+		InFlight:       len(b.sem),
+		Waiting:        int(atomic.LoadInt64(&b.waiting)),
+		Throttled:      atomic.LoadUint64(&b.throttled),
+	}
+}
+
+// Transport returns an http.RoundTripper that sends requests to next within b's limits.
+// If next is nil, http.DefaultTransport is used
+func (b *APIBudget) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if b == nil {
+		return next
+	}
+	return &budgetTransport{b: b, next: next}
+}
+
+type budgetTransport struct {
+	b    *APIBudget
+	next http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.b.acquire(r); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		t.b.release()
+		return nil, err
+	}
+	resp.Body = &budgetBody{ReadCloser: resp.Body, release: t.b.release}
+	return resp, nil
+}
+
+// budgetBody releases its call's slot when it's closed
+type budgetBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *budgetBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,87 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/korylprince/go-infinias-api/cardutil"
+)
+
+var ErrInvalidCard = errors.New("invalid card")
+
+// CardInput is a card as output by an enrollment reader. Exactly one of Hex and Bits must be set
+type CardInput struct {
+	// Format is a cardutil format name, e.g. h10301
+	Format string `json:"format"`
+	Hex    string `json:"hex,omitempty"`
+	Bits   string `json:"bits,omitempty"`
+}
+
+// Convert returns the card c represents
+func (c *CardInput) Convert() (*cardutil.Card, error) {
+	f, err := cardutil.LookupFormat(c.Format)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.Hex != "" && c.Bits == "":
+		return f.FromHex(c.Hex)
+	case c.Bits != "" && c.Hex == "":
+		return f.FromBits(c.Bits)
+	}
+	return nil, fmt.Errorf("%w: exactly one of hex and bits is required", ErrInvalidCard)
+}
+
+// resolveCard sets p's site and card codes from p.Card, if set, and clears it.
+// A *ValidationError is returned if the card can't be converted
+func (p *Person) resolveCard() error {
+	if p.Card == nil {
+		return nil
+	}
+	c, err := p.Card.Convert()
+	if err != nil {
+		e := new(ValidationError)
+		e.add("card", err.Error())
+		return e
+	}
+	p.SiteCode, p.CardCode, p.Card = int(c.Facility), int(c.Number), nil
+	return nil
+}
+
+// ConvertCardHandler converts a card to all of its representations. The format query parameter is required,
+// along with exactly one of hex, bits, or facility_code and card_number
+func (s *Service) ConvertCardHandler(r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	in := &CardInput{Format: q.Get("format"), Hex: q.Get("hex"), Bits: q.Get("bits")}
+	facility, number := q.Get("facility_code"), q.Get("card_number")
+	if facility == "" && number == "" {
+		c, err := in.Convert()
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not convert card: %w", err)}
+		}
+		return c, nil
+	}
+
+	if in.Hex != "" || in.Bits != "" {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("%w: hex and bits can't be used with facility_code and card_number", ErrInvalidCard)}
+	}
+	f, err := cardutil.LookupFormat(in.Format)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not convert card: %w", err)}
+	}
+	fc, err := strconv.ParseUint(facility, 10, 64)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read facility_code: invalid value: %q", facility)}
+	}
+	cn, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read card_number: invalid value: %q", number)}
+	}
+	c, err := f.Encode(fc, cn)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not convert card: %w", err)}
+	}
+	return c, nil
+}
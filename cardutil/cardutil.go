@@ -0,0 +1,251 @@
+// Package cardutil converts card numbers between raw reader output, facility and card numbers, and Wiegand bit strings
+package cardutil
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown card format")
+	ErrInvalidBits   = errors.New("invalid bit string")
+	ErrInvalidHex    = errors.New("invalid hex")
+	ErrParity        = errors.New("parity check failed")
+	ErrOutOfRange    = errors.New("number out of range")
+)
+
+// field is a range of bits in a message, numbered from 0 at the first bit transmitted
+type field struct {
+	start  int
+	length int
+}
+
+func (f field) max() uint64 {
+	return 1<<uint(f.length) - 1
+}
+
+// parityBit is the bit at pos, set so that it and the bits covers returns true for have even or odd parity
+type parityBit struct {
+	pos    int
+	odd    bool
+	covers func(i int) bool
+}
+
+// between returns a parityBit.covers for the bits from start up to, but not including, end
+func between(start, end int) func(int) bool {
+	return func(i int) bool { return i >= start && i < end }
+}
+
+// Format is a Wiegand card format
+type Format struct {
+	Name string
+	// Bits is the length of a message
+	Bits int
+
+	facility field
+	card     field
+	// parity bits are computed in order, so later bits can cover earlier ones
+	parity []parityBit
+}
+
+// H10301 is the standard 26-bit format: an even parity bit, an 8-bit facility code, a 16-bit card number,
+// and an odd parity bit
+var H10301 = &Format{
+	Name:     "h10301",
+	Bits:     26,
+	facility: field{1, 8},
+	card:     field{9, 16},
+	parity:   []parityBit{{0, false, between(1, 13)}, {25, true, between(13, 25)}},
+}
+
+// H10306 is the 34-bit format: an even parity bit, a 16-bit facility code, a 16-bit card number,
+// and an odd parity bit
+var H10306 = &Format{
+	Name:     "h10306",
+	Bits:     34,
+	facility: field{1, 16},
+	card:     field{17, 16},
+	parity:   []parityBit{{0, false, between(1, 17)}, {33, true, between(17, 33)}},
+}
+
+// H10302 is the 37-bit format without a facility code: an even parity bit, a 35-bit card number,
+// and an odd parity bit
+var H10302 = &Format{
+	Name:   "h10302",
+	Bits:   37,
+	card:   field{1, 35},
+	parity: []parityBit{{0, false, between(1, 19)}, {36, true, between(18, 36)}},
+}
+
+// H10304 is the 37-bit format: an even parity bit, a 16-bit facility code, a 19-bit card number,
+// and an odd parity bit
+var H10304 = &Format{
+	Name:     "h10304",
+	Bits:     37,
+	facility: field{1, 16},
+	card:     field{17, 19},
+	parity:   []parityBit{{0, false, between(1, 19)}, {36, true, between(18, 36)}},
+}
+
+// Corporate1000 is HID's 35-bit Corporate 1000 format: an odd parity bit over the whole message, an even parity bit,
+// a 12-bit company ID (the facility code), a 20-bit card number, and an odd parity bit.
+// The inner parity bits each cover two of every three bits
+var Corporate1000 = &Format{
+	Name:     "corporate1000",
+	Bits:     35,
+	facility: field{2, 12},
+	card:     field{14, 20},
+	parity: []parityBit{
+		{1, false, func(i int) bool { return i >= 2 && i < 34 && i%3 != 1 }},
+		{34, true, func(i int) bool { return i >= 1 && i < 33 && i%3 != 0 }},
+		{0, true, between(1, 35)},
+	},
+}
+
+var formats = map[string]*Format{
+	H10301.Name:        H10301,
+	H10302.Name:        H10302,
+	H10304.Name:        H10304,
+	H10306.Name:        H10306,
+	Corporate1000.Name: Corporate1000,
+}
+
+// Formats returns the names of the supported formats, sorted
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupFormat returns the format with name, ignoring case
+func LookupFormat(name string) (*Format, error) {
+	f, ok := formats[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q (must be one of %s)", ErrUnknownFormat, name, strings.Join(Formats(), ", "))
+	}
+	return f, nil
+}
+
+// MaxFacility returns the largest facility code f can hold
+func (f *Format) MaxFacility() uint64 {
+	return f.facility.max()
+}
+
+// MaxCard returns the largest card number f can hold
+func (f *Format) MaxCard() uint64 {
+	return f.card.max()
+}
+
+// Fits returns true if f can hold facility code and card number
+func (f *Format) Fits(facility, number uint64) bool {
+	return facility <= f.MaxFacility() && number <= f.MaxCard()
+}
+
+// Card is a card in each of its representations
+type Card struct {
+	Format   string `json:"format"`
+	Facility uint64 `json:"facility_code"`
+	Number   uint64 `json:"card_number"`
+	// Bits is the Wiegand message, first bit transmitted first
+	Bits string `json:"bits"`
+	// Hex is the Wiegand message as a right-aligned hex number, the way most readers output raw reads
+	Hex string `json:"hex"`
+}
+
+func (f *Format) bit(msg uint64, i int) uint64 {
+	return msg >> uint(f.Bits-1-i) & 1
+}
+
+func (f *Format) get(msg uint64, fd field) uint64 {
+	return msg >> uint(f.Bits-fd.start-fd.length) & fd.max()
+}
+
+func (f *Format) set(msg uint64, fd field, v uint64) uint64 {
+	return msg | v<<uint(f.Bits-fd.start-fd.length)
+}
+
+// ones returns how many bits p covers are set in msg
+func (f *Format) ones(msg uint64, p parityBit) int {
+	n := 0
+	for i := 0; i < f.Bits; i++ {
+		if i != p.pos && p.covers(i) {
+			n += int(f.bit(msg, i))
+		}
+	}
+	return n
+}
+
+func (f *Format) toCard(msg uint64) *Card {
+	return &Card{
+		Format:   f.Name,
+		Facility: f.get(msg, f.facility),
+		Number:   f.get(msg, f.card),
+		Bits:     fmt.Sprintf("%0*b", f.Bits, msg),
+		Hex:      fmt.Sprintf("%0*X", (f.Bits+3)/4, msg),
+	}
+}
+
+// Encode returns the card with facility code and card number
+func (f *Format) Encode(facility, number uint64) (*Card, error) {
+	if facility > f.MaxFacility() {
+		return nil, fmt.Errorf("%w: facility code must be at most %d for %s", ErrOutOfRange, f.MaxFacility(), f.Name)
+	}
+	if number > f.MaxCard() {
+		return nil, fmt.Errorf("%w: card number must be at most %d for %s", ErrOutOfRange, f.MaxCard(), f.Name)
+	}
+
+	msg := f.set(f.set(0, f.facility, facility), f.card, number)
+	for _, p := range f.parity {
+		odd := f.ones(msg, p)%2 == 1
+		if odd != p.odd {
+			msg |= 1 << uint(f.Bits-1-p.pos)
+		}
+	}
+	return f.toCard(msg), nil
+}
+
+// decode checks msg's parity and returns its card
+func (f *Format) decode(msg uint64) (*Card, error) {
+	for _, p := range f.parity {
+		odd := (f.ones(msg, p)+int(f.bit(msg, p.pos)))%2 == 1
+		if odd != p.odd {
+			return nil, fmt.Errorf("%w: bit %d", ErrParity, p.pos)
+		}
+	}
+	return f.toCard(msg), nil
+}
+
+// FromBits returns the card for a Wiegand message given as a string of 0s and 1s, first bit transmitted first
+func (f *Format) FromBits(bits string) (*Card, error) {
+	bits = strings.TrimSpace(bits)
+	if len(bits) != f.Bits {
+		return nil, fmt.Errorf("%w: must be %d bits for %s, got %d", ErrInvalidBits, f.Bits, f.Name, len(bits))
+	}
+	msg, err := strconv.ParseUint(bits, 2, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: must only contain 0 and 1", ErrInvalidBits)
+	}
+	return f.decode(msg)
+}
+
+// FromHex returns the card for a Wiegand message given as a right-aligned hex number, e.g. 0x2F764DD or 2f764dd
+func (f *Format) FromHex(hex string) (*Card, error) {
+	hex = strings.TrimSpace(hex)
+	if strings.HasPrefix(hex, "0x") || strings.HasPrefix(hex, "0X") {
+		hex = hex[2:]
+	}
+	msg, err := strconv.ParseUint(hex, 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHex, hex)
+	}
+	if f.Bits < 64 && msg>>uint(f.Bits) != 0 {
+		return nil, fmt.Errorf("%w: %q is longer than %d bits for %s", ErrInvalidHex, hex, f.Bits, f.Name)
+	}
+	return f.decode(msg)
+}
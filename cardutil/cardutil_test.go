@@ -0,0 +1,142 @@
+package cardutil
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// The messages below were worked out by hand from each format's layout, independently of Encode
+var knownCards = []struct {
+	format   *Format
+	facility uint64
+	number   uint64
+	bits     string
+	hex      string
+}{
+	{H10301, 123, 45678, "10111101110110010011011101", "2F764DD"},
+	{H10301, 0, 0, "00000000000000000000000001", "0000001"},
+	{H10301, 255, 65535, "01111111111111111111111111", "1FFFFFF"},
+	{H10306, 1234, 56789, "1000001001101001011011101110101010", "209A5BBAA"},
+	{H10302, 0, 123456789, "1000000001110101101111001101000101011", "100EB79A2B"},
+	{H10304, 4321, 456789, "1000100001110000111011111000010101011", "110E1DF0AB"},
+	{Corporate1000, 1001, 654321, "01001111101001100111111011111100011", "27D33F7E3"},
+}
+
+func TestEncode(t *testing.T) {
+	for _, test := range knownCards {
+		t.Run(test.format.Name+"/"+test.hex, func(t *testing.T) {
+			want := &Card{Format: test.format.Name, Facility: test.facility, Number: test.number, Bits: test.bits, Hex: test.hex}
+			have, err := test.format.Encode(test.facility, test.number)
+			if err != nil {
+				t.Fatalf("could not encode: %v", err)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("want %+v, have %+v", want, have)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range knownCards {
+		t.Run(test.format.Name+"/"+test.hex, func(t *testing.T) {
+			want := &Card{Format: test.format.Name, Facility: test.facility, Number: test.number, Bits: test.bits, Hex: test.hex}
+			for _, input := range []string{test.hex, "0x" + test.hex, " " + test.hex + " "} {
+				have, err := test.format.FromHex(input)
+				if err != nil {
+					t.Fatalf("could not decode hex %q: %v", input, err)
+				}
+				if !reflect.DeepEqual(have, want) {
+					t.Errorf("want %+v, have %+v", want, have)
+				}
+			}
+			have, err := test.format.FromBits(test.bits)
+			if err != nil {
+				t.Fatalf("could not decode bits: %v", err)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("want %+v, have %+v", want, have)
+			}
+
+			// flipping any bit fails a parity check
+			for i := range test.bits {
+				flipped := []byte(test.bits)
+				flipped[i] ^= 1
+				if _, err = test.format.FromBits(string(flipped)); !errors.Is(err, ErrParity) {
+					t.Errorf("bit %d: want %v, have %v", i, ErrParity, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOutOfRange(t *testing.T) {
+	tests := []struct {
+		format   *Format
+		facility uint64
+		number   uint64
+	}{
+		{H10301, 256, 0},
+		{H10301, 0, 65536},
+		{H10306, 65536, 0},
+		{H10306, 0, 65536},
+		{H10302, 1, 0},
+		{H10302, 0, 1 << 35},
+		{H10304, 65536, 0},
+		{H10304, 0, 524288},
+		{Corporate1000, 4096, 0},
+		{Corporate1000, 0, 1048576},
+	}
+	for _, test := range tests {
+		if test.format.Fits(test.facility, test.number) {
+			t.Errorf("%s: want %d, %d not to fit", test.format.Name, test.facility, test.number)
+		}
+		if _, err := test.format.Encode(test.facility, test.number); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("%s: want %v for %d, %d, have %v", test.format.Name, ErrOutOfRange, test.facility, test.number, err)
+		}
+	}
+
+	// the largest codes fit
+	for _, f := range []*Format{H10301, H10302, H10304, H10306, Corporate1000} {
+		if !f.Fits(f.MaxFacility(), f.MaxCard()) {
+			t.Errorf("%s: want %d, %d to fit", f.Name, f.MaxFacility(), f.MaxCard())
+		}
+		if _, err := f.Encode(f.MaxFacility(), f.MaxCard()); err != nil {
+			t.Errorf("%s: could not encode largest codes: %v", f.Name, err)
+		}
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func() (*Card, error)
+		want error
+	}{
+		{"short bits", func() (*Card, error) { return H10301.FromBits("1011") }, ErrInvalidBits},
+		{"long bits", func() (*Card, error) { return H10301.FromBits("101111011101100100110111010") }, ErrInvalidBits},
+		{"not bits", func() (*Card, error) { return H10301.FromBits("1011110111011001001101110x") }, ErrInvalidBits},
+		{"not hex", func() (*Card, error) { return H10301.FromHex("2F764DG") }, ErrInvalidHex},
+		{"hex too long", func() (*Card, error) { return H10301.FromHex("4000000") }, ErrInvalidHex},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.f(); !errors.Is(err, test.want) {
+				t.Errorf("want %v, have %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestLookupFormat(t *testing.T) {
+	if f, err := LookupFormat(" H10301 "); err != nil || f != H10301 {
+		t.Errorf("want %s, have %v, %v", H10301.Name, f, err)
+	}
+	if _, err := LookupFormat("h10303"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("want %v, have %v", ErrUnknownFormat, err)
+	}
+	if want := []string{"corporate1000", "h10301", "h10302", "h10304", "h10306"}; !reflect.DeepEqual(Formats(), want) {
+		t.Errorf("want %v, have %v", want, Formats())
+	}
+}
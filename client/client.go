@@ -0,0 +1,187 @@
+// Package client is a Go client for the infinias-api HTTP API (/api/1.0)
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMaxRetries = 3
+	DefaultBackoff    = 500 * time.Millisecond
+)
+
+// Error is an error response from the API
+type Error struct {
+	StatusCode  int             `json:"code"`
+	Description string          `json:"description"`
+	RequestID   string          `json:"request_id,omitempty"`
+	Detail      json.RawMessage `json:"detail,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s (request id %s)", e.Description, e.RequestID)
+	}
+	return e.Description
+}
+
+// IsNotFound returns true if err is a 404 response
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// IsConflict returns true if err is a 409 response, e.g. when a credential already exists
+func IsConflict(err error) bool {
+	return statusCode(err) == http.StatusConflict
+}
+
+func statusCode(err error) int {
+	e := new(Error)
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// Client is an infinias-api client
+type Client struct {
+	// BaseURL is the API root, e.g. https://infinias-api.example.com/api/1.0
+	BaseURL *url.URL
+	// Token is sent as a bearer token if set
+	Token string
+	// HTTPClient is used to make requests. http.DefaultClient is used if nil
+	HTTPClient *http.Client
+	// MaxRetries is the number of times a request is retried after a network error, 429, or 5xx response
+	MaxRetries int
+	// Backoff is the delay before the first retry. It doubles for each retry
+	Backoff time.Duration
+	// UserAgent is sent with every request if set
+	UserAgent string
+}
+
+// New returns a new Client for the API at baseURL, e.g. https://infinias-api.example.com/api/1.0
+func New(baseURL, token string) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse base url: %w", err)
+	}
+	return &Client{BaseURL: u, Token: token, MaxRetries: DefaultMaxRetries, Backoff: DefaultBackoff}, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func newIdempotencyKey() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("could not generate idempotency key: %w", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// do sends a request with an optional JSON body, decoding a JSON response into out if it isn't nil.
+// POST requests are sent with an Idempotency-Key so they can be retried safely
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := *c.BaseURL
+	u.Path += path
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	var buf []byte
+	if body != nil {
+		var err error
+		if buf, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("could not encode body: %w", err)
+		}
+	}
+
+	idemKey := ""
+	if method == http.MethodPost {
+		idemKey = newIdempotencyKey()
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(buf))
+		if err != nil {
+			return fmt.Errorf("could not create request: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+		if c.UserAgent != "" {
+			req.Header.Set("User-Agent", c.UserAgent)
+		}
+		if idemKey != "" {
+			req.Header.Set("Idempotency-Key", idemKey)
+		}
+
+		err = c.send(req, out)
+		if err == nil {
+			return nil
+		}
+
+		// don't retry client errors or cancellations
+		if code := statusCode(err); (code != 0 && !retryable(code)) || ctx.Err() != nil || attempt >= c.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("could not %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		e := &Error{StatusCode: resp.StatusCode, Description: resp.Status}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
+			e.StatusCode, e.Description = resp.StatusCode, resp.Status
+		}
+		return e
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Person struct {
+	ID          int           `json:"id,omitempty"`
+	FirstName   string        `json:"first_name"`
+	LastName    string        `json:"last_name"`
+	EmployeeID  string        `json:"employee_id"`
+	Department  string        `json:"department"`
+	SiteCode    int           `json:"site_code"`
+	CardCode    int           `json:"card_code"`
+	Image       []byte        `json:"image,omitempty"`
+	HasImage    bool          `json:"has_image,omitempty"`
+	GroupsToAdd []int         `json:"groups_to_add,omitempty"`
+	Credentials []*Credential `json:"credentials,omitempty"`
+	// TerminationDate is when the server deactivates the person. It's cleared with ClearTerminationDate
+	TerminationDate *time.Time `json:"termination_date,omitempty"`
+}
+
+type Credential struct {
+	ID         int        `json:"id,omitempty"`
+	Active     bool       `json:"active"`
+	SiteCode   int        `json:"site_code"`
+	CardCode   int        `json:"card_code"`
+	Activation *time.Time `json:"activation,omitempty"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+	// ClearActivation and ClearExpiration remove Activation and Expiration when updating an existing credential
+	ClearActivation bool `json:"clear_activation,omitempty"`
+	ClearExpiration bool `json:"clear_expiration,omitempty"`
+	// TTL, e.g. 8h or 30d, sets Expiration when the credential is created
+	TTL string `json:"ttl,omitempty"`
+	// OnExpiry is deactivate (the default) or delete
+	OnExpiry string `json:"on_expiry,omitempty"`
+}
+
+type Group struct {
+	ID          int    `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// ListOptions controls ListPeople
+type ListOptions struct {
+	// Sort is a list of fields to sort by, each optionally prefixed with - for descending order
+	Sort []string
+	// Fields limits the fields returned for each person
+	Fields []string
+	// GroupID, if set, only returns members of the group
+	GroupID int
+	// HasImage, if set, only returns people with or without a picture
+	HasImage *bool
+	// HasCredential, if set, only returns people with or without a credential that's currently active
+	HasCredential *bool
+}
+
+func (o *ListOptions) query() url.Values {
+	q := make(url.Values)
+	if o == nil {
+		return q
+	}
+	if len(o.Sort) > 0 {
+		q.Set("sort", strings.Join(o.Sort, ","))
+	}
+	if len(o.Fields) > 0 {
+		q.Set("fields", strings.Join(o.Fields, ","))
+	}
+	if o.GroupID != 0 {
+		q.Set("group_id", strconv.Itoa(o.GroupID))
+	}
+	if o.HasImage != nil {
+		q.Set("has_image", strconv.FormatBool(*o.HasImage))
+	}
+	if o.HasCredential != nil {
+		q.Set("has_credential", strconv.FormatBool(*o.HasCredential))
+	}
+	return q
+}
+
+func (c *Client) ListPeople(ctx context.Context, opts *ListOptions) ([]*Person, error) {
+	var people []*Person
+	if err := c.do(ctx, http.MethodGet, "/people", opts.query(), nil, &people); err != nil {
+		return nil, fmt.Errorf("could not list people: %w", err)
+	}
+	return people, nil
+}
+
+func (c *Client) GetPerson(ctx context.Context, id int) (*Person, error) {
+	p := new(Person)
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/people/%d", id), nil, nil, p); err != nil {
+		return nil, fmt.Errorf("could not get person: %w", err)
+	}
+	return p, nil
+}
+
+// CreatePerson creates p and returns the created person
+func (c *Client) CreatePerson(ctx context.Context, p *Person) (*Person, error) {
+	out := new(Person)
+	if err := c.do(ctx, http.MethodPost, "/people", nil, p, out); err != nil {
+		return nil, fmt.Errorf("could not create person: %w", err)
+	}
+	return out, nil
+}
+
+// UpdatePerson updates the person with p.ID
+func (c *Client) UpdatePerson(ctx context.Context, p *Person) (*Person, error) {
+	out := new(Person)
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/people/%d", p.ID), nil, p, out); err != nil {
+		return nil, fmt.Errorf("could not update person: %w", err)
+	}
+	return out, nil
+}
+
+func (c *Client) DeletePerson(ctx context.Context, id int) error {
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/people/%d", id), nil, nil, nil); err != nil {
+		return fmt.Errorf("could not delete person: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ClearTerminationDate(ctx context.Context, id int) error {
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/people/%d/termination_date", id), nil, nil, nil); err != nil {
+		return fmt.Errorf("could not clear termination date: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ListCredentials(ctx context.Context, id int) ([]*Credential, error) {
+	var creds []*Credential
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/people/%d/credentials", id), nil, nil, &creds); err != nil {
+		return nil, fmt.Errorf("could not list credentials: %w", err)
+	}
+	return creds, nil
+}
+
+func (c *Client) GetCredential(ctx context.Context, id, credID int) (*Credential, error) {
+	cred := new(Credential)
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/people/%d/credentials/%d", id, credID), nil, nil, cred); err != nil {
+		return nil, fmt.Errorf("could not get credential: %w", err)
+	}
+	return cred, nil
+}
+
+// CreateCredential adds cred to the person with the given id and returns the created credential.
+// If the credential belongs to another person, IsConflict(err) is true
+func (c *Client) CreateCredential(ctx context.Context, id int, cred *Credential) (*Credential, error) {
+	out := new(Credential)
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/people/%d/credentials", id), nil, cred, out); err != nil {
+		return nil, fmt.Errorf("could not create credential: %w", err)
+	}
+	return out, nil
+}
+
+func (c *Client) DeleteCredential(ctx context.Context, id, credID int) error {
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/people/%d/credentials/%d", id, credID), nil, nil, nil); err != nil {
+		return fmt.Errorf("could not delete credential: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ListPersonGroups(ctx context.Context, id int) ([]*Group, error) {
+	var groups []*Group
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/people/%d/groups", id), nil, nil, &groups); err != nil {
+		return nil, fmt.Errorf("could not list person groups: %w", err)
+	}
+	return groups, nil
+}
+
+func (c *Client) AddPersonGroup(ctx context.Context, id, groupID int) error {
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/people/%d/groups/%d", id, groupID), nil, nil, nil); err != nil {
+		return fmt.Errorf("could not add group: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) RemovePersonGroup(ctx context.Context, id, groupID int) error {
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/people/%d/groups/%d", id, groupID), nil, nil, nil); err != nil {
+		return fmt.Errorf("could not remove group: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) ListGroups(ctx context.Context) ([]*Group, error) {
+	var groups []*Group
+	if err := c.do(ctx, http.MethodGet, "/groups", nil, nil, &groups); err != nil {
+		return nil, fmt.Errorf("could not list groups: %w", err)
+	}
+	return groups, nil
+}
+
+func (c *Client) CreateGroup(ctx context.Context, g *Group) (*Group, error) {
+	out := new(Group)
+	if err := c.do(ctx, http.MethodPost, "/groups", nil, g, out); err != nil {
+		return nil, fmt.Errorf("could not create group: %w", err)
+	}
+	return out, nil
+}
+
+// UpdateGroup updates the group with g.ID
+func (c *Client) UpdateGroup(ctx context.Context, g *Group) (*Group, error) {
+	out := new(Group)
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/groups/%d", g.ID), nil, g, out); err != nil {
+		return nil, fmt.Errorf("could not update group: %w", err)
+	}
+	return out, nil
+}
+
+func (c *Client) DeleteGroup(ctx context.Context, id int) error {
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/groups/%d", id), nil, nil, nil); err != nil {
+		return fmt.Errorf("could not delete group: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultWebhookTolerance is how far a webhook's signing time may be from now before VerifyWebhook rejects it
+const DefaultWebhookTolerance = 5 * time.Minute
+
+const webhookSignatureHeader = "X-Signature"
+
+var (
+	ErrMissingSignature = errors.New("missing webhook signature")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrSignatureExpired = errors.New("webhook signature outside tolerance")
+)
+
+// VerifyWebhook checks that a webhook request's X-Signature header was made with secret over body, and that it was
+// signed within tolerance of now, so captured requests can't be replayed later. If tolerance is zero,
+// DefaultWebhookTolerance is used. Receivers can also reject repeated X-Webhook-ID headers within the tolerance
+func VerifyWebhook(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	if tolerance <= 0 {
+		tolerance = DefaultWebhookTolerance
+	}
+
+	str := header.Get(webhookSignatureHeader)
+	if str == "" {
+		return ErrMissingSignature
+	}
+
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(str, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return ErrInvalidSignature
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sig, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return ErrInvalidSignature
+	}
+	if d := time.Since(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
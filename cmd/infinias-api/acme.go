@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager returns a certificate manager for the configured ACME domains, or nil if none are configured
+func acmeManager(config *Config) (*autocert.Manager, error) {
+	c := config.HTTP.ACME
+	if len(c.Domains) == 0 {
+		return nil, nil
+	}
+	if config.HTTP.TLSCert != "" || config.HTTP.TLSKey != "" {
+		return nil, errors.New("acme can't be used with tls_cert and tls_key")
+	}
+
+	switch c.Challenge {
+	case "", "http-01", "tls-alpn-01":
+	case "dns-01":
+		return nil, errors.New("dns-01 challenges aren't supported; use http-01 or tls-alpn-01")
+	default:
+		return nil, fmt.Errorf("unknown acme challenge %q", c.Challenge)
+	}
+
+	dir := c.CacheDir
+	if dir == "" {
+		dir = filepath.Join(DefaultRoot, "acme")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Cache:      autocert.DirCache(dir),
+		Email:      c.Email,
+	}
+	if c.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: c.DirectoryURL}
+	}
+
+	return m, nil
+}
+
+// serveACMEChallenges answers HTTP-01 challenges for m on addr until stop is called. Other requests are redirected to HTTPS
+func serveACMEChallenges(m *autocert.Manager, addr string, logger infinias.Logger) (stop func(), err error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen: %w", err)
+	}
+
+	server := &http.Server{Handler: m.HTTPHandler(nil), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("acme challenge listener stopped", "error", err)
+		}
+	}()
+
+	logger.Info("acme challenge listening", "addr", l.Addr().String())
+	return func() { server.Close() }, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+)
+
+var ErrUsage = errors.New("usage")
+
+const commandUsage = `commands:
+  people list                 print all people as JSON
+  people get <id>             print a person as JSON
+  people create               create a person from JSON on stdin and print its id
+  credentials list <id>       print a person's credentials as JSON
+  export csv                  print all people as CSV
+  picture get <id> [file]     write a person's picture to file, or stdout
+  picture put <id> <file|->   set a person's picture from file, or stdin
+  ping                        check connectivity to the Infinias API and database`
+
+// commandService returns a Service connected with config.yaml for CLI commands
+func commandService() (*infinias.Service, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	budget := infinias.NewAPIBudget(config.APIBudget.MaxConcurrent, config.APIBudget.CallsPerMinute, config.APIBudget.Burst)
+	apiConn, dbConn, err := connect(config, nil, nil, budget, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := newLogger(config, log.Writer(), infinias.NewLevelVar(infinias.LevelWarn))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &infinias.Service{APIConn: apiConn, DBConn: dbConn, Log: logger, APIBudget: budget}
+	if err = configureData(s, config); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func parseID(args []string, idx int) (int, error) {
+	if len(args) <= idx {
+		return 0, ErrUsage
+	}
+	id, err := strconv.Atoi(args[idx])
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid id %q", args[idx])
+	}
+	return id, nil
+}
+
+// runCommand runs the CLI command in args
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	if len(args) == 1 && args[0] == "ping" {
+		return ping()
+	}
+	if len(args) < 2 {
+		return ErrUsage
+	}
+
+	cmd := args[0] + " " + args[1]
+	switch cmd {
+	case "people list", "people get", "people create", "credentials list", "export csv", "picture get", "picture put":
+	default:
+		return ErrUsage
+	}
+
+	s, err := commandService()
+	if err != nil {
+		return err
+	}
+	defer s.DBConn.Close()
+
+	switch cmd {
+	case "people list":
+		people, err := s.ListPeople()
+		if err != nil {
+			return err
+		}
+		return printJSON(people)
+	case "people get":
+		id, err := parseID(args, 2)
+		if err != nil {
+			return err
+		}
+		p, err := s.ReadPerson(id)
+		if err != nil {
+			return err
+		}
+		p.Image = nil
+		return printJSON(p)
+	case "people create":
+		p := new(infinias.Person)
+		if err := json.NewDecoder(os.Stdin).Decode(p); err != nil {
+			return fmt.Errorf("could not parse person: %w", err)
+		}
+		id, err := s.CreatePerson(p)
+		// with a partial error, the person was still created
+		if id != 0 {
+			fmt.Println(id)
+		}
+		if err != nil {
+			return err
+		}
+		return nil
+	case "credentials list":
+		id, err := parseID(args, 2)
+		if err != nil {
+			return err
+		}
+		creds, err := s.ListCredentials(id)
+		if err != nil {
+			return err
+		}
+		return printJSON(creds)
+	case "export csv":
+		return exportCSV(s, os.Stdout)
+	case "picture get":
+		return getPicture(s, args)
+	case "picture put":
+		return putPicture(s, args)
+	}
+	return ErrUsage
+}
+
+// exportCSV writes all people to w as CSV
+func exportCSV(s *infinias.Service, w io.Writer) error {
+	people, err := s.ListPeople()
+	if err != nil {
+		return err
+	}
+
+	c := csv.NewWriter(w)
+	c.Write([]string{"id", "first_name", "last_name", "employee_id", "department", "site_code", "card_code", "has_image"})
+	for _, p := range people {
+		c.Write([]string{strconv.Itoa(p.ID), p.FirstName, p.LastName, p.EmployeeID, p.Department,
+			strconv.Itoa(p.SiteCode), strconv.Itoa(p.CardCode), strconv.FormatBool(p.HasImage)})
+	}
+	c.Flush()
+	return c.Error()
+}
+
+func getPicture(s *infinias.Service, args []string) error {
+	id, err := parseID(args, 2)
+	if err != nil {
+		return err
+	}
+	buf, err := s.ReadPicture(id)
+	if err != nil {
+		return err
+	}
+	if len(args) < 4 || args[3] == "-" {
+		_, err = os.Stdout.Write(buf)
+		return err
+	}
+	return ioutil.WriteFile(args[3], buf, 0644)
+}
+
+func putPicture(s *infinias.Service, args []string) error {
+	id, err := parseID(args, 2)
+	if err != nil {
+		return err
+	}
+	if len(args) < 4 {
+		return ErrUsage
+	}
+
+	var buf []byte
+	if args[3] == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(args[3])
+	}
+	if err != nil {
+		return fmt.Errorf("could not read picture: %w", err)
+	}
+	return s.UpdatePicture(id, buf)
+}
+
+// ping checks connectivity to the Infinias API and database, printing the latency of each
+func ping() error {
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	apiConn, dbConn, err := connect(config, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	fmt.Println("database: ok", time.Since(start).Round(time.Millisecond))
+
+	start = time.Now()
+	if _, err = apiConn.ListGroups(); err != nil {
+		return fmt.Errorf("could not reach infinias api: %w", err)
+	}
+	fmt.Println("infinias api: ok", time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+)
+
+var ErrDiagnosticsNotLoopback = errors.New("diagnostics listener must be on a loopback address")
+
+var startTime = time.Now()
+
+// RuntimeStats is a snapshot of the Go runtime
+type RuntimeStats struct {
+	GoVersion    string        `json:"go_version"`
+	Uptime       time.Duration `json:"uptime_ns"`
+	NumCPU       int           `json:"num_cpu"`
+	GOMAXPROCS   int           `json:"gomaxprocs"`
+	Goroutines   int           `json:"goroutines"`
+	HeapAlloc    uint64        `json:"heap_alloc_bytes"`
+	HeapInuse    uint64        `json:"heap_inuse_bytes"`
+	HeapIdle     uint64        `json:"heap_idle_bytes"`
+	HeapReleased uint64        `json:"heap_released_bytes"`
+	HeapObjects  uint64        `json:"heap_objects"`
+	TotalAlloc   uint64        `json:"total_alloc_bytes"`
+	Sys          uint64        `json:"sys_bytes"`
+	NumGC        uint32        `json:"num_gc"`
+	PauseTotal   time.Duration `json:"gc_pause_total_ns"`
+	LastGC       time.Time     `json:"last_gc"`
+}
+
+func readRuntimeStats() *RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return &RuntimeStats{
+		GoVersion:    runtime.Version(),
+		Uptime:       time.Since(startTime),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapIdle:     m.HeapIdle,
+		HeapReleased: m.HeapReleased,
+		HeapObjects:  m.HeapObjects,
+		TotalAlloc:   m.TotalAlloc,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotal:   time.Duration(m.PauseTotalNs),
+		LastGC:       time.Unix(0, int64(m.LastGC)).UTC(),
+	}
+}
+
+// diagnosticsHandler serves pprof profiles under /debug/pprof/ and runtime stats at /debug/runtime
+func diagnosticsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(readRuntimeStats())
+	})
+	return mux
+}
+
+// checkLoopback returns an error if addr's host isn't a loopback address
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("could not parse address: %w", err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrDiagnosticsNotLoopback, addr)
+}
+
+// startDiagnostics serves diagnosticsHandler on the loopback address addr until stop is called
+func startDiagnostics(addr string, logger infinias.Logger) (stop func(), err error) {
+	if err = checkLoopback(addr); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen: %w", err)
+	}
+
+	// profiles can take longer than any reasonable write timeout, so only reads are limited
+	server := &http.Server{Handler: diagnosticsHandler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("diagnostics listener stopped", "error", err)
+		}
+	}()
+
+	logger.Info("diagnostics listening", "addr", l.Addr().String())
+	return func() { server.Close() }, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// encPrefix marks a config value encrypted with the config key
+const encPrefix = "enc:"
+
+// ConfigKeyEnv is the environment variable holding the base64 encoded 32 byte key for enc: values.
+// If it's not set, the key is read from config.key, encrypted with DPAPI
+const ConfigKeyEnv = EnvPrefix + "CONFIG_KEY"
+
+const configKeySize = 32
+
+var errNoConfigKey = fmt.Errorf("no config key: set %s or create one with -encrypt-value", ConfigKeyEnv)
+
+func configKeyPath() string {
+	return filepath.Join(DefaultRoot, "config.key")
+}
+
+// readConfigKey returns the key for enc: values from ConfigKeyEnv or config.key
+func readConfigKey() ([]byte, error) {
+	if s := os.Getenv(ConfigKeyEnv); s != "" {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(key) != configKeySize {
+			return nil, fmt.Errorf("%s must be a base64 encoded %d byte key", ConfigKeyEnv, configKeySize)
+		}
+		return key, nil
+	}
+
+	buf, err := os.ReadFile(configKeyPath())
+	if os.IsNotExist(err) {
+		return nil, errNoConfigKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read config key: %w", err)
+	}
+	key, err := unprotectSecret(buf)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt config key: %w", err)
+	}
+	if len(key) != configKeySize {
+		return nil, errors.New("config key is invalid")
+	}
+	return key, nil
+}
+
+// createConfigKey creates a new random key and writes it to config.key, encrypted with DPAPI
+func createConfigKey() ([]byte, error) {
+	key := make([]byte, configKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate config key: %w", err)
+	}
+	buf, err := protectSecret(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt config key (set %s instead): %w", ConfigKeyEnv, err)
+	}
+	if err = os.MkdirAll(filepath.Dir(configKeyPath()), 0755); err != nil {
+		return nil, fmt.Errorf("could not create config key directory: %w", err)
+	}
+	if err = os.WriteFile(configKeyPath(), buf, 0600); err != nil {
+		return nil, fmt.Errorf("could not write config key: %w", err)
+	}
+	return key, nil
+}
+
+func encryptValue(key []byte, value string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("could not create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("could not create cipher: %w", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+	return encPrefix + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+func decryptValue(key []byte, value string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encPrefix))
+	if err != nil {
+		return "", fmt.Errorf("could not decode value: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", fmt.Errorf("could not create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("could not create cipher: %w", err)
+	}
+	if len(buf) < gcm.NonceSize() {
+		return "", errors.New("value is too short")
+	}
+	plain, err := gcm.Open(nil, buf[:gcm.NonceSize()], buf[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("could not decrypt value: wrong key or corrupted value")
+	}
+	return string(plain), nil
+}
+
+// configDecrypter decrypts enc: values, reading the config key the first time it's needed
+type configDecrypter struct {
+	key []byte
+}
+
+func (d *configDecrypter) decrypt(value string) (string, error) {
+	if d.key == nil {
+		key, err := readConfigKey()
+		if err != nil {
+			return "", err
+		}
+		d.key = key
+	}
+	return decryptValue(d.key, value)
+}
+
+// decryptValues replaces enc: strings in v, including in nested structs, lists, and maps. path names v in errors
+func (d *configDecrypter) decryptValues(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !strings.HasPrefix(v.String(), encPrefix) {
+			return nil
+		}
+		s, err := d.decrypt(v.String())
+		if err != nil {
+			return fmt.Errorf("could not decrypt %s: %w", path, err)
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return d.decryptValues(v.Elem(), path)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if tag == "" || tag == "-" {
+				continue
+			}
+			name := tag
+			if path != "" {
+				name = path + "." + tag
+			}
+			if err := d.decryptValues(v.Field(i), name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.decryptValues(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if !strings.HasPrefix(iter.Value().String(), encPrefix) {
+				continue
+			}
+			s, err := d.decrypt(iter.Value().String())
+			if err != nil {
+				return fmt.Errorf("could not decrypt %s.%v: %w", path, iter.Key(), err)
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(s).Convert(v.Type().Elem()))
+		}
+	}
+	return nil
+}
+
+// decryptConfig replaces enc: values in config with their plaintext values
+func decryptConfig(config *Config) error {
+	return new(configDecrypter).decryptValues(reflect.ValueOf(config).Elem(), "")
+}
+
+// encryptConfigValue reads a value from the first line of stdin and prints it encrypted for config.yaml,
+// creating config.key if there's no config key
+func encryptConfigValue() error {
+	value, err := readLine()
+	if err != nil {
+		return err
+	}
+
+	key, err := readConfigKey()
+	if err == errNoConfigKey {
+		if key, err = createConfigKey(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "created config key:", configKeyPath())
+	}
+	if err != nil {
+		return err
+	}
+
+	enc, err := encryptValue(key, value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(enc)
+	return nil
+}
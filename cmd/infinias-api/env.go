@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variables read in container mode
+const EnvPrefix = "INFINIAS_API_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// readEnvConfig returns the config from environment variables named after its yaml keys, e.g. INFINIAS_API_DB_HOST.
+// Durations use time.ParseDuration syntax, string lists are comma separated,
+// and other lists and maps are YAML or JSON, e.g. INFINIAS_API_HTTP_API_KEYS='[{"name": "app", ...}]'
+func readEnvConfig() (*Config, error) {
+	config := new(Config)
+	if err := decodeEnv(reflect.ValueOf(config).Elem(), EnvPrefix); err != nil {
+		return nil, fmt.Errorf("could not parse config: %w", err)
+	}
+
+	if err := resolveSecrets(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// decodeEnv sets the fields of the struct v from environment variables named prefix plus their uppercased yaml keys
+func decodeEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(tag)
+
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct {
+			if err := decodeEnv(f, name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(f, s); err != nil {
+			return fmt.Errorf("could not parse %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setEnvValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err := setEnvValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return yaml.Unmarshal([]byte(s), v.Addr().Interface())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return yaml.Unmarshal([]byte(s), v.Addr().Interface())
+	}
+	return nil
+}
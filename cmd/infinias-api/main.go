@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -8,14 +12,29 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/judwhite/go-svc"
 	"github.com/korylprince/go-infinias-api"
 	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/cardutil"
 	"github.com/korylprince/go-infinias-api/cmd/infinias-api/service"
 	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/directory"
+	"github.com/korylprince/go-infinias-api/notify"
+	"github.com/korylprince/go-infinias-api/photo"
+	"github.com/korylprince/go-infinias-api/syslog"
+	"github.com/korylprince/go-infinias-api/tracing"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,24 +45,107 @@ var ServiceConfig = &service.ServiceConfig{
 	LogPath:     filepath.Join(DefaultRoot, "logs", "infinias-api.log"),
 	Name:        "infinias-api",
 	DisplayName: "Infinias API (Go)",
+
+	OnRetriesExhausted: alertRetriesExhausted,
+	NewLogger:          defaultLogger,
+
+	RestartDelay:       10 * time.Second,
+	FailureResetPeriod: 24 * time.Hour,
 }
 
-func run(w io.Writer) error {
-	f, err := os.Open(filepath.Join(DefaultRoot, "config.yaml"))
+func configPath() string {
+	return filepath.Join(DefaultRoot, "config.yaml")
+}
+
+// containerMode reads config from the environment instead of config.yaml and runs without the Windows service
+var containerMode bool
+
+func readConfig() (*Config, error) {
+	if containerMode {
+		return readEnvConfig()
+	}
+
+	f, err := os.Open(configPath())
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not open config: %w (an example is written to %s on install)", err, configTemplatePath())
+	}
 	if err != nil {
-		return fmt.Errorf("could not open config: %w", err)
+		return nil, fmt.Errorf("could not open config: %w", err)
 	}
+	defer f.Close()
 
 	config := new(Config)
 	if err = yaml.NewDecoder(f).Decode(config); err != nil {
-		f.Close()
-		return fmt.Errorf("could not parse config: %w", err)
+		return nil, fmt.Errorf("could not parse config: %w", err)
+	}
+
+	if err = resolveSecrets(config); err != nil {
+		return nil, err
 	}
-	f.Close()
 
+	return config, nil
+}
+
+// newLogger returns a Logger writing to w in the configured format
+func newLogger(config *Config, w io.Writer, level *infinias.LevelVar) (infinias.Logger, error) {
+	switch config.Log.Format {
+	case "", "text":
+		return infinias.NewLoggerVar(w, level), nil
+	case "json":
+		return infinias.NewJSONLogger(w, level), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", config.Log.Format)
+}
+
+// defaultLogger returns an info level Logger writing to w in the format from config.yaml, or text if it can't be read
+func defaultLogger(w io.Writer) infinias.Logger {
+	level := infinias.NewLevelVar(infinias.LevelInfo)
+	if config, err := readConfig(); err == nil {
+		if logger, err := newLogger(config, w, level); err == nil {
+			return logger
+		}
+	}
+	return infinias.NewLoggerVar(w, level)
+}
+
+// apiTransport returns the transport for API requests with the configured proxy, or nil to use http.DefaultTransport,
+// which uses the proxy environment variables
+func apiTransport(config APIConfig) (http.RoundTripper, error) {
+	if config.Proxy == "" {
+		return nil, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy == "direct" {
+		t.Proxy = nil
+		return t, nil
+	}
+
+	// the url may contain credentials, so it isn't included in errors
+	u, err := url.Parse(config.Proxy)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+		return nil, errors.New("could not parse api.proxy: must be an http, https, or socks5 url or direct")
+	}
+	t.Proxy = http.ProxyURL(u)
+	return t, nil
+}
+
+// connect connects to the API and database. If tracer is non-nil, requests and queries are traced.
+// If breaker or budget are non-nil, API requests are sent through them. If slow is non-nil, slow statements are logged
+func connect(config *Config, tracer *tracing.Tracer, breaker *infinias.Breaker, budget *infinias.APIBudget, slow *infinias.SlowLog) (*api.Conn, *db.Conn, error) {
 	apiConn, err := api.NewConn(config.API.Prefix, config.API.Username, config.API.Password)
 	if err != nil {
-		return fmt.Errorf("could not create api conn: %w", err)
+		return nil, nil, fmt.Errorf("could not create api conn: %w", err)
+	}
+	transport, err := apiTransport(config.API)
+	if err != nil {
+		return nil, nil, err
+	}
+	if transport != nil || tracer != nil || breaker != nil || budget != nil {
+		if tracer != nil {
+			transport = tracer.Transport(transport)
+		}
+		apiConn.Client = &http.Client{Transport: breaker.Transport(budget.Transport(transport))}
 	}
 
 	query := url.Values{}
@@ -56,40 +158,810 @@ func run(w io.Writer) error {
 
 	u := &url.URL{
 		Scheme:   "sqlserver",
-		User:     url.UserPassword(config.DB.Username, config.DB.Password),
 		Host:     host,
 		Path:     config.DB.Instance,
 		RawQuery: query.Encode(),
 	}
+	// without a username, Windows integrated authentication is used as the service account
+	if config.DB.Username != "" {
+		u.User = url.UserPassword(config.DB.Username, config.DB.Password)
+	}
+
+	var observers []tracing.QueryObserver
+	if slow != nil && slow.QueryThreshold > 0 {
+		observers = append(observers, slow.ObserveQuery)
+	}
+	dbConn, err := db.NewTracedConn(u.String(), tracer, observers...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create db conn: %w", err)
+	}
 
-	dbConn, err := db.NewConn(u.String())
+	return apiConn, dbConn, nil
+}
+
+// tracerShutdownTimeout limits exporting the remaining spans when a tracer is shut down
+const tracerShutdownTimeout = 5 * time.Second
+
+// newTracer returns the configured tracer and a function to flush its spans, or a nil tracer if tracing is disabled
+func newTracer(config *Config, logger infinias.Logger) (*tracing.Tracer, func()) {
+	if config.Tracing.Endpoint == "" {
+		return nil, func() {}
+	}
+
+	name := config.Tracing.ServiceName
+	if name == "" {
+		name = "infinias-api"
+	}
+	ratio := 1.0
+	if config.Tracing.SampleRatio != nil {
+		ratio = *config.Tracing.SampleRatio
+	}
+
+	tracer, err := tracing.New(config.Tracing.Endpoint, name, config.Tracing.Headers, ratio)
 	if err != nil {
-		return fmt.Errorf("could not create db conn: %w", err)
+		logger.Error("could not configure tracing", "error", err)
+		return nil, func() {}
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("could not export spans", "error", err)
+	}))
+
+	return tracer, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			logger.Warn("could not export spans", "error", err)
+		}
+	}
+}
+
+func newAlerts(config *Config, logger infinias.Logger) (*infinias.Alerts, error) {
+	if len(config.Alerts) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]*infinias.AlertRule, len(config.Alerts))
+	for idx, a := range config.Alerts {
+		var n notify.Notifier
+		switch a.Type {
+		case "slack":
+			n = notify.NewSlack(a.URL)
+		case "teams":
+			n = notify.NewTeams(a.URL)
+		case "smtp":
+			n = &notify.SMTP{Addr: a.SMTP.Addr, Username: a.SMTP.Username, Password: a.SMTP.Password, From: a.SMTP.From, To: a.SMTP.To}
+		default:
+			return nil, fmt.Errorf("unknown alert type %q", a.Type)
+		}
+		rules[idx] = &infinias.AlertRule{Notifier: n, Conditions: a.Conditions, EventTypes: a.EventTypes}
+	}
+
+	return infinias.NewAlerts(rules, logger), nil
+}
+
+// newSyslogSink returns a SyslogSink for config.Syslog
+func newSyslogSink(config *Config) (*infinias.SyslogSink, error) {
+	network, format, appName := config.Syslog.Network, config.Syslog.Format, config.Syslog.AppName
+	if network == "" {
+		network = "udp"
+	}
+	if format == "" {
+		format = infinias.SyslogFormatCEF
+	}
+	if appName == "" {
+		appName = "infinias-api"
+	}
+	w, err := syslog.New(network, config.Syslog.Addr, appName)
+	if err != nil {
+		return nil, err
+	}
+	return infinias.NewSyslogSink(w, format)
+}
+
+// alertRetriesExhausted sends an alert that the service has stopped restarting
+func alertRetriesExhausted(err error) {
+	config, cfgErr := readConfig()
+	if cfgErr != nil {
+		defaultLogger(log.Writer()).Error("could not send alert", "error", cfgErr)
+		return
+	}
+
+	logger, cfgErr := newLogger(config, log.Writer(), infinias.NewLevelVar(infinias.LevelWarn))
+	if cfgErr != nil {
+		defaultLogger(log.Writer()).Error("could not send alert", "error", cfgErr)
+		return
+	}
+
+	alerts, cfgErr := newAlerts(config, logger)
+	if cfgErr != nil {
+		logger.Error("could not send alert", "error", cfgErr)
+		return
 	}
 
+	alerts.SendSync(infinias.AlertRetryExhausted, &notify.Message{
+		Title: "Infinias API service stopped",
+		Text:  fmt.Sprintf("The service stopped restarting after repeated failures: %v", err),
+	})
+}
+
+// reconcile prints a reconciliation report to stdout
+func reconcile() error {
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	apiConn, dbConn, err := connect(config, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	report, err := (&infinias.Service{APIConn: apiConn, DBConn: dbConn}).Reconcile()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// readLine reads the first line of stdin, which must not be empty
+func readLine() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("could not read stdin: %w", err)
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return "", errors.New("input is empty")
+	}
+	return line, nil
+}
+
+// hashAPIKey reads an api key from the first line of stdin and prints its hash
+func hashAPIKey() error {
+	key, err := readLine()
+	if err != nil {
+		return err
+	}
+
+	hash, err := infinias.HashAPIKey(key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// run serves the API until ctx is canceled, restarting it in place when config.yaml changes in ways applyConfig can't apply.
+// The API and database are only reconnected when their configuration changes
+func run(ctx context.Context, w io.Writer) error {
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	l, err := infinias.ParseLevel(config.Log.Level)
+	if err != nil {
+		return fmt.Errorf("could not parse log level: %w", err)
+	}
+	level := infinias.NewLevelVar(l)
+	logger, err := newLogger(config, log.Writer(), level)
+	if err != nil {
+		return err
+	}
+
+	b := infinias.Build()
+	logger.Info("starting infinias-api", "version", b.Version, "commit", b.Commit, "build_date", b.BuildDate, "go_version", b.GoVersion)
+
+	reloads, stop := watchConfig(config.Reload.Interval, logger)
+	defer stop()
+
+	var conns *connections
+	defer func() { conns.close() }()
+
+	// prev is the last config that started successfully, used if a reloaded config fails
+	var prev *Config
+	for {
+		next, err := func() (*Config, error) {
+			if conns == nil || connectionChanged(conns.config, config) {
+				c, err := newConnections(config, logger)
+				if err != nil {
+					return nil, err
+				}
+				conns.close()
+				conns = c
+			}
+			return serve(ctx, w, config, conns, level, logger, reloads)
+		}()
+		if errors.Is(err, infinias.ErrWatchdogTripped) && ctx.Err() == nil {
+			watchdogRestarts++
+			logger.Warn("restarting after watchdog failure", "restarts", watchdogRestarts)
+			conns.close()
+			conns = nil
+			continue
+		}
+		if err != nil && prev != nil && ctx.Err() == nil {
+			logger.Error("could not restart with new config; reverting", "error", err)
+			config, prev = prev, nil
+			continue
+		}
+		if err != nil || next == nil {
+			return err
+		}
+		prev, config = config, next
+	}
+}
+
+// configureData sets s's image handling, picture storage, and validation from config
+func configureData(s *infinias.Service, config *Config) error {
+	var err error
+	s.ImageLimits = &photo.Limits{
+		MaxBytes:     config.Images.MaxSize,
+		MaxDimension: config.Images.MaxDimension,
+	}
+
+	if config.Images.Normalize || config.Images.CropAspectRatio != "" {
+		s.ImageNormalization = &photo.NormalizeOptions{
+			MaxDimension: config.Images.StoredMaxDimension,
+			Quality:      config.Images.JPEGQuality,
+			MaxBytes:     config.Images.StoredMaxSize,
+		}
+		if config.Images.CropAspectRatio != "" {
+			if s.ImageNormalization.AspectRatio, err = photo.ParseAspectRatio(config.Images.CropAspectRatio); err != nil {
+				return fmt.Errorf("could not parse images.crop_aspect_ratio: %w", err)
+			}
+		}
+	} else if config.Images.StoredMaxSize > 0 {
+		s.ImageCompression = &photo.NormalizeOptions{
+			MaxDimension: config.Images.StoredMaxDimension,
+			Quality:      config.Images.JPEGQuality,
+			MaxBytes:     config.Images.StoredMaxSize,
+		}
+	}
+
+	switch config.Images.Storage {
+	case "", "database":
+	case "file":
+		if s.Pictures, err = photo.NewFileStore(config.Images.Dir); err != nil {
+			return fmt.Errorf("could not create picture store: %w", err)
+		}
+	case "s3":
+		c := config.Images.S3
+		store := photo.NewS3Store(c.Endpoint, c.Region, c.Bucket, c.AccessKey, c.SecretKey)
+		store.Prefix = c.Prefix
+		store.PathStyle = c.PathStyle
+		s.Pictures = store
+	default:
+		return fmt.Errorf("could not create picture store: unknown images.storage %q", config.Images.Storage)
+	}
+
+	s.Validation = &infinias.PersonValidation{
+		MaxSiteCode: config.Validation.MaxSiteCode,
+		MaxCardCode: config.Validation.MaxCardCode,
+	}
+	if config.Validation.EmployeeIDPattern != "" {
+		if s.Validation.EmployeeIDPattern, err = regexp.Compile(config.Validation.EmployeeIDPattern); err != nil {
+			return fmt.Errorf("could not parse validation.employee_id_pattern: %w", err)
+		}
+	}
+	for _, name := range config.Validation.CardFormats {
+		f, err := cardutil.LookupFormat(name)
+		if err != nil {
+			return fmt.Errorf("could not parse validation.card_formats: %w", err)
+		}
+		s.Validation.CardFormats = append(s.Validation.CardFormats, f)
+	}
+
+	return nil
+}
+
+// serve serves the API until it fails, ctx is canceled, or a config is received from reloads that can't be applied while running.
+// In the last case, the server is shut down and the new config is returned. Otherwise the returned config is nil
+func serve(ctx context.Context, w io.Writer, config *Config, conns *connections, level *infinias.LevelVar, logger infinias.Logger, reloads <-chan *Config) (*Config, error) {
+	if config.Diagnostics.ListenAddr != "" {
+		stop, err := startDiagnostics(config.Diagnostics.ListenAddr, logger)
+		if err != nil {
+			return nil, fmt.Errorf("could not start diagnostics: %w", err)
+		}
+		defer stop()
+	}
+
+	shared, closeShared, err := newSharedCache(config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeShared()
+
 	s := &infinias.Service{
-		APIConn: apiConn,
-		DBConn:  dbConn,
-		Log:     func(msg string) { log.Println(msg) },
-		APIKey:  config.HTTP.APIKey,
+		APIConn:       conns.api,
+		DBConn:        conns.db,
+		Log:           logger,
+		Events:        infinias.NewEventStream(conns.db, config.Events.PollInterval, logger),
+		MaxBodySize:   config.HTTP.MaxBodySize,
+		Idempotency:   newIdempotencyStore(shared, sharedCachePrefix(config, "")),
+		Thumbnails:    infinias.NewThumbnailCache(infinias.DefaultThumbnailCacheSize, infinias.DefaultThumbnailCacheTTL),
+		EmployeeIndex: infinias.NewEmployeeIndex(),
+		ListCache:     newListCache(config, shared, sharedCachePrefix(config, "")),
+		Breaker:       conns.breaker,
+		APIBudget:     conns.budget,
+		SlowLog:       conns.slow,
+		AuthLimiter:   infinias.NewAuthLimiter(0, 0, 0, 0),
+		Usage:         infinias.NewUsage(),
+	}
+	// after a restart, the next stream uses the same cursor, so this one must stop polling before serve returns
+	defer s.Events.Close()
+
+	s, err = applyConfig(s, config, level)
+	if err != nil {
+		return nil, err
+	}
+	defer s.WatchEmployeeIndex(config.EmployeeIndex.RefreshInterval)()
+	defer s.WatchListCache(config.ListCache.RefreshInterval, config.ListCache.Debounce)()
+
+	if err = configureData(s, config); err != nil {
+		return nil, err
+	}
+
+	if s.MaxBodySize == 0 {
+		s.MaxBodySize = infinias.DefaultMaxBodySize
+	}
+
+	if config.HTTP.KeyStore != "" {
+		if s.Keys, err = infinias.NewKeyStore(config.HTTP.KeyStore); err != nil {
+			return nil, fmt.Errorf("could not load key store: %w", err)
+		}
+	}
+
+	if config.Events.CursorPath != "" {
+		s.Events.CursorPath = config.Events.CursorPath
+		defer s.Events.Ingest()()
+	}
+
+	if len(config.Occupancy.Zones) > 0 {
+		zones := make([]*infinias.OccupancyZone, len(config.Occupancy.Zones))
+		for idx, z := range config.Occupancy.Zones {
+			zones[idx] = &infinias.OccupancyZone{Name: z.Name, EntryDoors: z.EntryDoors, ExitDoors: z.ExitDoors}
+		}
+		s.Occupancy = infinias.NewOccupancy(zones, config.Occupancy.EventTypes)
+		defer s.Occupancy.Watch(s.Events)()
+	}
+
+	if config.Terminations.Path != "" {
+		if s.Terminations, err = infinias.NewTerminationStore(config.Terminations.Path); err != nil {
+			return nil, fmt.Errorf("could not load termination store: %w", err)
+		}
+		s.Terminations.RemoveGroups = config.Terminations.RemoveGroups
+		defer s.WatchTerminations(config.Terminations.CheckInterval)()
+	}
+
+	if config.Visitors.Path != "" {
+		if config.Visitors.FirstCard <= 0 || config.Visitors.LastCard < config.Visitors.FirstCard {
+			return nil, errors.New("visitors.first_card and visitors.last_card must be a valid card range")
+		}
+		if s.Visitors, err = infinias.NewVisitorStore(config.Visitors.Path); err != nil {
+			return nil, fmt.Errorf("could not load visitor store: %w", err)
+		}
+		s.Visitors.SiteCode = config.Visitors.SiteCode
+		s.Visitors.FirstCard, s.Visitors.LastCard = config.Visitors.FirstCard, config.Visitors.LastCard
+		s.Visitors.GroupID = config.Visitors.GroupID
+		s.Visitors.Department = config.Visitors.Department
+		defer s.WatchVisitors(config.Visitors.CheckInterval)()
+	}
+
+	if config.TemporaryCredentials.Path != "" {
+		if s.TemporaryCredentials, err = infinias.NewTemporaryCredentialStore(config.TemporaryCredentials.Path); err != nil {
+			return nil, fmt.Errorf("could not load temporary credential store: %w", err)
+		}
+	}
+	if s.TemporaryCredentials != nil || config.TemporaryCredentials.CheckInterval > 0 {
+		defer s.WatchCredentialExpiry(config.TemporaryCredentials.CheckInterval)()
+	}
+
+	if config.HTTP.OIDC.Issuer != "" {
+		s.OIDC = infinias.NewOIDC(config.HTTP.OIDC.Issuer, config.HTTP.OIDC.Audience)
+		s.OIDC.RequiredClaims = config.HTTP.OIDC.RequiredClaims
+		if config.HTTP.OIDC.ScopeClaim != "" {
+			s.OIDC.ScopeClaim = config.HTTP.OIDC.ScopeClaim
+		}
+		if config.HTTP.OIDC.NameClaim != "" {
+			s.OIDC.NameClaim = config.HTTP.OIDC.NameClaim
+		}
+	}
+
+	for _, c := range config.HTTP.ClientCerts {
+		s.ClientCerts = append(s.ClientCerts, &infinias.ClientCert{CommonName: c.CommonName, Scopes: c.Scopes})
+	}
+
+	if config.Audit.Path != "" {
+		auditLog, err := infinias.NewFileAuditLog(config.Audit.Path)
+		if err != nil {
+			return nil, fmt.Errorf("could not create audit log: %w", err)
+		}
+		s.Audit = auditLog
+		s.AuditRetention = config.Audit.Retention
+		defer s.WatchAuditRetention(config.Audit.PruneInterval)()
+	}
+
+	if s.Alerts, err = newAlerts(config, logger); err != nil {
+		return nil, fmt.Errorf("could not configure alerts: %w", err)
+	}
+	defer s.Alerts.WatchEvents(s.Events)()
+
+	sites, closeSites, err := newSiteBackends(config, logger, shared)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSites()
+	for _, b := range sites {
+		defer s.Alerts.WatchEvents(b.events)()
+		defer b.service(s).WatchEmployeeIndex(config.EmployeeIndex.RefreshInterval)()
+		defer b.service(s).WatchListCache(config.ListCache.RefreshInterval, config.ListCache.Debounce)()
+	}
+
+	if len(config.Webhooks) > 0 {
+		targets := make([]*infinias.WebhookTarget, len(config.Webhooks))
+		for idx, w := range config.Webhooks {
+			targets[idx] = &infinias.WebhookTarget{URL: w.URL, Secret: w.Secret, Events: w.Events}
+		}
+		s.Webhooks = infinias.NewWebhooks(targets, logger)
+		s.Webhooks.Alerts = s.Alerts
+		defer s.WatchEventWebhooks()()
+	}
+
+	if config.Syslog.Addr != "" {
+		sink, err := newSyslogSink(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not configure syslog: %w", err)
+		}
+		defer sink.Writer.Close()
+		defer infinias.ForwardEvents(s.Events, sink, config.Syslog.EventTypes, logger)()
+	}
+
+	publisher, closePublisher, err := newPublisher(config, logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure publisher: %w", err)
+	}
+	defer closePublisher()
+	s.Publisher = publisher
+	defer s.Publisher.Watch(s.Events)()
+
+	if len(config.Directories) > 0 {
+		s.Directories = make(map[string]*infinias.Directory)
+		for _, d := range config.Directories {
+			var src directory.Source
+			switch d.Type {
+			case "entra":
+				e := directory.NewEntra(d.TenantID, d.ClientID, d.ClientSecret)
+				e.Filter = d.Filter
+				src = e
+			case "google":
+				g, err := directory.NewGoogle(d.KeyFile, d.Subject)
+				if err != nil {
+					return nil, fmt.Errorf("could not configure directory %s: %w", d.Name, err)
+				}
+				if d.Customer != "" {
+					g.Customer = d.Customer
+				}
+				g.Query = d.Query
+				src = g
+			default:
+				return nil, fmt.Errorf("could not configure directory %s: unknown type %q", d.Name, d.Type)
+			}
+			s.Directories[d.Name] = &infinias.Directory{Source: src, DeactivateMissing: d.DeactivateMissing}
+		}
 	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/", http.StripPrefix("/api/1.0", s.Handler()))
-	log.Println("Listening on", config.HTTP.ListenAddr)
-	return http.ListenAndServe(config.HTTP.ListenAddr, handlers.CombinedLoggingHandler(w, mux))
+	if s.Jobs, err = infinias.NewJobManager(config.Jobs.Dir, config.Jobs.Workers, logger); err != nil {
+		return nil, fmt.Errorf("could not create job manager: %w", err)
+	}
+	if config.Jobs.Retention != 0 {
+		s.Jobs.Retention = config.Jobs.Retention
+	}
+	defer s.Jobs.Stop()
+
+	if config.PendingWrites.Path != "" {
+		if s.PendingWrites, err = infinias.NewPendingWriteStore(config.PendingWrites.Path); err != nil {
+			return nil, fmt.Errorf("could not load pending write store: %w", err)
+		}
+		if config.PendingWrites.MaxAttempts != 0 {
+			s.PendingWrites.MaxAttempts = config.PendingWrites.MaxAttempts
+		}
+		if config.PendingWrites.RetryInterval != 0 {
+			s.PendingWrites.Interval = config.PendingWrites.RetryInterval
+		}
+		s.ResumePendingWrites()
+	}
+	s.RollbackFailedCreates = config.FailedCreates.Rollback
+
+	if len(config.Schedule) > 0 {
+		s.Scheduler = infinias.NewScheduler(logger)
+		for _, t := range config.Schedule {
+			var fn infinias.TaskFunc
+			switch t.Task {
+			case "directory_sync":
+				if _, ok := s.Directories[t.Directory]; !ok {
+					return nil, fmt.Errorf("could not configure scheduled task %s: unknown directory %q", t.Name, t.Directory)
+				}
+				fn = s.DirectorySyncTask(t.Directory)
+			case "expire_credentials":
+				fn = s.ExpireCredentialsTask
+			case "cleanup_orphans":
+				fn = s.CleanupOrphansTask
+			case "access_report":
+				fn = s.AccessReportTask(t.Path)
+			case "reset_occupancy":
+				fn = s.ResetOccupancyTask
+			case "prune_audit":
+				fn = s.PruneAuditTask
+			default:
+				return nil, fmt.Errorf("could not configure scheduled task %s: unknown task %q", t.Name, t.Task)
+			}
+			if err = s.Scheduler.Add(t.Name, t.Cron, fn); err != nil {
+				return nil, fmt.Errorf("could not configure scheduled task: %w", err)
+			}
+		}
+		s.Scheduler.Start()
+		defer s.Scheduler.Stop()
+	}
+
+	certManager, err := acmeManager(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure acme: %w", err)
+	}
+	if certManager != nil && config.HTTP.ACME.Challenge != "tls-alpn-01" {
+		addr := config.HTTP.ACME.HTTPListenAddr
+		if addr == "" {
+			addr = ":80"
+		}
+		stop, err := serveACMEChallenges(certManager, addr, logger)
+		if err != nil {
+			return nil, fmt.Errorf("could not start acme challenge listener: %w", err)
+		}
+		defer stop()
+	}
+
+	tlsConf, stopCertReload, err := tlsConfig(config, certManager, logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure tls: %w", err)
+	}
+	defer stopCertReload()
+
+	router, err := siteRouter(s, sites)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure sites: %w", err)
+	}
+	handler := new(handlerSwap)
+	handler.Store(handlers.CombinedLoggingHandler(w, conns.tracer.Middleware(withGRPC(config, s, router))))
+	server := &http.Server{
+		Addr:      config.HTTP.ListenAddr,
+		Handler:   handler,
+		TLSConfig: tlsConf,
+	}
+	if config.HTTP.GRPC && tlsConf == nil {
+		// gRPC clients use HTTP/2 without TLS by prior knowledge, which net/http only serves with TLS
+		server.Handler = h2c.NewHandler(handler, new(http2.Server))
+	}
+
+	if tlsConf == nil && (len(s.APIKeys) > 0 || s.Keys != nil || s.OIDC != nil) {
+		logger.Warn("serving without TLS: bearer tokens will be sent in cleartext; set http.tls_cert and http.tls_key or http.acme.domains to enable HTTPS")
+	}
+
+	if s.Watchdog, err = newWatchdog(config, conns, tlsConf != nil, logger); err != nil {
+		return nil, fmt.Errorf("could not configure watchdog: %w", err)
+	}
+
+	// if one listener fails, stop the other
+	defer server.Close()
+
+	// buffered so neither listener's goroutine blocks after serve returns
+	errc := make(chan error, 2)
+
+	if config.HTTP.SocketPath != "" {
+		l, err := listenSocket(config.HTTP.SocketPath)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("listening", "socket", config.HTTP.SocketPath)
+		go func() {
+			errc <- server.Serve(l)
+		}()
+	}
+
+	logger.Info("listening", "addr", config.HTTP.ListenAddr, "tls", tlsConf != nil)
+	go func() {
+		if tlsConf != nil {
+			errc <- server.ListenAndServeTLS("", "")
+			return
+		}
+		errc <- server.ListenAndServe()
+	}()
+
+	tripped := make(chan error, 1)
+	if s.Watchdog != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			tripped <- s.Watchdog.Run(ctx)
+		}()
+	}
+
+	// shutdown stops accepting requests and waits for in-flight requests to finish
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warn("could not shut down server gracefully", "error", err)
+		}
+	}
+
+	for {
+		select {
+		case err := <-errc:
+			return nil, err
+		case <-ctx.Done():
+			logger.Info("shutting down")
+			shutdown()
+			return nil, nil
+		case err := <-tripped:
+			if err == nil {
+				continue
+			}
+			if config.Watchdog.Action == "exit" {
+				logger.Error("exiting after watchdog failure", "error", err)
+				os.Exit(1)
+			}
+			logger.Error("restarting after watchdog failure", "error", err)
+			return nil, err
+		case next := <-reloads:
+			if !hotReloadable(config, next) {
+				logger.Info("restarting to apply config changes", "reconnect", connectionChanged(config, next))
+				shutdown()
+				return next, nil
+			}
+
+			s2, err := applyConfig(s, next, level)
+			if err != nil {
+				logger.Error("could not apply config changes", "error", err)
+				continue
+			}
+			router, err := siteRouter(s2, sites)
+			if err != nil {
+				logger.Error("could not apply config changes", "error", err)
+				continue
+			}
+			for _, b := range sites {
+				setCacheTTLs(b.thumbnails, b.idempotency, next)
+			}
+			s, config = s2, next
+			handler.Store(handlers.CombinedLoggingHandler(w, conns.tracer.Middleware(withGRPC(config, s, router))))
+			logger.Info("applied config changes")
+		}
+	}
+}
+
+// withGRPC serves s's gRPC service alongside router if it's enabled in config
+func withGRPC(config *Config, s *infinias.Service, router http.Handler) http.Handler {
+	if !config.HTTP.GRPC {
+		return router
+	}
+	return infinias.WithGRPC(s.GRPCHandler(), router)
 }
 
 func main() {
 	flInstall := flag.Bool("install", false, "install as service to "+DefaultRoot)
 	flUninstall := flag.Bool("uninstall", false, "uninstall service")
+	flReconcile := flag.Bool("reconcile", false, "print a report of differences between the api and database, then exit")
+	flHashAPIKey := flag.Bool("hash-api-key", false, "read an api key from stdin and print its hash for http.api_keys[].key_hash, then exit")
+	flProtectPassword := flag.Bool("protect-password", false, "read a password from stdin and print it encrypted with DPAPI for api.password_dpapi or db.password_dpapi, then exit")
+	flEncryptValue := flag.Bool("encrypt-value", false, "read a value from stdin and print it encrypted as an enc: value for config.yaml, creating config.key if needed, then exit")
+	flContainer := flag.Bool("container", false, "read config from "+EnvPrefix+"* environment variables, log to stdout, and run in the foreground without the Windows service. Also enabled by setting "+EnvPrefix+"CONTAINER=true")
+	flServiceAccount := flag.String("service-account", "", "with -install, the account the service logs on as, e.g. DOMAIN\\user or DOMAIN\\gmsa$. Defaults to LocalSystem")
+	flServicePassword := flag.String("service-password", "", "with -install, the password for -service-account, or - to read it from stdin. Not needed for gMSAs")
+	flVersion := flag.Bool("version", false, "print the version and build metadata, then exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [command]\n\nflags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\n"+commandUsage)
+	}
 	flag.Parse()
 
+	if *flVersion {
+		fmt.Println("infinias-api", infinias.Build())
+		return
+	}
+
+	containerMode = *flContainer
+	if env, ok := os.LookupEnv(EnvPrefix + "CONTAINER"); ok && !containerMode {
+		containerMode, _ = strconv.ParseBool(env)
+	}
+
+	if *flProtectPassword {
+		if err := protectPassword(); err != nil {
+			fmt.Println("could not protect password:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flEncryptValue {
+		if err := encryptConfigValue(); err != nil {
+			fmt.Println("could not encrypt value:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flHashAPIKey {
+		if err := hashAPIKey(); err != nil {
+			fmt.Println("could not hash api key:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			if errors.Is(err, ErrUsage) {
+				fmt.Fprintln(os.Stderr, commandUsage)
+				os.Exit(2)
+			}
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flReconcile {
+		if err := reconcile(); err != nil {
+			fmt.Println("could not reconcile:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// stop gracefully on Ctrl-C or SIGTERM when not running as a Windows service
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if containerMode {
+		log.SetOutput(os.Stdout)
+		if err := run(ctx, os.Stdout); err != nil {
+			defaultLogger(os.Stdout).Error("service stopped", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *flInstall {
+		ServiceConfig.Account, ServiceConfig.Password = *flServiceAccount, *flServicePassword
+		if ServiceConfig.Password == "-" {
+			password, err := readLine()
+			if err != nil {
+				fmt.Println("could not read service password:", err)
+				os.Exit(1)
+			}
+			ServiceConfig.Password = password
+		}
+		// don't start a service that would fail without a config
+		exists, err := configExists()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ServiceConfig.NoStart = !exists
 		if err := ServiceConfig.Install(); err != nil {
 			fmt.Println("could not install service:", err)
 			os.Exit(1)
 		}
+		if !exists {
+			if err := writeConfigTemplate(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Printf("service installed but not started: edit %s, save it as %s, then run: sc start %s\n", configTemplatePath(), configPath(), ServiceConfig.Name)
+			return
+		}
 		fmt.Println("service installed successfully")
 		return
 	}
@@ -106,11 +978,14 @@ func main() {
 	s := ServiceConfig.Service(run)
 	if err := svc.Run(s); err != nil {
 		if err == service.ErrNotWindowsService {
-			log.Println("not started as windows service; running in terminal")
-			log.Println(run(os.Stdout))
+			logger := defaultLogger(log.Writer())
+			logger.Info("not started as windows service; running in terminal")
+			if err = run(ctx, os.Stdout); err != nil {
+				logger.Error("service stopped", "error", err)
+			}
 			return
 		}
-		log.Println("could not start service:", err)
+		defaultLogger(log.Writer()).Error("could not start service", "error", err)
 		os.Exit(1)
 	}
 }
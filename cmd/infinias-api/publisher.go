@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+	"github.com/korylprince/go-infinias-api/kafka"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultPublisherSubject = "infinias"
+	// natsTimeout limits connecting to NATS and waiting for the server to process each event
+	natsTimeout = 5 * time.Second
+)
+
+// kafkaBroker adapts a kafka.Producer to infinias.Broker. Records are keyed by person id, with the event type in
+// the type header
+type kafkaBroker struct {
+	*kafka.Producer
+	topic string
+}
+
+func (b kafkaBroker) Publish(typ, key string, value []byte) error {
+	var k []byte
+	if key != "" {
+		k = []byte(key)
+	}
+	return b.Produce(b.topic, k, value, kafka.Header{Key: "type", Value: []byte(typ)})
+}
+
+// natsBroker adapts a nats.Conn to infinias.Broker. Events are published to the subject prefix followed by the
+// event type, e.g. infinias.person.updated
+type natsBroker struct {
+	*nats.Conn
+	prefix string
+}
+
+// Publish publishes the event and waits for the server to process it, so failures are returned instead of lost in
+// the connection's buffer
+func (b natsBroker) Publish(typ, key string, value []byte) error {
+	if err := b.Conn.Publish(b.prefix+"."+typ, value); err != nil {
+		return err
+	}
+	return b.FlushTimeout(natsTimeout)
+}
+
+// newPublisher returns the publisher in config, or nil if none is configured. close must be called when it's no
+// longer used
+func newPublisher(config *Config, logger infinias.Logger) (p *infinias.Publisher, close func(), err error) {
+	var broker infinias.Broker
+	var closeBroker func()
+	switch config.Publisher.Type {
+	case "":
+		return nil, func() {}, nil
+	case "kafka":
+		if len(config.Publisher.Brokers) == 0 || config.Publisher.Topic == "" {
+			return nil, nil, errors.New("publisher.brokers and publisher.topic are required for kafka")
+		}
+		k := kafka.New(config.Publisher.Brokers)
+		if config.Publisher.TLS {
+			k.TLS = new(tls.Config)
+		}
+		broker, closeBroker = kafkaBroker{k, config.Publisher.Topic}, func() { k.Close() }
+	case "nats":
+		subject := config.Publisher.Subject
+		if subject == "" {
+			subject = defaultPublisherSubject
+		}
+		// keep trying to connect in the background, so the service starts while NATS is down
+		n, err := nats.Connect(config.Publisher.URL,
+			nats.Name("infinias-api"), nats.Timeout(natsTimeout),
+			nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1),
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not configure nats: %w", err)
+		}
+		broker, closeBroker = natsBroker{n, subject}, n.Close
+	default:
+		return nil, nil, fmt.Errorf("unknown publisher type: %q", config.Publisher.Type)
+	}
+
+	p = infinias.NewPublisher(broker, config.Publisher.Events, config.Publisher.QueueSize, logger)
+	return p, func() {
+		p.Stop()
+		closeBroker()
+	}, nil
+}
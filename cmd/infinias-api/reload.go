@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/tracing"
+)
+
+const (
+	DefaultReloadInterval = 10 * time.Second
+	shutdownTimeout       = 30 * time.Second
+)
+
+// connections are the API and database connections, kept across restarts unless their configuration changes
+type connections struct {
+	config   *Config
+	api      *api.Conn
+	db       *db.Conn
+	tracer   *tracing.Tracer
+	breaker  *infinias.Breaker
+	budget   *infinias.APIBudget
+	slow     *infinias.SlowLog
+	shutdown func()
+}
+
+func newConnections(config *Config, logger infinias.Logger) (*connections, error) {
+	tracer, shutdown := newTracer(config, logger)
+	breaker := infinias.NewBreaker(config.Breaker.Threshold, config.Breaker.Cooldown)
+	budget := infinias.NewAPIBudget(config.APIBudget.MaxConcurrent, config.APIBudget.CallsPerMinute, config.APIBudget.Burst)
+	slow := infinias.NewSlowLog(config.SlowLog.RequestThreshold, config.SlowLog.QueryThreshold, logger)
+
+	apiConn, dbConn, err := connect(config, tracer, breaker, budget, slow)
+	if err != nil {
+		shutdown()
+		return nil, err
+	}
+
+	return &connections{config: config, api: apiConn, db: dbConn, tracer: tracer, breaker: breaker, budget: budget, slow: slow, shutdown: shutdown}, nil
+}
+
+func (c *connections) close() {
+	if c == nil {
+		return
+	}
+	c.db.Close()
+	c.shutdown()
+}
+
+// connectionChanged returns true if the sections used by newConnections differ between old and next
+func connectionChanged(old, next *Config) bool {
+	return !reflect.DeepEqual(old.API, next.API) || !reflect.DeepEqual(old.DB, next.DB) ||
+		!reflect.DeepEqual(old.Tracing, next.Tracing) || old.Breaker != next.Breaker || old.APIBudget != next.APIBudget ||
+		old.SlowLog != next.SlowLog
+}
+
+// hotReloadable returns true if old and next only differ in settings applyConfig can change while running
+func hotReloadable(old, next *Config) bool {
+	n := *next
+	n.Log = old.Log
+	n.HTTP.APIKey = old.HTTP.APIKey
+	n.HTTP.APIKeys = old.HTTP.APIKeys
+	n.HTTP.AuthLimit = old.HTTP.AuthLimit
+	n.Cache = old.Cache
+	n.Reload = old.Reload
+	return reflect.DeepEqual(old, &n)
+}
+
+// applyConfig returns a copy of s with config's API keys, and updates the log level, authorization limits,
+// and cache TTLs shared with s. Nothing is changed if config is invalid
+func applyConfig(s *infinias.Service, config *Config, level *infinias.LevelVar) (*infinias.Service, error) {
+	l, err := infinias.ParseLevel(config.Log.Level)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse log level: %w", err)
+	}
+
+	keys, err := apiKeys(config)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := roles(config)
+	if err != nil {
+		return nil, err
+	}
+
+	level.Set(l)
+
+	limit := config.HTTP.AuthLimit
+	s.AuthLimiter.SetLimits(limit.MaxFailures, limit.BaseDelay, limit.MaxDelay, limit.BanDuration)
+
+	setCacheTTLs(s.Thumbnails, s.Idempotency, config)
+
+	s2 := *s
+	s2.APIKeys = keys
+	s2.Roles = roles
+	return &s2, nil
+}
+
+// setCacheTTLs sets the TTLs of thumbnails and idempotency from config
+func setCacheTTLs(thumbnails *infinias.ThumbnailCache, idempotency infinias.IdempotencyStore, config *Config) {
+	ttl := config.Cache.ThumbnailTTL
+	if ttl <= 0 {
+		ttl = infinias.DefaultThumbnailCacheTTL
+	}
+	thumbnails.SetTTL(ttl)
+
+	if store, ok := idempotency.(interface{ SetTTL(time.Duration) }); ok {
+		ttl = config.Cache.IdempotencyTTL
+		if ttl <= 0 {
+			ttl = infinias.DefaultIdempotencyTTL
+		}
+		store.SetTTL(ttl)
+	}
+}
+
+// apiKeys returns the API keys in config
+func apiKeys(config *Config) ([]*infinias.APIKey, error) {
+	var keys []*infinias.APIKey
+	if config.HTTP.APIKey != "" {
+		keys = append(keys, &infinias.APIKey{Name: "default", Key: config.HTTP.APIKey, Scopes: []string{infinias.ScopeAdmin}})
+	}
+	for _, k := range config.HTTP.APIKeys {
+		if (k.Key == "") == (k.KeyHash == "") {
+			return nil, fmt.Errorf("could not configure api key %s: exactly one of key and key_hash must be set", k.Name)
+		}
+		if k.KeyHash != "" {
+			if err := infinias.ValidateAPIKeyHash(k.KeyHash); err != nil {
+				return nil, fmt.Errorf("could not configure api key %s: %w", k.Name, err)
+			}
+			if err := infinias.ValidateAPIKeyID(k.KeyID); err != nil {
+				return nil, fmt.Errorf("could not configure api key %s: %w", k.Name, err)
+			}
+		}
+		keys = append(keys, &infinias.APIKey{Name: k.Name, Key: k.Key, Hash: k.KeyHash, ID: k.KeyID, Scopes: k.Scopes})
+	}
+	return keys, nil
+}
+
+// roles returns the roles in config
+func roles(config *Config) (map[string]*infinias.Role, error) {
+	roles := make(map[string]*infinias.Role, len(config.HTTP.Roles))
+	for _, r := range config.HTTP.Roles {
+		if _, ok := roles[r.Name]; ok {
+			return nil, fmt.Errorf("could not configure role %s: duplicate name", r.Name)
+		}
+		role, err := infinias.NewRole(r.Name, r.Routes, r.HiddenFields)
+		if err != nil {
+			return nil, fmt.Errorf("could not configure role %s: %w", r.Name, err)
+		}
+		roles[r.Name] = role
+	}
+	return roles, nil
+}
+
+// watchConfig sends the config each time its file changes or SIGHUP is received, until stop is called.
+// Configs that can't be read are logged and skipped
+func watchConfig(interval time.Duration, logger infinias.Logger) (configs <-chan *Config, stop func()) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	modified := func() (time.Time, int64) {
+		info, err := os.Stat(configPath())
+		if err != nil {
+			return time.Time{}, 0
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	c := make(chan *Config)
+	done := make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		modTime, size := modified()
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+			case <-ticker.C:
+				t, sz := modified()
+				if t.Equal(modTime) && sz == size {
+					continue
+				}
+			}
+			modTime, size = modified()
+
+			config, err := readConfig()
+			if err != nil {
+				logger.Error("could not reload config", "error", err)
+				continue
+			}
+
+			select {
+			case c <- config:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return c, func() { close(done) }
+}
+
+type handlerBox struct {
+	http.Handler
+}
+
+// handlerSwap is an http.Handler that can be replaced while serving
+type handlerSwap struct {
+	v atomic.Value
+}
+
+func (h *handlerSwap) Store(handler http.Handler) {
+	h.v.Store(handlerBox{handler})
+}
+
+func (h *handlerSwap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.v.Load().(handlerBox).ServeHTTP(w, r)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrSecretsUnsupported = errors.New("dpapi and credential manager are only supported on windows")
+
+// resolvePassword returns the password from exactly one of plain, a base64 DPAPI blob, or a Windows Credential Manager target
+func resolvePassword(plain, dpapi, credential string) (string, error) {
+	set := 0
+	for _, v := range []string{plain, dpapi, credential} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", errors.New("only one of password, password_dpapi, and password_credential can be set")
+	}
+
+	switch {
+	case dpapi != "":
+		buf, err := base64.StdEncoding.DecodeString(dpapi)
+		if err != nil {
+			return "", fmt.Errorf("could not decode password_dpapi: %w", err)
+		}
+		secret, err := unprotectSecret(buf)
+		if err != nil {
+			return "", err
+		}
+		return string(secret), nil
+	case credential != "":
+		return readCredential(credential)
+	}
+	return plain, nil
+}
+
+// resolveSecrets replaces config's enc: values and protected passwords with their plaintext values
+func resolveSecrets(config *Config) error {
+	err := decryptConfig(config)
+	if err != nil {
+		return err
+	}
+	if config.API.Password, err = resolvePassword(config.API.Password, config.API.PasswordDPAPI, config.API.PasswordCredential); err != nil {
+		return fmt.Errorf("could not read api password: %w", err)
+	}
+	if config.DB.Password, err = resolvePassword(config.DB.Password, config.DB.PasswordDPAPI, config.DB.PasswordCredential); err != nil {
+		return fmt.Errorf("could not read db password: %w", err)
+	}
+	for idx := range config.Sites {
+		site := &config.Sites[idx]
+		if site.API.Password, err = resolvePassword(site.API.Password, site.API.PasswordDPAPI, site.API.PasswordCredential); err != nil {
+			return fmt.Errorf("could not read api password for site %s: %w", site.Name, err)
+		}
+		if site.DB.Password, err = resolvePassword(site.DB.Password, site.DB.PasswordDPAPI, site.DB.PasswordCredential); err != nil {
+			return fmt.Errorf("could not read db password for site %s: %w", site.Name, err)
+		}
+	}
+	return nil
+}
+
+// protectPassword reads a password from the first line of stdin and prints it encrypted with DPAPI, for password_dpapi
+func protectPassword() error {
+	password, err := readLine()
+	if err != nil {
+		return err
+	}
+
+	buf, err := protectSecret([]byte(password))
+	if err != nil {
+		return err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(buf))
+	return nil
+}
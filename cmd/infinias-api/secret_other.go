@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+func protectSecret(secret []byte) ([]byte, error) {
+	return nil, ErrSecretsUnsupported
+}
+
+func unprotectSecret(buf []byte) ([]byte, error) {
+	return nil, ErrSecretsUnsupported
+}
+
+func readCredential(target string) (string, error) {
+	return "", ErrSecretsUnsupported
+}
@@ -0,0 +1,121 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32  = syscall.NewLazyDLL("crypt32.dll")
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procCredReadW          = advapi32.NewProc("CredReadW")
+	procCredFree           = advapi32.NewProc("CredFree")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+const (
+	cryptProtectUIForbidden  = 0x1
+	cryptProtectLocalMachine = 0x4
+	credTypeGeneric          = 1
+)
+
+// dataBlob is a DATA_BLOB
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newDataBlob(buf []byte) *dataBlob {
+	if len(buf) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{size: uint32(len(buf)), data: &buf[0]}
+}
+
+// free copies b's data and frees it
+func (b *dataBlob) free() []byte {
+	if b.data == nil {
+		return nil
+	}
+	buf := make([]byte, b.size)
+	copy(buf, unsafe.Slice(b.data, b.size))
+	procLocalFree.Call(uintptr(unsafe.Pointer(b.data)))
+	return buf
+}
+
+// protectSecret encrypts secret with DPAPI. It's scoped to the machine instead of the user
+// so the service account can decrypt secrets encrypted by the administrator installing the service
+func protectSecret(secret []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newDataBlob(secret))), 0, 0, 0, 0,
+		cryptProtectUIForbidden|cryptProtectLocalMachine, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, fmt.Errorf("could not encrypt secret: %w", err)
+	}
+	return out.free(), nil
+}
+
+// unprotectSecret decrypts a secret encrypted with protectSecret
+func unprotectSecret(buf []byte) ([]byte, error) {
+	var out dataBlob
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newDataBlob(buf))), 0, 0, 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, fmt.Errorf("could not decrypt secret: %w", err)
+	}
+	return out.free(), nil
+}
+
+// credential is a CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// readCredential returns the password of the generic credential target in the service account's Credential Manager
+func readCredential(target string) (string, error) {
+	name, err := syscall.UTF16PtrFromString(target)
+	if err != nil {
+		return "", fmt.Errorf("could not read credential %s: %w", target, err)
+	}
+
+	var cred *credential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		return "", fmt.Errorf("could not read credential %s: %w", target, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+
+	// passwords stored with cmdkey or the Credential Manager UI are UTF-16
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	if len(blob)%2 != 0 {
+		return string(blob), nil
+	}
+	u := make([]uint16, len(blob)/2)
+	for i := range u {
+		u[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return syscall.UTF16ToString(u), nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package service
+
+// createService returns ErrNotWindowsService, since services can only be created on Windows
+func (s *ServiceConfig) createService() error {
+	return ErrNotWindowsService
+}
@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package service
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// createService creates the Windows service with the service control manager. The password is passed to the
+// service control manager directly, so it doesn't appear in a command line
+func (s *ServiceConfig) createService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("could not connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	srv, err := m.CreateService(s.Name, s.ExecPath, mgr.Config{
+		StartType:        mgr.StartAutomatic,
+		DisplayName:      s.DisplayName,
+		ServiceStartName: s.Account,
+		Password:         s.Password,
+	})
+	if err != nil {
+		return err
+	}
+	return srv.Close()
+}
@@ -1,9 +1,11 @@
 package service
 
 import (
-	"log"
+	"context"
 	"math/rand"
 	"time"
+
+	"github.com/korylprince/go-infinias-api"
 )
 
 type RetryStrategy struct {
@@ -13,13 +15,14 @@ type RetryStrategy struct {
 	MaxJitter   time.Duration
 }
 
-func (s *RetryStrategy) Retry(f func() error) error {
+// Retry calls f until it succeeds, MaxRetries is reached, or ctx is canceled, logging each failure to logger
+func (s *RetryStrategy) Retry(ctx context.Context, logger infinias.Logger, f func() error) error {
 	tries := 0
 	backoff := s.Initial
 	for {
 		err := f()
-		if err == nil {
-			return nil
+		if err == nil || ctx.Err() != nil {
+			return err
 		}
 
 		tries += 1
@@ -32,9 +35,13 @@ func (s *RetryStrategy) Retry(f func() error) error {
 		}
 
 		dur := backoff + time.Duration(rand.Int63n(int64(s.MaxJitter)))
-		log.Printf("service failed unexpectedly (retry in %v): %v\n", dur, err)
+		logger.Error("service failed unexpectedly", "retry_in", dur, "error", err)
 
-		time.Sleep(dur)
+		select {
+		case <-time.After(dur):
+		case <-ctx.Done():
+			return err
+		}
 		backoff *= 2
 	}
 }
@@ -9,19 +9,42 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/judwhite/go-svc"
+	"github.com/korylprince/go-infinias-api"
 )
 
 var ErrNotWindowsService = errors.New("process not started as Windows service")
 
+// DefaultStopTimeout is how long Stop waits for main to return if ServiceConfig.StopTimeout isn't set
+const DefaultStopTimeout = 30 * time.Second
+
 // ServiceConfig holds information to create a Windows service
 type ServiceConfig struct {
 	ExecPath    string
 	LogPath     string
 	Name        string
 	DisplayName string
+	// OnRetriesExhausted, if set, is called when the service stops restarting main after repeated failures
+	OnRetriesExhausted func(err error)
+	// NewLogger, if set, returns the Logger for service events written to w. Defaults to text at the info level
+	NewLogger func(w io.Writer) infinias.Logger
+	// Account, if set, is the account the service logs on as, e.g. DOMAIN\user or DOMAIN\gmsa$. Defaults to LocalSystem.
+	// The account must have the "Log on as a service" right
+	Account string
+	// Password is Account's password. Group managed service accounts don't have one
+	Password string
+	// StopTimeout is how long Stop waits for main to return. Defaults to DefaultStopTimeout
+	StopTimeout time.Duration
+	// NoStart creates the service without starting it
+	NoStart bool
+	// RestartDelay, if set, configures Windows to restart the service this long after it fails
+	RestartDelay time.Duration
+	// FailureResetPeriod is how long the service must run without failing before Windows resets its failure count
+	FailureResetPeriod time.Duration
 }
 
 // Install installs the service executable, creates the Windows service, and starts it
@@ -62,12 +85,19 @@ func (s *ServiceConfig) Install() error {
 	}
 
 	// create and start service
-	cmd := exec.Command(`C:\Windows\System32\sc`, "create", s.Name, "start=", "auto", "binPath=", s.ExecPath, "DisplayName=", s.DisplayName)
-	if err := cmd.Run(); err != nil {
+	if err = s.createService(); err != nil {
 		return fmt.Errorf("could not create service: %w", err)
 	}
 
-	cmd = exec.Command(`C:\Windows\System32\sc`, "start", s.Name)
+	if err = s.configureRecovery(); err != nil {
+		return err
+	}
+
+	if s.NoStart {
+		return nil
+	}
+
+	cmd := exec.Command(`C:\Windows\System32\sc`, "start", s.Name)
 	if err = cmd.Run(); err != nil {
 		return fmt.Errorf("could not start service: %w", err)
 	}
@@ -75,6 +105,29 @@ func (s *ServiceConfig) Install() error {
 	return nil
 }
 
+// configureRecovery sets the service's failure actions to restart it after RestartDelay
+func (s *ServiceConfig) configureRecovery() error {
+	if s.RestartDelay <= 0 {
+		return nil
+	}
+
+	restart := fmt.Sprintf("restart/%d", s.RestartDelay.Milliseconds())
+	actions := strings.Join([]string{restart, restart, restart}, "/")
+	reset := strconv.Itoa(int(s.FailureResetPeriod.Seconds()))
+	cmd := exec.Command(`C:\Windows\System32\sc`, "failure", s.Name, "reset=", reset, "actions=", actions)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not configure service recovery: %w", err)
+	}
+
+	// also apply the actions when the service stops with an error, not just when it crashes
+	cmd = exec.Command(`C:\Windows\System32\sc`, "failureflag", s.Name, "1")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not configure service recovery: %w", err)
+	}
+
+	return nil
+}
+
 // Uninstall uninstalls the service
 func (s *ServiceConfig) Uninstall() error {
 	cmd := exec.Command(`C:\Windows\System32\sc`, "stop", s.Name)
@@ -89,19 +142,36 @@ func (s *ServiceConfig) Uninstall() error {
 	return nil
 }
 
-// Service returns a new Service for use with svc.Run
-func (s *ServiceConfig) Service(main func(w io.Writer) error) *Service {
+// Service returns a new Service for use with svc.Run. The context passed to main is canceled when the service is stopped
+func (s *ServiceConfig) Service(main func(ctx context.Context, w io.Writer) error) *Service {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Service{main: main, logPath: s.LogPath, ctx: ctx, cancel: cancel}
+	runCtx, stopRun := context.WithCancel(context.Background())
+	timeout := s.StopTimeout
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	return &Service{
+		main: main, logPath: s.LogPath, onRetriesExhausted: s.OnRetriesExhausted, newLogger: s.NewLogger, stopTimeout: timeout,
+		ctx: ctx, cancel: cancel, runCtx: runCtx, stopRun: stopRun, done: make(chan struct{}),
+	}
 }
 
 // Service implements svc.Service
 type Service struct {
-	main    func(io.Writer) error
-	logPath string
-	fi      *os.File
-	ctx     context.Context
-	cancel  context.CancelFunc
+	main               func(context.Context, io.Writer) error
+	logPath            string
+	onRetriesExhausted func(error)
+	newLogger          func(io.Writer) infinias.Logger
+	stopTimeout        time.Duration
+	log                infinias.Logger
+	fi                 *os.File
+	// ctx is canceled to stop the service when main stops restarting
+	ctx    context.Context
+	cancel context.CancelFunc
+	// runCtx is canceled by Stop to shut down main. done is closed when main has returned
+	runCtx  context.Context
+	stopRun context.CancelFunc
+	done    chan struct{}
 }
 
 // Context implements svc.Context
@@ -123,17 +193,27 @@ func (s *Service) Init(env svc.Environment) error {
 	s.fi = fi
 	log.SetOutput(fi)
 
+	if s.newLogger != nil {
+		s.log = s.newLogger(fi)
+	} else {
+		s.log = infinias.NewLogger(fi, infinias.LevelInfo)
+	}
+
 	return nil
 }
 
 // Start implements svc.Service
 func (s *Service) Start() error {
-	log.Println("starting service")
+	s.log.Info("starting service")
 	go func() {
-		if err := DefaultRetryStrategy.Retry(func() error {
-			return s.main(s.fi)
-		}); err != nil {
-			log.Println("service retries exhausted:", err)
+		defer close(s.done)
+		if err := DefaultRetryStrategy.Retry(s.runCtx, s.log, func() error {
+			return s.main(s.runCtx, s.fi)
+		}); err != nil && s.runCtx.Err() == nil {
+			s.log.Error("service retries exhausted", "error", err)
+			if s.onRetriesExhausted != nil {
+				s.onRetriesExhausted(err)
+			}
 		}
 		s.cancel()
 	}()
@@ -142,7 +222,14 @@ func (s *Service) Start() error {
 
 // Stop implements svc.Service
 func (s *Service) Stop() error {
-	log.Println("stopping service")
+	s.log.Info("stopping service")
+	s.stopRun()
+	select {
+	case <-s.done:
+		s.log.Info("service stopped")
+	case <-time.After(s.stopTimeout):
+		s.log.Warn("service didn't stop in time", "timeout", s.stopTimeout)
+	}
 	s.fi.Sync()
 	return nil
 }
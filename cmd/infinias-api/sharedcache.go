@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultSharedCachePrefix = "infinias:"
+
+// redisCache adapts a redis.Client to infinias.SharedCache. Commands are limited by the client's timeouts
+type redisCache struct {
+	*redis.Client
+}
+
+func (c redisCache) Get(key string) ([]byte, error) {
+	buf, err := c.Client.Get(context.Background(), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, infinias.ErrCacheMiss
+	}
+	return buf, err
+}
+
+func (c redisCache) Set(key string, value []byte, ttl time.Duration) error {
+	return c.Client.Set(context.Background(), key, value, ttl).Err()
+}
+
+func (c redisCache) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.Client.SetNX(context.Background(), key, value, ttl).Result()
+}
+
+func (c redisCache) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.Client.Del(context.Background(), keys...).Err()
+}
+
+// newSharedCache returns the shared cache in config, or nil if none is configured. close must be called when it's no longer used
+func newSharedCache(config *Config) (cache infinias.SharedCache, close func(), err error) {
+	switch config.SharedCache.Type {
+	case "":
+		return nil, func() {}, nil
+	case "redis":
+		opts := &redis.Options{Addr: config.SharedCache.Addr, Password: config.SharedCache.Password, DB: config.SharedCache.DB}
+		if config.SharedCache.TLS {
+			opts.TLSConfig = new(tls.Config)
+		}
+		c := redis.NewClient(opts)
+		if err = c.Ping(context.Background()).Err(); err != nil {
+			c.Close()
+			return nil, nil, fmt.Errorf("could not connect to shared cache: %w", err)
+		}
+		return redisCache{c}, func() { c.Close() }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown shared cache type: %q", config.SharedCache.Type)
+}
+
+// sharedCachePrefix returns the prefix of keys in the shared cache for the named site, or the top level backend if site is empty
+func sharedCachePrefix(config *Config, site string) string {
+	prefix := config.SharedCache.Prefix
+	if prefix == "" {
+		prefix = defaultSharedCachePrefix
+	}
+	if site != "" {
+		prefix += site + ":"
+	}
+	return prefix
+}
+
+// newIdempotencyStore returns an idempotency store in shared under prefix, or in memory if shared is nil
+func newIdempotencyStore(shared infinias.SharedCache, prefix string) infinias.IdempotencyStore {
+	if shared == nil {
+		return infinias.NewMemoryIdempotencyStore(infinias.DefaultIdempotencyTTL)
+	}
+	return infinias.NewSharedIdempotencyStore(shared, prefix, infinias.DefaultIdempotencyTTL)
+}
+
+// newListCache returns a list cache in shared under prefix, or in memory if shared is nil.
+// It returns nil if the list cache is disabled
+func newListCache(config *Config, shared infinias.SharedCache, prefix string) *infinias.ListCache {
+	interval := config.ListCache.RefreshInterval
+	if interval <= 0 {
+		return nil
+	}
+	if shared == nil {
+		return infinias.NewListCache()
+	}
+	// lists outlive a missed refresh, but not an instance that stopped refreshing them
+	return infinias.NewSharedListCache(shared, prefix, 2*interval)
+}
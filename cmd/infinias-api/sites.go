@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/korylprince/go-infinias-api"
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+// siteBackend is the state of an additional site that differs from the top level Service
+type siteBackend struct {
+	conns       *connections
+	log         infinias.Logger
+	events      *infinias.EventStream
+	idempotency infinias.IdempotencyStore
+	thumbnails  *infinias.ThumbnailCache
+	index       *infinias.EmployeeIndex
+	lists       *infinias.ListCache
+	pictures    photo.Store
+}
+
+// newSiteBackends connects to config's sites. close must be called to stop their event streams and close their connections
+// If shared is non-nil, each site's idempotency keys and list cache are kept in it
+func newSiteBackends(config *Config, logger infinias.Logger, shared infinias.SharedCache) (backends map[string]*siteBackend, close func(), err error) {
+	backends = make(map[string]*siteBackend, len(config.Sites))
+	close = func() {
+		for _, b := range backends {
+			b.events.Close()
+			b.conns.close()
+		}
+	}
+
+	for _, site := range config.Sites {
+		if _, ok := backends[site.Name]; ok {
+			close()
+			return nil, nil, fmt.Errorf("could not configure site %s: duplicate name", site.Name)
+		}
+
+		siteConfig := *config
+		siteConfig.API, siteConfig.DB = site.API, site.DB
+		// keep file and s3 pictures separate from other sites
+		siteConfig.Images.Dir = filepath.Join(config.Images.Dir, site.Name)
+		siteConfig.Images.S3.Prefix = path.Join(config.Images.S3.Prefix, site.Name) + "/"
+
+		log := logger.With("site", site.Name)
+		conns, err := newConnections(&siteConfig, log)
+		if err != nil {
+			close()
+			return nil, nil, fmt.Errorf("could not connect to site %s: %w", site.Name, err)
+		}
+
+		b := &siteBackend{
+			conns:       conns,
+			log:         log,
+			events:      infinias.NewEventStream(conns.db, config.Events.PollInterval, log),
+			idempotency: newIdempotencyStore(shared, sharedCachePrefix(config, site.Name)),
+			thumbnails:  infinias.NewThumbnailCache(infinias.DefaultThumbnailCacheSize, infinias.DefaultThumbnailCacheTTL),
+			index:       infinias.NewEmployeeIndex(),
+			lists:       newListCache(config, shared, sharedCachePrefix(config, site.Name)),
+		}
+		backends[site.Name] = b
+		setCacheTTLs(b.thumbnails, b.idempotency, config)
+
+		tmp := new(infinias.Service)
+		if err = configureData(tmp, &siteConfig); err != nil {
+			close()
+			return nil, nil, fmt.Errorf("could not configure site %s: %w", site.Name, err)
+		}
+		b.pictures = tmp.Pictures
+	}
+
+	return backends, close, nil
+}
+
+// service returns a copy of s using b's backend
+func (b *siteBackend) service(s *infinias.Service) *infinias.Service {
+	s2 := *s
+	s2.APIConn, s2.DBConn = b.conns.api, b.conns.db
+	s2.Log = b.log
+	s2.Events = b.events
+	s2.Idempotency = b.idempotency
+	s2.Thumbnails = b.thumbnails
+	s2.EmployeeIndex = b.index
+	s2.ListCache = b.lists
+	s2.Pictures = b.pictures
+	s2.Breaker = b.conns.breaker
+	s2.APIBudget = b.conns.budget
+	s2.SlowLog = b.conns.slow
+	s2.Directories = nil
+	s2.Terminations = nil
+	s2.Occupancy = nil
+	s2.Visitors = nil
+	s2.TemporaryCredentials = nil
+	s2.PendingWrites = nil
+	return &s2
+}
+
+// siteRouter returns a SiteRouter for s and a copy of s for each of backends
+func siteRouter(s *infinias.Service, backends map[string]*siteBackend) (*infinias.SiteRouter, error) {
+	sites := make(map[string]*infinias.Service, len(backends))
+	for name, b := range backends {
+		sites[name] = b.service(s)
+	}
+	return infinias.NewSiteRouter(s, sites)
+}
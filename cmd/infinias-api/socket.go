@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenSocket listens on a Unix domain socket at path, removing a socket left behind by a previous run.
+// Unix domain sockets are also supported on Windows 10 1803 and later
+func listenSocket(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.IsDir() {
+			return nil, fmt.Errorf("could not listen on %s: path is a directory", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove old socket: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not check socket: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", path, err)
+	}
+
+	// allow local clients in the service account's group, not everyone
+	if err = os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not set socket permissions: %w", err)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// configTemplate is written next to config.yaml on install when no config exists
+const configTemplate = `# infinias-api configuration
+#
+# Fill in the required values below, save this file as config.yaml in the same directory, then start the service with:
+#   sc start infinias-api
+
+# Any string value can be encrypted so backups of this directory don't expose it. Generate one with:
+#   echo secret| infinias-api.exe -encrypt-value
+# and use the output, e.g. password: "enc:...". The key is stored in config.key, encrypted with DPAPI for this machine,
+# or read from the INFINIAS_API_CONFIG_KEY environment variable
+
+# Infinias web API connection (required)
+api:
+  # Scheme and host of the Infinias web server, without the /infinias/ia path
+  prefix: http://localhost
+  username: admin
+  password: ""
+  # Instead of password, a Windows DPAPI-encrypted password (generate with infinias-api.exe -protect-password)
+  # password_dpapi: ""
+  # or the target name of a generic credential in the service account's Windows Credential Manager
+  # password_credential: ""
+  # Forward proxy for reaching the Infinias server, or direct. Defaults to the HTTP_PROXY and HTTPS_PROXY environment variables
+  # proxy: http://proxy.example.com:3128
+
+# Infinias SQL Server database (required)
+db:
+  host: localhost
+  port: 1433
+  # instance: SQLEXPRESS
+  database: ""
+  # Leave username empty to connect as the service account with Windows integrated authentication
+  username: ""
+  password: ""
+  # password_dpapi: ""
+  # password_credential: ""
+
+http:
+  listen_addr: :8080
+  # tls_cert: C:\path\to\cert.pem
+  # tls_key: C:\path\to\key.pem
+  # serve the gRPC API on listen_addr too
+  # grpc: false
+  # API keys and their scopes. Generate a key_hash with infinias-api.exe -hash-api-key.
+  # Hashed keys are presented as <key_id>.<key>
+  api_keys:
+    - name: admin
+      key_id: admin
+      key_hash: ""
+      scopes: [admin]
+
+log:
+  # debug, info, warn, or error
+  level: info
+  # text or json
+  format: text
+
+# Other optional sections: images, validation, events, audit, directories, jobs, schedule, alerts, webhooks,
+# syslog, publisher, occupancy, terminations, pending_writes, failed_creates, visitors, temporary_credentials,
+# employee_index, list_cache, shared_cache, tracing, slow_log, breaker, cache, reload, sites, and diagnostics
+`
+
+// configTemplatePath returns the path the config template is written to
+func configTemplatePath() string {
+	return configPath() + ".example"
+}
+
+// configExists returns true if config.yaml exists
+func configExists() (bool, error) {
+	if _, err := os.Stat(configPath()); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not check config: %w", err)
+	}
+	return true, nil
+}
+
+// writeConfigTemplate writes the config template to configTemplatePath, replacing any existing template
+func writeConfigTemplate() error {
+	if err := os.MkdirAll(filepath.Dir(configTemplatePath()), 0755); err != nil {
+		return fmt.Errorf("could not create config directory: %w", err)
+	}
+	if err := os.WriteFile(configTemplatePath(), []byte(configTemplate), 0644); err != nil {
+		return fmt.Errorf("could not write config template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	ErrInvalidClientCA     = errors.New("no certificates found in client CA file")
+	ErrInvalidTLSVersion   = errors.New("invalid tls version")
+	ErrInvalidCipherSuite  = errors.New("invalid cipher suite")
+	ErrInsecureCipherSuite = errors.New("insecure cipher suite")
+)
+
+// parseTLSVersion parses a minimum TLS version. An empty version is TLS 1.2
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("%w: %q: must be 1.2 or 1.3", ErrInvalidTLSVersion, version)
+}
+
+// parseCipherSuites returns the ids of the named cipher suites. Insecure suites are rejected
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	secure := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		secure[s.Name] = s.ID
+	}
+	insecure := make(map[string]bool)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		if id, ok := secure[name]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		if insecure[name] {
+			return nil, fmt.Errorf("%w: %s", ErrInsecureCipherSuite, name)
+		}
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCipherSuite, name)
+	}
+	return ids, nil
+}
+
+// certReloadInterval is how often certReloader checks the certificate and key for changes
+const certReloadInterval = time.Minute
+
+// certReloader reloads a certificate and key from disk when either file changes,
+// so renewed certificates are picked up without restarting the service.
+// Files are only checked by watch, so handshakes don't touch the disk
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	// cert is the loaded *tls.Certificate
+	cert    atomic.Value
+	modTime time.Time
+}
+
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	c := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certPath, c.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, fmt.Errorf("could not stat %s: %w", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the certificate and key if either has changed since they were last loaded.
+// On error, the loaded certificate keeps being served. It must only be called by one goroutine at a time
+func (c *certReloader) reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	if c.cert.Load() != nil && !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return fmt.Errorf("could not load certificate: %w", err)
+	}
+
+	c.cert.Store(&cert)
+	c.modTime = modTime
+	return nil
+}
+
+// watch reloads the certificate every interval until stop is called
+func (c *certReloader) watch(interval time.Duration, logger infinias.Logger) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			// files may be temporarily unavailable during renewal
+			if err := c.reload(); err != nil {
+				logger.Warn("could not reload certificate", "error", err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load().(*tls.Certificate), nil
+}
+
+// tlsConfig returns the TLS config for the HTTP server, or nil if TLS isn't configured.
+// If m is non-nil, certificates are obtained with it instead of loaded from tls_cert and tls_key.
+// stop stops reloading tls_cert and tls_key, and is never nil
+func tlsConfig(config *Config, m *autocert.Manager, logger infinias.Logger) (c *tls.Config, stop func(), err error) {
+	stop = func() {}
+	if config.HTTP.TLSCert == "" && config.HTTP.TLSKey == "" && m == nil {
+		if config.HTTP.ClientCA != "" {
+			return nil, stop, errors.New("client_ca requires tls_cert and tls_key or acme")
+		}
+		return nil, stop, nil
+	}
+
+	minVersion, err := parseTLSVersion(config.HTTP.TLSMinVersion)
+	if err != nil {
+		return nil, stop, err
+	}
+
+	suites, err := parseCipherSuites(config.HTTP.TLSCipherSuites)
+	if err != nil {
+		return nil, stop, err
+	}
+
+	c = &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+	}
+
+	if m != nil {
+		c.GetCertificate = m.GetCertificate
+		// answer TLS-ALPN-01 challenges
+		c.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	} else {
+		reloader, err := newCertReloader(config.HTTP.TLSCert, config.HTTP.TLSKey)
+		if err != nil {
+			return nil, stop, err
+		}
+		c.GetCertificate = reloader.GetCertificate
+		defer func() {
+			if err == nil {
+				stop = reloader.watch(certReloadInterval, logger)
+			}
+		}()
+	}
+
+	if config.HTTP.ClientCA == "" {
+		return c, stop, nil
+	}
+
+	buf, err := os.ReadFile(config.HTTP.ClientCA)
+	if err != nil {
+		return nil, stop, fmt.Errorf("could not read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, stop, ErrInvalidClientCA
+	}
+
+	c.ClientCAs = pool
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return c, stop, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/korylprince/go-infinias-api"
+)
+
+const (
+	defaultWatchdogTimeout     = 10 * time.Second
+	defaultWatchdogMaxFailures = 3
+)
+
+// watchdogRestarts is the number of times run restarted the server because the watchdog tripped
+var watchdogRestarts uint64
+
+// unresponsive returns err if it's a timeout, or nil otherwise. Other errors mean the component responded
+func unresponsive(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return err
+	}
+	return nil
+}
+
+// loopbackURL returns the URL for the server listening on addr from the local machine
+func loopbackURL(addr string, useTLS bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("could not parse listen address: %w", err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/health", nil
+}
+
+// newWatchdog returns a Watchdog checking the HTTP listener, API client, and database pool, or nil if it's disabled
+func newWatchdog(config *Config, conns *connections, useTLS bool, logger infinias.Logger) (*infinias.Watchdog, error) {
+	if config.Watchdog.Interval <= 0 {
+		return nil, nil
+	}
+	switch config.Watchdog.Action {
+	case "", "restart", "exit":
+	default:
+		return nil, fmt.Errorf("unknown watchdog action %q", config.Watchdog.Action)
+	}
+
+	addr := config.HTTP.ListenAddr
+	if addr == "" {
+		addr = ":http"
+		if useTLS {
+			addr = ":https"
+		}
+	}
+	u, err := loopbackURL(addr, useTLS)
+	if err != nil {
+		return nil, err
+	}
+	// only responsiveness is checked, so the certificate and authorization don't matter
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	timeout := config.Watchdog.Timeout
+	if timeout <= 0 {
+		timeout = defaultWatchdogTimeout
+	}
+	maxFailures := config.Watchdog.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultWatchdogMaxFailures
+	}
+
+	w := infinias.NewWatchdog(config.Watchdog.Interval, timeout, maxFailures, logger,
+		infinias.WatchdogCheck{Name: "http", Check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return unresponsive(err)
+			}
+			resp.Body.Close()
+			return nil
+		}},
+		infinias.WatchdogCheck{Name: "infinias_api", Check: func(ctx context.Context) error {
+			_, err := conns.api.WithContext(ctx).ListGroups()
+			return unresponsive(err)
+		}},
+		infinias.WatchdogCheck{Name: "database", Check: func(ctx context.Context) error {
+			return unresponsive(conns.db.PingContext(ctx))
+		}},
+	)
+	w.Restarts = watchdogRestarts
+	return w, nil
+}
@@ -0,0 +1,168 @@
+package infinias
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the position after the last item of a page. It's sent to clients as an opaque token
+type pageCursor struct {
+	// Sort is the sort query parameter the cursor was created with
+	Sort string `json:"s,omitempty"`
+	ID   int    `json:"id"`
+	// Person holds the last person's sortable fields if people are sorted by more than id
+	Person *Person `json:"p,omitempty"`
+}
+
+func encodeCursor(c *pageCursor) string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeCursor(str string) (*pageCursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := new(pageCursor)
+	if err = json.Unmarshal(buf, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// v2CursorMeta is the meta of a page requested with a cursor
+type v2CursorMeta struct {
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+	// NextCursor is omitted on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// itemID returns the int ID field of v, a pointer to a struct
+func itemID(v reflect.Value) (int, bool) {
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0, false
+	}
+	f := v.Elem().FieldByName("ID")
+	if !f.IsValid() || f.Kind() != reflect.Int {
+		return 0, false
+	}
+	return int(f.Int()), true
+}
+
+// sortKeyPerson returns a copy of p with only its id and the fields in keys, so the cursor doesn't carry fields
+// the client didn't sort by, and can't sort by if they're hidden from it
+func sortKeyPerson(p *Person, keys []*SortKey) *Person {
+	key := &Person{ID: p.ID}
+	for _, k := range keys {
+		switch k.Field {
+		case "first_name":
+			key.FirstName = p.FirstName
+		case "last_name":
+			key.LastName = p.LastName
+		case "employee_id":
+			key.EmployeeID = p.EmployeeID
+		case "department":
+			key.Department = p.Department
+		case "site_code":
+			key.SiteCode = p.SiteCode
+		case "card_code":
+			key.CardCode = p.CardCode
+		case "has_image":
+			key.HasImage = p.HasImage
+		}
+	}
+	return key
+}
+
+// paginateCursor returns the page of the slice v after the cursor query parameter, or the first page if it's empty.
+// Pages are positioned by the last item's id, or its sort fields for people sorted with the sort query parameter,
+// instead of an offset, so items inserted or deleted before the cursor don't cause later items to be skipped or repeated.
+// People can't be sorted by fields in hidden. v's items must be pointers to structs with an int ID field
+func paginateCursor(r *http.Request, v reflect.Value, perPage int, hidden map[string]bool) (interface{}, *v2CursorMeta, error) {
+	q := r.URL.Query()
+	sortStr := q.Get("sort")
+
+	var cur *pageCursor
+	if str := q.Get("cursor"); str != "" {
+		var err error
+		if cur, err = decodeCursor(str); err != nil {
+			return nil, nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read cursor: %w", err)}
+		}
+		if cur.Sort != sortStr {
+			return nil, nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read cursor: %w: it was created with a different sort", ErrInvalidCursor)}
+		}
+	}
+
+	n := v.Len()
+	order := make([]int, n)
+	ids := make([]int, n)
+	for i := 0; i < n; i++ {
+		id, ok := itemID(v.Index(i))
+		if !ok {
+			return nil, nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: errors.New("cursor pagination isn't supported for this list")}
+		}
+		order[i], ids[i] = i, id
+	}
+
+	var keys []*SortKey
+	people, isPeople := v.Interface().([]*Person)
+	if isPeople {
+		var err error
+		if keys, err = parseSortQuery(sortStr, hidden); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// compare returns <0, 0, or >0 if the item at index i is before, at, or after c
+	compare := func(i int, c *pageCursor) int {
+		if len(keys) > 0 {
+			last := c.Person
+			if last == nil {
+				last = &Person{ID: c.ID}
+			}
+			return comparePeople(people[i], last, keys)
+		}
+		return ids[i] - c.ID
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if len(keys) > 0 {
+			return comparePeople(people[order[i]], people[order[j]], keys) < 0
+		}
+		return ids[order[i]] < ids[order[j]]
+	})
+
+	start := 0
+	if cur != nil {
+		start = sort.Search(n, func(i int) bool { return compare(order[i], cur) > 0 })
+	}
+	end := start + perPage
+	if end > n {
+		end = n
+	}
+
+	page := reflect.MakeSlice(v.Type(), 0, end-start)
+	for _, i := range order[start:end] {
+		page = reflect.Append(page, v.Index(i))
+	}
+
+	meta := &v2CursorMeta{PerPage: perPage, Total: n}
+	if end < n {
+		last := order[end-1]
+		next := &pageCursor{Sort: sortStr, ID: ids[last]}
+		if len(keys) > 0 {
+			next.Person = sortKeyPerson(people[last], keys)
+		}
+		meta.NextCursor = encodeCursor(next)
+	}
+
+	return page.Interface(), meta, nil
+}
@@ -0,0 +1,317 @@
+package infinias
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComparePeople(t *testing.T) {
+	alice := &Person{ID: 1, FirstName: "Alice", LastName: "Smith", Department: "IT", SiteCode: 10, HasImage: true}
+	bob := &Person{ID: 2, FirstName: "bob", LastName: "smith", Department: "it", SiteCode: 5}
+	alice2 := &Person{ID: 3, FirstName: "alice", LastName: "Jones", Department: "HR", SiteCode: 10}
+
+	tests := []struct {
+		name string
+		a, b *Person
+		sort string
+		want int
+	}{
+		{"no keys sorts by id", bob, alice, "", 1},
+		{"same person", alice, alice, "last_name", 0},
+		{"case-insensitive", alice, bob, "first_name", -1},
+		{"descending", alice, bob, "-first_name", 1},
+		{"tie broken by id", alice, bob, "last_name", -1},
+		{"ties on every key broken by id", bob, alice, "last_name,department", 1},
+		{"second key", alice, alice2, "first_name,last_name", 1},
+		{"second key descending", alice, alice2, "first_name,-last_name", -1},
+		{"first key wins", alice, bob, "-site_code,first_name", -1},
+		{"has image after no image", alice, bob, "has_image", 1},
+		{"has image descending", alice, bob, "-has_image", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := ParseSort(test.sort)
+			if err != nil {
+				t.Fatalf("could not parse sort: %v", err)
+			}
+			have := comparePeople(test.a, test.b, keys)
+			if (have < 0) != (test.want < 0) || (have > 0) != (test.want > 0) {
+				t.Errorf("want %d, have %d", test.want, have)
+			}
+		})
+	}
+}
+
+// cursorRequest returns a request with the sort and cursor query parameters
+func cursorRequest(sort, cursor string) *http.Request {
+	q := make(url.Values)
+	if sort != "" {
+		q.Set("sort", sort)
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	return httptest.NewRequest(http.MethodGet, "/people?"+q.Encode(), nil)
+}
+
+func cursorPeople() []*Person {
+	return []*Person{
+		{ID: 5, LastName: "Smith", Department: "IT"},
+		{ID: 1, LastName: "Jones", Department: "HR"},
+		{ID: 4, LastName: "Adams", Department: "IT"},
+		{ID: 2, LastName: "Brown", Department: "HR"},
+		{ID: 3, LastName: "Smith", Department: "HR"},
+		{ID: 6, LastName: "Clark", Department: "IT"},
+	}
+}
+
+// withoutPeople returns people without the people with ids
+func withoutPeople(people []*Person, ids ...int) []*Person {
+	var kept []*Person
+	for _, p := range people {
+		if !containsInt(ids, p.ID) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// pageIDs returns the ids of a page of people
+func pageIDs(page interface{}) []int {
+	ids := make([]int, 0)
+	for _, p := range page.([]*Person) {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func TestPaginateCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		sort    string
+		perPage int
+		want    [][]int
+	}{
+		{"id", "", 4, [][]int{{1, 2, 3, 4}, {5, 6}}},
+		{"one per page", "", 1, [][]int{{1}, {2}, {3}, {4}, {5}, {6}}},
+		{"exact pages", "", 3, [][]int{{1, 2, 3}, {4, 5, 6}}},
+		{"one key", "last_name", 2, [][]int{{4, 2}, {6, 1}, {3, 5}}},
+		{"descending", "-last_name", 4, [][]int{{3, 5, 1, 6}, {2, 4}}},
+		{"multiple keys", "department,-last_name", 2, [][]int{{3, 1}, {2, 5}, {6, 4}}},
+		{"tie on every key across pages", "last_name,department", 5, [][]int{{4, 2, 6, 1, 3}, {5}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			people := cursorPeople()
+			var pages [][]int
+			cursor := ""
+			for i := 0; i < 10; i++ {
+				page, meta, err := paginateCursor(cursorRequest(test.sort, cursor), reflect.ValueOf(people), test.perPage, nil)
+				if err != nil {
+					t.Fatalf("could not paginate: %v", err)
+				}
+				if meta.Total != len(people) || meta.PerPage != test.perPage {
+					t.Errorf("want total %d and per page %d, have %d and %d", len(people), test.perPage, meta.Total, meta.PerPage)
+				}
+				pages = append(pages, pageIDs(page))
+				if cursor = meta.NextCursor; cursor == "" {
+					break
+				}
+			}
+			if !reflect.DeepEqual(pages, test.want) {
+				t.Errorf("want pages %v, have %v", test.want, pages)
+			}
+		})
+	}
+}
+
+func TestPaginateCursorChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		sort   string
+		change func([]*Person) []*Person
+		want   []int
+	}{
+		{"insert before cursor", "", func(people []*Person) []*Person {
+			return append(people, &Person{ID: 0})
+		}, []int{4, 5, 6}},
+		{"insert after cursor", "", func(people []*Person) []*Person {
+			return append(people, &Person{ID: 7})
+		}, []int{4, 5, 6}},
+		{"delete before cursor", "", func(people []*Person) []*Person {
+			return withoutPeople(people, 1, 2)
+		}, []int{4, 5, 6}},
+		{"delete cursor item", "", func(people []*Person) []*Person {
+			return withoutPeople(people, 3)
+		}, []int{4, 5, 6}},
+		{"delete after cursor", "", func(people []*Person) []*Person {
+			return withoutPeople(people, 4)
+		}, []int{5, 6}},
+		{"insert before sorted cursor", "last_name", func(people []*Person) []*Person {
+			return append(people, &Person{ID: 7, LastName: "Baker"})
+		}, []int{6, 1, 3}},
+		{"insert after sorted cursor", "last_name", func(people []*Person) []*Person {
+			return append(people, &Person{ID: 8, LastName: "Davis"})
+		}, []int{6, 8, 1}},
+		{"delete sorted cursor item", "last_name", func(people []*Person) []*Person {
+			// Brown, the last person of the first page
+			return withoutPeople(people, 2)
+		}, []int{6, 1, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the first page is 1, 2, 3 by id, or Adams, Brown, Clark by last name
+			perPage := 3
+			if test.sort != "" {
+				perPage = 2
+			}
+			_, meta, err := paginateCursor(cursorRequest(test.sort, ""), reflect.ValueOf(cursorPeople()), perPage, nil)
+			if err != nil {
+				t.Fatalf("could not paginate: %v", err)
+			}
+
+			page, _, err := paginateCursor(cursorRequest(test.sort, meta.NextCursor), reflect.ValueOf(test.change(cursorPeople())), 3, nil)
+			if err != nil {
+				t.Fatalf("could not paginate: %v", err)
+			}
+			if have := pageIDs(page); !reflect.DeepEqual(have, test.want) {
+				t.Errorf("want %v, have %v", test.want, have)
+			}
+		})
+	}
+}
+
+func TestPaginateCursorErrors(t *testing.T) {
+	people := reflect.ValueOf(cursorPeople())
+	_, meta, err := paginateCursor(cursorRequest("last_name", ""), people, 2, nil)
+	if err != nil {
+		t.Fatalf("could not paginate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		v      reflect.Value
+		hidden map[string]bool
+		code   int
+		// invalid is set if the error should wrap ErrInvalidCursor
+		invalid bool
+	}{
+		{"different sort", cursorRequest("-last_name", meta.NextCursor), people, nil, http.StatusBadRequest, true},
+		{"no sort", cursorRequest("", meta.NextCursor), people, nil, http.StatusBadRequest, true},
+		{"invalid cursor", cursorRequest("last_name", "not a cursor"), people, nil, http.StatusBadRequest, true},
+		{"invalid cursor json", cursorRequest("", base64.RawURLEncoding.EncodeToString([]byte("{"))), people, nil, http.StatusBadRequest, true},
+		{"unknown sort field", cursorRequest("image", ""), people, nil, http.StatusBadRequest, false},
+		{"hidden sort field", cursorRequest("department", ""), people, map[string]bool{"department": true}, http.StatusForbidden, false},
+		{"items without ids", cursorRequest("", ""), reflect.ValueOf([]string{"a"}), nil, http.StatusBadRequest, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := paginateCursor(test.r, test.v, 2, test.hidden)
+			h := new(HTTPError)
+			if !errors.As(err, &h) || h.StatusCode != test.code {
+				t.Fatalf("want %d error, have %v", test.code, err)
+			}
+			if test.invalid && !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("want %v, have %v", ErrInvalidCursor, err)
+			}
+		})
+	}
+}
+
+func TestPaginateCursorHiddenFields(t *testing.T) {
+	people := []*Person{
+		{ID: 1, FirstName: "Alice", LastName: "Smith", EmployeeID: "secret-1", Department: "IT", SiteCode: 10, CardCode: 100},
+		{ID: 2, FirstName: "Bob", LastName: "Jones", EmployeeID: "secret-2", Department: "HR", SiteCode: 10, CardCode: 200},
+	}
+	hidden := map[string]bool{"employee_id": true, "card_code": true}
+	_, meta, err := paginateCursor(cursorRequest("last_name", ""), reflect.ValueOf(people), 1, hidden)
+	if err != nil {
+		t.Fatalf("could not paginate: %v", err)
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(meta.NextCursor)
+	if err != nil {
+		t.Fatalf("could not decode cursor: %v", err)
+	}
+	for _, s := range []string{"secret-2", "200", "Bob", "HR"} {
+		if strings.Contains(string(buf), s) {
+			t.Errorf("want cursor without %q, have %s", s, buf)
+		}
+	}
+
+	cur, err := decodeCursor(meta.NextCursor)
+	if err != nil {
+		t.Fatalf("could not decode cursor: %v", err)
+	}
+	if want := (&Person{ID: 2, LastName: "Jones"}); !reflect.DeepEqual(cur.Person, want) {
+		t.Errorf("want cursor person %+v, have %+v", want, cur.Person)
+	}
+}
+
+func TestPaginateCursorGroups(t *testing.T) {
+	groups := []*Group{{ID: 3, Name: "c"}, {ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+	page, meta, err := paginateCursor(cursorRequest("", ""), reflect.ValueOf(groups), 2, nil)
+	if err != nil {
+		t.Fatalf("could not paginate: %v", err)
+	}
+	if want := []*Group{groups[1], groups[2]}; !reflect.DeepEqual(page, want) {
+		t.Errorf("want %v, have %v", want, page)
+	}
+
+	page, meta, err = paginateCursor(cursorRequest("", meta.NextCursor), reflect.ValueOf(groups), 2, nil)
+	if err != nil {
+		t.Fatalf("could not paginate: %v", err)
+	}
+	if want := []*Group{groups[0]}; !reflect.DeepEqual(page, want) || meta.NextCursor != "" {
+		t.Errorf("want last page %v, have %v with cursor %q", want, page, meta.NextCursor)
+	}
+}
+
+func TestPaginateCursorFields(t *testing.T) {
+	s := new(Service)
+	h := s.HandleJSON(withPersonFields(func(r *http.Request) (interface{}, error) {
+		return cursorPeople(), nil
+	}))
+
+	var pages [][]map[string]interface{}
+	cursor := ""
+	for i := 0; i < 10; i++ {
+		q := url.Values{"fields": {"last_name"}, "per_page": {"4"}, "sort": {"last_name"}, "cursor": {cursor}}
+		r := httptest.NewRequest(http.MethodGet, "/people?"+q.Encode(), nil)
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyAPIVersion, APIVersion2))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("want status %d, have %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+
+		var resp struct {
+			Data []map[string]interface{} `json:"data"`
+			Meta v2CursorMeta             `json:"meta"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+		pages = append(pages, resp.Data)
+		if cursor = resp.Meta.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	want := [][]map[string]interface{}{
+		{{"last_name": "Adams"}, {"last_name": "Brown"}, {"last_name": "Clark"}, {"last_name": "Jones"}},
+		{{"last_name": "Smith"}, {"last_name": "Smith"}},
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("want pages %v, have %v", want, pages)
+	}
+}
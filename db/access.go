@@ -0,0 +1,115 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Access is a single effective access grant: a person can use a door during a schedule because of their membership in a group
+type Access struct {
+	PersonID   int
+	FirstName  string
+	LastName   string
+	GroupID    int
+	Group      string
+	DoorID     int
+	Door       string
+	ScheduleID int
+	Schedule   string
+}
+
+// AccessQuery filters ListAccess. Zero values match everything
+type AccessQuery struct {
+	PersonID int
+	DoorID   int
+}
+
+// ListAccess returns the effective access grants computed from group membership and the groups' access rules
+func (c *Conn) ListAccess(q *AccessQuery) ([]*Access, error) {
+	rows, err := c.QueryContext(c.context(), `select p.Id, p.FirstName, p.LastName, g.Id, g.Name, d.Id, d.Name, s.Id, s.Name
+from EAC.Person as p
+inner join EAC.PersonGroup as pg on pg.PersonId = p.Id
+inner join EAC.[Group] as g on g.Id = pg.GroupId
+inner join EAC.AccessRule as ar on ar.GroupId = g.Id
+inner join EAC.Door as d on d.Id = ar.DoorId
+left join EAC.Schedule as s on s.Id = ar.ScheduleId
+where (@p1 = 0 or p.Id = @p1) and (@p2 = 0 or d.Id = @p2)
+order by p.LastName, p.FirstName, p.Id, d.Name, d.Id`, q.PersonID, q.DoorID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query access: %w", err)
+	}
+	defer rows.Close()
+
+	access := make([]*Access, 0)
+	for rows.Next() {
+		var (
+			a          = new(Access)
+			first      sql.NullString
+			last       sql.NullString
+			scheduleID sql.NullInt64
+			schedule   sql.NullString
+		)
+		if err := rows.Scan(&a.PersonID, &first, &last, &a.GroupID, &a.Group, &a.DoorID, &a.Door, &scheduleID, &schedule); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		a.FirstName = first.String
+		a.LastName = last.String
+		a.ScheduleID = int(scheduleID.Int64)
+		a.Schedule = schedule.String
+		access = append(access, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return access, nil
+}
+
+// ListGroupMemberships returns the ids of each person's groups, keyed by person id
+func (c *Conn) ListGroupMemberships() (map[int][]int, error) {
+	rows, err := c.QueryContext(c.context(), "select PersonId, GroupId from EAC.PersonGroup order by PersonId, GroupId")
+	if err != nil {
+		return nil, fmt.Errorf("could not query group memberships: %w", err)
+	}
+	defer rows.Close()
+
+	memberships := make(map[int][]int)
+	for rows.Next() {
+		var personID, groupID int
+		if err := rows.Scan(&personID, &groupID); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		memberships[personID] = append(memberships[personID], groupID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return memberships, nil
+}
+
+// ListGroupMembers returns the ids of the people in the group with groupID, sorted
+func (c *Conn) ListGroupMembers(groupID int) ([]int, error) {
+	rows, err := c.QueryContext(c.context(), "select PersonId from EAC.PersonGroup where GroupId = @p1 order by PersonId", groupID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query group members: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return ids, nil
+}
@@ -5,8 +5,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/korylprince/go-infinias-api/tracing"
 )
 
 var (
@@ -16,10 +18,16 @@ var (
 
 type Conn struct {
 	*sql.DB
+	ctx context.Context
 }
 
 func NewConn(dsn string) (*Conn, error) {
-	db, err := sql.Open("sqlserver", dsn)
+	return NewTracedConn(dsn, nil)
+}
+
+// NewTracedConn is like NewConn, but starts a span with t and calls observers for each statement executed. If t is nil, no spans are started
+func NewTracedConn(dsn string, t *tracing.Tracer, observers ...tracing.QueryObserver) (*Conn, error) {
+	db, err := t.OpenDB("sqlserver", dsn, observers...)
 	if err != nil {
 		return nil, fmt.Errorf("could not open database connection: %w", err)
 	}
@@ -31,8 +39,22 @@ func NewConn(dsn string) (*Conn, error) {
 	return &Conn{DB: db}, nil
 }
 
+// WithContext returns a shallow copy of c that executes statements with ctx
+func (c *Conn) WithContext(ctx context.Context) *Conn {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+func (c *Conn) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 func (c *Conn) WithTx(fn func(tx *sql.Tx) error) error {
-	tx, err := c.Begin()
+	tx, err := c.BeginTx(c.context(), nil)
 	if err != nil {
 		return fmt.Errorf("could not start transaction: %w", err)
 	}
@@ -53,7 +75,7 @@ func (c *Conn) WithTx(fn func(tx *sql.Tx) error) error {
 
 func (c *Conn) ReadPicture(id int) ([]byte, error) {
 	var buf []byte
-	if err := c.QueryRow("select Image from EAC.PersonImage where PersonId = @p1", id).Scan(&buf); err != nil {
+	if err := c.QueryRowContext(c.context(), "select Image from EAC.PersonImage where PersonId = @p1", id).Scan(&buf); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -66,18 +88,18 @@ func (c *Conn) ReadPicture(id int) ([]byte, error) {
 func (c *Conn) UpdatePicture(id int, buf []byte) error {
 	return c.WithTx(func(tx *sql.Tx) error {
 		var count int
-		if err := tx.QueryRow("select count(*) from EAC.PersonImage where PersonId = @p1", id).Scan(&count); err != nil {
+		if err := tx.QueryRowContext(c.context(), "select count(*) from EAC.PersonImage where PersonId = @p1", id).Scan(&count); err != nil {
 			return fmt.Errorf("could not query row count: %w", err)
 		}
 
 		if count == 0 {
-			if _, err := tx.Exec("insert into EAC.PersonImage(PersonId, Image) values (@p1, @p2)", id, buf); err != nil {
+			if _, err := tx.ExecContext(c.context(), "insert into EAC.PersonImage(PersonId, Image) values (@p1, @p2)", id, buf); err != nil {
 				return fmt.Errorf("could not insert image: %w", err)
 			}
 			return nil
 		}
 
-		if _, err := tx.Exec("update EAC.PersonImage set Image = @p1 where PersonId = @p2", buf, id); err != nil {
+		if _, err := tx.ExecContext(c.context(), "update EAC.PersonImage set Image = @p1 where PersonId = @p2", buf, id); err != nil {
 			return fmt.Errorf("could not update image: %w", err)
 		}
 
@@ -85,9 +107,16 @@ func (c *Conn) UpdatePicture(id int, buf []byte) error {
 	})
 }
 
+func (c *Conn) DeletePicture(id int) error {
+	if _, err := c.ExecContext(c.context(), "delete from EAC.PersonImage where PersonId = @p1", id); err != nil {
+		return fmt.Errorf("could not delete picture: %w", err)
+	}
+	return nil
+}
+
 func (c *Conn) HasPictureIDs() ([]int, error) {
 	var ids []int
-	rows, err := c.QueryContext(context.Background(), "select Id from EAC.Person where Id in (select PersonId from EAC.PersonImage where Image is not null)")
+	rows, err := c.QueryContext(c.context(), "select Id from EAC.Person where Id in (select PersonId from EAC.PersonImage where Image is not null)")
 	if err != nil {
 		return nil, fmt.Errorf("could not query picture ids: %w", err)
 	}
@@ -110,7 +139,7 @@ func (c *Conn) HasPictureIDs() ([]int, error) {
 
 func (c *Conn) ListDepartments() (map[int]string, error) {
 	depts := make(map[int]string)
-	rows, err := c.QueryContext(context.Background(), "select Id, Department from EAC.Person where Department is not null")
+	rows, err := c.QueryContext(c.context(), "select Id, Department from EAC.Person where Department is not null")
 	if err != nil {
 		return nil, fmt.Errorf("could not query departments: %w", err)
 	}
@@ -137,18 +166,68 @@ type Credential struct {
 	Active   bool
 	SiteCode int
 	CardCode int
+	// Activation and Expiration bound when the credential works. A nil Expiration never expires
+	Activation *time.Time
+	Expiration *time.Time
+	// ClearActivation and ClearExpiration remove the dates of an existing credential. A nil Activation or
+	// Expiration otherwise leaves them unchanged
+	ClearActivation bool
+	ClearExpiration bool
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+// CredentialExistsError is returned when a credential's site and card code are already assigned to another person
+type CredentialExistsError struct {
+	PersonID     int
+	CredentialID int
+	SiteCode     int
+	CardCode     int
+}
+
+func (e *CredentialExistsError) Error() string {
+	return fmt.Sprintf("%s: %d-%d is assigned to person %d", ErrCredentialExists.Error(), e.SiteCode, e.CardCode, e.PersonID)
+}
+
+func (e *CredentialExistsError) Is(target error) bool {
+	return target == ErrCredentialExists
+}
+
+// CredentialOwner returns the person and credential ids for the given site and card code, or ErrNotFound
+func (c *Conn) CredentialOwner(siteCode, cardCode int) (personID, credID int, err error) {
+	if err := c.QueryRowContext(c.context(), "select cred.Id, cred.PersonId from EAC.Credential as cred inner join EAC.WiegandCredential as wiegand on cred.Id = wiegand.CredentialId where wiegand.SiteCode = @p1 and wiegand.CardCode = @p2 and CustomerZoneId = 1", siteCode, cardCode).Scan(&credID, &personID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, ErrNotFound
+		}
+		return 0, 0, fmt.Errorf("could not query credentials: %w", err)
+	}
+
+	return personID, credID, nil
 }
 
 func (c *Conn) CreateCredential(id int, cred *Credential) (int, error) {
 	// TODO: zone is currently hard set to 1
 	var credID int64
-	return int(credID), c.WithTx(func(tx *sql.Tx) error {
+	err := c.WithTx(func(tx *sql.Tx) error {
 		// check if credential exists
 		var (
 			personID int
 			active   bool
 		)
-		if err := tx.QueryRow("select cred.Id, cred.PersonId, cred.IsActive from EAC.Credential as cred inner join EAC.WiegandCredential as wiegand on cred.Id = wiegand.CredentialId where wiegand.SiteCode = @p1 and wiegand.CardCode = @p2 and CustomerZoneId = 1", cred.SiteCode, cred.CardCode).Scan(&credID, &personID, &active); err != nil {
+		if err := tx.QueryRowContext(c.context(), "select cred.Id, cred.PersonId, cred.IsActive from EAC.Credential as cred inner join EAC.WiegandCredential as wiegand on cred.Id = wiegand.CredentialId where wiegand.SiteCode = @p1 and wiegand.CardCode = @p2 and CustomerZoneId = 1", cred.SiteCode, cred.CardCode).Scan(&credID, &personID, &active); err != nil {
 			if !errors.Is(err, sql.ErrNoRows) {
 				return fmt.Errorf("could not query credentials: %w", err)
 			}
@@ -156,24 +235,26 @@ func (c *Conn) CreateCredential(id int, cred *Credential) (int, error) {
 
 		// credential exists for another user
 		if credID != 0 && personID != id {
-			return ErrCredentialExists
+			return &CredentialExistsError{PersonID: personID, CredentialID: int(credID), SiteCode: cred.SiteCode, CardCode: cred.CardCode}
 		}
 
 		// credential exists and matches
-		if credID != 0 && personID == id && cred.Active == active {
+		if credID != 0 && personID == id && cred.Active == active && cred.Activation == nil && cred.Expiration == nil && !cred.ClearActivation && !cred.ClearExpiration {
 			return nil
 		}
 
-		// credential exists but has mismatched status
+		// credential exists but has mismatched status or a new validity window
 		if credID != 0 && personID == id {
-			if _, err := tx.Exec("update EAC.Credential set IsActive = @p1 where Id = @p2", cred.Active, int(credID)); err != nil {
+			if _, err := tx.ExecContext(c.context(), "update EAC.Credential set IsActive = @p1, ActivationDateUTC = case when @p5 = 1 then null else coalesce(@p2, ActivationDateUTC) end, ExpirationDateUTC = case when @p6 = 1 then null else coalesce(@p3, ExpirationDateUTC) end where Id = @p4",
+				cred.Active, nullTime(cred.Activation), nullTime(cred.Expiration), int(credID), cred.ClearActivation, cred.ClearExpiration); err != nil {
 				return fmt.Errorf("could not update credential: %w", err)
 			}
 			return nil
 		}
 
 		// create credential
-		if err := tx.QueryRow("insert into EAC.Credential(IsActive, ActivationDateUTC, PersonId) values (@p1, CURRENT_TIMESTAMP, @p2); select ID = convert(bigint, SCOPE_IDENTITY())", cred.Active, id).Scan(&credID); err != nil {
+		if err := tx.QueryRowContext(c.context(), "insert into EAC.Credential(IsActive, ActivationDateUTC, ExpirationDateUTC, PersonId) values (@p1, coalesce(@p2, CURRENT_TIMESTAMP), @p3, @p4); select ID = convert(bigint, SCOPE_IDENTITY())",
+			cred.Active, nullTime(cred.Activation), nullTime(cred.Expiration), id).Scan(&credID); err != nil {
 			return fmt.Errorf("could not create credential: %w", err)
 		}
 
@@ -182,46 +263,144 @@ func (c *Conn) CreateCredential(id int, cred *Credential) (int, error) {
 		}
 
 		// create wiegand credential
-		if _, err := tx.Exec("insert into EAC.WiegandCredential(SiteCode, CardCode, CredentialId, CustomerZoneId, IsStringCredential) values (@p1, @p2, @p3, 1, 0)", cred.SiteCode, cred.CardCode, int(credID)); err != nil {
+		if _, err := tx.ExecContext(c.context(), "insert into EAC.WiegandCredential(SiteCode, CardCode, CredentialId, CustomerZoneId, IsStringCredential) values (@p1, @p2, @p3, 1, 0)", cred.SiteCode, cred.CardCode, int(credID)); err != nil {
 			return fmt.Errorf("could not create wiegand credential: %w", err)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(credID), nil
 }
 
+// DeleteCredential deletes the credential with credID from the person with id. ErrNotFound is returned if they don't have it
 func (c *Conn) DeleteCredential(id, credID int) error {
 	return c.WithTx(func(tx *sql.Tx) error {
 		// check if credential exists
-		row := tx.QueryRow("select count(*) from EAC.Credential where Id = @p1 and PersonId = @p2", credID, id)
+		row := tx.QueryRowContext(c.context(), "select count(*) from EAC.Credential where Id = @p1 and PersonId = @p2", credID, id)
 		var count int
 		if err := row.Scan(&count); err != nil {
 			return fmt.Errorf("could not count credentials: %w", err)
 		}
 
 		if count == 0 {
-			return nil
+			return ErrNotFound
 		} else if count > 1 {
 			return fmt.Errorf("unexpected credential count: %d", count)
 		}
 
-		// delete wiegand credentials
-		if _, err := tx.Exec("delete from EAC.WiegandCredential where CredentialId = @p1", credID); err != nil {
-			return fmt.Errorf("could not delete wiegand credentials: %w", err)
+		return c.deleteCredential(tx, credID)
+	})
+}
+
+// DeleteCredentialByID deletes the credential with credID whether or not it still belongs to a person,
+// e.g. after its person was deleted. ErrNotFound is returned if it doesn't exist
+func (c *Conn) DeleteCredentialByID(credID int) error {
+	return c.WithTx(func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(c.context(), "select count(*) from EAC.Credential where Id = @p1", credID)
+		var count int
+		if err := row.Scan(&count); err != nil {
+			return fmt.Errorf("could not count credentials: %w", err)
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+
+		return c.deleteCredential(tx, credID)
+	})
+}
+
+// deleteCredential deletes the credential with credID and its wiegand credential in tx
+func (c *Conn) deleteCredential(tx *sql.Tx, credID int) error {
+	// delete wiegand credentials
+	if _, err := tx.ExecContext(c.context(), "delete from EAC.WiegandCredential where CredentialId = @p1", credID); err != nil {
+		return fmt.Errorf("could not delete wiegand credentials: %w", err)
+	}
+
+	// delete credentials
+	if _, err := tx.ExecContext(c.context(), "delete from EAC.Credential where Id = @p1", credID); err != nil {
+		return fmt.Errorf("could not delete credentials: %w", err)
+	}
+
+	return nil
+}
+
+// DeactivateCredentials deactivates all of a person's active credentials, returning the ids of the deactivated credentials
+func (c *Conn) DeactivateCredentials(id int) ([]int, error) {
+	var ids []int
+	err := c.WithTx(func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(c.context(), "select Id from EAC.Credential where PersonId = @p1 and IsActive = 1", id)
+		if err != nil {
+			return fmt.Errorf("could not query credentials: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var credID int
+			if err = rows.Scan(&credID); err != nil {
+				return fmt.Errorf("could not scan row: %w", err)
+			}
+			ids = append(ids, credID)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("could not read rows: %w", err)
+		}
+		rows.Close()
+
+		if _, err = tx.ExecContext(c.context(), "update EAC.Credential set IsActive = 0 where PersonId = @p1 and IsActive = 1", id); err != nil {
+			return fmt.Errorf("could not update credentials: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// MoveCredentials moves all of a person's credentials to the person with toID, returning the ids of the moved credentials
+func (c *Conn) MoveCredentials(fromID, toID int) ([]int, error) {
+	var ids []int
+	err := c.WithTx(func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(c.context(), "select Id from EAC.Credential where PersonId = @p1", fromID)
+		if err != nil {
+			return fmt.Errorf("could not query credentials: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var credID int
+			if err = rows.Scan(&credID); err != nil {
+				return fmt.Errorf("could not scan row: %w", err)
+			}
+			ids = append(ids, credID)
 		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("could not read rows: %w", err)
+		}
+		rows.Close()
 
-		// delete credentials
-		if _, err := tx.Exec("delete from EAC.Credential where Id = @p1", credID); err != nil {
-			return fmt.Errorf("could not delete credentials: %w", err)
+		if _, err = tx.ExecContext(c.context(), "update EAC.Credential set PersonId = @p1 where PersonId = @p2", toID, fromID); err != nil {
+			return fmt.Errorf("could not update credentials: %w", err)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
 }
 
 func (c *Conn) ListCredentials(id int) ([]*Credential, error) {
 	creds := make([]*Credential, 0)
-	rows, err := c.QueryContext(context.Background(), "select cred.Id, cred.IsActive, wiegand.SiteCode, wiegand.CardCode from EAC.credential as cred inner join EAC.WiegandCredential as wiegand on cred.PersonId = @p1 and cred.Id = wiegand.CredentialId", id)
+	rows, err := c.QueryContext(c.context(), "select cred.Id, cred.IsActive, wiegand.SiteCode, wiegand.CardCode, cred.ActivationDateUTC, cred.ExpirationDateUTC from EAC.credential as cred inner join EAC.WiegandCredential as wiegand on cred.PersonId = @p1 and cred.Id = wiegand.CredentialId", id)
 
 	if err != nil {
 		return nil, fmt.Errorf("could not query credentials: %w", err)
@@ -230,9 +409,11 @@ func (c *Conn) ListCredentials(id int) ([]*Credential, error) {
 
 	for rows.Next() {
 		cred := new(Credential)
-		if err := rows.Scan(&cred.ID, &cred.Active, &cred.SiteCode, &cred.CardCode); err != nil {
+		var activation, expiration sql.NullTime
+		if err := rows.Scan(&cred.ID, &cred.Active, &cred.SiteCode, &cred.CardCode, &activation, &expiration); err != nil {
 			return nil, fmt.Errorf("could not scan row: %w", err)
 		}
+		cred.Activation, cred.Expiration = timePtr(activation), timePtr(expiration)
 		creds = append(creds, cred)
 	}
 
@@ -245,7 +426,7 @@ func (c *Conn) ListCredentials(id int) ([]*Credential, error) {
 
 func (c *Conn) ListAllCredentials() (map[int][]*Credential, error) {
 	creds := make(map[int][]*Credential)
-	rows, err := c.QueryContext(context.Background(), "select cred.PersonId, cred.Id, cred.IsActive, wiegand.SiteCode, wiegand.CardCode from EAC.credential as cred inner join EAC.WiegandCredential as wiegand on cred.Id = wiegand.CredentialId")
+	rows, err := c.QueryContext(c.context(), "select cred.PersonId, cred.Id, cred.IsActive, wiegand.SiteCode, wiegand.CardCode, cred.ActivationDateUTC, cred.ExpirationDateUTC from EAC.credential as cred inner join EAC.WiegandCredential as wiegand on cred.Id = wiegand.CredentialId")
 
 	if err != nil {
 		return nil, fmt.Errorf("could not query credentials: %w", err)
@@ -255,9 +436,11 @@ func (c *Conn) ListAllCredentials() (map[int][]*Credential, error) {
 	for rows.Next() {
 		var id int
 		cred := new(Credential)
-		if err := rows.Scan(&id, &cred.ID, &cred.Active, &cred.SiteCode, &cred.CardCode); err != nil {
+		var activation, expiration sql.NullTime
+		if err := rows.Scan(&id, &cred.ID, &cred.Active, &cred.SiteCode, &cred.CardCode, &activation, &expiration); err != nil {
 			return nil, fmt.Errorf("could not scan row: %w", err)
 		}
+		cred.Activation, cred.Expiration = timePtr(activation), timePtr(expiration)
 		creds[id] = append(creds[id], cred)
 	}
 
@@ -0,0 +1,274 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventColumns = "e.Id, e.EventTypeId, t.Name, e.PersonId, e.DoorId, d.Name, e.EventDateUTC, e.Description"
+	eventTables  = "from EAC.Event as e inner join EAC.EventType as t on e.EventTypeId = t.Id left join EAC.Door as d on e.DoorId = d.Id"
+)
+
+type Event struct {
+	ID          int64
+	TypeID      int
+	Type        string
+	PersonID    int
+	DoorID      int
+	Door        string
+	Time        time.Time
+	Description string
+}
+
+func (c *Conn) LatestEventID() (int64, error) {
+	var id sql.NullInt64
+	if err := c.QueryRowContext(c.context(), "select max(Id) from EAC.Event").Scan(&id); err != nil {
+		return 0, fmt.Errorf("could not query latest event id: %w", err)
+	}
+
+	return id.Int64, nil
+}
+
+func scanEvents(rows *sql.Rows) ([]*Event, error) {
+	events := make([]*Event, 0)
+	for rows.Next() {
+		var (
+			e           = new(Event)
+			personID    sql.NullInt64
+			doorID      sql.NullInt64
+			door        sql.NullString
+			description sql.NullString
+		)
+		if err := rows.Scan(&e.ID, &e.TypeID, &e.Type, &personID, &doorID, &door, &e.Time, &description); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		e.PersonID = int(personID.Int64)
+		e.DoorID = int(doorID.Int64)
+		e.Door = door.String
+		e.Description = description.String
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return events, nil
+}
+
+func (c *Conn) ListEventsSince(id int64, limit int) ([]*Event, error) {
+	rows, err := c.QueryContext(c.context(), "select top (@p2) "+eventColumns+" "+eventTables+" where e.Id > @p1 order by e.Id", id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not query events: %w", err)
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// typeCondition returns a condition matching events e with a type t whose name or id is in types.
+// param adds an argument and returns its placeholder
+func typeCondition(types []string, param func(interface{}) string) string {
+	var names, ids []string
+	for _, t := range types {
+		if id, err := strconv.Atoi(t); err == nil {
+			ids = append(ids, param(id))
+		} else {
+			names = append(names, param(t))
+		}
+	}
+	var or []string
+	if len(names) > 0 {
+		or = append(or, "t.Name in ("+strings.Join(names, ", ")+")")
+	}
+	if len(ids) > 0 {
+		or = append(or, "e.EventTypeId in ("+strings.Join(ids, ", ")+")")
+	}
+	return "(" + strings.Join(or, " or ") + ")"
+}
+
+// EventQuery filters events returned by QueryEvents. Zero values match everything
+type EventQuery struct {
+	PersonID int
+	DoorID   int
+	// Types matches event type names or ids
+	Types  []string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// QueryEvents returns matching events, newest first, and the total number of matching events
+func (c *Conn) QueryEvents(q *EventQuery) ([]*Event, int, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("@p%d", len(args))
+	}
+
+	if q.PersonID != 0 {
+		where = append(where, "e.PersonId = "+param(q.PersonID))
+	}
+	if q.DoorID != 0 {
+		where = append(where, "e.DoorId = "+param(q.DoorID))
+	}
+	if len(q.Types) > 0 {
+		where = append(where, typeCondition(q.Types, param))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "e.EventDateUTC >= "+param(q.Since.UTC()))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "e.EventDateUTC <= "+param(q.Until.UTC()))
+	}
+
+	clause := ""
+	if len(where) > 0 {
+		clause = " where " + strings.Join(where, " and ")
+	}
+
+	var total int
+	if err := c.QueryRowContext(c.context(), "select count(*) "+eventTables+clause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("could not count events: %w", err)
+	}
+
+	page := fmt.Sprintf(" order by e.Id desc offset %s rows fetch next %s rows only", param(q.Offset), param(q.Limit))
+	rows, err := c.QueryContext(c.context(), "select "+eventColumns+" "+eventTables+clause+page, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not query events: %w", err)
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// InactivePerson is a person with an active credential and the time of their last event, if any
+type InactivePerson struct {
+	PersonID     int
+	FirstName    string
+	LastName     string
+	Department   string
+	LastActivity *time.Time
+}
+
+// ListInactivePeople returns people with an active credential and no events since t, including those with no events
+// at all, longest inactive first
+func (c *Conn) ListInactivePeople(t time.Time) ([]*InactivePerson, error) {
+	rows, err := c.QueryContext(c.context(), `select p.Id, p.FirstName, p.LastName, p.Department, max(e.EventDateUTC)
+from EAC.Person as p
+left join EAC.Event as e on e.PersonId = p.Id
+where exists (select 1 from EAC.Credential as cred where cred.PersonId = p.Id and cred.IsActive = 1)
+group by p.Id, p.FirstName, p.LastName, p.Department
+having max(e.EventDateUTC) is null or max(e.EventDateUTC) < @p1
+order by max(e.EventDateUTC), p.Id`, t.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not query inactive people: %w", err)
+	}
+	defer rows.Close()
+
+	people := make([]*InactivePerson, 0)
+	for rows.Next() {
+		var (
+			p                 = new(InactivePerson)
+			first, last, dept sql.NullString
+			lastActivity      sql.NullTime
+		)
+		if err := rows.Scan(&p.PersonID, &first, &last, &dept, &lastActivity); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		p.FirstName, p.LastName, p.Department = first.String, last.String, dept.String
+		if lastActivity.Valid {
+			utc := lastActivity.Time.UTC()
+			p.LastActivity = &utc
+		}
+		people = append(people, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return people, nil
+}
+
+// LastDoorEvent is a person's last event at one of a set of doors
+type LastDoorEvent struct {
+	PersonID   int
+	FirstName  string
+	LastName   string
+	Department string
+	DoorID     int
+	Door       string
+	Time       time.Time
+}
+
+// ListLastDoorEvents returns each person's last event since t at one of doorIDs, sorted by name.
+// If types is set, only events whose type name or id is in types are considered
+func (c *Conn) ListLastDoorEvents(doorIDs []int, types []string, t time.Time) ([]*LastDoorEvent, error) {
+	if len(doorIDs) == 0 {
+		return make([]*LastDoorEvent, 0), nil
+	}
+
+	var args []interface{}
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("@p%d", len(args))
+	}
+
+	doors := make([]string, len(doorIDs))
+	for idx, id := range doorIDs {
+		doors[idx] = param(id)
+	}
+	where := []string{"e.PersonId is not null", "e.DoorId in (" + strings.Join(doors, ", ") + ")", "e.EventDateUTC >= " + param(t.UTC())}
+	if len(types) > 0 {
+		where = append(where, typeCondition(types, param))
+	}
+
+	rows, err := c.QueryContext(c.context(), `select p.Id, p.FirstName, p.LastName, p.Department, last.DoorId, d.Name, last.EventDateUTC
+from EAC.Event as last
+inner join EAC.Person as p on p.Id = last.PersonId
+left join EAC.Door as d on d.Id = last.DoorId
+where last.Id in (
+	select max(e.Id) from EAC.Event as e inner join EAC.EventType as t on e.EventTypeId = t.Id
+	where `+strings.Join(where, " and ")+`
+	group by e.PersonId
+)
+order by p.LastName, p.FirstName, p.Id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query last door events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]*LastDoorEvent, 0)
+	for rows.Next() {
+		var (
+			e                       = new(LastDoorEvent)
+			first, last, dept, door sql.NullString
+		)
+		if err := rows.Scan(&e.PersonID, &first, &last, &dept, &e.DoorID, &door, &e.Time); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		e.FirstName, e.LastName, e.Department, e.Door = first.String, last.String, dept.String, door.String
+		e.Time = e.Time.UTC()
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ExpiredCredential is a credential deactivated by ExpireCredentials
+type ExpiredCredential struct {
+	PersonID     int
+	CredentialID int
+}
+
+// ExpireCredentials deactivates active credentials whose expiration has passed
+func (c *Conn) ExpireCredentials() ([]*ExpiredCredential, error) {
+	expired := make([]*ExpiredCredential, 0)
+	err := c.WithTx(func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(c.context(), "select PersonId, Id from EAC.Credential with (updlock) where IsActive = 1 and ExpirationDateUTC < SYSUTCDATETIME()")
+		if err != nil {
+			return fmt.Errorf("could not query credentials: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			e := new(ExpiredCredential)
+			if err = rows.Scan(&e.PersonID, &e.CredentialID); err != nil {
+				return fmt.Errorf("could not scan row: %w", err)
+			}
+			expired = append(expired, e)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("could not read rows: %w", err)
+		}
+		rows.Close()
+
+		for _, e := range expired {
+			if _, err = tx.ExecContext(c.context(), "update EAC.Credential set IsActive = 0 where Id = @p1", e.CredentialID); err != nil {
+				return fmt.Errorf("could not update credential %d: %w", e.CredentialID, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+// Orphans is the result of DeleteOrphans
+type Orphans struct {
+	Pictures           int64
+	WiegandCredentials int64
+	Credentials        int64
+}
+
+// DeleteOrphans deletes pictures and credentials that belong to people that no longer exist,
+// and wiegand rows that belong to credentials that no longer exist
+func (c *Conn) DeleteOrphans() (*Orphans, error) {
+	o := new(Orphans)
+	err := c.WithTx(func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(c.context(), "delete from EAC.PersonImage where PersonId not in (select Id from EAC.Person)")
+		if err != nil {
+			return fmt.Errorf("could not delete pictures: %w", err)
+		}
+		if o.Pictures, err = res.RowsAffected(); err != nil {
+			return fmt.Errorf("could not count pictures: %w", err)
+		}
+
+		if res, err = tx.ExecContext(c.context(), `delete from EAC.WiegandCredential where CredentialId not in (select Id from EAC.Credential)
+or CredentialId in (select Id from EAC.Credential where PersonId not in (select Id from EAC.Person))`); err != nil {
+			return fmt.Errorf("could not delete wiegand credentials: %w", err)
+		}
+		if o.WiegandCredentials, err = res.RowsAffected(); err != nil {
+			return fmt.Errorf("could not count wiegand credentials: %w", err)
+		}
+
+		if res, err = tx.ExecContext(c.context(), "delete from EAC.Credential where PersonId not in (select Id from EAC.Person)"); err != nil {
+			return fmt.Errorf("could not delete credentials: %w", err)
+		}
+		if o.Credentials, err = res.RowsAffected(); err != nil {
+			return fmt.Errorf("could not count credentials: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// ExpiringCredential is an active credential that expires soon, and its owner
+type ExpiringCredential struct {
+	PersonID   int
+	FirstName  string
+	LastName   string
+	Department string
+	Credential
+}
+
+// ListExpiringCredentials returns active credentials that haven't expired yet and expire before t, soonest first
+func (c *Conn) ListExpiringCredentials(t time.Time) ([]*ExpiringCredential, error) {
+	rows, err := c.QueryContext(c.context(), `select p.Id, p.FirstName, p.LastName, p.Department, cred.Id, cred.IsActive, wiegand.SiteCode, wiegand.CardCode, cred.ActivationDateUTC, cred.ExpirationDateUTC
+from EAC.Credential as cred
+inner join EAC.WiegandCredential as wiegand on wiegand.CredentialId = cred.Id
+inner join EAC.Person as p on p.Id = cred.PersonId
+where cred.IsActive = 1 and cred.ExpirationDateUTC >= SYSUTCDATETIME() and cred.ExpirationDateUTC < @p1
+order by cred.ExpirationDateUTC, p.Id, cred.Id`, t.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("could not query credentials: %w", err)
+	}
+	defer rows.Close()
+
+	creds := make([]*ExpiringCredential, 0)
+	for rows.Next() {
+		var (
+			e                      = new(ExpiringCredential)
+			first, last, dept      sql.NullString
+			activation, expiration sql.NullTime
+		)
+		if err := rows.Scan(&e.PersonID, &first, &last, &dept, &e.ID, &e.Active, &e.SiteCode, &e.CardCode, &activation, &expiration); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		e.FirstName, e.LastName, e.Department = first.String, last.String, dept.String
+		e.Activation, e.Expiration = timePtr(activation), timePtr(expiration)
+		creds = append(creds, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return creds, nil
+}
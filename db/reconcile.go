@@ -0,0 +1,79 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// CredentialRow is a row in EAC.Credential and its wiegand row, if any
+type CredentialRow struct {
+	ID       int
+	PersonID int
+	// HasPerson is false if PersonId is null, in which case PersonID is 0
+	HasPerson  bool
+	Active     bool
+	HasWiegand bool
+	SiteCode   int
+	CardCode   int
+}
+
+// ListPersonIDs returns the ids of all rows in EAC.Person
+func (c *Conn) ListPersonIDs() ([]int, error) {
+	rows, err := c.QueryContext(c.context(), "select Id from EAC.Person order by Id")
+	if err != nil {
+		return nil, fmt.Errorf("could not query people: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return ids, nil
+}
+
+// ListCredentialRows returns all rows in EAC.Credential, including those without a wiegand row
+func (c *Conn) ListCredentialRows() ([]*CredentialRow, error) {
+	rows, err := c.QueryContext(c.context(), `select cred.Id, cred.PersonId, cred.IsActive, wiegand.SiteCode, wiegand.CardCode
+from EAC.Credential as cred
+left join EAC.WiegandCredential as wiegand on wiegand.CredentialId = cred.Id
+order by cred.PersonId, cred.Id`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query credentials: %w", err)
+	}
+	defer rows.Close()
+
+	creds := make([]*CredentialRow, 0)
+	for rows.Next() {
+		var (
+			cred     = new(CredentialRow)
+			personID sql.NullInt64
+			siteCode sql.NullInt64
+			cardCode sql.NullInt64
+		)
+		if err := rows.Scan(&cred.ID, &personID, &cred.Active, &siteCode, &cardCode); err != nil {
+			return nil, fmt.Errorf("could not scan row: %w", err)
+		}
+		cred.PersonID = int(personID.Int64)
+		cred.HasPerson = personID.Valid
+		cred.HasWiegand = siteCode.Valid && cardCode.Valid
+		cred.SiteCode = int(siteCode.Int64)
+		cred.CardCode = int(cardCode.Int64)
+		creds = append(creds, cred)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %w", err)
+	}
+
+	return creds, nil
+}
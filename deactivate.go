@@ -0,0 +1,128 @@
+package infinias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// BulkDeactivation is the body of BulkDeactivateHandler. Exactly one of Department and GroupID must be set
+type BulkDeactivation struct {
+	Department   string `json:"department"`
+	GroupID      int    `json:"group_id"`
+	RemoveGroups bool   `json:"remove_groups"`
+}
+
+// BulkDeactivationResult is the result of BulkDeactivate
+type BulkDeactivationResult struct {
+	PeopleDeactivated      int      `json:"people_deactivated"`
+	CredentialsDeactivated int      `json:"credentials_deactivated"`
+	GroupsRemoved          int      `json:"groups_removed"`
+	Errors                 []string `json:"errors,omitempty"`
+}
+
+func (b *BulkDeactivation) validate() error {
+	b.Department = strings.TrimSpace(b.Department)
+	if (b.Department == "") == (b.GroupID == 0) {
+		return fmt.Errorf("%w: exactly one of department and group_id is required", ErrInvalidFilter)
+	}
+	if b.GroupID < 0 {
+		return fmt.Errorf("%w: group_id must be a valid group id", ErrInvalidFilter)
+	}
+	return nil
+}
+
+// bulkDeactivationTargets returns the ids of the people matching b's filter, sorted
+func (s *Service) bulkDeactivationTargets(b *BulkDeactivation) ([]int, error) {
+	var ids []int
+	if b.Department != "" {
+		members, err := s.departmentMembers(b.Department)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range members {
+			ids = append(ids, p.ID)
+		}
+		return ids, nil
+	}
+
+	ids, err := s.DBConn.ListGroupMembers(b.GroupID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list group members: %w", err)
+	}
+	return ids, nil
+}
+
+// BulkDeactivate deactivates everyone matching b with DeactivatePerson.
+// Errors for individual people are collected in the result instead of stopping the deactivation
+func (s *Service) BulkDeactivate(ctx context.Context, b *BulkDeactivation) (*BulkDeactivationResult, error) {
+	s = s.WithContext(ctx)
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	ids, err := s.bulkDeactivationTargets(b)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(BulkDeactivationResult)
+	for idx, id := range ids {
+		ReportProgress(ctx, idx, len(ids))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		d, err := s.DeactivatePerson(id, b.RemoveGroups)
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("person %d: %v", id, err))
+			continue
+		}
+		res.PeopleDeactivated++
+		res.CredentialsDeactivated += len(d.CredentialsDeactivated)
+		res.GroupsRemoved += len(d.GroupsRemoved)
+	}
+
+	return res, nil
+}
+
+// BulkDeactivateHandler starts a job deactivating everyone in a department or group
+func (s *Service) BulkDeactivateHandler(r *http.Request) (interface{}, error) {
+	b := new(BulkDeactivation)
+	if err := readJSON(r, b); err != nil {
+		return nil, err
+	}
+	if err := b.validate(); err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: err}
+	}
+
+	return s.submitJob(r, "bulk_deactivate", func(ctx context.Context) (interface{}, error) {
+		return s.BulkDeactivate(ctx, b)
+	})
+}
+
+// BulkDeactivateDryRunHandler returns the people BulkDeactivateHandler would deactivate
+func (s *Service) BulkDeactivateDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	b := new(BulkDeactivation)
+	if err := readJSON(r, b); err != nil {
+		return nil, err
+	}
+	if err := b.validate(); err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: err}
+	}
+
+	ids, err := s.bulkDeactivationTargets(b)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+	if ids == nil {
+		ids = make([]int, 0)
+	}
+
+	return &DryRunResult{DryRun: true, Action: EventPersonDeactivated, Old: map[string][]int{"person_ids": ids}, Changed: len(ids) > 0}, nil
+}
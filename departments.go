@@ -0,0 +1,147 @@
+package infinias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/korylprince/go-infinias-api/api"
+)
+
+var ErrInvalidDepartment = errors.New("invalid department")
+
+// DepartmentRename is the body of RenameDepartmentHandler
+type DepartmentRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DepartmentRenameResult is the result of RenameDepartment
+type DepartmentRenameResult struct {
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	PeopleUpdated int      `json:"people_updated"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+func (d *DepartmentRename) validate() error {
+	d.From, d.To = strings.TrimSpace(d.From), strings.TrimSpace(d.To)
+	if d.From == "" || d.To == "" {
+		return fmt.Errorf("%w: from and to are required", ErrInvalidDepartment)
+	}
+	if d.From == d.To {
+		return fmt.Errorf("%w: from and to are the same", ErrInvalidDepartment)
+	}
+	return nil
+}
+
+// departmentMembers returns the people whose department is dept, sorted by id
+func (s *Service) departmentMembers(dept string) ([]*api.Person, error) {
+	depts, err := s.DBConn.ListDepartments()
+	if err != nil {
+		return nil, fmt.Errorf("could not list departments: %w", err)
+	}
+
+	people, err := s.APIConn.ListPeople()
+	if err != nil {
+		return nil, fmt.Errorf("could not list people: %w", err)
+	}
+
+	var members []*api.Person
+	for _, p := range people {
+		if depts[p.ID] == dept {
+			members = append(members, p)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members, nil
+}
+
+// RenameDepartment moves everyone in department from to department to through the Infinias API.
+// Errors for individual people are collected in the result instead of stopping the rename
+func (s *Service) RenameDepartment(ctx context.Context, from, to string) (*DepartmentRenameResult, error) {
+	s = s.WithContext(ctx)
+	rename := &DepartmentRename{From: from, To: to}
+	if err := rename.validate(); err != nil {
+		return nil, err
+	}
+
+	members, err := s.departmentMembers(rename.From)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &DepartmentRenameResult{From: rename.From, To: rename.To}
+	for idx, p := range members {
+		ReportProgress(ctx, idx, len(members))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := s.APIConn.UpdatePerson(&api.Person{ID: p.ID, Department: rename.To}); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("person %d (%s %s): %v", p.ID, p.FirstName, p.LastName, err))
+			continue
+		}
+		res.PeopleUpdated++
+		s.notify(EventPersonUpdated, &Person{
+			ID:         p.ID,
+			FirstName:  p.FirstName,
+			LastName:   p.LastName,
+			EmployeeID: p.EmployeeID,
+			Department: rename.To,
+			SiteCode:   p.SiteCode,
+			CardCode:   p.CardCode,
+		})
+	}
+	s.notify(EventDepartmentRenamed, res)
+
+	return res, nil
+}
+
+// RenameDepartmentHandler starts a job moving everyone in a department to another one
+func (s *Service) RenameDepartmentHandler(r *http.Request) (interface{}, error) {
+	rename := new(DepartmentRename)
+	if err := readJSON(r, rename); err != nil {
+		return nil, err
+	}
+	if err := rename.validate(); err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: err}
+	}
+
+	return s.submitJob(r, "department_rename", func(ctx context.Context) (interface{}, error) {
+		return s.RenameDepartment(ctx, rename.From, rename.To)
+	})
+}
+
+// RenameDepartmentDryRunHandler returns how many people RenameDepartmentHandler would update
+func (s *Service) RenameDepartmentDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	rename := new(DepartmentRename)
+	if err := readJSON(r, rename); err != nil {
+		return nil, err
+	}
+	if err := rename.validate(); err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: err}
+	}
+
+	members, err := s.departmentMembers(rename.From)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+
+	ids := make([]int, len(members))
+	for idx, p := range members {
+		ids[idx] = p.ID
+	}
+
+	return &DryRunResult{
+		DryRun:  true,
+		Action:  EventDepartmentRenamed,
+		Old:     map[string]interface{}{"department": rename.From, "person_ids": ids},
+		New:     map[string]interface{}{"department": rename.To},
+		Changed: len(ids) > 0,
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Package directory reads users from cloud identity providers
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	tokenLeeway    = time.Minute
+)
+
+// User is a person as seen by a directory
+type User struct {
+	EmployeeID string
+	FirstName  string
+	LastName   string
+	Department string
+}
+
+// Source is a directory that can list its users
+type Source interface {
+	Users(ctx context.Context) ([]*User, error)
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// tokenCache caches an OAuth 2.0 access token until shortly before it expires
+type tokenCache struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (c *tokenCache) get(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	tok := new(tokenResponse)
+	if err = doJSON(client, req, tok); err != nil {
+		return "", fmt.Errorf("could not get token: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("could not get token: empty access_token")
+	}
+
+	c.token = tok.AccessToken
+	c.expires = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - tokenLeeway)
+
+	return c.token, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, u, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doJSON(client, req, v)
+}
+
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	r, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not %s %s: %w", req.Method, req.URL.Redacted(), err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 512))
+		return fmt.Errorf("could not %s %s: unexpected status: %s: %s", req.Method, req.URL.Redacted(), r.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	return nil
+}
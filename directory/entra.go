@@ -0,0 +1,88 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	entraTokenURL = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+	entraUsersURL = "https://graph.microsoft.com/v1.0/users"
+	entraScope    = "https://graph.microsoft.com/.default"
+	entraSelect   = "givenName,surname,employeeId,department"
+)
+
+// Entra reads users from Microsoft Entra ID (Azure AD) with the Microsoft Graph API.
+// The app registration needs the User.Read.All application permission
+type Entra struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// Filter is an optional OData $filter expression, e.g. "accountEnabled eq true"
+	Filter string
+	Client *http.Client
+
+	tokens tokenCache
+}
+
+// NewEntra returns a new Entra for the given tenant and app registration
+func NewEntra(tenantID, clientID, clientSecret string) *Entra {
+	return &Entra{
+		TenantID:     tenantID,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Client:       &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+type entraUser struct {
+	GivenName  string `json:"givenName"`
+	Surname    string `json:"surname"`
+	EmployeeID string `json:"employeeId"`
+	Department string `json:"department"`
+}
+
+type entraUsersResponse struct {
+	Value    []*entraUser `json:"value"`
+	NextLink string       `json:"@odata.nextLink"`
+}
+
+// Users returns all users matching Filter
+func (e *Entra) Users(ctx context.Context) ([]*User, error) {
+	token, err := e.tokens.get(ctx, e.Client, fmt.Sprintf(entraTokenURL, url.PathEscape(e.TenantID)), url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {e.ClientID},
+		"client_secret": {e.ClientSecret},
+		"scope":         {entraScope},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"$select": {entraSelect}, "$top": {"999"}}
+	if e.Filter != "" {
+		query.Set("$filter", e.Filter)
+	}
+	next := entraUsersURL + "?" + query.Encode()
+
+	users := make([]*User, 0)
+	for next != "" {
+		resp := new(entraUsersResponse)
+		if err := getJSON(ctx, e.Client, next, token, resp); err != nil {
+			return nil, fmt.Errorf("could not list users: %w", err)
+		}
+		for _, u := range resp.Value {
+			users = append(users, &User{
+				EmployeeID: u.EmployeeID,
+				FirstName:  u.GivenName,
+				LastName:   u.Surname,
+				Department: u.Department,
+			})
+		}
+		next = resp.NextLink
+	}
+
+	return users, nil
+}
@@ -0,0 +1,195 @@
+package directory
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	googleTokenURL  = "https://oauth2.googleapis.com/token"
+	googleUsersURL  = "https://admin.googleapis.com/admin/directory/v1/users"
+	googleScope     = "https://www.googleapis.com/auth/admin.directory.user.readonly"
+	googleGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	googleCustomer  = "my_customer"
+)
+
+var ErrInvalidKey = errors.New("invalid private key")
+
+// Google reads users from Google Workspace with the Admin SDK Directory API.
+// The service account needs domain-wide delegation for the admin.directory.user.readonly scope
+type Google struct {
+	ClientEmail string
+	PrivateKey  *rsa.PrivateKey
+	TokenURL    string
+	// Subject is the email of an admin the service account impersonates
+	Subject string
+	// Customer is the Workspace customer ID. Defaults to my_customer
+	Customer string
+	// Query is an optional Admin SDK user search query, e.g. "isSuspended=false"
+	Query  string
+	Client *http.Client
+
+	tokens tokenCache
+}
+
+type googleKeyFile struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// NewGoogle returns a new Google using the service account JSON key file at path, impersonating subject
+func NewGoogle(path, subject string) (*Google, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+
+	f := new(googleKeyFile)
+	if err = json.Unmarshal(buf, f); err != nil {
+		return nil, fmt.Errorf("could not parse key file: %w", err)
+	}
+
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("could not decode private key: %w", ErrInvalidKey)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("could not parse private key: %w: not RSA", ErrInvalidKey)
+	}
+
+	g := &Google{
+		ClientEmail: f.ClientEmail,
+		PrivateKey:  rsaKey,
+		TokenURL:    f.TokenURI,
+		Subject:     subject,
+		Customer:    googleCustomer,
+		Client:      &http.Client{Timeout: defaultTimeout},
+	}
+	if g.TokenURL == "" {
+		g.TokenURL = googleTokenURL
+	}
+
+	return g, nil
+}
+
+// assertion returns a signed JWT used to request an access token
+func (g *Google) assertion() (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("could not encode header: %w", err)
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   g.ClientEmail,
+		"sub":   g.Subject,
+		"scope": googleScope,
+		"aud":   g.TokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not encode claims: %w", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, g.PrivateKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign assertion: %w", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+type googleUser struct {
+	Name struct {
+		GivenName  string `json:"givenName"`
+		FamilyName string `json:"familyName"`
+	} `json:"name"`
+	ExternalIDs []struct {
+		Value string `json:"value"`
+		Type  string `json:"type"`
+	} `json:"externalIds"`
+	Organizations []struct {
+		Department string `json:"department"`
+		Primary    bool   `json:"primary"`
+	} `json:"organizations"`
+}
+
+type googleUsersResponse struct {
+	Users         []*googleUser `json:"users"`
+	NextPageToken string        `json:"nextPageToken"`
+}
+
+func (u *googleUser) user() *User {
+	user := &User{FirstName: u.Name.GivenName, LastName: u.Name.FamilyName}
+	for _, id := range u.ExternalIDs {
+		if id.Type == "organization" {
+			user.EmployeeID = id.Value
+			break
+		}
+	}
+	for idx, org := range u.Organizations {
+		if org.Primary || idx == 0 {
+			user.Department = org.Department
+		}
+	}
+	return user
+}
+
+// Users returns all users matching Query. The employee ID is read from the user's organization external ID
+func (g *Google) Users(ctx context.Context) ([]*User, error) {
+	assertion, err := g.assertion()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := g.tokens.get(ctx, g.Client, g.TokenURL, url.Values{
+		"grant_type": {googleGrantType},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"customer": {g.Customer}, "maxResults": {"500"}, "projection": {"full"}}
+	if g.Query != "" {
+		query.Set("query", g.Query)
+	}
+
+	users := make([]*User, 0)
+	for {
+		resp := new(googleUsersResponse)
+		if err := getJSON(ctx, g.Client, googleUsersURL+"?"+query.Encode(), token, resp); err != nil {
+			return nil, fmt.Errorf("could not list users: %w", err)
+		}
+		for _, u := range resp.Users {
+			users = append(users, u.user())
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", resp.NextPageToken)
+	}
+
+	return users, nil
+}
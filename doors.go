@@ -0,0 +1,86 @@
+package infinias
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/korylprince/go-infinias-api/api"
+)
+
+type Door struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type doorEvent struct {
+	DoorID int `json:"door_id"`
+}
+
+var doorCommandEvents = map[api.DoorCommand]string{
+	api.DoorCommandLock:   EventDoorLocked,
+	api.DoorCommandUnlock: EventDoorUnlocked,
+	api.DoorCommandPulse:  EventDoorPulsed,
+}
+
+func (s *Service) ListDoors() ([]*Door, error) {
+	apiDoors, err := s.APIConn.ListDoors()
+	if err != nil {
+		return nil, fmt.Errorf("could not list doors: %w", err)
+	}
+
+	doors := make([]*Door, len(apiDoors))
+	for idx, d := range apiDoors {
+		doors[idx] = &Door{ID: d.ID, Name: d.Name}
+	}
+
+	return doors, nil
+}
+
+// SendDoorCommand locks, unlocks, or pulses the door with the given id
+func (s *Service) SendDoorCommand(id int, cmd api.DoorCommand) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	if err := s.APIConn.SendDoorCommand(id, cmd); err != nil {
+		return fmt.Errorf("could not send door command: %w", err)
+	}
+
+	s.notify(doorCommandEvents[cmd], &doorEvent{DoorID: id})
+
+	return nil
+}
+
+func (s *Service) ListDoorsHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	doors, err := s.ListDoors()
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list doors: %w", err)}
+	}
+
+	return doors, nil
+}
+
+// doorCommandHandler returns a handler that sends cmd to the door in the request path
+func (s *Service) doorCommandHandler(cmd api.DoorCommand) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		id, err := readIntVar(r, "id", "id")
+		if err != nil {
+			return err
+		}
+
+		if err := s.WithContext(r.Context()).SendDoorCommand(id, cmd); err != nil {
+			code := http.StatusInternalServerError
+			if err == ErrInvalidID {
+				code = http.StatusBadRequest
+			} else if api.IsNotFoundError(err) {
+				code = http.StatusNotFound
+			}
+			return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not send door command: %w", err)}
+		}
+
+		s.audit(r, doorCommandEvents[cmd], 0, nil, &doorEvent{DoorID: id})
+
+		return nil
+	}
+}
@@ -0,0 +1,479 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+var ErrGroupNotFound = errors.New("group not found")
+
+// DryRunResult describes what a mutation would change
+type DryRunResult struct {
+	DryRun   bool        `json:"dry_run"`
+	Action   string      `json:"action"`
+	PersonID int         `json:"person_id,omitempty"`
+	Old      interface{} `json:"old,omitempty"`
+	New      interface{} `json:"new,omitempty"`
+	// Changed is false if the mutation would leave everything as it is
+	Changed bool `json:"changed"`
+}
+
+// withDryRun serves requests with ?dry_run=true with plan, which must validate the request without changing anything
+func (s *Service) withDryRun(plan func(r *http.Request) (interface{}, error), next http.Handler) http.Handler {
+	planHandler := s.HandleJSON(plan)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dry, err := readBoolQuery(r, "dry_run")
+		if err != nil {
+			s.HandleJSON(func(r *http.Request) (interface{}, error) { return nil, err }).ServeHTTP(w, r)
+			return
+		}
+		if dry {
+			planHandler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkGroups returns a 422 *HTTPError if any of ids isn't an existing group
+func (s *Service) checkGroups(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	groups, err := s.ListGroups()
+	if err != nil {
+		return &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+
+	exists := make(map[int]bool)
+	for _, g := range groups {
+		exists[g.ID] = true
+	}
+
+	for _, id := range ids {
+		if !exists[id] {
+			return &HTTPError{StatusCode: http.StatusUnprocessableEntity, Err: fmt.Errorf("%w: %d", ErrGroupNotFound, id)}
+		}
+	}
+
+	return nil
+}
+
+// checkCredential returns a 409 *HTTPError if the site and card code belong to someone other than personID
+func (s *Service) checkCredential(r *http.Request, personID, siteCode, cardCode int) error {
+	if siteCode == 0 && cardCode == 0 {
+		return nil
+	}
+
+	ownerID, credID, err := s.DBConn.CredentialOwner(siteCode, cardCode)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+
+	if ownerID == personID {
+		return nil
+	}
+
+	return s.credentialConflict(r, &db.CredentialExistsError{PersonID: ownerID, CredentialID: credID, SiteCode: siteCode, CardCode: cardCode}, siteCode, cardCode)
+}
+
+// checkPerson validates p as CreatePerson or UpdatePerson would, without changing anything
+func (s *Service) checkPerson(r *http.Request, p *Person) error {
+	if v := validationError(p.resolveCard()); v != nil {
+		return v
+	}
+	validate := s.ValidatePerson
+	if p.ID != 0 {
+		validate = s.ValidatePersonUpdate
+	}
+	if v := validationError(validate(p)); v != nil {
+		return v
+	}
+
+	if len(p.Image) != 0 {
+		if _, err := photo.Validate(p.Image, s.ImageLimits); err != nil {
+			return &HTTPError{StatusCode: imageErrorCode(err), Err: fmt.Errorf("invalid image: %w", err)}
+		}
+	}
+
+	if err := s.checkGroups(p.GroupsToAdd); err != nil {
+		return err
+	}
+
+	if err := s.checkCredential(r, p.ID, p.SiteCode, p.CardCode); err != nil {
+		return err
+	}
+
+	for _, cred := range p.Credentials {
+		if err := s.checkCredential(r, p.ID, cred.SiteCode, cred.CardCode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readCurrentPerson reads a person for a dry run, returning an *HTTPError on failure
+func (s *Service) readCurrentPerson(id int) (*Person, error) {
+	p, err := s.ReadPerson(id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return nil, &HTTPError{StatusCode: code, Err: err}
+	}
+	return p, nil
+}
+
+// updatedPerson returns the event for current after UpdatePerson(p). Empty fields in p aren't changed, the picture
+// is only replaced if p has one, and p's credentials are added to current's
+func updatedPerson(current, p *Person) *Person {
+	after := newPersonEvent(current)
+	if p.FirstName != "" {
+		after.FirstName = p.FirstName
+	}
+	if p.LastName != "" {
+		after.LastName = p.LastName
+	}
+	if p.EmployeeID != "" {
+		after.EmployeeID = p.EmployeeID
+	}
+	if p.Department != "" {
+		after.Department = p.Department
+	}
+	if p.SiteCode != 0 {
+		after.SiteCode = p.SiteCode
+	}
+	if p.CardCode != 0 {
+		after.CardCode = p.CardCode
+	}
+	if len(p.Image) != 0 {
+		after.HasImage = true
+	}
+	if p.TerminationDate != nil {
+		after.TerminationDate = p.TerminationDate
+	}
+
+	after.Credentials = append([]*Credential(nil), current.Credentials...)
+	for _, cred := range p.Credentials {
+		// savePictureAndCredentials skips the person's own card
+		if cred.SiteCode == p.SiteCode && cred.CardCode == p.CardCode {
+			continue
+		}
+		after.Credentials = append(after.Credentials, cred)
+	}
+
+	return after
+}
+
+func (s *Service) CreatePersonDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	p := new(Person)
+	if err := readPerson(r, p); err != nil {
+		return nil, err
+	}
+	if err := s.requireCredentialsWrite(r, p); err != nil {
+		return nil, err
+	}
+	p.ID = 0
+
+	if err := s.checkPerson(r, p); err != nil {
+		return nil, err
+	}
+
+	return &DryRunResult{DryRun: true, Action: EventPersonCreated, New: newPersonEvent(p), Changed: true}, nil
+}
+
+func (s *Service) UpdatePersonDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	p := new(Person)
+	if err := readJSON(r, p); err != nil {
+		return nil, err
+	}
+	if err := s.requireCredentialsWrite(r, p); err != nil {
+		return nil, err
+	}
+	p.ID = id
+
+	current, err := s.readCurrentPerson(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(r, personETag(current), id); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPerson(r, p); err != nil {
+		return nil, err
+	}
+
+	before, after := newPersonEvent(current), updatedPerson(current, p)
+
+	changed := before.FirstName != after.FirstName || before.LastName != after.LastName ||
+		before.EmployeeID != after.EmployeeID || before.Department != after.Department ||
+		before.SiteCode != after.SiteCode || before.CardCode != after.CardCode ||
+		len(after.Credentials) != len(before.Credentials) || p.TerminationDate != nil ||
+		len(p.Image) != 0 || len(p.GroupsToAdd) != 0
+
+	return &DryRunResult{DryRun: true, Action: EventPersonUpdated, PersonID: id, Old: before, New: after, Changed: changed}, nil
+}
+
+func (s *Service) DeletePersonDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.readCurrentPerson(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DryRunResult{DryRun: true, Action: EventPersonDeleted, PersonID: id, Old: newPersonEvent(current), Changed: true}, nil
+}
+
+func (s *Service) CreateCredentialDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(CredentialRequest)
+	if err := readJSON(r, req); err != nil {
+		return nil, err
+	}
+	cred, err := req.resolve(time.Now(), s.TemporaryCredentials)
+	if err != nil {
+		return nil, validationError(err)
+	}
+
+	if v := validationError(s.ValidateCredential(cred)); v != nil {
+		return nil, v
+	}
+
+	if _, err := s.readCurrentPerson(id); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCredential(r, id, cred.SiteCode, cred.CardCode); err != nil {
+		return nil, err
+	}
+
+	creds, err := s.ListCredentials(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+
+	result := &DryRunResult{DryRun: true, Action: EventCredentialCreated, PersonID: id, New: cred, Changed: true}
+	for _, c := range creds {
+		if c.SiteCode == cred.SiteCode && c.CardCode == cred.CardCode {
+			cred.ID = c.ID
+			result.Old = c
+			result.Changed = c.Active != cred.Active
+		}
+	}
+
+	return result, nil
+}
+
+func (s *Service) DeleteCredentialDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+	credID, err := readIntVar(r, "credid", "credential id")
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := s.ListCredentials(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+
+	for _, c := range creds {
+		if c.ID == credID {
+			return &DryRunResult{DryRun: true, Action: EventCredentialDeleted, PersonID: id, Old: c, Changed: true}, nil
+		}
+	}
+
+	return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: fmt.Errorf("could not delete credential: %w", db.ErrNotFound)}
+}
+
+// personGroupDryRun plans adding (add = true) or removing a group membership
+func (s *Service) personGroupDryRun(r *http.Request, add bool) (interface{}, error) {
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+	groupID, err := readIntVar(r, "groupid", "group id")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkGroups([]int{groupID}); err != nil {
+		return nil, err
+	}
+
+	groups, err := s.ListPersonGroups(id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return nil, &HTTPError{StatusCode: code, Err: err}
+	}
+
+	member := false
+	for _, g := range groups {
+		if g.ID == groupID {
+			member = true
+		}
+	}
+
+	membership := &groupMembershipEvent{PersonID: id, GroupID: groupID}
+	result := &DryRunResult{DryRun: true, PersonID: id, Changed: member != add}
+	if add {
+		result.Action, result.New = EventGroupMembershipAdded, membership
+	} else {
+		result.Action, result.Old = EventGroupMembershipRemoved, membership
+	}
+
+	return result, nil
+}
+
+func (s *Service) AddPersonGroupDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	return s.personGroupDryRun(r, true)
+}
+
+func (s *Service) RemovePersonGroupDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	return s.personGroupDryRun(r, false)
+}
+
+// readCurrentGroup reads a group for a dry run, returning an *HTTPError on failure
+func (s *Service) readCurrentGroup(id int) (*Group, error) {
+	groups, err := s.ListGroups()
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+	for _, g := range groups {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: fmt.Errorf("%w: %d", ErrGroupNotFound, id)}
+}
+
+func (s *Service) CreateGroupDryRunHandler(r *http.Request) (interface{}, error) {
+	g := new(Group)
+	if err := readJSON(r, g); err != nil {
+		return nil, err
+	}
+	g.ID = 0
+
+	if g.Name == "" {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not create group: %w", ErrInvalidGroupName)}
+	}
+
+	return &DryRunResult{DryRun: true, Action: EventGroupCreated, New: g, Changed: true}, nil
+}
+
+func (s *Service) UpdateGroupDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	g := new(Group)
+	if err := readJSON(r, g); err != nil {
+		return nil, err
+	}
+	g.ID = id
+
+	current, err := s.readCurrentGroup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := current.Name != g.Name || current.Description != g.Description
+
+	return &DryRunResult{DryRun: true, Action: EventGroupUpdated, Old: current, New: g, Changed: changed}, nil
+}
+
+func (s *Service) DeleteGroupDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.readCurrentGroup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DryRunResult{DryRun: true, Action: EventGroupDeleted, Old: current, Changed: true}, nil
+}
+
+func (s *Service) DeactivatePersonDryRunHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	removeGroups, err := readBoolQuery(r, "remove_groups")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.readCurrentPerson(id); err != nil {
+		return nil, err
+	}
+
+	d := &Deactivation{PersonID: id, CredentialsDeactivated: make([]int, 0)}
+
+	creds, err := s.ListCredentials(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+	for _, c := range creds {
+		if c.Active {
+			d.CredentialsDeactivated = append(d.CredentialsDeactivated, c.ID)
+		}
+	}
+
+	if removeGroups {
+		groups, err := s.ListPersonGroups(id)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+		}
+		for _, g := range groups {
+			d.GroupsRemoved = append(d.GroupsRemoved, g.ID)
+		}
+	}
+
+	return &DryRunResult{DryRun: true, Action: EventPersonDeactivated, PersonID: id, New: d, Changed: len(d.CredentialsDeactivated) > 0 || len(d.GroupsRemoved) > 0}, nil
+}
@@ -0,0 +1,169 @@
+package infinias
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Duplicate reasons reported by FindDuplicates
+const (
+	// DuplicateEmployeeID is people with the same employee ID
+	DuplicateEmployeeID = "employee_id"
+	// DuplicateNameAndCard is people with the same name and a shared card
+	DuplicateNameAndCard = "name_and_card"
+	// DuplicateCard is people with a shared card
+	DuplicateCard = "card"
+)
+
+// Duplicate is a set of people who are likely the same person
+type Duplicate struct {
+	Reason string `json:"reason"`
+	// Confidence is between 0 and 1
+	Confidence  float64 `json:"confidence"`
+	PersonIDs   []int   `json:"person_ids"`
+	Description string  `json:"description"`
+}
+
+// DuplicateReport is the result of FindDuplicates
+type DuplicateReport struct {
+	Time       time.Time    `json:"time"`
+	People     int          `json:"people"`
+	Duplicates []*Duplicate `json:"duplicates"`
+}
+
+func normalizeName(first, last string) string {
+	return strings.ToLower(strings.Join(strings.Fields(first+" "+last), " "))
+}
+
+// idSetKey returns a key for the sorted ids
+func idSetKey(ids []int) string {
+	return strings.Trim(fmt.Sprint(ids), "[]")
+}
+
+// FindDuplicates returns people who are likely duplicates, ordered by confidence
+func (s *Service) FindDuplicates() (*DuplicateReport, error) {
+	people, err := s.APIConn.ListPeople()
+	if err != nil {
+		return nil, fmt.Errorf("could not list people: %w", err)
+	}
+
+	creds, err := s.DBConn.ListCredentialRows()
+	if err != nil {
+		return nil, fmt.Errorf("could not list credentials: %w", err)
+	}
+
+	report := &DuplicateReport{Time: time.Now().UTC(), People: len(people), Duplicates: make([]*Duplicate, 0)}
+
+	type badge struct{ site, card int }
+	names := make(map[int]string, len(people))
+	byEmployee := make(map[string][]int)
+	badges := make(map[badge]map[int]struct{})
+	addBadge := func(id int, b badge) {
+		if b.site == 0 && b.card == 0 {
+			return
+		}
+		if badges[b] == nil {
+			badges[b] = make(map[int]struct{})
+		}
+		badges[b][id] = struct{}{}
+	}
+
+	for _, p := range people {
+		names[p.ID] = normalizeName(p.FirstName, p.LastName)
+		if key := normalizeEmployeeID(p.EmployeeID); key != "" {
+			byEmployee[key] = append(byEmployee[key], p.ID)
+		}
+		addBadge(p.ID, badge{p.SiteCode, p.CardCode})
+	}
+	for _, c := range creds {
+		// credentials of people the api doesn't return are reported by Reconcile
+		if _, ok := names[c.PersonID]; ok && c.HasWiegand {
+			addBadge(c.PersonID, badge{c.SiteCode, c.CardCode})
+		}
+	}
+
+	sameName := func(ids []int) bool {
+		for _, id := range ids[1:] {
+			if names[id] != names[ids[0]] || names[id] == "" {
+				return false
+			}
+		}
+		return true
+	}
+
+	for key, ids := range byEmployee {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Ints(ids)
+		d := &Duplicate{Reason: DuplicateEmployeeID, Confidence: 0.9, PersonIDs: ids, Description: fmt.Sprintf("people %s have employee id %q", idSetKey(ids), key)}
+		if sameName(ids) {
+			d.Confidence = 1
+			d.Description += " and the same name"
+		}
+		report.Duplicates = append(report.Duplicates, d)
+	}
+
+	for b, set := range badges {
+		if len(set) < 2 {
+			continue
+		}
+
+		byName := make(map[string][]int)
+		ids := make([]int, 0, len(set))
+		for id := range set {
+			ids = append(ids, id)
+			if names[id] != "" {
+				byName[names[id]] = append(byName[names[id]], id)
+			}
+		}
+		sort.Ints(ids)
+
+		reported := false
+		for _, named := range byName {
+			if len(named) < 2 {
+				continue
+			}
+			sort.Ints(named)
+			report.Duplicates = append(report.Duplicates, &Duplicate{
+				Reason: DuplicateNameAndCard, Confidence: 0.95, PersonIDs: named,
+				Description: fmt.Sprintf("people %s have the same name and card %d-%d", idSetKey(named), b.site, b.card),
+			})
+			reported = reported || len(named) == len(ids)
+		}
+		if reported {
+			continue
+		}
+
+		report.Duplicates = append(report.Duplicates, &Duplicate{
+			Reason: DuplicateCard, Confidence: 0.6, PersonIDs: ids,
+			Description: fmt.Sprintf("people %s share card %d-%d", idSetKey(ids), b.site, b.card),
+		})
+	}
+
+	sort.Slice(report.Duplicates, func(i, j int) bool {
+		di, dj := report.Duplicates[i], report.Duplicates[j]
+		if di.Confidence != dj.Confidence {
+			return di.Confidence > dj.Confidence
+		}
+		if di.PersonIDs[0] != dj.PersonIDs[0] {
+			return di.PersonIDs[0] < dj.PersonIDs[0]
+		}
+		return di.Reason < dj.Reason
+	})
+
+	return report, nil
+}
+
+// FindDuplicatesHandler returns a DuplicateReport
+func (s *Service) FindDuplicatesHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	report, err := s.FindDuplicates()
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not find duplicates: %w", err)}
+	}
+	return report, nil
+}
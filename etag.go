@@ -0,0 +1,72 @@
+package infinias
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// personETag returns a strong entity tag for p's stored state, including its picture, credentials, and termination date
+func personETag(p *Person) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%s\x00%s\x00%d\x00%d\x00", p.ID, p.FirstName, p.LastName, p.EmployeeID, p.Department, p.SiteCode, p.CardCode)
+	h.Write(p.Image)
+	fmt.Fprintf(h, "\x00%s", formatTimePtr(p.TerminationDate))
+
+	creds := make([]*Credential, len(p.Credentials))
+	copy(creds, p.Credentials)
+	sort.Slice(creds, func(i, j int) bool { return creds[i].ID < creds[j].ID })
+	for _, c := range creds {
+		fmt.Fprintf(h, "\x00%d,%t,%d,%d,%s,%s", c.ID, c.Active, c.SiteCode, c.CardCode, formatTimePtr(c.Activation), formatTimePtr(c.Expiration))
+	}
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// matchesIfMatch returns true if the If-Match header value matches etag using strong comparison
+func matchesIfMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns an *HTTPError if the request has an If-Match header that doesn't match etag, the current entity tag of the person with the given id
+func checkIfMatch(r *http.Request, etag string, id int) error {
+	if header := r.Header.Get("If-Match"); header != "" && !matchesIfMatch(header, etag) {
+		return &HTTPError{StatusCode: http.StatusPreconditionFailed, Err: fmt.Errorf("%w: person %d has changed", ErrPreconditionFailed, id), Detail: map[string]string{"etag": etag}}
+	}
+	return nil
+}
+
+// checkPersonIfMatch reads the person with the given id and calls checkIfMatch if the request has an If-Match header.
+// The check and the following update aren't atomic, since the Infinias API has no conditional updates
+func (s *Service) checkPersonIfMatch(r *http.Request, id int) error {
+	if r.Header.Get("If-Match") == "" {
+		return nil
+	}
+
+	current, err := s.readCurrentPerson(id)
+	if err != nil {
+		return err
+	}
+
+	return checkIfMatch(r, personETag(current), id)
+}
@@ -0,0 +1,31 @@
+package infinias
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPersonETag(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(24 * time.Hour)
+	t1Offset := t1.In(time.FixedZone("", 3600))
+	base := &Person{ID: 1, FirstName: "Alice", TerminationDate: &t1}
+
+	tests := []struct {
+		name string
+		p    *Person
+		same bool
+	}{
+		{"same termination date in another zone", &Person{ID: 1, FirstName: "Alice", TerminationDate: &t1Offset}, true},
+		{"new termination date", &Person{ID: 1, FirstName: "Alice", TerminationDate: &t2}, false},
+		{"cleared termination date", &Person{ID: 1, FirstName: "Alice"}, false},
+		{"new name", &Person{ID: 1, FirstName: "Bob", TerminationDate: &t1}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if have := personETag(base) == personETag(test.p); have != test.same {
+				t.Errorf("want same etag %t, have %t", test.same, have)
+			}
+		})
+	}
+}
@@ -0,0 +1,669 @@
+package infinias
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+const (
+	DefaultEventPollInterval = 2 * time.Second
+	eventPollLimit           = 1000
+	eventBufferSize          = 100
+	eventBacklogSize         = 1000
+	eventHeartbeatInterval   = 15 * time.Second
+	DefaultEventQueryLimit   = 100
+	MaxEventQueryLimit       = 1000
+	// eventStartMaxBackoff caps the wait between attempts to read the latest event id
+	eventStartMaxBackoff = time.Minute
+)
+
+var ErrStreamingUnsupported = errors.New("streaming unsupported")
+
+// Event kinds normalize Infinias event types, whose names vary between versions and installs
+const (
+	EventKindAccessGranted = "access_granted"
+	EventKindAccessDenied  = "access_denied"
+	EventKindDoorForced    = "door_forced"
+	EventKindDoorHeld      = "door_held"
+	EventKindOther         = "other"
+)
+
+type Event struct {
+	ID          int64     `json:"id"`
+	TypeID      int       `json:"type_id"`
+	Type        string    `json:"type"`
+	Kind        string    `json:"kind"`
+	PersonID    int       `json:"person_id,omitempty"`
+	DoorID      int       `json:"door_id,omitempty"`
+	Door        string    `json:"door,omitempty"`
+	Time        time.Time `json:"time"`
+	Description string    `json:"description,omitempty"`
+}
+
+// eventKind returns the kind of an event with the Infinias event type name typ
+func eventKind(typ string) string {
+	t := strings.ToLower(typ)
+	switch {
+	case strings.Contains(t, "forced"):
+		return EventKindDoorForced
+	case strings.Contains(t, "held"):
+		return EventKindDoorHeld
+	case strings.Contains(t, "denied"), strings.Contains(t, "invalid"), strings.Contains(t, "rejected"):
+		return EventKindAccessDenied
+	case strings.Contains(t, "granted"):
+		return EventKindAccessGranted
+	}
+	return EventKindOther
+}
+
+func newEvent(e *db.Event) *Event {
+	return &Event{
+		ID:          e.ID,
+		TypeID:      e.TypeID,
+		Type:        e.Type,
+		Kind:        eventKind(e.Type),
+		PersonID:    e.PersonID,
+		DoorID:      e.DoorID,
+		Door:        e.Door,
+		Time:        e.Time,
+		Description: e.Description,
+	}
+}
+
+// subscription is a subscriber to an EventStream
+type subscription struct {
+	ch chan *Event
+	// buffered subscriptions are closed instead of blocking polling when they aren't keeping up
+	buffered bool
+
+	// sendMu is held while sending to ch, so ch isn't closed during a send
+	sendMu     sync.Mutex
+	closed     bool
+	done       chan struct{}
+	cancelOnce sync.Once
+}
+
+// send delivers evt, blocking until it's received unless the subscription is buffered. It returns true if a
+// buffered subscription was closed because its buffer was full
+func (s *subscription) send(evt *Event) (behind bool) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.buffered {
+		select {
+		case s.ch <- evt:
+		default:
+			s.closed = true
+			close(s.ch)
+			return true
+		}
+		return false
+	}
+	select {
+	case s.ch <- evt:
+	case <-s.done:
+	}
+	return false
+}
+
+// cancel stops the subscription, interrupting a blocked send, and closes ch. It's safe to call more than once
+func (s *subscription) cancel() {
+	s.cancelOnce.Do(func() {
+		close(s.done)
+		s.sendMu.Lock()
+		defer s.sendMu.Unlock()
+		if !s.closed {
+			s.closed = true
+			close(s.ch)
+		}
+	})
+}
+
+// EventStream polls the database for new access events and fans them out to subscribers.
+// Polling only happens while there is at least one subscriber or Ingest is running
+type EventStream struct {
+	DBConn   *db.Conn
+	Interval time.Duration
+	Log      Logger
+	// CursorPath, if set, is the JSON file the id of the last event read is stored in, so polling resumes after it
+	// instead of at the newest event
+	CursorPath string
+
+	mu        sync.Mutex
+	subs      map[*subscription]struct{}
+	running   bool
+	ingesting bool
+	// quit is closed by Close, and stopped is closed when the current poller exits
+	quit    chan struct{}
+	closed  bool
+	stopped chan struct{}
+
+	// recent holds the latest events read, all of which have an id after recentAfter. It's only valid while
+	// recentReady is true
+	recent      []*Event
+	recentAfter int64
+	recentReady bool
+}
+
+// NewEventStream returns a new EventStream polling conn at the given interval
+func NewEventStream(conn *db.Conn, interval time.Duration, log Logger) *EventStream {
+	if interval <= 0 {
+		interval = DefaultEventPollInterval
+	}
+	if log == nil {
+		log = NopLogger
+	}
+	return &EventStream{DBConn: conn, Interval: interval, Log: log, subs: make(map[*subscription]struct{}), quit: make(chan struct{})}
+}
+
+// Subscribe returns a channel of new events and a function to cancel the subscription.
+// Every event is delivered: polling waits for the subscriber to receive each event, and the cursor isn't saved past
+// an event until every subscriber has received it
+func (e *EventStream) Subscribe() (<-chan *Event, func()) {
+	return e.subscribe(&subscription{ch: make(chan *Event), done: make(chan struct{})})
+}
+
+// SubscribeBuffered is like Subscribe, but instead of delaying polling, the channel is closed after the buffered
+// events if the subscriber falls eventBufferSize events behind, e.g. for clients that can catch up with Since
+func (e *EventStream) SubscribeBuffered() (<-chan *Event, func()) {
+	return e.subscribe(&subscription{ch: make(chan *Event, eventBufferSize), buffered: true, done: make(chan struct{})})
+}
+
+func (e *EventStream) subscribe(sub *subscription) (<-chan *Event, func()) {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		sub.cancel()
+		return sub.ch, func() {}
+	}
+	e.subs[sub] = struct{}{}
+	e.start()
+	e.mu.Unlock()
+
+	return sub.ch, func() {
+		e.mu.Lock()
+		delete(e.subs, sub)
+		e.mu.Unlock()
+		sub.cancel()
+	}
+}
+
+// Close stops polling, closes every subscription, and waits for polling to stop, so the cursor isn't written after
+// Close returns. Subscriptions made afterwards are closed immediately
+func (e *EventStream) Close() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.quit)
+	}
+	subs := e.subs
+	e.subs = make(map[*subscription]struct{})
+	e.ingesting = false
+	stopped := e.stopped
+	e.mu.Unlock()
+
+	for sub := range subs {
+		sub.cancel()
+	}
+	if stopped != nil {
+		<-stopped
+	}
+}
+
+// subscriptions returns the current subscriptions
+func (e *EventStream) subscriptions() []*subscription {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	subs := make([]*subscription, 0, len(e.subs))
+	for sub := range e.subs {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// Ingest polls continuously, whether or not there are subscribers, until stop is called.
+// With CursorPath set, each event is read once across restarts
+func (e *EventStream) Ingest() (stop func()) {
+	e.mu.Lock()
+	e.ingesting = true
+	e.start()
+	e.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.ingesting = false
+		})
+	}
+}
+
+type eventCursor struct {
+	LastID  int64     `json:"last_id"`
+	Updated time.Time `json:"updated"`
+}
+
+// readCursor returns the id in CursorPath, or false if it isn't set or doesn't exist yet
+func (e *EventStream) readCursor() (int64, bool, error) {
+	if e.CursorPath == "" {
+		return 0, false, nil
+	}
+	buf, err := os.ReadFile(e.CursorPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("could not read event cursor: %w", err)
+	}
+	c := new(eventCursor)
+	if err = json.Unmarshal(buf, c); err != nil {
+		return 0, false, fmt.Errorf("could not decode event cursor: %w", err)
+	}
+	return c.LastID, true, nil
+}
+
+func (e *EventStream) saveCursor(id int64) error {
+	buf, err := json.Marshal(&eventCursor{LastID: id, Updated: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("could not encode event cursor: %w", err)
+	}
+	if err = os.WriteFile(e.CursorPath+".tmp", buf, 0600); err == nil {
+		err = os.Rename(e.CursorPath+".tmp", e.CursorPath)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write event cursor: %w", err)
+	}
+	return nil
+}
+
+// startID returns the id polling starts after. Without a cursor, it's the latest event's id, which is retried with
+// backoff until it's read, so a database error doesn't replay every historic event. It returns false if polling
+// stopped because the stream was no longer needed
+func (e *EventStream) startID() (int64, bool) {
+	id, ok, err := e.readCursor()
+	if err != nil {
+		e.Log.Error("could not read event cursor", "path", e.CursorPath, "error", err)
+	}
+	if ok {
+		return id, true
+	}
+
+	wait := e.Interval
+	for {
+		if id, err = e.DBConn.LatestEventID(); err == nil {
+			return id, true
+		}
+		e.Log.Error("could not read latest event id", "error", err, "retry", wait)
+		select {
+		case <-time.After(wait):
+		case <-e.quit:
+		}
+		if e.stopIfIdle() {
+			return 0, false
+		}
+		if wait *= 2; wait > eventStartMaxBackoff {
+			wait = eventStartMaxBackoff
+		}
+	}
+}
+
+// isClosed returns true if Close was called
+func (e *EventStream) isClosed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closed
+}
+
+// stopIfIdle marks polling stopped and returns true if there are no subscribers and events aren't being ingested
+func (e *EventStream) stopIfIdle() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.subs) == 0 && !e.ingesting {
+		e.running, e.recentReady = false, false
+		return true
+	}
+	return false
+}
+
+// start starts polling if it isn't running or closed. e.mu must be held
+func (e *EventStream) start() {
+	if e.running || e.closed {
+		return
+	}
+	e.running = true
+	e.recent, e.recentReady = nil, false
+	e.stopped = make(chan struct{})
+	go e.run(e.stopped)
+}
+
+// run polls until stopIfIdle returns true, then closes stopped
+func (e *EventStream) run(stopped chan struct{}) {
+	defer close(stopped)
+	lastID, ok := e.startID()
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	e.recentAfter, e.recentReady = lastID, true
+	e.mu.Unlock()
+
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.quit:
+		}
+		if e.stopIfIdle() {
+			return
+		}
+
+		events, err := e.DBConn.ListEventsSince(lastID, eventPollLimit)
+		if err != nil {
+			e.Log.Error("could not poll events", "last_id", lastID, "error", err)
+			continue
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		for _, dbEvt := range events {
+			evt := newEvent(dbEvt)
+			e.mu.Lock()
+			e.remember(evt)
+			e.mu.Unlock()
+
+			// subscriptions are read for each event, since sends can block for a while
+			for _, sub := range e.subscriptions() {
+				if sub.send(evt) {
+					e.Log.Warn("closed subscription that fell behind", "event_id", evt.ID)
+				}
+			}
+			// a send interrupted by Close may not have been received, so the event is read again after a restart
+			if e.isClosed() {
+				break
+			}
+			lastID = evt.ID
+		}
+
+		if e.CursorPath != "" {
+			if err = e.saveCursor(lastID); err != nil {
+				e.Log.Error("could not save event cursor", "path", e.CursorPath, "last_id", lastID, "error", err)
+			}
+		}
+	}
+}
+
+// remember adds evt to e.recent, dropping the oldest event if it's full. e.mu must be held
+func (e *EventStream) remember(evt *Event) {
+	if len(e.recent) >= eventBacklogSize {
+		e.recentAfter = e.recent[0].ID
+		e.recent = append(e.recent[:0], e.recent[1:]...)
+	}
+	e.recent = append(e.recent, evt)
+}
+
+// Since returns the events read after id, or false if some of them are no longer held in memory
+func (e *EventStream) Since(id int64) ([]*Event, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.recentReady || id < e.recentAfter {
+		return nil, false
+	}
+	var events []*Event
+	for _, evt := range e.recent {
+		if evt.ID > id {
+			events = append(events, evt)
+		}
+	}
+	return events, true
+}
+
+// WatchEventWebhooks sends an EventAccessEvent webhook for each event from s.Events until stop is called
+func (s *Service) WatchEventWebhooks() (stop func()) {
+	if s.Events == nil || s.Webhooks == nil {
+		return func() {}
+	}
+	wanted := false
+	for _, t := range s.Webhooks.Targets {
+		if t.wants(EventAccessEvent) {
+			wanted = true
+		}
+	}
+	if !wanted {
+		return func() {}
+	}
+
+	ch, unsubscribe := s.Events.Subscribe()
+	go func() {
+		for e := range ch {
+			s.Webhooks.Send(EventAccessEvent, e)
+		}
+	}()
+
+	return unsubscribe
+}
+
+type eventFilter []string
+
+func newEventFilter(r *http.Request) eventFilter {
+	var f eventFilter
+	for _, v := range r.URL.Query()["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				f = append(f, t)
+			}
+		}
+	}
+	return f
+}
+
+func (f eventFilter) matches(e *Event) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, t := range f {
+		if strings.EqualFold(t, e.Type) || t == e.Kind || t == strconv.Itoa(e.TypeID) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeSSE(w http.ResponseWriter, e *Event) error {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, buf)
+	return err
+}
+
+// StreamEventsHandler streams access events to the client as Server-Sent Events.
+// Events can be filtered with one or more type parameters (name, kind, or type id).
+// Clients reconnecting with Last-Event-ID receive any events they missed. The response ends if the client falls
+// behind, so it reconnects and catches up
+func (s *Service) StreamEventsHandler(w http.ResponseWriter, r *http.Request) {
+	s = s.WithContext(r.Context())
+	errHandler := func(err error) {
+		s.HandleJSON(func(r *http.Request) (interface{}, error) {
+			return nil, err
+		}).ServeHTTP(w, r)
+	}
+
+	if s.Events == nil {
+		errHandler(&HTTPError{StatusCode: http.StatusNotImplemented, Err: ErrStreamingUnsupported})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errHandler(&HTTPError{StatusCode: http.StatusInternalServerError, Err: ErrStreamingUnsupported})
+		return
+	}
+
+	var lastID int64
+	if idStr := r.Header.Get("Last-Event-ID"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			errHandler(&HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read Last-Event-ID: %w", err)})
+			return
+		}
+		lastID = id
+	}
+
+	filter := newEventFilter(r)
+
+	// the subscription starts before the backlog is read, so no events fall between them
+	events, cancel := s.Events.SubscribeBuffered()
+	defer cancel()
+
+	// if the missed events aren't all in memory, they're read from the database a page at a time until a short page
+	// shows the backlog has caught up with the subscription
+	var backlog []*Event
+	fromDB := false
+	if lastID != 0 {
+		var ok bool
+		if backlog, ok = s.Events.Since(lastID); !ok {
+			fromDB = true
+			dbEvents, err := s.DBConn.ListEventsSince(lastID, eventPollLimit)
+			if err != nil {
+				errHandler(&HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list events: %w", err)})
+				return
+			}
+			for _, e := range dbEvents {
+				backlog = append(backlog, newEvent(e))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		for _, e := range backlog {
+			lastID = e.ID
+			if !filter.matches(e) {
+				continue
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		if !fromDB || len(backlog) < eventPollLimit {
+			break
+		}
+		dbEvents, err := s.DBConn.ListEventsSince(lastID, eventPollLimit)
+		if err != nil {
+			// the client reconnects with the last event it received
+			s.requestLogger(r).Warn("could not list events", "error", err)
+			return
+		}
+		backlog = backlog[:0]
+		for _, e := range dbEvents {
+			backlog = append(backlog, newEvent(e))
+		}
+	}
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-events:
+			// the subscription is closed if the client falls behind, so it reconnects with Last-Event-ID and catches up
+			if !ok {
+				return
+			}
+			if e.ID <= lastID || !filter.matches(e) {
+				continue
+			}
+			if err := writeSSE(w, e); err != nil {
+				s.requestLogger(r).Warn("could not write event", "error", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// EventPage is a page of events returned by QueryEventsHandler
+type EventPage struct {
+	Events []*Event `json:"events"`
+	Total  int      `json:"total"`
+	Limit  int      `json:"limit"`
+	Offset int      `json:"offset"`
+}
+
+// QueryEventsHandler returns past access events, newest first. Events can be filtered by person_id, door_id,
+// one or more type parameters (name or type id), and an RFC 3339 since/until time range, and paged with limit and offset
+func (s *Service) QueryEventsHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	q := r.URL.Query()
+	query := &db.EventQuery{Types: newEventFilter(r), Limit: DefaultEventQueryLimit}
+
+	for name, dst := range map[string]*int{"person_id": &query.PersonID, "door_id": &query.DoorID, "limit": &query.Limit, "offset": &query.Offset} {
+		str := q.Get(name)
+		if str == "" {
+			continue
+		}
+		i, err := strconv.Atoi(str)
+		if err != nil || i < 0 {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read %s: invalid value: %q", name, str)}
+		}
+		*dst = i
+	}
+
+	if query.Limit < 1 || query.Limit > MaxEventQueryLimit {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read limit: must be between 1 and %d", MaxEventQueryLimit)}
+	}
+
+	for name, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		str := q.Get(name)
+		if str == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read %s: %w", name, err)}
+		}
+		*dst = t
+	}
+
+	dbEvents, total, err := s.DBConn.QueryEvents(query)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not query events: %w", err)}
+	}
+
+	events := make([]*Event, len(dbEvents))
+	for idx, e := range dbEvents {
+		events[idx] = newEvent(e)
+	}
+
+	return &EventPage{Events: events, Total: total, Limit: query.Limit, Offset: query.Offset}, nil
+}
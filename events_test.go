@@ -0,0 +1,32 @@
+package infinias
+
+import (
+	"testing"
+)
+
+func TestBufferedSubscription(t *testing.T) {
+	sub := &subscription{ch: make(chan *Event, 2), buffered: true, done: make(chan struct{})}
+	for id := int64(1); id <= 2; id++ {
+		if sub.send(&Event{ID: id}) {
+			t.Fatalf("event %d: want subscription open", id)
+		}
+	}
+	if !sub.send(&Event{ID: 3}) {
+		t.Fatal("want subscription closed when its buffer is full")
+	}
+	if sub.send(&Event{ID: 4}) {
+		t.Error("want no sends after the subscription is closed")
+	}
+
+	// the buffered events are still received before the channel is closed
+	var ids []int64
+	for e := range sub.ch {
+		ids = append(ids, e.ID)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("want events [1 2], have %v", ids)
+	}
+
+	// canceling a subscription closed by send doesn't close it again
+	sub.cancel()
+}
@@ -0,0 +1,127 @@
+package infinias
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/korylprince/go-infinias-api/syslog"
+)
+
+const (
+	SyslogFormatCEF  = "cef"
+	SyslogFormatJSON = "json"
+)
+
+// EventSink receives access events, e.g. to forward them to a SIEM
+type EventSink interface {
+	Send(e *Event) error
+}
+
+// ForwardEvents sends events from stream matching types (event type names, kinds, or ids, or every event if empty)
+// to sink until stop is called
+func ForwardEvents(stream *EventStream, sink EventSink, types []string, log Logger) (stop func()) {
+	if stream == nil || sink == nil {
+		return func() {}
+	}
+	if log == nil {
+		log = NopLogger
+	}
+
+	filter := eventFilter(types)
+	ch, unsubscribe := stream.Subscribe()
+	go func() {
+		for e := range ch {
+			if !filter.matches(e) {
+				continue
+			}
+			if err := sink.Send(e); err != nil {
+				log.Warn("could not forward event", "event_id", e.ID, "error", err)
+			}
+		}
+	}()
+
+	return unsubscribe
+}
+
+// SyslogSink is an EventSink that writes events to syslog in CEF or JSON format
+type SyslogSink struct {
+	Writer *syslog.Writer
+	// Format is SyslogFormatCEF or SyslogFormatJSON
+	Format string
+}
+
+// NewSyslogSink returns a new SyslogSink, or an error if format is unknown
+func NewSyslogSink(w *syslog.Writer, format string) (*SyslogSink, error) {
+	switch format {
+	case SyslogFormatCEF, SyslogFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown syslog format: %q", format)
+	}
+	return &SyslogSink{Writer: w, Format: format}, nil
+}
+
+// eventSeverity returns the syslog and CEF (0-10) severity of an event with the given kind
+func eventSeverity(kind string) (syslog.Severity, int) {
+	switch kind {
+	case EventKindDoorForced:
+		return syslog.Critical, 9
+	case EventKindDoorHeld:
+		return syslog.Warning, 6
+	case EventKindAccessDenied:
+		return syslog.Notice, 5
+	}
+	return syslog.Informational, 3
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// formatCEF returns e as an ArcSight Common Event Format message
+func formatCEF(e *Event, severity int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|Infinias|go-infinias-api|%s|%d|%s|%d|",
+		cefHeaderEscaper.Replace(Version), e.TypeID, cefHeaderEscaper.Replace(e.Type), severity,
+	)
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(e.Time.UnixNano()/1e6, 10),
+		"externalId=" + strconv.FormatInt(e.ID, 10),
+		"cat=" + e.Kind,
+	}
+	if e.PersonID != 0 {
+		ext = append(ext, "suid="+strconv.Itoa(e.PersonID))
+	}
+	if e.DoorID != 0 {
+		ext = append(ext, "cn1Label=doorId", "cn1="+strconv.Itoa(e.DoorID))
+	}
+	if e.Door != "" {
+		ext = append(ext, "cs1Label=door", "cs1="+cefExtensionEscaper.Replace(e.Door))
+	}
+	if e.Description != "" {
+		ext = append(ext, "msg="+cefExtensionEscaper.Replace(e.Description))
+	}
+	b.WriteString(strings.Join(ext, " "))
+
+	return b.String()
+}
+
+func (s *SyslogSink) Send(e *Event) error {
+	sev, cefSev := eventSeverity(e.Kind)
+
+	var msg string
+	if s.Format == SyslogFormatJSON {
+		buf, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("could not encode event: %w", err)
+		}
+		msg = string(buf)
+	} else {
+		msg = formatCEF(e, cefSev)
+	}
+
+	return s.Writer.Write(sev, e.Kind, e.Time, msg)
+}
@@ -0,0 +1,114 @@
+package infinias
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+var ErrUnknownField = errors.New("unknown field")
+
+// jsonFields returns the set of JSON field names for struct type t
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+var personFields = jsonFields(reflect.TypeOf(Person{}))
+
+// parseFields parses the comma-separated fields query parameter, which may be repeated.
+// A nil map is returned if the parameter isn't set
+func parseFields(r *http.Request, valid map[string]bool) (map[string]bool, error) {
+	values, ok := r.URL.Query()["fields"]
+	if !ok {
+		return nil, nil
+	}
+
+	fields := make(map[string]bool)
+	for _, v := range values {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "" {
+				continue
+			}
+			if !valid[f] {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownField, f)
+			}
+			fields[f] = true
+		}
+	}
+
+	return fields, nil
+}
+
+// selectFields returns v, which must encode to a JSON object or array of objects, with only the given fields
+func selectFields(v interface{}, fields map[string]bool) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode response: %w", err)
+	}
+
+	filter := func(obj map[string]json.RawMessage) {
+		for k := range obj {
+			if !fields[k] {
+				delete(obj, k)
+			}
+		}
+	}
+
+	if len(buf) > 0 && buf[0] == '[' {
+		var objs []map[string]json.RawMessage
+		if err = json.Unmarshal(buf, &objs); err != nil {
+			return nil, fmt.Errorf("could not decode response: %w", err)
+		}
+		for _, obj := range objs {
+			filter(obj)
+		}
+		return objs, nil
+	}
+
+	var obj map[string]json.RawMessage
+	if err = json.Unmarshal(buf, &obj); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+	filter(obj)
+
+	return obj, nil
+}
+
+// selection is returned by withPersonFields so body is limited to fields after it's paginated
+type selection struct {
+	body   interface{}
+	fields map[string]bool
+}
+
+// withPersonFields limits the person or people returned by next to the fields in the fields query parameter
+func withPersonFields(next func(r *http.Request) (interface{}, error)) func(r *http.Request) (interface{}, error) {
+	return func(r *http.Request) (interface{}, error) {
+		fields, err := parseFields(r, personFields)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read fields: %w", err)}
+		}
+
+		resp, err := next(r)
+		if err != nil || fields == nil {
+			return resp, err
+		}
+
+		if c, ok := resp.(*created); ok {
+			c.body = &selection{body: c.body, fields: fields}
+			return c, nil
+		}
+
+		return &selection{body: resp, fields: fields}, nil
+	}
+}
@@ -0,0 +1,559 @@
+package infinias
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	infiniasv1 "github.com/korylprince/go-infinias-api/proto/infinias/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcRequestKey is the context key for the *http.Request a gRPC call was received in
+type grpcRequestKey struct{}
+
+// GRPCHandler returns a handler for the gRPC service in proto/infinias/v1/infinias.proto, served by a *grpc.Server
+// over net/http. Each method is served by its /api/1.0 route, so authentication, scopes, hidden fields, validation,
+// and auditing are the same as over HTTP
+func (s *Service) GRPCHandler() http.Handler {
+	var opts []grpc.ServerOption
+	if s.MaxBodySize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(int(s.MaxBodySize)))
+	}
+	srv := grpc.NewServer(opts...)
+	infiniasv1.RegisterInfiniasServer(srv, &grpcServer{h: s.Handler()})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// grpc.Server.ServeHTTP runs methods with the request's context
+		srv.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), grpcRequestKey{}, r)))
+	})
+}
+
+// IsGRPC returns true if r is a gRPC request
+func IsGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// WithGRPC serves gRPC requests with grpcHandler and all other requests with next
+func WithGRPC(grpcHandler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsGRPC(r) {
+			grpcHandler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// grpcCodes maps HTTP status codes to gRPC status codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusMethodNotAllowed:      codes.Unimplemented,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusPreconditionFailed:    codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge: codes.ResourceExhausted,
+	http.StatusTooManyRequests:       codes.ResourceExhausted,
+	http.StatusNotImplemented:        codes.Unimplemented,
+	http.StatusServiceUnavailable:    codes.Unavailable,
+	http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// grpcError returns a gRPC status error for an HTTP error response
+func grpcError(code int, body []byte) error {
+	c, ok := grpcCodes[code]
+	if !ok {
+		c = codes.Unknown
+		if code >= http.StatusInternalServerError {
+			c = codes.Internal
+		}
+	}
+	msg := http.StatusText(code)
+	jr := new(jsonResponse)
+	if err := json.Unmarshal(body, jr); err == nil && jr.Description != "" {
+		msg = jr.Description
+	}
+	return status.Error(c, msg)
+}
+
+// grpcCall makes requests to the /api/1.0 routes on behalf of the gRPC request r
+type grpcCall struct {
+	h http.Handler
+	r *http.Request
+}
+
+// request returns a new request for the route at path with the headers and connection of c.r.
+// gRPC metadata like authorization and idempotency-key are passed through as headers
+func (c *grpcCall) request(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var buf []byte
+	if body != nil {
+		var err error
+		if buf, err = json.Marshal(body); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not encode request: %v", err)
+		}
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(buf))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not create request: %v", err)
+	}
+	for k, v := range c.r.Header {
+		switch {
+		case k == "Content-Type", k == "Content-Length", k == "Te", strings.HasPrefix(k, "Grpc-"):
+			continue
+		}
+		r.Header[k] = v
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.RequestURI = r.URL.RequestURI()
+	r.RemoteAddr = c.r.RemoteAddr
+	r.TLS = c.r.TLS
+	r.Host = c.r.Host
+	return r, nil
+}
+
+// do calls the route at path and decodes its JSON response into resp, if not nil
+func (c *grpcCall) do(method, path string, body, resp interface{}) error {
+	r, err := c.request(c.r.Context(), method, path, body)
+	if err != nil {
+		return err
+	}
+	w := &grpcResponseWriter{header: make(http.Header), code: http.StatusOK}
+	c.h.ServeHTTP(w, r)
+
+	if w.code < 200 || w.code > 299 {
+		return grpcError(w.code, w.body.Bytes())
+	}
+	if resp == nil {
+		return nil
+	}
+	if err := json.Unmarshal(w.body.Bytes(), resp); err != nil {
+		return status.Errorf(codes.Internal, "could not decode response: %v", err)
+	}
+	return nil
+}
+
+// grpcResponseWriter buffers a response from the /api/1.0 routes
+type grpcResponseWriter struct {
+	header      http.Header
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *grpcResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.code, w.wroteHeader = code, true
+}
+
+func (w *grpcResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// grpcStreamWriter sends each Server-Sent Event written to it as an Event with send.
+// Responses other than 200 OK are buffered so they can be returned as errors
+type grpcStreamWriter struct {
+	grpcResponseWriter
+	send   func(*infiniasv1.Event) error
+	cancel func()
+	err    error
+}
+
+func (w *grpcStreamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.grpcResponseWriter.Write(p)
+}
+
+// Flush sends the complete events written so far
+func (w *grpcStreamWriter) Flush() {
+	if w.code != http.StatusOK || w.err != nil {
+		return
+	}
+	for {
+		buf := w.body.Bytes()
+		idx := bytes.Index(buf, []byte("\n\n"))
+		if idx < 0 {
+			return
+		}
+		block := string(buf[:idx])
+		w.body.Next(idx + 2)
+
+		if err := w.sendEvent(block); err != nil {
+			w.err = err
+			// stop the handler, which otherwise only notices on its next write
+			w.cancel()
+			return
+		}
+	}
+}
+
+// sendEvent sends the event in block, ignoring comments like heartbeats
+func (w *grpcStreamWriter) sendEvent(block string) error {
+	var data []string
+	scanner := bufio.NewScanner(strings.NewReader(block))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	e := new(Event)
+	if err := json.Unmarshal([]byte(strings.Join(data, "\n")), e); err != nil {
+		return status.Errorf(codes.Internal, "could not decode event: %v", err)
+	}
+	return w.send(grpcEvent(e))
+}
+
+// grpcPerson is the JSON form of Person read from the /api/1.0 routes. HasImage is nil if it's a hidden field
+type grpcPerson struct {
+	Person
+	HasImage *bool `json:"has_image"`
+}
+
+func (p *grpcPerson) proto() *infiniasv1.Person {
+	pb := &infiniasv1.Person{
+		Id:         int32(p.ID),
+		FirstName:  p.FirstName,
+		LastName:   p.LastName,
+		EmployeeId: p.EmployeeID,
+		Department: p.Department,
+		SiteCode:   int32(p.SiteCode),
+		CardCode:   int32(p.CardCode),
+		HasImage:   p.HasImage != nil && *p.HasImage,
+	}
+	for _, c := range p.Credentials {
+		pb.Credentials = append(pb.Credentials, grpcCredential(c))
+	}
+	return pb
+}
+
+// personFromGRPC returns the Person for pb. has_image is output only, like over HTTP
+func personFromGRPC(pb *infiniasv1.Person) *Person {
+	p := new(Person)
+	if pb == nil {
+		return p
+	}
+	p.ID = int(pb.GetId())
+	p.FirstName = pb.GetFirstName()
+	p.LastName = pb.GetLastName()
+	p.EmployeeID = pb.GetEmployeeId()
+	p.Department = pb.GetDepartment()
+	p.SiteCode = int(pb.GetSiteCode())
+	p.CardCode = int(pb.GetCardCode())
+	for _, c := range pb.GetCredentials() {
+		p.Credentials = append(p.Credentials, credentialFromGRPC(c))
+	}
+	return p
+}
+
+func grpcCredential(c *Credential) *infiniasv1.Credential {
+	return &infiniasv1.Credential{
+		Id:       int32(c.ID),
+		Active:   c.Active,
+		SiteCode: int32(c.SiteCode),
+		CardCode: int32(c.CardCode),
+	}
+}
+
+func credentialFromGRPC(pb *infiniasv1.Credential) *Credential {
+	return &Credential{
+		ID:       int(pb.GetId()),
+		Active:   pb.GetActive(),
+		SiteCode: int(pb.GetSiteCode()),
+		CardCode: int(pb.GetCardCode()),
+	}
+}
+
+func grpcEvent(e *Event) *infiniasv1.Event {
+	return &infiniasv1.Event{
+		Id:          e.ID,
+		TypeId:      int32(e.TypeID),
+		Type:        e.Type,
+		PersonId:    int32(e.PersonID),
+		DoorId:      int32(e.DoorID),
+		Door:        e.Door,
+		Time:        timestamppb.New(e.Time),
+		Description: e.Description,
+	}
+}
+
+// grpcID returns id as an int, or an InvalidArgument error if it isn't valid
+func grpcID(id int32) (int, error) {
+	if id <= 0 {
+		return 0, status.Error(codes.InvalidArgument, ErrInvalidID.Error())
+	}
+	return int(id), nil
+}
+
+// grpcServer implements the Infinias gRPC service with the /api/1.0 routes served by h
+type grpcServer struct {
+	infiniasv1.UnimplementedInfiniasServer
+	h http.Handler
+}
+
+// call returns a grpcCall for the gRPC request that ctx belongs to
+func (s *grpcServer) call(ctx context.Context) (*grpcCall, error) {
+	r, ok := ctx.Value(grpcRequestKey{}).(*http.Request)
+	if !ok {
+		return nil, status.Error(codes.Internal, "gRPC request wasn't received by GRPCHandler")
+	}
+	return &grpcCall{h: s.h, r: r.WithContext(ctx)}, nil
+}
+
+func (s *grpcServer) ListPeople(ctx context.Context, req *infiniasv1.ListPeopleRequest) (*infiniasv1.ListPeopleResponse, error) {
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/people"
+	if req.GetSort() != "" {
+		path += "?" + url.Values{"sort": {req.GetSort()}}.Encode()
+	}
+	var people []*grpcPerson
+	if err := c.do(http.MethodGet, path, nil, &people); err != nil {
+		return nil, err
+	}
+
+	resp := &infiniasv1.ListPeopleResponse{People: make([]*infiniasv1.Person, 0, len(people))}
+	for _, p := range people {
+		resp.People = append(resp.People, p.proto())
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetPerson(ctx context.Context, req *infiniasv1.GetPersonRequest) (*infiniasv1.Person, error) {
+	id, err := grpcID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := new(grpcPerson)
+	if err := c.do(http.MethodGet, fmt.Sprintf("/people/%d", id), nil, p); err != nil {
+		return nil, err
+	}
+	return p.proto(), nil
+}
+
+// personRequest returns the Person of a CreatePersonRequest or UpdatePersonRequest
+func personRequest(pb *infiniasv1.Person, image []byte, groups []int32) *Person {
+	p := personFromGRPC(pb)
+	p.Image = image
+	for _, id := range groups {
+		p.GroupsToAdd = append(p.GroupsToAdd, int(id))
+	}
+	return p
+}
+
+func (s *grpcServer) CreatePerson(ctx context.Context, req *infiniasv1.CreatePersonRequest) (*infiniasv1.Person, error) {
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(grpcPerson)
+	if err := c.do(http.MethodPost, "/people", personRequest(req.GetPerson(), req.GetImage(), req.GetGroupsToAdd()), resp); err != nil {
+		return nil, err
+	}
+	return resp.proto(), nil
+}
+
+func (s *grpcServer) UpdatePerson(ctx context.Context, req *infiniasv1.UpdatePersonRequest) (*infiniasv1.Person, error) {
+	id, err := grpcID(req.GetPerson().GetId())
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(grpcPerson)
+	if err := c.do(http.MethodPut, fmt.Sprintf("/people/%d", id), personRequest(req.GetPerson(), req.GetImage(), req.GetGroupsToAdd()), resp); err != nil {
+		return nil, err
+	}
+	return resp.proto(), nil
+}
+
+func (s *grpcServer) DeletePerson(ctx context.Context, req *infiniasv1.DeletePersonRequest) (*emptypb.Empty, error) {
+	id, err := grpcID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(http.MethodDelete, fmt.Sprintf("/people/%d", id), nil, nil); err != nil {
+		return nil, err
+	}
+	return new(emptypb.Empty), nil
+}
+
+func (s *grpcServer) ListCredentials(ctx context.Context, req *infiniasv1.ListCredentialsRequest) (*infiniasv1.ListCredentialsResponse, error) {
+	id, err := grpcID(req.GetPersonId())
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var creds []*Credential
+	if err := c.do(http.MethodGet, fmt.Sprintf("/people/%d/credentials", id), nil, &creds); err != nil {
+		return nil, err
+	}
+
+	resp := &infiniasv1.ListCredentialsResponse{Credentials: make([]*infiniasv1.Credential, 0, len(creds))}
+	for _, cred := range creds {
+		resp.Credentials = append(resp.Credentials, grpcCredential(cred))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) CreateCredential(ctx context.Context, req *infiniasv1.CreateCredentialRequest) (*infiniasv1.Credential, error) {
+	id, err := grpcID(req.GetPersonId())
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := new(Credential)
+	if req.GetCredential() != nil {
+		cred = credentialFromGRPC(req.GetCredential())
+	}
+	resp := new(Credential)
+	if err := c.do(http.MethodPost, fmt.Sprintf("/people/%d/credentials", id), &CredentialRequest{Credential: *cred}, resp); err != nil {
+		return nil, err
+	}
+	return grpcCredential(resp), nil
+}
+
+func (s *grpcServer) DeleteCredential(ctx context.Context, req *infiniasv1.DeleteCredentialRequest) (*emptypb.Empty, error) {
+	id, err := grpcID(req.GetPersonId())
+	if err != nil {
+		return nil, err
+	}
+	credID, err := grpcID(req.GetCredentialId())
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(http.MethodDelete, fmt.Sprintf("/people/%d/credentials/%d", id, credID), nil, nil); err != nil {
+		return nil, err
+	}
+	return new(emptypb.Empty), nil
+}
+
+func (s *grpcServer) GetPicture(ctx context.Context, req *infiniasv1.GetPictureRequest) (*infiniasv1.Picture, error) {
+	id, err := grpcID(req.GetPersonId())
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p := new(grpcPerson)
+	if err := c.do(http.MethodGet, fmt.Sprintf("/people/%d", id), nil, p); err != nil {
+		return nil, err
+	}
+	if len(p.Image) == 0 {
+		// the image and has_image fields are omitted if hidden from the caller, like for the thumbnail route
+		if p.HasImage == nil || *p.HasImage {
+			return nil, status.Errorf(codes.PermissionDenied, "%v: image", ErrFieldHidden)
+		}
+		return nil, status.Errorf(codes.NotFound, "person %d has no picture", id)
+	}
+	return &infiniasv1.Picture{PersonId: int32(p.ID), Image: p.Image}, nil
+}
+
+func (s *grpcServer) UpdatePicture(ctx context.Context, req *infiniasv1.UpdatePictureRequest) (*emptypb.Empty, error) {
+	id, err := grpcID(req.GetPersonId())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetImage()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "image is required")
+	}
+	c, err := s.call(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(http.MethodPut, fmt.Sprintf("/people/%d", id), &Person{Image: req.GetImage()}, nil); err != nil {
+		return nil, err
+	}
+	return new(emptypb.Empty), nil
+}
+
+func (s *grpcServer) StreamEvents(req *infiniasv1.StreamEventsRequest, stream infiniasv1.Infinias_StreamEventsServer) error {
+	c, err := s.call(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	q := make(url.Values)
+	for _, t := range req.GetTypes() {
+		q.Add("type", t)
+	}
+	path := "/events/stream"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	r, err := c.request(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Del("Last-Event-ID")
+	if id := req.GetLastEventId(); id != 0 {
+		r.Header.Set("Last-Event-ID", strconv.FormatInt(id, 10))
+	}
+
+	w := &grpcStreamWriter{grpcResponseWriter: grpcResponseWriter{header: make(http.Header), code: http.StatusOK}, send: stream.Send, cancel: cancel}
+	c.h.ServeHTTP(w, r)
+
+	if w.code != http.StatusOK {
+		return grpcError(w.code, w.body.Bytes())
+	}
+	if w.err != nil {
+		return w.err
+	}
+	// the stream only ends when the client disconnects, or the request times out
+	return status.FromContextError(stream.Context().Err()).Err()
+}
@@ -0,0 +1,76 @@
+package infinias
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	infiniasv1 "github.com/korylprince/go-infinias-api/proto/infinias/v1"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	s := &Service{APIKeys: []*APIKey{{Name: "reader", Key: "secret", Scopes: []string{ScopeReadOnly}}}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "http")
+	})
+	srv := httptest.NewServer(h2c.NewHandler(WithGRPC(s.GRPCHandler(), next), new(http2.Server)))
+	defer srv.Close()
+
+	// requests other than gRPC are served by next
+	resp, err := http.Get(srv.URL + "/people")
+	if err != nil {
+		t.Fatalf("could not get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "http" {
+		t.Errorf("want http response, have %q", body)
+	}
+
+	conn, err := grpc.Dial(srv.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+	client := infiniasv1.NewInfiniasClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		call func(ctx context.Context) error
+		want codes.Code
+	}{
+		{"invalid id", ctx, func(ctx context.Context) error {
+			_, err := client.GetPerson(ctx, &infiniasv1.GetPersonRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"unauthenticated", ctx, func(ctx context.Context) error {
+			_, err := client.ListPeople(ctx, &infiniasv1.ListPeopleRequest{})
+			return err
+		}, codes.Unauthenticated},
+		{"insufficient scope", metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret"), func(ctx context.Context) error {
+			_, err := client.DeletePerson(ctx, &infiniasv1.DeletePersonRequest{Id: 1})
+			return err
+		}, codes.PermissionDenied},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := status.Code(test.call(test.ctx)); code != test.want {
+				t.Errorf("want %v, have %v", test.want, code)
+			}
+		})
+	}
+}
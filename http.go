@@ -1,20 +1,30 @@
 package infinias
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/korylprince/go-infinias-api/api"
 	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/photo"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type HTTPError struct {
 	StatusCode int
 	Err        error
+	// Detail is optional structured information about the error, returned to the client
+	Detail interface{}
 }
 
 func (h *HTTPError) Error() string {
@@ -26,6 +36,9 @@ func (h *HTTPError) Unwrap() error {
 }
 
 func HTTPErrorCode(err error) int {
+	if errors.Is(err, ErrBreakerOpen) {
+		return http.StatusServiceUnavailable
+	}
 	h := new(HTTPError)
 	if errors.As(err, &h) {
 		return h.StatusCode
@@ -34,8 +47,35 @@ func HTTPErrorCode(err error) int {
 }
 
 type jsonResponse struct {
-	Code        int    `json:"code"`
-	Description string `json:"description"`
+	Code        int         `json:"code"`
+	Description string      `json:"description"`
+	RequestID   string      `json:"request_id,omitempty"`
+	Detail      interface{} `json:"detail,omitempty"`
+}
+
+// created is returned by handlers to respond with 201 Created (or 202 Accepted) and a Location header
+type created struct {
+	status   int
+	location string
+	etag     string
+	body     interface{}
+}
+
+// newCreated returns a created response for body at path, relative to where the handler is mounted
+func newCreated(r *http.Request, path string, body interface{}) *created {
+	// r.URL.Path has had any mount prefix stripped, so recover it from the original request URI
+	prefix := ""
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		prefix = strings.TrimSuffix(u.Path, r.URL.Path)
+	}
+	return &created{status: http.StatusCreated, location: prefix + path, body: body}
+}
+
+// newAccepted returns a 202 Accepted response for body, whose status can be checked at path
+func newAccepted(r *http.Request, path string, body interface{}) *created {
+	c := newCreated(r, path, body)
+	c.status = http.StatusAccepted
+	return c
 }
 
 func (s *Service) HandleJSON(next func(r *http.Request) (interface{}, error)) http.Handler {
@@ -43,19 +83,63 @@ func (s *Service) HandleJSON(next func(r *http.Request) (interface{}, error)) ht
 		w.Header().Set("Content-Type", "application/json")
 		code := http.StatusOK
 		resp, err := next(r)
-		if err != nil {
-			if s.Log != nil {
-				s.Log(fmt.Sprintf("%s %s: %v", r.Method, r.URL.String(), err))
+		if c, ok := resp.(*created); ok && err == nil {
+			code = c.status
+			if c.location != "" {
+				w.Header().Set("Location", c.location)
 			}
+			if c.etag != "" {
+				w.Header().Set("ETag", c.etag)
+			}
+			resp = c.body
+		}
+		if err != nil {
 			code = HTTPErrorCode(err)
-			resp = &jsonResponse{Code: code, Description: err.Error()}
+			if status := s.Breaker.Status(); errors.Is(err, ErrBreakerOpen) && status.RetryAfter != nil {
+				w.Header().Set("Retry-After", retryAfter(time.Until(*status.RetryAfter)))
+			}
+			if code >= http.StatusInternalServerError {
+				s.requestLogger(r).Error("request failed", "status", code, "error", err)
+			} else {
+				s.requestLogger(r).Info("request failed", "status", code, "error", err)
+			}
+			if h := new(HTTPError); errors.As(err, &h) {
+				if pf, ok := h.Detail.(*PartialFailure); ok && !pf.RolledBack {
+					storeIdempotentResponse(r.Context())
+				}
+			}
+		}
+
+		if APIVersionFromContext(r.Context()) == APIVersion2 {
+			s.writeV2(w, r, code, resp, err)
+			return
+		}
+
+		if sel, ok := resp.(*selection); ok && err == nil {
+			if resp, err = selectFields(sel.body, sel.fields); err != nil {
+				code = http.StatusInternalServerError
+				s.requestLogger(r).Error("request failed", "status", code, "error", err)
+			}
+		}
+
+		if hidden := s.hiddenFields(r); err == nil && hidden != nil {
+			if resp, err = hideFields(resp, hidden); err != nil {
+				code = http.StatusInternalServerError
+				s.requestLogger(r).Error("request failed", "status", code, "error", err)
+			}
+		}
+
+		if err != nil {
+			jr := &jsonResponse{Code: code, Description: err.Error(), RequestID: RequestIDFromContext(r.Context())}
+			if h := new(HTTPError); errors.As(err, &h) {
+				jr.Detail = s.errorDetail(r, h)
+			}
+			resp = jr
 		}
 
 		w.WriteHeader(code)
 		if err = json.NewEncoder(w).Encode(resp); err != nil {
-			if s.Log != nil {
-				s.Log(fmt.Sprintf("%s %s: could not encode: %v", r.Method, r.URL.String(), err))
-			}
+			s.requestLogger(r).Error("could not encode response", "error", err)
 		}
 	})
 }
@@ -70,33 +154,455 @@ func (s *Service) okHandler(f func(r *http.Request) error) http.Handler {
 	})
 }
 
+// retryAfter returns a Retry-After header value for d, rounded up to whole seconds. It's at least 1 second, since
+// d is 0 or negative once a half-open breaker's cooldown has passed
+func retryAfter(d time.Duration) string {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.Itoa(secs)
+}
+
+// DefaultMaxBodySize is the request body limit used by the service if none is configured
+const DefaultMaxBodySize = 32 << 20
+
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// maxBytesReader wraps http.MaxBytesReader, returning ErrBodyTooLarge and remembering that it did, since
+// readers wrapping it like multipart.Reader don't keep the error
+type maxBytesReader struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	tooLarge bool
+}
+
+func newMaxBytesReader(w http.ResponseWriter, body io.ReadCloser, limit int64) *maxBytesReader {
+	return &maxBytesReader{ReadCloser: http.MaxBytesReader(w, body, limit), limit: limit}
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	// http.MaxBytesReader only fails after returning exactly limit bytes when the body is too large
+	if err != nil && err != io.EOF && r.read >= r.limit {
+		r.tooLarge = true
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// bodyTooLarge returns true if err, from reading r's body, is because it's larger than the limit set by WithMaxBodySize
+func bodyTooLarge(r *http.Request, err error) bool {
+	if errors.Is(err, ErrBodyTooLarge) {
+		return true
+	}
+	body, ok := r.Body.(*maxBytesReader)
+	return ok && body.tooLarge
+}
+
+// readJSON decodes the request body into v, returning an *HTTPError on failure
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return bodyError(r, "body", err)
+	}
+	return nil
+}
+
+// bodyError returns an *HTTPError for an error reading r's body
+func bodyError(r *http.Request, desc string, err error) error {
+	if bodyTooLarge(r, err) {
+		return &HTTPError{StatusCode: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("could not read %s: %w", desc, err)}
+	}
+	return &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read %s: %w", desc, err)}
+}
+
+const (
+	multipartPersonPart = "person"
+	multipartImagePart  = "image"
+)
+
+// readPerson decodes a person from a JSON body, or from a multipart/form-data body
+// with a JSON "person" part and an optional raw "image" part
+func readPerson(r *http.Request, p *Person) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return readJSON(r, p)
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read body: %w", err)}
+	}
+
+	var hasPerson bool
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return bodyError(r, "body", err)
+		}
+
+		switch part.FormName() {
+		case multipartPersonPart:
+			if err = json.NewDecoder(part).Decode(p); err != nil {
+				return bodyError(r, "person part", err)
+			}
+			hasPerson = true
+		case multipartImagePart:
+			buf, err := io.ReadAll(part)
+			if err != nil {
+				return bodyError(r, "image part", err)
+			}
+			p.Image = buf
+		}
+		part.Close()
+	}
+
+	if !hasPerson {
+		return &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read body: missing %q part", multipartPersonPart)}
+	}
+
+	return nil
+}
+
+// WithMaxBodySize limits request bodies to s.MaxBodySize bytes
+func (s *Service) WithMaxBodySize(next http.Handler) http.Handler {
+	if s.MaxBodySize <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > s.MaxBodySize {
+			s.HandleJSON(func(r *http.Request) (interface{}, error) {
+				return nil, &HTTPError{StatusCode: http.StatusRequestEntityTooLarge, Err: ErrBodyTooLarge}
+			}).ServeHTTP(w, r)
+			return
+		}
+		r.Body = newMaxBytesReader(w, r.Body, s.MaxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// imageErrorCode returns the HTTP status code for an invalid image error, or 0 if err isn't an image error
+func imageErrorCode(err error) int {
+	switch {
+	case errors.Is(err, photo.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, photo.ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, photo.ErrDimensionTooLarge), errors.Is(err, photo.ErrEmpty):
+		return http.StatusUnprocessableEntity
+	}
+	return 0
+}
+
+// PartialFailure is the detail of an error response for a person that was saved, but whose picture, some
+// credentials, or termination date weren't
+type PartialFailure struct {
+	PersonID        int                  `json:"person_id"`
+	Picture         string               `json:"picture,omitempty"`
+	Credentials     []*CredentialFailure `json:"credentials,omitempty"`
+	TerminationDate string               `json:"termination_date,omitempty"`
+	// Job, if set, is the id of the job retrying the picture and credentials that were queued
+	Job string `json:"job,omitempty"`
+	// RolledBack is set if the person was deleted again, so nothing was saved
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// CredentialFailure is a credential that couldn't be created
+type CredentialFailure struct {
+	SiteCode int    `json:"site_code"`
+	CardCode int    `json:"card_code"`
+	Error    string `json:"error"`
+}
+
+// partialFailure returns a 409 *HTTPError if err only failed because credentials already exist, or a 500 otherwise
+func partialFailure(err *PartialError) *HTTPError {
+	code := http.StatusConflict
+	detail := &PartialFailure{PersonID: err.PersonID, Job: err.JobID}
+	if err.Picture != nil {
+		code = http.StatusInternalServerError
+		detail.Picture = err.Picture.Error()
+	}
+	for _, c := range err.Credentials {
+		if !errors.Is(c, db.ErrCredentialExists) {
+			code = http.StatusInternalServerError
+		}
+		detail.Credentials = append(detail.Credentials, &CredentialFailure{SiteCode: c.SiteCode, CardCode: c.CardCode, Error: c.Err.Error()})
+	}
+	if err.TerminationDate != nil {
+		code = http.StatusInternalServerError
+		detail.TerminationDate = err.TerminationDate.Error()
+	}
+	return &HTTPError{StatusCode: code, Err: err, Detail: detail}
+}
+
+// rolledBackFailure returns partialFailure for the *PartialError of err, marked as rolled back
+func rolledBackFailure(err *RolledBackError) *HTTPError {
+	e := partialFailure(err.Partial)
+	e.Err = fmt.Errorf("could not create person: %w", err)
+	e.Detail.(*PartialFailure).RolledBack = true
+	return e
+}
+
+// CredentialConflict identifies the person that already owns a site and card code
+type CredentialConflict struct {
+	SiteCode     int              `json:"site_code"`
+	CardCode     int              `json:"card_code"`
+	CredentialID int              `json:"credential_id,omitempty"`
+	Owner        *CredentialOwner `json:"owner,omitempty"`
+}
+
+type CredentialOwner struct {
+	ID         int    `json:"id"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	EmployeeID string `json:"employee_id"`
+}
+
+// credentialConflict returns a 409 HTTPError describing who owns the conflicting credential.
+// If err doesn't identify the owner, it is looked up by siteCode and cardCode
+func (s *Service) credentialConflict(r *http.Request, err error, siteCode, cardCode int) *HTTPError {
+	conflict := &CredentialConflict{SiteCode: siteCode, CardCode: cardCode}
+	personID := 0
+
+	if e := new(db.CredentialExistsError); errors.As(err, &e) {
+		conflict.SiteCode, conflict.CardCode, conflict.CredentialID = e.SiteCode, e.CardCode, e.CredentialID
+		personID = e.PersonID
+	} else if pID, credID, lookupErr := s.DBConn.CredentialOwner(siteCode, cardCode); lookupErr == nil {
+		conflict.CredentialID = credID
+		personID = pID
+	} else if !errors.Is(lookupErr, db.ErrNotFound) {
+		s.requestLogger(r).Warn("could not look up credential owner", "site_code", siteCode, "card_code", cardCode, "error", lookupErr)
+	}
+
+	if personID != 0 {
+		conflict.Owner = &CredentialOwner{ID: personID}
+		if p, readErr := s.APIConn.ReadPerson(personID); readErr == nil {
+			conflict.Owner.FirstName, conflict.Owner.LastName, conflict.Owner.EmployeeID = p.FirstName, p.LastName, p.EmployeeID
+		} else {
+			s.requestLogger(r).Warn("could not read credential owner", "person_id", personID, "error", readErr)
+		}
+	}
+
+	fields := map[string]string{"credential": fmt.Sprintf("%d-%d", conflict.SiteCode, conflict.CardCode)}
+	if conflict.Owner != nil {
+		fields["owner_id"] = strconv.Itoa(conflict.Owner.ID)
+	}
+	s.alertFields(AlertCredentialConflict, "Credential conflict", fields, "%s %s was rejected: %v", r.Method, r.URL.Path, err)
+
+	return &HTTPError{StatusCode: http.StatusConflict, Err: err, Detail: conflict}
+}
+
+// validationError returns a 422 *HTTPError with the invalid fields if err is a *ValidationError, or nil otherwise
+func validationError(err error) *HTTPError {
+	v := new(ValidationError)
+	if !errors.As(err, &v) {
+		return nil
+	}
+	return &HTTPError{StatusCode: http.StatusUnprocessableEntity, Err: err, Detail: v}
+}
+
+// readBoolQuery parses the named query parameter as a bool. A missing parameter is false
+func readBoolQuery(r *http.Request, name string) (bool, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read %s: %w", name, err)}
+	}
+	return b, nil
+}
+
+// readOptionalBoolQuery parses the named query parameter as a bool. A missing parameter is nil
+func readOptionalBoolQuery(r *http.Request, name string) (*bool, error) {
+	if r.URL.Query().Get(name) == "" {
+		return nil, nil
+	}
+	b, err := readBoolQuery(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// readIntQuery parses the named query parameter as a positive int. A missing parameter is 0
+func readIntQuery(r *http.Request, name string) (int, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(str)
+	if err != nil || i < 0 {
+		return 0, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read %s: invalid value: %q", name, str)}
+	}
+	return i, nil
+}
+
+func readIntVar(r *http.Request, name, desc string) (int, error) {
+	str := mux.Vars(r)[name]
+	if str == "" {
+		return 0, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read %s: %w", desc, ErrInvalidID)}
+	}
+	i, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read %s: %w", desc, err)}
+	}
+	return i, nil
+}
+
 func (s *Service) Handler() http.Handler {
+	return s.WithRequestID(s.WithAuth(s.WithMaxBodySize(s.router())))
+}
+
+// router returns the service's routes
+func (s *Service) router() *mux.Router {
 	mux := mux.NewRouter()
 
-	mux.Path("/people").Methods(http.MethodPost).Handler(s.HandleJSON(s.CreatePersonHandler))
-	mux.Path("/people/{id}").Methods(http.MethodGet).Handler(s.HandleJSON(s.ReadPersonHandler))
-	mux.Path("/people/{id}").Methods(http.MethodPut).Handler(s.HandleJSON(s.UpdatePersonHandler))
-	mux.Path("/people/{id}").Methods(http.MethodDelete).Handler(s.okHandler(s.DeletePersonHandler))
-	mux.Path("/people").Methods(http.MethodGet).Handler(s.HandleJSON(s.ListPeopleHandler))
-	mux.Path("/people/{id}/credentials").Methods(http.MethodPost).Handler(s.HandleJSON(s.CreateCredentialHandler))
-	mux.Path("/people/{id}/credentials/{credid}").Methods(http.MethodDelete).Handler(s.okHandler(s.DeleteCredentialHandler))
-	mux.Path("/people/{id}/credentials").Methods(http.MethodGet).Handler(s.HandleJSON(s.ListCredentialsHandler))
-	mux.Path("/groups").Methods(http.MethodGet).Handler(s.HandleJSON(s.ListGroupsHandler))
+	mux.Path("/people").Methods(http.MethodPost).Handler(s.WithScope(ScopePeopleWrite, s.withDryRun(s.CreatePersonDryRunHandler, s.WithIdempotency(s.HandleJSON(withPersonFields(s.CreatePersonHandler))))))
+	mux.Path("/people/deactivate").Methods(http.MethodPost).Handler(s.WithScope(ScopeAdmin, s.withDryRun(s.BulkDeactivateDryRunHandler, s.WithIdempotency(s.HandleJSON(s.BulkDeactivateHandler)))))
+	mux.Path("/people/duplicates").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.FindDuplicatesHandler)))
+	mux.Path("/people/employee/{employee_id}").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(withPersonFields(s.ReadPersonByEmployeeIDHandler))))
+	mux.Path("/people/{id}").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(withPersonFields(s.ReadPersonHandler))))
+	mux.Path("/people/{id}").Methods(http.MethodPut).Handler(s.WithScope(ScopePeopleWrite, s.withDryRun(s.UpdatePersonDryRunHandler, s.HandleJSON(withPersonFields(s.UpdatePersonHandler)))))
+	mux.Path("/people/{id}").Methods(http.MethodDelete).Handler(s.WithScope(ScopePeopleWrite, s.withDryRun(s.DeletePersonDryRunHandler, s.okHandler(s.DeletePersonHandler))))
+	mux.Path("/people/{id}/deactivate").Methods(http.MethodPost).Handler(s.WithScope(ScopePeopleWrite, s.withDryRun(s.DeactivatePersonDryRunHandler, s.HandleJSON(s.DeactivatePersonHandler))))
+	mux.Path("/people/{id}/termination_date").Methods(http.MethodDelete).Handler(s.WithScope(ScopePeopleWrite, s.okHandler(s.ClearTerminationDateHandler)))
+	mux.Path("/people/{id}/merge/{dupid}").Methods(http.MethodPost).Handler(s.WithScope(ScopePeopleWrite, s.HandleJSON(s.MergePeopleHandler)))
+	mux.Path("/people").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(withPersonFields(s.ListPeopleHandler))))
+	mux.Path("/people/{id}/picture/thumbnail").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, http.HandlerFunc(s.ThumbnailHandler)))
+	mux.Path("/people/{id}/credentials").Methods(http.MethodPost).Handler(s.WithScope(ScopeCredentialsWrite, s.withDryRun(s.CreateCredentialDryRunHandler, s.WithIdempotency(s.HandleJSON(s.CreateCredentialHandler)))))
+	mux.Path("/people/{id}/credentials/{credid}").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ReadCredentialHandler)))
+	mux.Path("/people/{id}/credentials/{credid}").Methods(http.MethodDelete).Handler(s.WithScope(ScopeCredentialsWrite, s.withDryRun(s.DeleteCredentialDryRunHandler, s.okHandler(s.DeleteCredentialHandler))))
+	mux.Path("/people/{id}/credentials").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ListCredentialsHandler)))
+	mux.Path("/people/{id}/groups").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ListPersonGroupsHandler)))
+	mux.Path("/people/{id}/groups/{groupid}").Methods(http.MethodPost).Handler(s.WithScope(ScopePeopleWrite, s.withDryRun(s.AddPersonGroupDryRunHandler, s.okHandler(s.AddPersonGroupHandler))))
+	mux.Path("/people/{id}/groups/{groupid}").Methods(http.MethodDelete).Handler(s.WithScope(ScopePeopleWrite, s.withDryRun(s.RemovePersonGroupDryRunHandler, s.okHandler(s.RemovePersonGroupHandler))))
+	mux.Path("/groups").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ListGroupsHandler)))
+	mux.Path("/groups").Methods(http.MethodPost).Handler(s.WithScope(ScopeAdmin, s.withDryRun(s.CreateGroupDryRunHandler, s.WithIdempotency(s.HandleJSON(s.CreateGroupHandler)))))
+	mux.Path("/groups/{id}/members").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ListGroupMembersHandler)))
+	mux.Path("/groups/{id}").Methods(http.MethodPut).Handler(s.WithScope(ScopeAdmin, s.withDryRun(s.UpdateGroupDryRunHandler, s.HandleJSON(s.UpdateGroupHandler))))
+	mux.Path("/groups/{id}").Methods(http.MethodDelete).Handler(s.WithScope(ScopeAdmin, s.withDryRun(s.DeleteGroupDryRunHandler, s.okHandler(s.DeleteGroupHandler))))
+	mux.Path("/departments/rename").Methods(http.MethodPost).Handler(s.WithScope(ScopeAdmin, s.withDryRun(s.RenameDepartmentDryRunHandler, s.WithIdempotency(s.HandleJSON(s.RenameDepartmentHandler)))))
+	mux.Path("/doors").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ListDoorsHandler)))
+	mux.Path("/doors/{id}/unlock").Methods(http.MethodPost).Handler(s.WithScope(ScopeDoorsControl, s.okHandler(s.doorCommandHandler(api.DoorCommandUnlock))))
+	mux.Path("/doors/{id}/lock").Methods(http.MethodPost).Handler(s.WithScope(ScopeDoorsControl, s.okHandler(s.doorCommandHandler(api.DoorCommandLock))))
+	mux.Path("/doors/{id}/pulse").Methods(http.MethodPost).Handler(s.WithScope(ScopeDoorsControl, s.okHandler(s.doorCommandHandler(api.DoorCommandPulse))))
+	mux.Path("/events").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.QueryEventsHandler)))
+	mux.Path("/reports/reconciliation").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.ReconcileHandler)))
+	mux.Path("/stats").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.StatsHandler)))
+	mux.Path("/reports/expiring").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ExpiringReportHandler)))
+	mux.Path("/reports/muster").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.MusterReportHandler)))
+	mux.Path("/reports/inactive").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.InactiveReportHandler)))
+	mux.Path("/reports/access").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.AccessReportHandler)))
+	mux.Path("/events/stream").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, http.HandlerFunc(s.StreamEventsHandler)))
+	mux.Path("/directories/{name}/sync").Methods(http.MethodPost).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.SyncDirectoryHandler)))
+	mux.Path("/export").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.ExportHandler)))
+	mux.Path("/export/photos").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, http.HandlerFunc(s.ExportPhotosHandler)))
+	mux.Path("/import").Methods(http.MethodPost).Handler(s.WithScope(ScopeAdmin, s.WithIdempotency(s.HandleJSON(s.ImportHandler))))
+	mux.Path("/jobs/{id}").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ReadJobHandler)))
+	mux.Path("/schedule").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.ScheduleHandler)))
+	mux.Path("/audit").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.QueryAuditHandler)))
+	mux.Path("/webhooks/deliveries").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.ListWebhookDeliveriesHandler)))
+	mux.Path("/admin/usage").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.UsageHandler)))
+
+	mux.Path("/keys").Methods(http.MethodGet).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.ListKeysHandler)))
+	mux.Path("/keys").Methods(http.MethodPost).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.CreateKeyHandler)))
+	mux.Path("/keys/{name}/rotate").Methods(http.MethodPost).Handler(s.WithScope(ScopeAdmin, s.HandleJSON(s.RotateKeyHandler)))
+	mux.Path("/keys/{name}").Methods(http.MethodDelete).Handler(s.WithScope(ScopeAdmin, s.okHandler(s.RevokeKeyHandler)))
+	mux.Path("/visitors").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ListVisitorsHandler)))
+	mux.Path("/visitors").Methods(http.MethodPost).Handler(s.WithScope(ScopePeopleWrite, s.WithIdempotency(s.HandleJSON(s.CreateVisitorHandler))))
+	mux.Path("/visitors/{id}").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ReadVisitorHandler)))
+	mux.Path("/visitors/{id}").Methods(http.MethodDelete).Handler(s.WithScope(ScopePeopleWrite, s.HandleJSON(s.CheckOutVisitorHandler)))
+	mux.Path("/occupancy").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.OccupancyHandler)))
+	mux.Path("/cards/convert").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.ConvertCardHandler)))
+	mux.Path("/health").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.HealthHandler)))
+	mux.Path("/version").Methods(http.MethodGet).Handler(s.WithScope(ScopeReadOnly, s.HandleJSON(s.VersionHandler)))
+
+	mux.Use(withRouteSpanName, withMutationContext, s.SlowLog.Middleware, s.Usage.Middleware)
 
-	return s.WithAuth(mux)
+	return mux
+}
+
+// MutationTimeout limits how long a request that changes data runs after the client disconnects
+const MutationTimeout = 5 * time.Minute
+
+// detachedContext has the values of its parent, like its span and request id, but isn't canceled with it
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// withMutationContext runs requests other than GET and HEAD with a context that isn't canceled when the client
+// disconnects, so multi-step changes to Infinias and the database aren't left half-applied. They're canceled
+// after MutationTimeout instead
+func withMutationContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(detachedContext{r.Context()}, MutationTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withRouteSpanName names the request's span after its route, e.g. "PUT /people/{id}"
+func withRouteSpanName(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			trace.SpanFromContext(r.Context()).SetName(r.Method + " " + tmpl)
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 func (s *Service) CreatePersonHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
 	p := new(Person)
-	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
-		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read body: %w", err)}
+	if err := readPerson(r, p); err != nil {
+		return nil, err
+	}
+	if err := s.requireCredentialsWrite(r, p); err != nil {
+		return nil, err
 	}
 
 	id, err := s.CreatePerson(p)
+	if partial := new(PartialError); errors.As(err, &partial) {
+		p.ID = id
+		p.HasImage = len(p.Image) != 0 && partial.Picture == nil && (partial.Pending == nil || len(partial.Pending.Image) == 0)
+		p.Image = nil
+		p.GroupsToAdd = nil
+		s.audit(r, EventPersonCreated, id, nil, p)
+		if !partial.failed() {
+			// everything that failed was queued for retry
+			return newAccepted(r, "/jobs/"+partial.JobID, p), nil
+		}
+		return nil, partialFailure(partial)
+	}
+	if rolledBack := new(RolledBackError); errors.As(err, &rolledBack) {
+		return nil, rolledBackFailure(rolledBack)
+	}
 	if err != nil {
 		code := http.StatusInternalServerError
-		if api.IsBadgeExistsError(err) {
-			code = http.StatusConflict
+		if v := validationError(err); v != nil {
+			return nil, v
+		} else if api.IsBadgeExistsError(err) {
+			return nil, s.credentialConflict(r, fmt.Errorf("could not create person: %w", err), p.SiteCode, p.CardCode)
+		} else if errors.Is(err, db.ErrCredentialExists) {
+			return nil, s.credentialConflict(r, fmt.Errorf("could not create person: %w", err), 0, 0)
+		} else if c := imageErrorCode(err); c != 0 {
+			code = c
 		}
 		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not create person: %w", err)}
 	}
@@ -106,10 +612,13 @@ func (s *Service) CreatePersonHandler(r *http.Request) (interface{}, error) {
 	p.Image = nil
 	p.GroupsToAdd = nil
 
-	return p, nil
+	s.audit(r, EventPersonCreated, id, nil, p)
+
+	return newCreated(r, fmt.Sprintf("/people/%d", id), p), nil
 }
 
 func (s *Service) ReadPersonHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
 	idStr := mux.Vars(r)["id"]
 	if idStr == "" {
 		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read id: %w", ErrInvalidID)}
@@ -130,10 +639,11 @@ func (s *Service) ReadPersonHandler(r *http.Request) (interface{}, error) {
 		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not update person: %w", err)}
 	}
 
-	return p, nil
+	return &created{status: http.StatusOK, etag: personETag(p), body: p}, nil
 }
 
 func (s *Service) UpdatePersonHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
 	idStr := mux.Vars(r)["id"]
 	if idStr == "" {
 		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read id: %w", ErrInvalidID)}
@@ -144,8 +654,11 @@ func (s *Service) UpdatePersonHandler(r *http.Request) (interface{}, error) {
 	}
 
 	p := new(Person)
-	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
-		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read body: %w", err)}
+	if err := readJSON(r, p); err != nil {
+		return nil, err
+	}
+	if err := s.requireCredentialsWrite(r, p); err != nil {
+		return nil, err
 	}
 
 	p.ID = id
@@ -153,14 +666,34 @@ func (s *Service) UpdatePersonHandler(r *http.Request) (interface{}, error) {
 	// client can't set this
 	p.HasImage = false
 
-	if err := s.UpdatePerson(p); err != nil {
+	if err := s.checkPersonIfMatch(r, id); err != nil {
+		return nil, err
+	}
+
+	before := s.auditPerson(r, id)
+
+	err = s.UpdatePerson(p)
+	if partial := new(PartialError); errors.As(err, &partial) {
+		p.HasImage = len(p.Image) != 0 && partial.Picture == nil
+		p.Image = nil
+		p.GroupsToAdd = nil
+		s.audit(r, EventPersonUpdated, id, before, p)
+		return nil, partialFailure(partial)
+	}
+	if err != nil {
 		code := http.StatusInternalServerError
 		if err == ErrInvalidID {
 			code = http.StatusBadRequest
+		} else if v := validationError(err); v != nil {
+			return nil, v
 		} else if api.IsNotFoundError(err) {
 			code = http.StatusNotFound
 		} else if api.IsBadgeExistsError(err) {
-			code = http.StatusConflict
+			return nil, s.credentialConflict(r, fmt.Errorf("could not update person: %w", err), p.SiteCode, p.CardCode)
+		} else if errors.Is(err, db.ErrCredentialExists) {
+			return nil, s.credentialConflict(r, fmt.Errorf("could not update person: %w", err), 0, 0)
+		} else if c := imageErrorCode(err); c != 0 {
+			code = c
 		}
 		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not update person: %w", err)}
 	}
@@ -171,11 +704,13 @@ func (s *Service) UpdatePersonHandler(r *http.Request) (interface{}, error) {
 	if len(p.Image) != 0 {
 		p.HasImage = true
 		p.Image = nil
+		s.audit(r, EventPersonUpdated, id, before, p)
 		return p, nil
 	}
 
-	if _, err := s.DBConn.ReadPicture(p.ID); err != nil {
-		if err == db.ErrNotFound {
+	if _, err := s.pictures().Read(p.ID); err != nil {
+		if errors.Is(err, photo.ErrNotFound) {
+			s.audit(r, EventPersonUpdated, id, before, p)
 			return p, nil
 		}
 		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not read picture: %w", err)}
@@ -183,10 +718,13 @@ func (s *Service) UpdatePersonHandler(r *http.Request) (interface{}, error) {
 
 	p.HasImage = true
 
+	s.audit(r, EventPersonUpdated, id, before, p)
+
 	return p, nil
 }
 
 func (s *Service) DeletePersonHandler(r *http.Request) error {
+	s = s.WithContext(r.Context())
 	idStr := mux.Vars(r)["id"]
 	if idStr == "" {
 		return &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read id: %w", ErrInvalidID)}
@@ -196,6 +734,8 @@ func (s *Service) DeletePersonHandler(r *http.Request) error {
 		return &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read id: %w", err)}
 	}
 
+	before := s.auditPerson(r, id)
+
 	if err := s.DeletePerson(id); err != nil {
 		code := http.StatusInternalServerError
 		if api.IsNotFoundError(err) {
@@ -204,20 +744,157 @@ func (s *Service) DeletePersonHandler(r *http.Request) error {
 		return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not delete person: %w", err)}
 	}
 
+	s.audit(r, EventPersonDeleted, id, before, nil)
+
 	return nil
 }
 
+func (s *Service) DeactivatePersonHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	removeGroups, err := readBoolQuery(r, "remove_groups")
+	if err != nil {
+		return nil, err
+	}
+
+	before := s.auditPerson(r, id)
+
+	d, err := s.DeactivatePerson(id, removeGroups)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvalidID {
+			code = http.StatusBadRequest
+		} else if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not deactivate person: %w", err)}
+	}
+
+	s.audit(r, EventPersonDeactivated, id, before, s.auditPerson(r, id))
+
+	return d, nil
+}
+
+// ListPeopleHandler returns all people, optionally filtered to members of group_id, people with or without a picture
+// with has_image, or people with or without a currently active credential with has_credential, and sorted by sort
 func (s *Service) ListPeopleHandler(r *http.Request) (interface{}, error) {
-	people, err := s.ListPeople()
+	s = s.WithContext(r.Context())
+	hidden := s.hiddenFields(r)
+	keys, err := parseSortQuery(r.URL.Query().Get("sort"), hidden)
+	if err != nil {
+		return nil, err
+	}
+
+	groupID, err := readIntQuery(r, "group_id")
+	if err != nil {
+		return nil, err
+	}
+	hasImage, err := readOptionalBoolQuery(r, "has_image")
+	if err != nil {
+		return nil, err
+	}
+	hasCredential, err := readOptionalBoolQuery(r, "has_credential")
+	if err != nil {
+		return nil, err
+	}
+	// filters would reveal the fields they filter by, like sorting
+	if hasImage != nil {
+		if err = hiddenFieldError(hidden, "has_image"); err != nil {
+			return nil, err
+		}
+	}
+	if hasCredential != nil {
+		if err = hiddenFieldError(hidden, "credentials"); err != nil {
+			return nil, err
+		}
+	}
+
+	people, err := s.cachedPeople()
 	if err != nil {
 		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list people: %w", err)}
 	}
 
+	if groupID != 0 {
+		if people, err = s.filterGroupMembers(people, groupID); err != nil {
+			return nil, err
+		}
+	}
+	if hasImage != nil || hasCredential != nil {
+		now := time.Now()
+		filtered := make([]*Person, 0, len(people))
+		for _, p := range people {
+			if hasImage != nil && p.HasImage != *hasImage {
+				continue
+			}
+			if hasCredential != nil && p.hasWorkingCredential(now) != *hasCredential {
+				continue
+			}
+			filtered = append(filtered, p)
+		}
+		people = filtered
+	}
+
+	// the Infinias API doesn't support ordering, so all sorting happens here
+	SortPeople(people, keys)
+
+	return people, nil
+}
+
+// filterGroupMembers returns the people in the group with groupID, or a 404 *HTTPError if the group doesn't exist
+func (s *Service) filterGroupMembers(people []*Person, groupID int) ([]*Person, error) {
+	if _, err := s.readCurrentGroup(groupID); err != nil {
+		return nil, err
+	}
+	ids, err := s.DBConn.ListGroupMembers(groupID)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: err}
+	}
+
+	members := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		members[id] = true
+	}
+	filtered := make([]*Person, 0, len(ids))
+	for _, p := range people {
+		if members[p.ID] {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered, nil
+}
+
+// ListGroupMembersHandler returns the people in a group, sorted by the sort query parameter
+func (s *Service) ListGroupMembersHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseSortQuery(r.URL.Query().Get("sort"), s.hiddenFields(r))
+	if err != nil {
+		return nil, err
+	}
+
+	people, err := s.cachedPeople()
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list people: %w", err)}
+	}
+	if people, err = s.filterGroupMembers(people, id); err != nil {
+		return nil, err
+	}
+
+	SortPeople(people, keys)
+
 	return people, nil
 }
 
 func (s *Service) ListGroupsHandler(r *http.Request) (interface{}, error) {
-	groups, err := s.ListGroups()
+	s = s.WithContext(r.Context())
+	groups, err := s.cachedGroups()
 	if err != nil {
 		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list groups: %w", err)}
 	}
@@ -226,10 +903,7 @@ func (s *Service) ListGroupsHandler(r *http.Request) (interface{}, error) {
 }
 
 func (s *Service) CreateCredentialHandler(r *http.Request) (interface{}, error) {
-	type response struct {
-		ID int `json:"id"`
-	}
-
+	s = s.WithContext(r.Context())
 	idStr := mux.Vars(r)["id"]
 	if idStr == "" {
 		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read id: %w", ErrInvalidID)}
@@ -239,24 +913,64 @@ func (s *Service) CreateCredentialHandler(r *http.Request) (interface{}, error)
 		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read id: %w", err)}
 	}
 
-	cred := new(Credential)
-	if err := json.NewDecoder(r.Body).Decode(cred); err != nil {
-		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read body: %w", err)}
+	req := new(CredentialRequest)
+	if err := readJSON(r, req); err != nil {
+		return nil, err
+	}
+	cred, err := req.resolve(time.Now(), s.TemporaryCredentials)
+	if err != nil {
+		return nil, validationError(err)
 	}
 
 	credID, err := s.CreateCredential(id, cred)
 	if err != nil {
 		code := http.StatusInternalServerError
-		if errors.Is(err, db.ErrCredentialExists) {
-			code = http.StatusConflict
+		if v := validationError(err); v != nil {
+			return nil, v
+		} else if errors.Is(err, db.ErrCredentialExists) {
+			return nil, s.credentialConflict(r, fmt.Errorf("could not create credential: %w", err), cred.SiteCode, cred.CardCode)
 		}
 		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not create credential: %w", err)}
 	}
 
-	return &response{ID: credID}, nil
+	s.audit(r, EventCredentialCreated, id, nil, cred)
+
+	if req.OnExpiry == ExpiryActionDelete {
+		if err = s.TemporaryCredentials.Add(&TemporaryCredential{PersonID: id, CredentialID: credID, ExpiresAt: *cred.Expiration}); err != nil {
+			s.requestLogger(r).Error("could not schedule credential deletion", "person_id", id, "credential_id", credID, "error", err)
+		}
+	}
+
+	return newCreated(r, fmt.Sprintf("/people/%d/credentials/%d", id, credID), cred), nil
+}
+
+func (s *Service) ReadCredentialHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+	credID, err := readIntVar(r, "credid", "credential id")
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := s.ListCredentials(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not read credential: %w", err)}
+	}
+
+	for _, c := range creds {
+		if c.ID == credID {
+			return c, nil
+		}
+	}
+
+	return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: fmt.Errorf("could not read credential: %w", db.ErrNotFound)}
 }
 
 func (s *Service) DeleteCredentialHandler(r *http.Request) error {
+	s = s.WithContext(r.Context())
 	type response struct {
 		ID int `json:"id"`
 	}
@@ -271,7 +985,7 @@ func (s *Service) DeleteCredentialHandler(r *http.Request) error {
 	}
 
 	credIDStr := mux.Vars(r)["credid"]
-	if idStr == "" {
+	if credIDStr == "" {
 		return &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read credential id: %w", ErrInvalidID)}
 	}
 	credID, err := strconv.Atoi(credIDStr)
@@ -279,14 +993,23 @@ func (s *Service) DeleteCredentialHandler(r *http.Request) error {
 		return &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read credential id: %w", err)}
 	}
 
+	before := s.auditCredential(r, id, credID)
+
 	if err := s.DeleteCredential(id, credID); err != nil {
-		return &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not delete credential: %w", err)}
+		code := http.StatusInternalServerError
+		if errors.Is(err, db.ErrNotFound) {
+			code = http.StatusNotFound
+		}
+		return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not delete credential: %w", err)}
 	}
 
+	s.audit(r, EventCredentialDeleted, id, before, nil)
+
 	return nil
 }
 
 func (s *Service) ListCredentialsHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
 	type response struct {
 		ID int `json:"id"`
 	}
@@ -307,3 +1030,160 @@ func (s *Service) ListCredentialsHandler(r *http.Request) (interface{}, error) {
 
 	return creds, nil
 }
+
+func (s *Service) ListWebhookDeliveriesHandler(r *http.Request) (interface{}, error) {
+	if s.Webhooks == nil {
+		return make([]*WebhookDelivery, 0), nil
+	}
+
+	return s.Webhooks.Deliveries(), nil
+}
+
+func (s *Service) ListPersonGroupsHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := s.ListPersonGroups(id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not list groups: %w", err)}
+	}
+
+	return groups, nil
+}
+
+func (s *Service) AddPersonGroupHandler(r *http.Request) error {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return err
+	}
+	groupID, err := readIntVar(r, "groupid", "group id")
+	if err != nil {
+		return err
+	}
+
+	if err := s.AddPersonGroup(id, groupID); err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvalidID {
+			code = http.StatusBadRequest
+		} else if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not add group: %w", err)}
+	}
+
+	s.audit(r, EventGroupMembershipAdded, id, nil, &groupMembershipEvent{PersonID: id, GroupID: groupID})
+
+	return nil
+}
+
+func (s *Service) RemovePersonGroupHandler(r *http.Request) error {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return err
+	}
+	groupID, err := readIntVar(r, "groupid", "group id")
+	if err != nil {
+		return err
+	}
+
+	if err := s.RemovePersonGroup(id, groupID); err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvalidID {
+			code = http.StatusBadRequest
+		} else if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not remove group: %w", err)}
+	}
+
+	s.audit(r, EventGroupMembershipRemoved, id, &groupMembershipEvent{PersonID: id, GroupID: groupID}, nil)
+
+	return nil
+}
+
+func (s *Service) CreateGroupHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	g := new(Group)
+	if err := readJSON(r, g); err != nil {
+		return nil, err
+	}
+
+	id, err := s.CreateGroup(g)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvalidGroupName {
+			code = http.StatusBadRequest
+		}
+		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not create group: %w", err)}
+	}
+
+	g.ID = id
+
+	s.audit(r, EventGroupCreated, 0, nil, g)
+
+	return g, nil
+}
+
+func (s *Service) UpdateGroupHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	g := new(Group)
+	if err := readJSON(r, g); err != nil {
+		return nil, err
+	}
+
+	g.ID = id
+
+	before := s.auditGroup(r, id)
+
+	if err := s.UpdateGroup(g); err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvalidID {
+			code = http.StatusBadRequest
+		} else if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not update group: %w", err)}
+	}
+
+	s.audit(r, EventGroupUpdated, 0, before, g)
+
+	return g, nil
+}
+
+func (s *Service) DeleteGroupHandler(r *http.Request) error {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return err
+	}
+
+	before := s.auditGroup(r, id)
+
+	if err := s.DeleteGroup(id); err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvalidID {
+			code = http.StatusBadRequest
+		} else if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not delete group: %w", err)}
+	}
+
+	s.audit(r, EventGroupDeleted, 0, before, nil)
+
+	return nil
+}
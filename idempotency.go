@@ -0,0 +1,243 @@
+package infinias
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultIdempotencyTTL  = 24 * time.Hour
+	idempotencyKeyHeader   = "Idempotency-Key"
+	idempotentReplayHeader = "Idempotent-Replayed"
+)
+
+var (
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+	ErrIdempotencyMismatch   = errors.New("idempotency key reused with a different request")
+)
+
+// IdempotentResponse is a stored response replayed for retried requests
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Location    string
+	Body        []byte
+}
+
+// IdempotencyStore tracks requests by idempotency key
+type IdempotencyStore interface {
+	// Begin marks key as in progress. If a response was already stored for key it is returned.
+	// Begin returns ErrIdempotencyInProgress if key is in progress,
+	// or ErrIdempotencyMismatch if key was used for a request with a different fingerprint
+	Begin(key, fingerprint string) (*IdempotentResponse, error)
+	// Complete stores the response for key
+	Complete(key string, resp *IdempotentResponse) error
+	// Abort forgets key so the request can be retried
+	Abort(key string) error
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	resp        *IdempotentResponse
+	expires     time.Time
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore
+type MemoryIdempotencyStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// NewMemoryIdempotencyStore returns a new MemoryIdempotencyStore that keeps responses for ttl
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{ttl: ttl, entries: make(map[string]*idempotencyEntry)}
+}
+
+// SetTTL changes how long new responses are kept
+func (m *MemoryIdempotencyStore) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ttl = ttl
+}
+
+// expire removes expired entries. m.mu must be held
+func (m *MemoryIdempotencyStore) expire() {
+	now := time.Now()
+	for k, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+}
+
+func (m *MemoryIdempotencyStore) Begin(key, fingerprint string) (*IdempotentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+
+	e, ok := m.entries[key]
+	if !ok {
+		m.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expires: time.Now().Add(m.ttl)}
+		return nil, nil
+	}
+
+	if e.fingerprint != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+
+	if e.resp == nil {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	return e.resp, nil
+}
+
+func (m *MemoryIdempotencyStore) Complete(key string, resp *IdempotentResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok {
+		e.resp = resp
+		e.expires = time.Now().Add(m.ttl)
+	}
+
+	return nil
+}
+
+func (m *MemoryIdempotencyStore) Abort(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// responseRecorder captures a response while writing it through
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	buf  bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	r.buf.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// storeIdempotentResponse marks the response to the request with ctx to be stored even if it's a server error,
+// because something was saved and retrying the request would save it again
+func storeIdempotentResponse(ctx context.Context) {
+	if store, ok := ctx.Value(contextKeyIdempotency).(*bool); ok {
+		*store = true
+	}
+}
+
+// WithIdempotency replays the stored response for requests with a previously seen Idempotency-Key header.
+// Keys are scoped to the caller, method, and path. Server errors aren't stored so the request can be retried,
+// unless they're partial failures where something was saved
+func (s *Service) WithIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idemKey := r.Header.Get(idempotencyKeyHeader)
+		if s.Idempotency == nil || idemKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		errHandler := func(err error) {
+			s.HandleJSON(func(r *http.Request) (interface{}, error) {
+				return nil, err
+			}).ServeHTTP(w, r)
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			code := http.StatusBadRequest
+			if bodyTooLarge(r, err) {
+				code = http.StatusRequestEntityTooLarge
+			}
+			errHandler(&HTTPError{StatusCode: code, Err: fmt.Errorf("could not read body: %w", err)})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		actor := ""
+		if p := PrincipalFromContext(r.Context()); p != nil {
+			actor = p.Name
+		}
+		key := fmt.Sprintf("%s|%s|%s|%s", actor, r.Method, r.URL.Path, idemKey)
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		resp, err := s.Idempotency.Begin(key, fingerprint)
+		if err != nil {
+			code := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrIdempotencyInProgress):
+				code = http.StatusConflict
+			case errors.Is(err, ErrIdempotencyMismatch):
+				code = http.StatusUnprocessableEntity
+			}
+			errHandler(&HTTPError{StatusCode: code, Err: err})
+			return
+		}
+
+		if resp != nil {
+			if resp.ContentType != "" {
+				w.Header().Set("Content-Type", resp.ContentType)
+			}
+			if resp.Location != "" {
+				w.Header().Set("Location", resp.Location)
+			}
+			w.Header().Set(idempotentReplayHeader, "true")
+			w.WriteHeader(resp.StatusCode)
+			w.Write(resp.Body)
+			return
+		}
+
+		// the key is aborted unless a response is stored, including if next panics, so it isn't left in progress
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := s.Idempotency.Abort(key); err != nil {
+				s.requestLogger(r).Error("could not abort idempotency key", "error", err)
+			}
+		}()
+
+		store := false
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), contextKeyIdempotency, &store)))
+
+		if (rec.code >= http.StatusInternalServerError && !store) || rec.code == 0 {
+			return
+		}
+
+		if err := s.Idempotency.Complete(key, &IdempotentResponse{
+			StatusCode:  rec.code,
+			ContentType: w.Header().Get("Content-Type"),
+			Location:    w.Header().Get("Location"),
+			Body:        rec.buf.Bytes(),
+		}); err != nil {
+			s.requestLogger(r).Error("could not store idempotent response", "error", err)
+			return
+		}
+		completed = true
+	})
+}
@@ -0,0 +1,52 @@
+package infinias
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithIdempotency(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		replay bool
+	}{
+		{"ok", nil, true},
+		{"conflict", &HTTPError{StatusCode: http.StatusConflict, Err: errors.New("conflict")}, true},
+		{"server error", errors.New("failed"), false},
+		{"partial failure", partialFailure(&PartialError{PersonID: 42, Picture: errors.New("failed")}), true},
+		{"rolled back", rolledBackFailure(&RolledBackError{Partial: &PartialError{PersonID: 42, Picture: errors.New("failed")}}), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Service{Idempotency: NewMemoryIdempotencyStore(time.Minute)}
+			calls := 0
+			h := s.WithIdempotency(s.HandleJSON(func(r *http.Request) (interface{}, error) {
+				calls++
+				return map[string]int{"id": 42}, test.err
+			}))
+
+			var first, second *httptest.ResponseRecorder
+			for _, w := range []**httptest.ResponseRecorder{&first, &second} {
+				r := httptest.NewRequest(http.MethodPost, "/people", strings.NewReader(`{"first_name":"a"}`))
+				r.Header.Set(idempotencyKeyHeader, "key")
+				*w = httptest.NewRecorder()
+				h.ServeHTTP(*w, r)
+			}
+
+			if replayed := second.Header().Get(idempotentReplayHeader) == "true"; replayed != test.replay {
+				t.Errorf("want replayed %t, have %t", test.replay, replayed)
+			}
+			if want := map[bool]int{true: 1, false: 2}[test.replay]; calls != want {
+				t.Errorf("want %d calls, have %d", want, calls)
+			}
+			if second.Code != first.Code || second.Body.String() != first.Body.String() {
+				t.Errorf("want response %d %s, have %d %s", first.Code, first.Body, second.Code, second.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/korylprince/go-infinias-api/api"
+)
+
+const DefaultEmployeeIndexRefresh = 15 * time.Minute
+
+const (
+	// employeeIndexMissRefresh is the least time between refreshes caused by lookups that miss
+	employeeIndexMissRefresh = 10 * time.Second
+	// employeeIndexMissTTL is how long an employee ID that's still missing after a refresh is reported missing
+	// without refreshing again
+	employeeIndexMissTTL = time.Minute
+)
+
+var ErrEmployeeIDNotFound = errors.New("employee id not found")
+
+// EmployeeIndex maps employee IDs to person IDs. It's replaced whenever all people are listed,
+// and patched when people are created, updated, or deleted through the Service. A nil *EmployeeIndex is valid and empty
+type EmployeeIndex struct {
+	mu         sync.RWMutex
+	byEmployee map[string]int
+	byPerson   map[int]string
+	updated    time.Time
+
+	// misses maps employee IDs missing after a refresh to when they stop being reported missing
+	misses map[string]time.Time
+	// missRefreshed is when the last refresh for a miss started, and missErr is its error
+	missRefreshed time.Time
+	missErr       error
+	// missRefreshing is closed when the running refresh for a miss finishes. It's nil if none is running
+	missRefreshing chan struct{}
+}
+
+// NewEmployeeIndex returns a new, empty EmployeeIndex
+func NewEmployeeIndex() *EmployeeIndex {
+	return &EmployeeIndex{byEmployee: make(map[string]int), byPerson: make(map[int]string), misses: make(map[string]time.Time)}
+}
+
+// Lookup returns the person ID for employeeID
+func (x *EmployeeIndex) Lookup(employeeID string) (int, bool) {
+	if x == nil {
+		return 0, false
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	id, ok := x.byEmployee[normalizeEmployeeID(employeeID)]
+	return id, ok
+}
+
+// Set records that the person with the given id has employeeID. An empty employeeID removes the person
+func (x *EmployeeIndex) Set(id int, employeeID string) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.delete(id)
+	if key := normalizeEmployeeID(employeeID); key != "" {
+		x.byEmployee[key] = id
+		x.byPerson[id] = key
+		delete(x.misses, key)
+	}
+}
+
+// missed returns true if the normalized employeeID key was missing after a recent refresh
+func (x *EmployeeIndex) missed(key string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	expires, ok := x.misses[key]
+	return ok && time.Now().Before(expires)
+}
+
+// addMiss records that the normalized employeeID key is missing after a refresh
+func (x *EmployeeIndex) addMiss(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := time.Now()
+	for k, expires := range x.misses {
+		if !now.Before(expires) {
+			delete(x.misses, k)
+		}
+	}
+	x.misses[key] = now.Add(employeeIndexMissTTL)
+}
+
+// refreshForMiss calls refresh, unless a refresh for a miss started in the last employeeIndexMissRefresh,
+// returning its error. Concurrent callers wait for and share one call
+func (x *EmployeeIndex) refreshForMiss(refresh func() error) error {
+	x.mu.Lock()
+	if done := x.missRefreshing; done != nil {
+		x.mu.Unlock()
+		<-done
+		x.mu.RLock()
+		defer x.mu.RUnlock()
+		return x.missErr
+	}
+	if time.Since(x.missRefreshed) < employeeIndexMissRefresh {
+		defer x.mu.Unlock()
+		return x.missErr
+	}
+	done := make(chan struct{})
+	x.missRefreshing = done
+	x.missRefreshed = time.Now()
+	x.mu.Unlock()
+
+	err := refresh()
+
+	x.mu.Lock()
+	x.missErr = err
+	x.missRefreshing = nil
+	x.mu.Unlock()
+	close(done)
+	return err
+}
+
+// Delete removes the person with the given id
+func (x *EmployeeIndex) Delete(id int) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.delete(id)
+}
+
+// delete removes the person with the given id. The caller must hold x.mu
+func (x *EmployeeIndex) delete(id int) {
+	if key, ok := x.byPerson[id]; ok {
+		delete(x.byPerson, id)
+		if x.byEmployee[key] == id {
+			delete(x.byEmployee, key)
+		}
+	}
+}
+
+// Replace replaces the index with people
+func (x *EmployeeIndex) Replace(people []*Person) {
+	if x == nil {
+		return
+	}
+	byEmployee := make(map[string]int, len(people))
+	byPerson := make(map[int]string, len(people))
+	for _, p := range people {
+		if key := normalizeEmployeeID(p.EmployeeID); key != "" {
+			byEmployee[key] = p.ID
+			byPerson[p.ID] = key
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.byEmployee, x.byPerson, x.updated = byEmployee, byPerson, time.Now()
+}
+
+// Updated returns when the index was last replaced, or the zero time if it never has been
+func (x *EmployeeIndex) Updated() time.Time {
+	if x == nil {
+		return time.Time{}
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.updated
+}
+
+// RefreshEmployeeIndex replaces s.EmployeeIndex with the people currently in Infinias
+func (s *Service) RefreshEmployeeIndex() error {
+	people, err := s.APIConn.ListPeople()
+	if err != nil {
+		return fmt.Errorf("could not list people: %w", err)
+	}
+	indexed := make([]*Person, len(people))
+	for idx, p := range people {
+		indexed[idx] = &Person{ID: p.ID, EmployeeID: p.EmployeeID}
+	}
+	s.EmployeeIndex.Replace(indexed)
+	return nil
+}
+
+// WatchEmployeeIndex refreshes s.EmployeeIndex now and then every interval until stop is called
+func (s *Service) WatchEmployeeIndex(interval time.Duration) (stop func()) {
+	if s.EmployeeIndex == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultEmployeeIndexRefresh
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.RefreshEmployeeIndex(); err != nil {
+				s.logger().Warn("could not refresh employee index", "error", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// ReadPersonByEmployeeID returns the person with employeeID. If the index is missing or stale, it's refreshed first
+func (s *Service) ReadPersonByEmployeeID(employeeID string) (*Person, error) {
+	key := normalizeEmployeeID(employeeID)
+	if key == "" {
+		return nil, ErrEmployeeIDNotFound
+	}
+
+	if id, ok := s.EmployeeIndex.Lookup(key); ok {
+		p, err := s.ReadPerson(id)
+		if err == nil && normalizeEmployeeID(p.EmployeeID) == key {
+			return p, nil
+		}
+		if err != nil && !api.IsNotFoundError(err) {
+			return nil, err
+		}
+	}
+
+	// the person was created or changed outside of this service
+	if s.EmployeeIndex == nil {
+		return s.scanEmployeeID(key)
+	}
+	if s.EmployeeIndex.missed(key) {
+		return nil, ErrEmployeeIDNotFound
+	}
+	if err := s.EmployeeIndex.refreshForMiss(s.RefreshEmployeeIndex); err != nil {
+		return nil, err
+	}
+	id, ok := s.EmployeeIndex.Lookup(key)
+	if !ok {
+		s.EmployeeIndex.addMiss(key)
+		return nil, ErrEmployeeIDNotFound
+	}
+	return s.ReadPerson(id)
+}
+
+// scanEmployeeID finds the person with the normalized employee ID key by listing all people
+func (s *Service) scanEmployeeID(key string) (*Person, error) {
+	people, err := s.APIConn.ListPeople()
+	if err != nil {
+		return nil, fmt.Errorf("could not list people: %w", err)
+	}
+	for _, p := range people {
+		if normalizeEmployeeID(p.EmployeeID) == key {
+			return s.ReadPerson(p.ID)
+		}
+	}
+	return nil, ErrEmployeeIDNotFound
+}
+
+// ReadPersonByEmployeeIDHandler returns the person with the employee ID in the request path
+func (s *Service) ReadPersonByEmployeeIDHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	// a lookup by a hidden field would reveal it
+	if err := hiddenFieldError(s.hiddenFields(r), "employee_id"); err != nil {
+		return nil, err
+	}
+	employeeID := strings.TrimSpace(mux.Vars(r)["employee_id"])
+
+	p, err := s.ReadPersonByEmployeeID(employeeID)
+	if err != nil {
+		if errors.Is(err, ErrEmployeeIDNotFound) || api.IsNotFoundError(err) {
+			return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: fmt.Errorf("could not read person: %w", err)}
+		}
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not read person: %w", err)}
+	}
+
+	return p, nil
+}
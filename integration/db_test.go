@@ -0,0 +1,371 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+func TestCredentialLifecycle(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+	insertPerson(t, 2, "Bob", "Jones")
+
+	expiration := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	credID, err := conn.CreateCredential(1, &db.Credential{Active: true, SiteCode: 100, CardCode: 200, Expiration: &expiration})
+	if err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+
+	creds, err := conn.ListCredentials(1)
+	if err != nil {
+		t.Fatalf("could not list credentials: %v", err)
+	}
+	if len(creds) != 1 || creds[0].ID != credID || !creds[0].Active || creds[0].SiteCode != 100 || creds[0].CardCode != 200 {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+	if creds[0].Activation == nil {
+		t.Error("activation wasn't defaulted")
+	}
+	if creds[0].Expiration == nil || !creds[0].Expiration.Equal(expiration) {
+		t.Errorf("expiration: want %v, have %v", expiration, creds[0].Expiration)
+	}
+
+	personID, ownerCredID, err := conn.CredentialOwner(100, 200)
+	if err != nil || personID != 1 || ownerCredID != credID {
+		t.Fatalf("unexpected owner: %d, %d, %v", personID, ownerCredID, err)
+	}
+
+	// creating the same credential for another person conflicts
+	_, err = conn.CreateCredential(2, &db.Credential{Active: true, SiteCode: 100, CardCode: 200})
+	if !errors.Is(err, db.ErrCredentialExists) {
+		t.Fatalf("want ErrCredentialExists, have %v", err)
+	}
+	var exists *db.CredentialExistsError
+	if !errors.As(err, &exists) || exists.PersonID != 1 || exists.CredentialID != credID {
+		t.Fatalf("unexpected conflict: %+v", exists)
+	}
+
+	// creating it again for the same person updates its status in place
+	if id, err := conn.CreateCredential(1, &db.Credential{Active: false, SiteCode: 100, CardCode: 200}); err != nil || id != credID {
+		t.Fatalf("could not update credential: %d, %v", id, err)
+	}
+	if creds, err = conn.ListCredentials(1); err != nil || len(creds) != 1 || creds[0].Active {
+		t.Fatalf("credential wasn't deactivated: %+v, %v", creds, err)
+	}
+	if creds[0].Expiration == nil || !creds[0].Expiration.Equal(expiration) {
+		t.Errorf("expiration wasn't kept: want %v, have %v", expiration, creds[0].Expiration)
+	}
+
+	// dates are only removed when asked
+	if _, err = conn.CreateCredential(1, &db.Credential{Active: true, SiteCode: 100, CardCode: 200, ClearActivation: true, ClearExpiration: true}); err != nil {
+		t.Fatalf("could not update credential: %v", err)
+	}
+	if creds, err = conn.ListCredentials(1); err != nil || len(creds) != 1 || creds[0].Activation != nil || creds[0].Expiration != nil {
+		t.Fatalf("dates weren't cleared: %+v, %v", creds, err)
+	}
+
+	// deleting another person's credential does nothing
+	if err = conn.DeleteCredential(2, credID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, have %v", err)
+	}
+	if creds, err = conn.ListCredentials(1); err != nil || len(creds) != 1 {
+		t.Fatalf("credential was deleted: %+v, %v", creds, err)
+	}
+
+	if err = conn.DeleteCredential(1, credID); err != nil {
+		t.Fatalf("could not delete credential: %v", err)
+	}
+	rows, err := conn.ListCredentialRows()
+	if err != nil || len(rows) != 0 {
+		t.Fatalf("credential rows remain: %+v, %v", rows, err)
+	}
+	if _, _, err = conn.CredentialOwner(100, 200); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, have %v", err)
+	}
+}
+
+func TestDeleteCredentialByID(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+
+	credID, err := conn.CreateCredential(1, &db.Credential{Active: true, SiteCode: 100, CardCode: 200})
+	if err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+
+	// detach the credential, as deleting its person may
+	mustExec(t, "update EAC.Credential set PersonId = null where Id = @p1", credID)
+	mustExec(t, "delete from EAC.Person where Id = 1")
+
+	if err = conn.DeleteCredentialByID(credID); err != nil {
+		t.Fatalf("could not delete credential: %v", err)
+	}
+	rows, err := conn.ListCredentialRows()
+	if err != nil || len(rows) != 0 {
+		t.Fatalf("credential rows remain: %+v, %v", rows, err)
+	}
+
+	if err = conn.DeleteCredentialByID(credID); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, have %v", err)
+	}
+}
+
+func TestDeactivateCredentials(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+
+	for _, card := range []int{1, 2} {
+		if _, err := conn.CreateCredential(1, &db.Credential{Active: true, SiteCode: 10, CardCode: card}); err != nil {
+			t.Fatalf("could not create credential: %v", err)
+		}
+	}
+
+	ids, err := conn.DeactivateCredentials(1)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("unexpected deactivation: %v, %v", ids, err)
+	}
+	creds, err := conn.ListCredentials(1)
+	if err != nil {
+		t.Fatalf("could not list credentials: %v", err)
+	}
+	for _, c := range creds {
+		if c.Active {
+			t.Fatalf("credential %d is still active", c.ID)
+		}
+	}
+}
+
+func TestExpireCredentials(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	expiredID, err := conn.CreateCredential(1, &db.Credential{Active: true, SiteCode: 10, CardCode: 1, Expiration: &past})
+	if err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+	if _, err = conn.CreateCredential(1, &db.Credential{Active: true, SiteCode: 10, CardCode: 2, Expiration: &future}); err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+
+	expired, err := conn.ExpireCredentials()
+	if err != nil {
+		t.Fatalf("could not expire credentials: %v", err)
+	}
+	if len(expired) != 1 || expired[0].PersonID != 1 || expired[0].CredentialID != expiredID {
+		t.Fatalf("unexpected expired credentials: %+v", expired)
+	}
+
+	if expired, err = conn.ExpireCredentials(); err != nil || len(expired) != 0 {
+		t.Fatalf("credentials expired twice: %+v, %v", expired, err)
+	}
+}
+
+func TestListExpiringCredentials(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+
+	past := time.Now().Add(-time.Hour)
+	soon := time.Now().Add(24 * time.Hour)
+	later := time.Now().Add(60 * 24 * time.Hour)
+	for idx, exp := range []time.Time{past, soon, later} {
+		if _, err := conn.CreateCredential(1, &db.Credential{Active: true, SiteCode: 10, CardCode: idx + 1, Expiration: &exp}); err != nil {
+			t.Fatalf("could not create credential: %v", err)
+		}
+	}
+
+	creds, err := conn.ListExpiringCredentials(time.Now().Add(30 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("could not list expiring credentials: %v", err)
+	}
+	if len(creds) != 1 || creds[0].PersonID != 1 || creds[0].LastName != "Smith" || creds[0].CardCode != 2 {
+		t.Fatalf("unexpected expiring credentials: %+v", creds)
+	}
+}
+
+func TestPictures(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+
+	if _, err := conn.ReadPicture(1); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, have %v", err)
+	}
+
+	for _, buf := range [][]byte{[]byte("first"), []byte("second")} {
+		if err := conn.UpdatePicture(1, buf); err != nil {
+			t.Fatalf("could not update picture: %v", err)
+		}
+		have, err := conn.ReadPicture(1)
+		if err != nil || !bytes.Equal(have, buf) {
+			t.Fatalf("unexpected picture: %q, %v", have, err)
+		}
+	}
+
+	ids, err := conn.HasPictureIDs()
+	if err != nil || len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("unexpected picture ids: %v, %v", ids, err)
+	}
+
+	if err = conn.DeletePicture(1); err != nil {
+		t.Fatalf("could not delete picture: %v", err)
+	}
+	if _, err = conn.ReadPicture(1); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, have %v", err)
+	}
+}
+
+func TestDeleteOrphans(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+
+	for _, id := range []int{1, 2} {
+		if err := conn.UpdatePicture(id, []byte("picture")); err != nil {
+			t.Fatalf("could not update picture: %v", err)
+		}
+		if _, err := conn.CreateCredential(id, &db.Credential{Active: true, SiteCode: 10, CardCode: id}); err != nil {
+			t.Fatalf("could not create credential: %v", err)
+		}
+	}
+
+	o, err := conn.DeleteOrphans()
+	if err != nil {
+		t.Fatalf("could not delete orphans: %v", err)
+	}
+	if o.Pictures != 1 || o.Credentials != 1 || o.WiegandCredentials != 1 {
+		t.Fatalf("unexpected orphans: %+v", o)
+	}
+
+	if _, err = conn.ReadPicture(1); err != nil {
+		t.Fatalf("picture was deleted: %v", err)
+	}
+	if creds, err := conn.ListCredentials(1); err != nil || len(creds) != 1 {
+		t.Fatalf("credential was deleted: %+v, %v", creds, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	reset(t)
+	mustExec(t, "insert into EAC.EventType(Id, Name) values (1, 'Access Granted'), (2, 'Door Forced Open')")
+	mustExec(t, "insert into EAC.Door(Id, Name) values (1, 'Front')")
+
+	latest, err := conn.LatestEventID()
+	if err != nil {
+		t.Fatalf("could not read latest event id: %v", err)
+	}
+
+	now := time.Now().UTC()
+	mustExec(t, "insert into EAC.Event(EventTypeId, PersonId, DoorId, EventDateUTC) values (1, 1, 1, @p1), (2, null, 1, @p2), (1, 2, null, @p3)",
+		now.Add(-2*time.Minute), now.Add(-time.Minute), now)
+
+	events, err := conn.ListEventsSince(latest, 10)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("unexpected events: %+v, %v", events, err)
+	}
+	if events[0].Type != "Access Granted" || events[0].Door != "Front" || events[1].PersonID != 0 || events[2].DoorID != 0 {
+		t.Fatalf("unexpected events: %+v, %+v, %+v", events[0], events[1], events[2])
+	}
+
+	events, total, err := conn.QueryEvents(&db.EventQuery{Types: []string{"access granted"}, Limit: 1})
+	if err != nil || total != 2 || len(events) != 1 || events[0].PersonID != 2 {
+		t.Fatalf("unexpected query result: %+v, %d, %v", events, total, err)
+	}
+
+	if _, total, err = conn.QueryEvents(&db.EventQuery{Types: []string{"2"}, Since: now.Add(-90 * time.Second), Limit: 10}); err != nil || total != 1 {
+		t.Fatalf("unexpected query total: %d, %v", total, err)
+	}
+}
+
+func TestListInactivePeople(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+	insertPerson(t, 2, "Bob", "Jones")
+	insertPerson(t, 3, "Carol", "White")
+	insertPerson(t, 4, "Dan", "Brown")
+	for id := 1; id <= 3; id++ {
+		if _, err := conn.CreateCredential(id, &db.Credential{Active: true, SiteCode: 10, CardCode: id}); err != nil {
+			t.Fatalf("could not create credential: %v", err)
+		}
+	}
+	mustExec(t, "insert into EAC.EventType(Id, Name) values (1, 'Access Granted')")
+
+	// Alice is active, Bob is inactive, Carol has never badged, and Dan has no credential
+	now := time.Now().UTC()
+	mustExec(t, "insert into EAC.Event(EventTypeId, PersonId, EventDateUTC) values (1, 1, @p1), (1, 2, @p2), (1, 4, @p2)",
+		now.Add(-24*time.Hour), now.Add(-100*24*time.Hour))
+
+	people, err := conn.ListInactivePeople(now.AddDate(0, 0, -90))
+	if err != nil {
+		t.Fatalf("could not list inactive people: %v", err)
+	}
+	if len(people) != 2 || people[0].PersonID != 3 || people[0].LastActivity != nil || people[1].PersonID != 2 || people[1].LastActivity == nil {
+		t.Fatalf("unexpected inactive people: %+v", people)
+	}
+}
+
+func TestListLastDoorEvents(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+	insertPerson(t, 2, "Bob", "Jones")
+	insertPerson(t, 3, "Carol", "White")
+	mustExec(t, "insert into EAC.EventType(Id, Name) values (1, 'Access Granted'), (2, 'Access Denied')")
+	mustExec(t, "insert into EAC.Door(Id, Name) values (1, 'Front In'), (2, 'Front Out'), (3, 'Dock')")
+
+	// Alice entered, Bob entered and left, and Carol was denied at the exit and badged at an untracked door
+	now := time.Now().UTC()
+	mustExec(t, `insert into EAC.Event(EventTypeId, PersonId, DoorId, EventDateUTC) values
+(1, 1, 1, @p1), (1, 2, 1, @p1), (1, 2, 2, @p2), (1, 3, 1, @p1), (2, 3, 2, @p2), (1, 3, 3, @p2)`,
+		now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	events, err := conn.ListLastDoorEvents([]int{1, 2}, []string{"Access Granted"}, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("could not list last door events: %v", err)
+	}
+	last := make(map[int]int)
+	for _, e := range events {
+		last[e.PersonID] = e.DoorID
+	}
+	if len(events) != 3 || last[1] != 1 || last[2] != 2 || last[3] != 1 {
+		t.Fatalf("unexpected last door events: %+v", events)
+	}
+}
+
+func TestListAccess(t *testing.T) {
+	reset(t)
+	insertPerson(t, 1, "Alice", "Smith")
+	insertPerson(t, 2, "Bob", "Jones")
+	mustExec(t, "insert into EAC.[Group](Id, Name) values (1, 'Staff'), (2, 'Admins')")
+	mustExec(t, "insert into EAC.PersonGroup(PersonId, GroupId) values (1, 1), (2, 1), (2, 2)")
+	mustExec(t, "insert into EAC.Door(Id, Name) values (1, 'Front'), (2, 'Server Room')")
+	mustExec(t, "insert into EAC.Schedule(Id, Name) values (1, 'Business Hours')")
+	mustExec(t, "insert into EAC.AccessRule(GroupId, DoorId, ScheduleId) values (1, 1, 1), (2, 2, null)")
+
+	access, err := conn.ListAccess(&db.AccessQuery{PersonID: 2})
+	if err != nil || len(access) != 2 {
+		t.Fatalf("unexpected access: %+v, %v", access, err)
+	}
+	if access[0].Door != "Front" || access[0].Schedule != "Business Hours" || access[1].Door != "Server Room" || access[1].ScheduleID != 0 {
+		t.Fatalf("unexpected access: %+v, %+v", access[0], access[1])
+	}
+
+	if access, err = conn.ListAccess(&db.AccessQuery{DoorID: 2}); err != nil || len(access) != 1 || access[0].PersonID != 2 {
+		t.Fatalf("unexpected access: %+v, %v", access, err)
+	}
+
+	memberships, err := conn.ListGroupMemberships()
+	if err != nil || len(memberships[2]) != 2 {
+		t.Fatalf("unexpected memberships: %v, %v", memberships, err)
+	}
+
+	members, err := conn.ListGroupMembers(1)
+	if err != nil || len(members) != 2 || members[0] != 1 || members[1] != 2 {
+		t.Fatalf("unexpected members: %v, %v", members, err)
+	}
+}
@@ -0,0 +1,8 @@
+// Package integration contains end-to-end tests for db.Conn and Service against SQL Server and a fake Infinias API.
+// The tests are behind the integration build tag:
+//
+//	go test -tags integration ./integration
+//
+// By default, a SQL Server container is started with docker and loaded with a minimal EAC schema from testdata/eac.sql.
+// Set INFINIAS_TEST_DSN to use an existing server instead; the tests create and drop their own database on it
+package integration
@@ -0,0 +1,197 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+const (
+	defaultImage    = "mcr.microsoft.com/mssql/server:2022-latest"
+	containerPass   = "Integration-Test-1"
+	databaseName    = "infinias_integration"
+	startupTimeout  = 2 * time.Minute
+	startupInterval = 2 * time.Second
+)
+
+// conn is the database loaded with the EAC schema, shared by all tests
+var conn *db.Conn
+
+var goRegexp = regexp.MustCompile(`(?im)^\s*GO\s*$`)
+
+func TestMain(m *testing.M) {
+	code, err := run(m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration:", err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+func run(m *testing.M) (int, error) {
+	dsn := os.Getenv("INFINIAS_TEST_DSN")
+	if dsn == "" {
+		containerDSN, stop, err := startContainer()
+		if err != nil {
+			return 0, err
+		}
+		defer stop()
+		dsn = containerDSN
+	}
+
+	server, err := waitForServer(dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer server.Close()
+
+	if _, err = server.Exec("drop database if exists " + databaseName + "; create database " + databaseName); err != nil {
+		return 0, fmt.Errorf("could not create database: %w", err)
+	}
+	defer func() {
+		if _, err := server.Exec("alter database " + databaseName + " set single_user with rollback immediate; drop database " + databaseName); err != nil {
+			fmt.Fprintln(os.Stderr, "integration: could not drop database:", err)
+		}
+	}()
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse dsn: %w", err)
+	}
+	q := u.Query()
+	q.Set("database", databaseName)
+	u.RawQuery = q.Encode()
+
+	if conn, err = db.NewConn(u.String()); err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err = loadSchema(conn.DB, "testdata/eac.sql"); err != nil {
+		return 0, err
+	}
+
+	return m.Run(), nil
+}
+
+// startContainer starts a SQL Server container, returning its DSN and a function to remove it
+func startContainer() (string, func(), error) {
+	image := os.Getenv("INFINIAS_TEST_MSSQL_IMAGE")
+	if image == "" {
+		image = defaultImage
+	}
+
+	out, err := docker("run", "-d", "--rm",
+		"-e", "ACCEPT_EULA=Y",
+		"-e", "MSSQL_SA_PASSWORD="+containerPass,
+		"-p", "127.0.0.1::1433",
+		image,
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not start container (set INFINIAS_TEST_DSN to use an existing server): %w", err)
+	}
+	id := strings.TrimSpace(out)
+	stop := func() {
+		if _, err := docker("rm", "-f", id); err != nil {
+			fmt.Fprintln(os.Stderr, "integration: could not remove container:", err)
+		}
+	}
+
+	out, err = docker("port", id, "1433/tcp")
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("could not read container port: %w", err)
+	}
+	// docker may print one line per address family
+	addr := strings.TrimSpace(strings.Split(out, "\n")[0])
+
+	u := &url.URL{Scheme: "sqlserver", User: url.UserPassword("sa", containerPass), Host: addr}
+	return u.String(), stop, nil
+}
+
+func docker(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// waitForServer opens dsn, waiting for the server to accept connections
+func waitForServer(dsn string) (*sql.DB, error) {
+	server, err := sql.Open("sqlserver", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open server: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+	for {
+		if err = server.PingContext(ctx); err == nil {
+			return server, nil
+		}
+		select {
+		case <-ctx.Done():
+			server.Close()
+			return nil, fmt.Errorf("could not connect to server: %w", err)
+		case <-time.After(startupInterval):
+		}
+	}
+}
+
+// loadSchema executes the batches in the script at path, separated by GO lines
+func loadSchema(conn *sql.DB, path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read schema: %w", err)
+	}
+	for _, batch := range goRegexp.Split(string(buf), -1) {
+		if strings.TrimSpace(batch) == "" {
+			continue
+		}
+		if _, err = conn.Exec(batch); err != nil {
+			return fmt.Errorf("could not load schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// reset deletes all rows from the EAC tables
+func reset(t *testing.T) {
+	t.Helper()
+	for _, table := range []string{"Event", "EventType", "AccessRule", "Schedule", "Door", "PersonGroup", "[Group]", "WiegandCredential", "Credential", "PersonImage", "Person"} {
+		if _, err := conn.Exec("delete from EAC." + table); err != nil {
+			t.Fatalf("could not reset EAC.%s: %v", table, err)
+		}
+	}
+}
+
+// mustExec executes query, failing t on error
+func mustExec(t *testing.T, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := conn.Exec(query, args...); err != nil {
+		t.Fatalf("could not execute %q: %v", query, err)
+	}
+}
+
+// insertPerson adds a row to EAC.Person, as Infinias does when a person is created through the API
+func insertPerson(t *testing.T, id int, first, last string) {
+	t.Helper()
+	mustExec(t, "insert into EAC.Person(Id, FirstName, LastName) values (@p1, @p2, @p3)", id, first, last)
+}
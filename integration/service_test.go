@@ -0,0 +1,318 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	infinias "github.com/korylprince/go-infinias-api"
+	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/api/apitest"
+	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+// newService returns a Service using conn and a new fake Infinias API server
+func newService(t *testing.T) (*infinias.Service, *apitest.Server) {
+	t.Helper()
+	reset(t)
+	server := apitest.NewServer()
+	t.Cleanup(server.Close)
+	return &infinias.Service{APIConn: server.Conn(), DBConn: conn}, server
+}
+
+func testImage(t *testing.T) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("could not encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestServicePersonLifecycle(t *testing.T) {
+	s, server := newService(t)
+	img := testImage(t)
+
+	id, err := s.CreatePerson(&infinias.Person{
+		FirstName: "Alice",
+		LastName:  "Smith",
+		SiteCode:  10,
+		CardCode:  1,
+		Image:     img,
+		Credentials: []*infinias.Credential{
+			{Active: true, SiteCode: 10, CardCode: 1},
+			{Active: true, SiteCode: 10, CardCode: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create person: %v", err)
+	}
+	insertPerson(t, id, "Alice", "Smith")
+
+	p, err := s.ReadPerson(id)
+	if err != nil {
+		t.Fatalf("could not read person: %v", err)
+	}
+	if p.FirstName != "Alice" || p.SiteCode != 10 || p.CardCode != 1 || !p.HasImage || !bytes.Equal(p.Image, img) {
+		t.Fatalf("unexpected person: %+v", p)
+	}
+	// the primary badge is created by Infinias, so only the extra credential is in the database
+	if len(p.Credentials) != 1 || p.Credentials[0].CardCode != 2 {
+		t.Fatalf("unexpected credentials: %+v", p.Credentials)
+	}
+
+	if err = s.UpdatePerson(&infinias.Person{ID: id, FirstName: "Alice", LastName: "Jones", Image: img, Credentials: []*infinias.Credential{{Active: true, SiteCode: 10, CardCode: 3}}}); err != nil {
+		t.Fatalf("could not update person: %v", err)
+	}
+	if p, err = s.ReadPerson(id); err != nil || p.LastName != "Jones" || p.FirstName != "Alice" || len(p.Credentials) != 2 {
+		t.Fatalf("unexpected person: %+v, %v", p, err)
+	}
+
+	if err = s.DeletePerson(id); err != nil {
+		t.Fatalf("could not delete person: %v", err)
+	}
+	if server.Person(id) != nil {
+		t.Fatal("person wasn't deleted from api")
+	}
+	if _, err = conn.ReadPicture(id); !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("picture wasn't deleted: %v", err)
+	}
+	if _, err = s.ReadPerson(id); !api.IsNotFoundError(err) {
+		t.Fatalf("want not found, have %v", err)
+	}
+}
+
+func TestServiceCredentialsWithoutImage(t *testing.T) {
+	s, server := newService(t)
+	alice := server.AddPerson(&api.Person{FirstName: "Alice"})
+	if _, err := s.CreateCredential(alice, &infinias.Credential{Active: true, SiteCode: 10, CardCode: 1}); err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+
+	id, err := s.CreatePerson(&infinias.Person{
+		FirstName: "Bob",
+		LastName:  "Smith",
+		Credentials: []*infinias.Credential{
+			{Active: true, SiteCode: 10, CardCode: 1},
+			{Active: true, SiteCode: 10, CardCode: 2},
+		},
+	})
+	partial := new(infinias.PartialError)
+	if !errors.As(err, &partial) || partial.PersonID != id || len(partial.Credentials) != 1 || !errors.Is(err, db.ErrCredentialExists) {
+		t.Fatalf("want partial error for credential 10-1, have %v", err)
+	}
+	insertPerson(t, id, "Bob", "Smith")
+
+	creds, err := s.ListCredentials(id)
+	if err != nil {
+		t.Fatalf("could not list credentials: %v", err)
+	}
+	if len(creds) != 1 || creds[0].CardCode != 2 {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+
+	if err = s.UpdatePerson(&infinias.Person{ID: id, FirstName: "Bob", LastName: "Smith", Credentials: []*infinias.Credential{{Active: true, SiteCode: 10, CardCode: 3}}}); err != nil {
+		t.Fatalf("could not update person: %v", err)
+	}
+	if creds, err = s.ListCredentials(id); err != nil || len(creds) != 2 {
+		t.Fatalf("unexpected credentials: %+v, %v", creds, err)
+	}
+}
+
+func TestServiceCredentialConflict(t *testing.T) {
+	s, server := newService(t)
+	alice := server.AddPerson(&api.Person{FirstName: "Alice"})
+	bob := server.AddPerson(&api.Person{FirstName: "Bob"})
+
+	if _, err := s.CreateCredential(alice, &infinias.Credential{Active: true, SiteCode: 10, CardCode: 1}); err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+	_, err := s.CreateCredential(bob, &infinias.Credential{Active: true, SiteCode: 10, CardCode: 1})
+	if !errors.Is(err, db.ErrCredentialExists) {
+		t.Fatalf("want ErrCredentialExists, have %v", err)
+	}
+
+	creds, err := s.ListCredentials(bob)
+	if err != nil || len(creds) != 0 {
+		t.Fatalf("unexpected credentials: %+v, %v", creds, err)
+	}
+}
+
+func TestServiceDeactivatePerson(t *testing.T) {
+	s, server := newService(t)
+	group := server.AddGroup(&api.Group{Name: "Staff"})
+	id := server.AddPerson(&api.Person{FirstName: "Alice", GroupsToAdd: []int{group}})
+	if _, err := s.CreateCredential(id, &infinias.Credential{Active: true, SiteCode: 10, CardCode: 1}); err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+
+	// credentials stay active if the groups can't be removed
+	server.Inject(&apitest.Fault{Method: http.MethodPut, Errors: api.Errors{{Msg: "unavailable"}}, Times: 1})
+	if _, err := s.DeactivatePerson(id, true); err == nil {
+		t.Fatal("want error")
+	}
+	creds, err := s.ListCredentials(id)
+	if err != nil || len(creds) != 1 || !creds[0].Active {
+		t.Fatalf("credential was deactivated: %+v, %v", creds, err)
+	}
+
+	d, err := s.DeactivatePerson(id, true)
+	if err != nil {
+		t.Fatalf("could not deactivate person: %v", err)
+	}
+	if len(d.CredentialsDeactivated) != 1 || len(d.GroupsRemoved) != 1 || d.GroupsRemoved[0] != group {
+		t.Fatalf("unexpected deactivation: %+v", d)
+	}
+	if p := server.Person(id); len(p.Groups) != 0 {
+		t.Fatalf("groups weren't removed: %+v", p.Groups)
+	}
+	if creds, err = s.ListCredentials(id); err != nil || creds[0].Active {
+		t.Fatalf("credential wasn't deactivated: %+v, %v", creds, err)
+	}
+}
+
+func TestServiceReconcile(t *testing.T) {
+	s, server := newService(t)
+	alice := server.AddPerson(&api.Person{FirstName: "Alice"})
+	bob := server.AddPerson(&api.Person{FirstName: "Bob"})
+	insertPerson(t, alice, "Alice", "")
+
+	report, err := s.Reconcile()
+	if err != nil {
+		t.Fatalf("could not reconcile: %v", err)
+	}
+	if report.APIPeople != 2 || report.DBPeople != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	found := false
+	for _, d := range report.Discrepancies {
+		if d.Type == infinias.DiscrepancyMissingInDB && d.PersonID == bob {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("missing discrepancy for %d: %+v", bob, report.Discrepancies)
+	}
+}
+
+func TestServiceMergePeople(t *testing.T) {
+	s, server := newService(t)
+	staff := server.AddGroup(&api.Group{Name: "Staff"})
+	admins := server.AddGroup(&api.Group{Name: "Admins"})
+	keep := server.AddPerson(&api.Person{FirstName: "Alice", GroupsToAdd: []int{staff}})
+	dup := server.AddPerson(&api.Person{FirstName: "Alice", GroupsToAdd: []int{staff, admins}})
+	insertPerson(t, keep, "Alice", "")
+	insertPerson(t, dup, "Alice", "")
+	credID, err := s.CreateCredential(dup, &infinias.Credential{Active: true, SiteCode: 10, CardCode: 1})
+	if err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+	if err = conn.UpdatePicture(dup, []byte("picture")); err != nil {
+		t.Fatalf("could not update picture: %v", err)
+	}
+
+	m, err := s.MergePeople(keep, dup)
+	if err != nil {
+		t.Fatalf("could not merge people: %v", err)
+	}
+	if len(m.CredentialsMoved) != 1 || m.CredentialsMoved[0] != credID || len(m.GroupsAdded) != 1 || m.GroupsAdded[0] != admins || !m.PictureMoved {
+		t.Fatalf("unexpected merge: %+v", m)
+	}
+
+	if owner, _, err := conn.CredentialOwner(10, 1); err != nil || owner != keep {
+		t.Fatalf("credential wasn't moved: %d, %v", owner, err)
+	}
+	if buf, err := conn.ReadPicture(keep); err != nil || string(buf) != "picture" {
+		t.Fatalf("picture wasn't moved: %q, %v", buf, err)
+	}
+	if p := server.Person(keep); len(p.Groups) != 2 {
+		t.Fatalf("groups weren't added: %+v", p.Groups)
+	}
+	if server.Person(dup) != nil {
+		t.Fatal("duplicate wasn't deleted")
+	}
+}
+
+func TestServiceMergePeopleRequiresCredentialsWrite(t *testing.T) {
+	s, server := newService(t)
+	s.APIKeys = []*infinias.APIKey{
+		{Name: "people", Key: "people-secret", Scopes: []string{infinias.ScopePeopleWrite}},
+		{Name: "credentials", Key: "credentials-secret", Scopes: []string{infinias.ScopePeopleWrite, infinias.ScopeCredentialsWrite}},
+	}
+	keep := server.AddPerson(&api.Person{FirstName: "Alice"})
+	dup := server.AddPerson(&api.Person{FirstName: "Alice"})
+	insertPerson(t, keep, "Alice", "")
+	insertPerson(t, dup, "Alice", "")
+	if _, err := s.CreateCredential(dup, &infinias.Credential{Active: true, SiteCode: 10, CardCode: 1}); err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+
+	for _, test := range []struct {
+		key  string
+		want int
+	}{
+		{"people-secret", http.StatusForbidden},
+		{"credentials-secret", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/people/%d/merge/%d", keep, dup), nil)
+		r.Header.Set("Authorization", "Bearer "+test.key)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Fatalf("%s: want status %d, have %d: %s", test.key, test.want, w.Code, w.Body)
+		}
+	}
+}
+
+// failingPictures is a picture store whose writes fail
+type failingPictures struct {
+	*photo.FileStore
+}
+
+func (failingPictures) Write(id int, buf []byte) error {
+	return errors.New("write failed")
+}
+
+func TestServiceMergePeoplePictureFailure(t *testing.T) {
+	s, server := newService(t)
+	store, err := photo.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("could not create picture store: %v", err)
+	}
+	keep := server.AddPerson(&api.Person{FirstName: "Alice"})
+	dup := server.AddPerson(&api.Person{FirstName: "Alice"})
+	insertPerson(t, keep, "Alice", "")
+	insertPerson(t, dup, "Alice", "")
+	credID, err := s.CreateCredential(dup, &infinias.Credential{Active: true, SiteCode: 10, CardCode: 1})
+	if err != nil {
+		t.Fatalf("could not create credential: %v", err)
+	}
+	if err = store.Write(dup, []byte("picture")); err != nil {
+		t.Fatalf("could not write picture: %v", err)
+	}
+	s.Pictures = failingPictures{store}
+
+	m, err := s.MergePeople(keep, dup)
+	if err == nil {
+		t.Fatal("want error, have nil")
+	}
+	if m == nil || len(m.CredentialsMoved) != 1 || m.CredentialsMoved[0] != credID || m.PictureMoved {
+		t.Fatalf("want partial merge with credential %d moved, have %+v", credID, m)
+	}
+	if server.Person(dup) == nil {
+		t.Fatal("duplicate was deleted with its picture")
+	}
+	if buf, err := store.Read(dup); err != nil || string(buf) != "picture" {
+		t.Fatalf("duplicate picture wasn't kept: %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,76 @@
+-- Minimal EAC schema: only the tables and columns used by the db package
+create schema EAC
+GO
+
+create table EAC.Person (
+	Id int not null primary key,
+	FirstName nvarchar(100) null,
+	LastName nvarchar(100) null,
+	EmployeeId nvarchar(100) null,
+	Department nvarchar(100) null
+)
+
+create table EAC.PersonImage (
+	PersonId int not null primary key,
+	Image varbinary(max) null
+)
+
+create table EAC.Credential (
+	Id int identity(1, 1) not null primary key,
+	PersonId int null,
+	IsActive bit not null,
+	ActivationDateUTC datetime2 null,
+	ExpirationDateUTC datetime2 null
+)
+
+create table EAC.WiegandCredential (
+	Id int identity(1, 1) not null primary key,
+	CredentialId int not null,
+	SiteCode int not null,
+	CardCode int not null,
+	CustomerZoneId int not null,
+	IsStringCredential bit not null
+)
+
+create table EAC.[Group] (
+	Id int not null primary key,
+	Name nvarchar(100) not null
+)
+
+create table EAC.PersonGroup (
+	PersonId int not null,
+	GroupId int not null,
+	primary key (PersonId, GroupId)
+)
+
+create table EAC.Door (
+	Id int not null primary key,
+	Name nvarchar(100) not null
+)
+
+create table EAC.Schedule (
+	Id int not null primary key,
+	Name nvarchar(100) not null
+)
+
+create table EAC.AccessRule (
+	Id int identity(1, 1) not null primary key,
+	GroupId int not null,
+	DoorId int not null,
+	ScheduleId int null
+)
+
+create table EAC.EventType (
+	Id int not null primary key,
+	Name nvarchar(100) not null
+)
+
+create table EAC.Event (
+	Id bigint identity(1, 1) not null primary key,
+	EventTypeId int not null,
+	PersonId int null,
+	DoorId int null,
+	EventDateUTC datetime2 not null,
+	Description nvarchar(max) null
+)
+GO
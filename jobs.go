@@ -0,0 +1,300 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	DefaultJobWorkers   = 2
+	DefaultJobRetention = 24 * time.Hour
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// JobProgress is how far along a running job is. Total is 0 if unknown
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// Job is a long running operation
+type Job struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Owner is the name of the principal that started the job. Only it and admins can read the job
+	Owner    string          `json:"owner,omitempty"`
+	Status   string          `json:"status"`
+	Progress JobProgress     `json:"progress"`
+	Created  time.Time       `json:"created"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// JobFunc is the work done by a job. It can report progress with ReportProgress
+type JobFunc func(ctx context.Context) (interface{}, error)
+
+type jobProgressKey struct{}
+
+// ReportProgress updates the progress of the job running with ctx. It does nothing outside a job
+func ReportProgress(ctx context.Context, done, total int) {
+	if fn, ok := ctx.Value(jobProgressKey{}).(func(int, int)); ok {
+		fn(done, total)
+	}
+}
+
+// JobManager runs jobs in the background with a limited number of workers.
+// If Dir is set, jobs are persisted there as JSON files and reloaded by NewJobManager
+type JobManager struct {
+	Dir       string
+	Retention time.Duration
+	Log       Logger
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	sem  chan struct{}
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// NewJobManager returns a new JobManager running up to workers jobs at once, persisting to dir if it's not empty.
+// Jobs that were queued or running when the process stopped are marked failed
+func NewJobManager(dir string, workers int, log Logger) (*JobManager, error) {
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+	if log == nil {
+		log = NopLogger
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	m := &JobManager{
+		Dir:       dir,
+		Retention: DefaultJobRetention,
+		Log:       log,
+		jobs:      make(map[string]*Job),
+		sem:       make(chan struct{}, workers),
+		ctx:       ctx,
+		stop:      stop,
+	}
+
+	if dir == "" {
+		return m, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create job directory: %w", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list jobs: %w", err)
+	}
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read job: %w", err)
+		}
+		j := new(Job)
+		if err = json.Unmarshal(buf, j); err != nil {
+			log.Warn("skipping unreadable job", "path", path, "error", err)
+			continue
+		}
+		if j.Status == JobQueued || j.Status == JobRunning {
+			now := time.Now()
+			j.Status = JobFailed
+			j.Error = "interrupted by restart"
+			j.Finished = &now
+			m.save(j)
+		}
+		m.jobs[j.ID] = j
+	}
+
+	return m, nil
+}
+
+// save persists j. The caller must hold m.mu or own j exclusively
+func (m *JobManager) save(j *Job) {
+	if m.Dir == "" {
+		return
+	}
+	buf, err := json.Marshal(j)
+	if err != nil {
+		m.Log.Error("could not encode job", "job", j.ID, "error", err)
+		return
+	}
+	path := filepath.Join(m.Dir, j.ID+".json")
+	if err = os.WriteFile(path+".tmp", buf, 0600); err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		m.Log.Error("could not save job", "job", j.ID, "error", err)
+	}
+}
+
+// Submit queues fn to run in the background for owner and returns its job
+func (m *JobManager) Submit(owner, typ string, fn JobFunc) *Job {
+	return m.Resubmit(newID(), owner, typ, fn)
+}
+
+// Resubmit queues fn to run in the background as the job with id, replacing it if it exists, and returns the job.
+// It's used to resume work interrupted by a restart under the job id it was first given
+func (m *JobManager) Resubmit(id, owner, typ string, fn JobFunc) *Job {
+	j := &Job{ID: id, Type: typ, Owner: owner, Status: JobQueued, Created: time.Now().UTC()}
+
+	m.mu.Lock()
+	m.prune()
+	if old, ok := m.jobs[id]; ok {
+		j.Created = old.Created
+	}
+	m.jobs[j.ID] = j
+	m.save(j)
+	snapshot := *j
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.run(j, fn)
+
+	return &snapshot
+}
+
+func (m *JobManager) run(j *Job, fn JobFunc) {
+	defer m.wg.Done()
+
+	select {
+	case m.sem <- struct{}{}:
+		defer func() { <-m.sem }()
+	case <-m.ctx.Done():
+		m.finish(j, nil, m.ctx.Err())
+		return
+	}
+
+	m.mu.Lock()
+	started := time.Now().UTC()
+	j.Status = JobRunning
+	j.Started = &started
+	m.save(j)
+	m.mu.Unlock()
+
+	ctx := context.WithValue(m.ctx, jobProgressKey{}, func(done, total int) {
+		m.mu.Lock()
+		j.Progress = JobProgress{Done: done, Total: total}
+		m.mu.Unlock()
+	})
+
+	res, err := fn(ctx)
+	m.finish(j, res, err)
+}
+
+func (m *JobManager) finish(j *Job, res interface{}, err error) {
+	var buf []byte
+	if err == nil && res != nil {
+		var encErr error
+		if buf, encErr = json.Marshal(res); encErr != nil {
+			err = fmt.Errorf("could not encode result: %w", encErr)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	finished := time.Now().UTC()
+	j.Finished = &finished
+	if err != nil {
+		j.Status = JobFailed
+		j.Error = err.Error()
+		m.Log.Error("job failed", "job", j.ID, "type", j.Type, "error", err)
+	} else {
+		j.Status = JobSucceeded
+		j.Result = buf
+	}
+	m.save(j)
+}
+
+// prune removes finished jobs older than Retention. The caller must hold m.mu
+func (m *JobManager) prune() {
+	cutoff := time.Now().Add(-m.Retention)
+	for id, j := range m.jobs {
+		if j.Finished == nil || j.Finished.After(cutoff) {
+			continue
+		}
+		delete(m.jobs, id)
+		if m.Dir != "" {
+			if err := os.Remove(filepath.Join(m.Dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+				m.Log.Warn("could not remove job", "job", id, "error", err)
+			}
+		}
+	}
+}
+
+// Get returns a copy of the job with the given id
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	snapshot := *j
+	return &snapshot, nil
+}
+
+// Stop cancels running jobs and waits for them to return
+func (m *JobManager) Stop() {
+	m.stop()
+	m.wg.Wait()
+}
+
+// submitJob runs fn as a job and responds with 202 Accepted and the job's status URL
+func (s *Service) submitJob(r *http.Request, typ string, fn JobFunc) (interface{}, error) {
+	if s.Jobs == nil {
+		return nil, &HTTPError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("job manager not configured")}
+	}
+	j := s.Jobs.Submit(principalName(r.Context()), typ, fn)
+	return newAccepted(r, "/jobs/"+j.ID, j), nil
+}
+
+// principalName returns the name of ctx's principal, or an empty string if authentication is disabled
+func principalName(ctx context.Context) string {
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// ReadJobHandler returns a job's status, progress, and result. Jobs started by other principals are only visible
+// to admins
+func (s *Service) ReadJobHandler(r *http.Request) (interface{}, error) {
+	id := strings.TrimSpace(mux.Vars(r)["id"])
+	if s.Jobs == nil {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: ErrJobNotFound}
+	}
+	j, err := s.Jobs.Get(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: err}
+	}
+	// other principals' jobs aren't found, so their ids can't be probed
+	if p := PrincipalFromContext(r.Context()); p != nil && !p.HasScope(ScopeAdmin) && (j.Owner == "" || j.Owner != p.Name) {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: ErrJobNotFound}
+	}
+	return j, nil
+}
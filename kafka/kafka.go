@@ -0,0 +1,541 @@
+// Package kafka is a minimal Kafka producer implementing the parts of the protocol needed to publish events
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout  = 10 * time.Second
+	DefaultClientID = "go-infinias-api"
+
+	apiKeyProduce  = 0
+	apiKeyMetadata = 3
+
+	// maxResponseSize guards against reading garbage as a length
+	maxResponseSize = 64 << 20
+)
+
+var (
+	// ErrProtocol is returned for responses that can't be parsed
+	ErrProtocol = errors.New("kafka: protocol error")
+	// ErrNoBrokers is returned if none of the bootstrap brokers could be reached
+	ErrNoBrokers = errors.New("kafka: no brokers available")
+)
+
+// Error is an error code returned by a broker
+type Error int16
+
+func (e Error) Error() string {
+	return "kafka: error code " + strconv.Itoa(int(e))
+}
+
+// retriable returns true if the error may succeed after refreshing metadata, e.g. the partition's leader changed
+func (e Error) retriable() bool {
+	switch e {
+	// LEADER_NOT_AVAILABLE, NOT_LEADER_OR_FOLLOWER, REQUEST_TIMED_OUT, NETWORK_EXCEPTION
+	case 5, 6, 7, 13:
+		return true
+	}
+	return false
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Header is a record header
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Producer produces records to a Kafka cluster. Records with the same key are sent to the same partition,
+// chosen like the Java client's default partitioner. It's safe for concurrent use
+type Producer struct {
+	// Brokers are the host:port addresses used to discover the cluster
+	Brokers  []string
+	ClientID string
+	// TLS, if set, connects with TLS
+	TLS *tls.Config
+	// Timeout limits dialing, each request, and the broker's wait for acknowledgement. Defaults to DefaultTimeout
+	Timeout time.Duration
+
+	mu          sync.Mutex
+	correlation int32
+	conns       map[int32]*conn
+	addrs       map[int32]string
+	// partitions holds the leader of each topic's partitions, indexed by partition
+	partitions map[string][]int32
+}
+
+// New returns a new Producer. Connections are made as they're needed
+func New(brokers []string) *Producer {
+	return &Producer{
+		Brokers:    brokers,
+		ClientID:   DefaultClientID,
+		Timeout:    DefaultTimeout,
+		conns:      make(map[int32]*conn),
+		addrs:      make(map[int32]string),
+		partitions: make(map[string][]int32),
+	}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (p *Producer) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return p.Timeout
+}
+
+func (p *Producer) dial(addr string) (*conn, error) {
+	d := &net.Dialer{Timeout: p.timeout()}
+	var nc net.Conn
+	var err error
+	if p.TLS != nil {
+		nc, err = tls.DialWithDialer(d, "tcp", addr, p.TLS)
+	} else {
+		nc, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to kafka: %w", err)
+	}
+	return &conn{Conn: nc, r: bufio.NewReader(nc)}, nil
+}
+
+// encoder writes Kafka protocol primitives
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+// varbytes writes b with a varint length, or -1 if b is nil
+func (e *encoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.Write(b)
+}
+
+// decoder reads Kafka protocol primitives. Reading past the end sets err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = ErrProtocol
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string, returning "" for null
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// arrayLen reads an array length. Null arrays have length 0
+func (d *decoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	// each element is at least a byte, so a larger length is garbage
+	if int(n) > len(d.buf) {
+		d.err = ErrProtocol
+		return 0
+	}
+	return int(n)
+}
+
+// roundTrip sends a request with body to c and returns the response body. p.mu must be held
+func (p *Producer) roundTrip(c *conn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	p.correlation++
+	id := p.correlation
+
+	var req encoder
+	req.int32(0)
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(id)
+	req.string(p.ClientID)
+	req.Write(body)
+	buf := req.Bytes()
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+
+	if err := c.SetDeadline(time.Now().Add(p.timeout())); err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(buf); err != nil {
+		return nil, fmt.Errorf("could not send request: %w", err)
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > maxResponseSize {
+		return nil, ErrProtocol
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, fmt.Errorf("could not read response: %w", err)
+	}
+	if int32(binary.BigEndian.Uint32(resp)) != id {
+		return nil, ErrProtocol
+	}
+	return resp[4:], nil
+}
+
+// refresh reads the partition leaders of topic from any reachable broker. p.mu must be held
+func (p *Producer) refresh(topic string) error {
+	var body encoder
+	body.int32(1)
+	body.string(topic)
+
+	addrs := append([]string(nil), p.Brokers...)
+	for _, addr := range p.addrs {
+		addrs = append(addrs, addr)
+	}
+
+	err := ErrNoBrokers
+	for _, addr := range addrs {
+		var c *conn
+		if c, err = p.dial(addr); err != nil {
+			continue
+		}
+		var resp []byte
+		resp, err = p.roundTrip(c, apiKeyMetadata, 1, body.Bytes())
+		c.Close()
+		if err != nil {
+			continue
+		}
+		return p.readMetadata(topic, resp)
+	}
+	return fmt.Errorf("could not read metadata: %w", err)
+}
+
+// readMetadata reads a version 1 metadata response. p.mu must be held
+func (p *Producer) readMetadata(topic string, resp []byte) error {
+	d := &decoder{buf: resp}
+	brokers := make(map[int32]string)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+
+	var leaders []int32
+	var topicErr Error
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		code := Error(d.int16())
+		name := d.string()
+		d.int8() // is internal
+		var partitions []int32
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			d.int16() // error code
+			partition := d.int32()
+			leader := d.int32()
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // replicas
+			}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // in sync replicas
+			}
+			if partition < 0 || int(partition) >= m {
+				d.err = ErrProtocol
+				break
+			}
+			if partitions == nil {
+				partitions = make([]int32, m)
+			}
+			partitions[partition] = leader
+		}
+		if name == topic {
+			leaders, topicErr = partitions, code
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("could not read metadata: %w", d.err)
+	}
+	if topicErr != 0 {
+		return fmt.Errorf("could not read metadata for topic %s: %w", topic, topicErr)
+	}
+	if len(leaders) == 0 {
+		return fmt.Errorf("could not read metadata for topic %s: no partitions", topic)
+	}
+
+	for id, addr := range brokers {
+		if old, ok := p.addrs[id]; ok && old != addr {
+			if c := p.conns[id]; c != nil {
+				c.Close()
+				delete(p.conns, id)
+			}
+		}
+		p.addrs[id] = addr
+	}
+	p.partitions[topic] = leaders
+	return nil
+}
+
+// murmur2 is the hash used by the Java client to partition keyed records
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	h := uint32(seed) ^ uint32(len(data))
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+		data = data[4:]
+	}
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// partition returns the partition for key out of n. Records without a key are spread by time
+func partition(key []byte, n int) int32 {
+	if key == nil {
+		return int32(time.Now().UnixNano() % int64(n))
+	}
+	return int32(murmur2(key)&0x7fffffff) % int32(n)
+}
+
+// recordBatch returns a version 2 record batch holding one record
+func recordBatch(key, value []byte, headers []Header, t time.Time) []byte {
+	var rec encoder
+	rec.int8(0)   // attributes
+	rec.varint(0) // timestamp delta
+	rec.varint(0) // offset delta
+	rec.varbytes(key)
+	rec.varbytes(value)
+	rec.varint(int64(len(headers)))
+	for _, h := range headers {
+		rec.varint(int64(len(h.Key)))
+		rec.WriteString(h.Key)
+		rec.varbytes(h.Value)
+	}
+
+	// the CRC covers everything after it
+	var crcd encoder
+	ts := t.UnixNano() / int64(time.Millisecond)
+	crcd.int16(0) // attributes
+	crcd.int32(0) // last offset delta
+	crcd.int64(ts)
+	crcd.int64(ts)
+	crcd.int64(-1) // producer id
+	crcd.int16(-1) // producer epoch
+	crcd.int32(-1) // base sequence
+	crcd.int32(1)
+	crcd.varint(int64(rec.Len()))
+	crcd.Write(rec.Bytes())
+
+	var batch encoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + crcd.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(crcd.Bytes(), castagnoli)))
+	batch.Write(crcd.Bytes())
+	return batch.Bytes()
+}
+
+// produce sends a record to partition of topic on its leader. p.mu must be held
+func (p *Producer) produce(topic string, partition int32, batch []byte) error {
+	leader := p.partitions[topic][partition]
+	c := p.conns[leader]
+	if c == nil {
+		addr, ok := p.addrs[leader]
+		if !ok {
+			return Error(5)
+		}
+		var err error
+		if c, err = p.dial(addr); err != nil {
+			return err
+		}
+		p.conns[leader] = c
+	}
+
+	var body encoder
+	body.int16(-1) // transactional id
+	body.int16(1)  // acks from the leader
+	body.int32(int32(p.timeout() / time.Millisecond))
+	body.int32(1)
+	body.string(topic)
+	body.int32(1)
+	body.int32(partition)
+	body.int32(int32(len(batch)))
+	body.Write(batch)
+
+	resp, err := p.roundTrip(c, apiKeyProduce, 3, body.Bytes())
+	if err != nil {
+		c.Close()
+		delete(p.conns, leader)
+		return err
+	}
+
+	d := &decoder{buf: resp}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string() // topic
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			d.int32() // partition
+			code := Error(d.int16())
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err == nil && code != 0 {
+				return code
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	return nil
+}
+
+// Produce sends a record to topic and waits for the partition's leader to acknowledge it. If the partition's leader
+// has moved, it's sent again to the new leader
+func (p *Producer) Produce(topic string, key, value []byte, headers ...Header) error {
+	batch := recordBatch(key, value, headers, time.Now())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, ok := p.partitions[topic]; !ok || attempt > 0 {
+			if err = p.refresh(topic); err != nil {
+				return err
+			}
+		}
+		leaders := p.partitions[topic]
+		if err = p.produce(topic, partition(key, len(leaders)), batch); err == nil {
+			return nil
+		}
+		var e Error
+		if errors.As(err, &e) && !e.retriable() {
+			break
+		}
+	}
+	return fmt.Errorf("could not produce record: %w", err)
+}
+
+// Close closes the producer's connections
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, c := range p.conns {
+		c.Close()
+		delete(p.conns, id)
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The golden requests, responses, and record batches below were encoded by github.com/segmentio/kafka-go's
+// protocol package, not this one
+
+const (
+	goldenMetadataRequest = "000000270003000100000001000f676f2d696e66696e6961732d617069000000010008696e66696e696173"
+	// brokers kafka-1:9092 and kafka-2:9093 (rack b), topic other, and topic infinias with partition 1 led by 1 and
+	// partition 0 led by 2
+	goldenMetadataResponse = "000000b000000007000000020000000100076b61666b612d3100002384ffff0000000200076b61666b612d32" +
+		"000023850001620000000100000002000000056f746865720000000001000000000000000000010000000100000001000000010000" +
+		"000100000008696e66696e696173000000000200000000000100000001000000020000000100000002000000020000000100000002" +
+		"000000000000000000020000000200000002000000010000000100000002"
+	// topic missing with UNKNOWN_TOPIC_OR_PARTITION
+	goldenMetadataUnknownTopic = "0000003300000008000000010000000100076b61666b612d3100002384ffff0000000100000001000300076d" +
+		"697373696e670000000000"
+
+	// key 42, value {"id":42}, and header type: person.updated at 1700000000000 ms
+	goldenBatch = "000000000000000000000057ffffffff02f57db8860000000000000000018bcfe568000000018bcfe56800ffffffffffffff" +
+		"ffffffffffffff000000014a000000043432127b226964223a34327d0208747970651c706572736f6e2e75706461746564"
+	goldenBatchNilKey = "000000000000000000000039ffffffff02d3f2ac750000000000000000018bcfe568000000018bcfe56800ffffffffff" +
+		"ffffffffffffffffff000000010e00000001027600"
+
+	// goldenBatch to partition 1 of infinias with acks 1 and a 10s timeout
+	goldenProduceRequest = "0000009e0000000300000002000f676f2d696e66696e6961732d617069ffff000100002710000000010008696e666" +
+		"96e696173000000010000000100000063" + goldenBatch
+	// partition 1 of infinias with base offset 42, and with NOT_LEADER_OR_FOLLOWER and MESSAGE_TOO_LARGE
+	goldenProduceResponse  = "0000003000000009000000010008696e66696e69617300000001000000010000000000000000002affffffffffffffff00000000"
+	goldenProduceNotLeader = "0000003000000009000000010008696e66696e69617300000001000000010006000000000000002affffffffffffffff00000000"
+	goldenProduceTooLarge  = "0000003000000009000000010008696e66696e6961730000000100000001000a000000000000002affffffffffffffff00000000"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("could not decode hex: %v", err)
+	}
+	return b
+}
+
+// These are the librdkafka values, which match the Java client
+func TestMurmur2(t *testing.T) {
+	tests := []struct {
+		key  string
+		want uint32
+	}{
+		{"kafka", 0xd067cf64},
+		{"giberish123456789", 0x8f552b0c},
+		{"1234", 0x9fc97b14},
+		{"234", 0xe7c009ca},
+		{"34", 0x873930da},
+		{"4", 0x5a4b5ca1},
+		{"PreAmbleWillBeRemoved,ThePrePartThatIs", 0x78424f1c},
+		{"reAmbleWillBeRemoved,ThePrePartThatIs", 0x4a62b377},
+		{"eAmbleWillBeRemoved,ThePrePartThatIs", 0xe0e4e09e},
+		{"AmbleWillBeRemoved,ThePrePartThatIs", 0x62b8b43f},
+		{"", 0x106e08d9},
+	}
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			if have := uint32(murmur2([]byte(test.key))); have != test.want {
+				t.Errorf("want %#x, have %#x", test.want, have)
+			}
+		})
+	}
+}
+
+// These are the kafka-python partitioner's values for 1000 partitions, which match the Java client
+func TestPartition(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int32
+	}{
+		{"", 681},
+		{"a", 524},
+		{"ab", 434},
+		{"abc", 107},
+		{"123456789", 566},
+	}
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			if have := partition([]byte(test.key), 1000); have != test.want {
+				t.Errorf("want %d, have %d", test.want, have)
+			}
+		})
+	}
+
+	for i := 0; i < 10; i++ {
+		if have := partition(nil, 3); have < 0 || have >= 3 {
+			t.Errorf("want partition in [0, 3), have %d", have)
+		}
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	ts := time.Unix(0, 1700000000000*int64(time.Millisecond))
+	tests := []struct {
+		name    string
+		key     []byte
+		value   []byte
+		headers []Header
+		want    string
+	}{
+		{"key and header", []byte("42"), []byte(`{"id":42}`), []Header{{Key: "type", Value: []byte("person.updated")}}, goldenBatch},
+		{"nil key", nil, []byte("v"), nil, goldenBatchNilKey},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if have := recordBatch(test.key, test.value, test.headers, ts); !bytes.Equal(have, unhex(t, test.want)) {
+				t.Errorf("want %s, have %x", test.want, have)
+			}
+		})
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	p := New(nil)
+	// responses are read after the size and correlation id
+	if err := p.readMetadata("infinias", unhex(t, goldenMetadataResponse)[8:]); err != nil {
+		t.Fatalf("could not read metadata: %v", err)
+	}
+	if want := []int32{2, 1}; !reflect.DeepEqual(p.partitions["infinias"], want) {
+		t.Errorf("want leaders %v, have %v", want, p.partitions["infinias"])
+	}
+	if want := map[int32]string{1: "kafka-1:9092", 2: "kafka-2:9093"}; !reflect.DeepEqual(p.addrs, want) {
+		t.Errorf("want brokers %v, have %v", want, p.addrs)
+	}
+
+	err := New(nil).readMetadata("missing", unhex(t, goldenMetadataUnknownTopic)[8:])
+	if !errors.Is(err, Error(3)) {
+		t.Errorf("want %v, have %v", Error(3), err)
+	}
+
+	if err = New(nil).readMetadata("infinias", unhex(t, goldenMetadataResponse)[8:40]); !errors.Is(err, ErrProtocol) {
+		t.Errorf("want %v for truncated response, have %v", ErrProtocol, err)
+	}
+}
+
+// exchange is a request a fakeBroker expects and its response
+type exchange struct {
+	request  string
+	response string
+}
+
+// fakeBroker accepts a connection on ln and answers each exchange in order, replacing the response's correlation
+// id with the request's. Unexpected requests are reported on t
+func fakeBroker(t *testing.T, ln net.Listener, exchanges ...exchange) {
+	for len(exchanges) > 0 {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		for len(exchanges) > 0 {
+			var size [4]byte
+			if _, err = io.ReadFull(c, size[:]); err != nil {
+				break
+			}
+			req := make([]byte, 4+binary.BigEndian.Uint32(size[:]))
+			copy(req, size[:])
+			if _, err = io.ReadFull(c, req[4:]); err != nil {
+				break
+			}
+
+			e := exchanges[0]
+			exchanges = exchanges[1:]
+			if want := unhex(t, e.request); !bytes.Equal(req, want) {
+				t.Errorf("want request %x, have %x", want, req)
+			}
+			resp := unhex(t, e.response)
+			copy(resp[4:8], req[8:12])
+			c.Write(resp)
+		}
+		c.Close()
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer ln.Close()
+	go fakeBroker(t, ln, exchange{goldenMetadataRequest, goldenMetadataResponse})
+
+	p := New([]string{ln.Addr().String()})
+	p.Timeout = 5 * time.Second
+	p.mu.Lock()
+	err = p.refresh("infinias")
+	p.mu.Unlock()
+	if err != nil {
+		t.Fatalf("could not refresh: %v", err)
+	}
+	if want := []int32{2, 1}; !reflect.DeepEqual(p.partitions["infinias"], want) {
+		t.Errorf("want leaders %v, have %v", want, p.partitions["infinias"])
+	}
+}
+
+func TestProduce(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     error
+	}{
+		{"ok", goldenProduceResponse, nil},
+		{"not leader", goldenProduceNotLeader, Error(6)},
+		{"too large", goldenProduceTooLarge, Error(10)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("could not listen: %v", err)
+			}
+			defer ln.Close()
+			go fakeBroker(t, ln, exchange{goldenProduceRequest, test.response})
+
+			p := New(nil)
+			p.addrs[1] = ln.Addr().String()
+			p.partitions["infinias"] = []int32{2, 1}
+			// the golden request's correlation id is 2
+			p.correlation = 1
+
+			p.mu.Lock()
+			err = p.produce("infinias", 1, unhex(t, goldenBatch))
+			p.mu.Unlock()
+			p.Close()
+			if !errors.Is(err, test.want) {
+				t.Errorf("want %v, have %v", test.want, err)
+			}
+		})
+	}
+
+	if !Error(6).retriable() || Error(10).retriable() {
+		t.Error("want NOT_LEADER_OR_FOLLOWER to be retriable and MESSAGE_TOO_LARGE not to be")
+	}
+}
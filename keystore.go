@@ -0,0 +1,456 @@
+package infinias
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultKeyRotationGrace is how long a rotated key keeps working if no grace period is given
+const DefaultKeyRotationGrace = 24 * time.Hour
+
+var (
+	ErrKeyExists   = errors.New("api key exists")
+	ErrKeyNotFound = errors.New("api key not found")
+	ErrInvalidKey  = errors.New("invalid api key")
+)
+
+// KeyStore holds API keys added at runtime, persisted to a file. Only hashes of the keys are kept.
+// It can also revoke configured keys by name
+type KeyStore struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    []*APIKey
+	revoked map[string]time.Time
+}
+
+// keyStoreFile is the persisted form of a KeyStore
+type keyStoreFile struct {
+	Keys []*storedKey `json:"keys"`
+	// Revoked maps the names of configured keys to when they stop working
+	Revoked map[string]time.Time `json:"revoked"`
+}
+
+type storedKey struct {
+	Name    string     `json:"name"`
+	ID      string     `json:"id"`
+	Hash    string     `json:"hash"`
+	Scopes  []string   `json:"scopes"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// NewKeyStore returns a KeyStore persisted to path, loading any existing keys
+func NewKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{path: path, revoked: make(map[string]time.Time)}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read key store: %w", err)
+	}
+
+	f := new(keyStoreFile)
+	if err = json.Unmarshal(buf, f); err != nil {
+		return nil, fmt.Errorf("could not decode key store: %w", err)
+	}
+	for _, k := range f.Keys {
+		if err = ValidateAPIKeyHash(k.Hash); err != nil {
+			return nil, fmt.Errorf("could not load key %s: %w", k.Name, err)
+		}
+		s.keys = append(s.keys, &APIKey{Name: k.Name, ID: k.ID, Hash: k.Hash, Scopes: k.Scopes, Created: k.Created, Expires: k.Expires})
+	}
+	for name, t := range f.Revoked {
+		s.revoked[name] = t
+	}
+
+	return s, nil
+}
+
+// save persists s. The caller must hold s.mu
+func (s *KeyStore) save() error {
+	f := &keyStoreFile{Keys: make([]*storedKey, len(s.keys)), Revoked: s.revoked}
+	for idx, k := range s.keys {
+		f.Keys[idx] = &storedKey{Name: k.Name, ID: k.ID, Hash: k.Hash, Scopes: k.Scopes, Created: k.Created, Expires: k.Expires}
+	}
+
+	buf, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode key store: %w", err)
+	}
+	if err = os.WriteFile(s.path+".tmp", buf, 0600); err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write key store: %w", err)
+	}
+	return nil
+}
+
+// prune removes expired keys and revocations. The caller must hold s.mu for writing
+func (s *KeyStore) prune(now time.Time) {
+	keys := s.keys[:0]
+	for _, k := range s.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	s.keys = keys
+}
+
+// Keys returns the unexpired keys in s
+func (s *KeyStore) Keys() []*APIKey {
+	if s == nil {
+		return nil
+	}
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		if !k.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// revokedAt returns when the configured key with name stops working
+func (s *KeyStore) revokedAt(name string) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.revoked[name]
+	return t, ok
+}
+
+// randomString returns n random bytes, base64url encoded
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// newKey returns a new random key and its id
+func newKey() (id, key string, err error) {
+	if id, err = randomString(9); err != nil {
+		return "", "", err
+	}
+	if key, err = randomString(32); err != nil {
+		return "", "", err
+	}
+	return id, key, nil
+}
+
+// add adds a new key, returning it as it's presented. The caller must hold s.mu for writing
+func (s *KeyStore) add(name string, scopes []string) (string, *APIKey, error) {
+	id, key, err := newKey()
+	if err != nil {
+		return "", nil, err
+	}
+	hash, err := HashAPIKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	k := &APIKey{Name: name, ID: id, Hash: hash, Scopes: scopes, Created: time.Now().UTC()}
+	s.keys = append(s.keys, k)
+	// the new key is known to match, so it doesn't need to be hashed again on first use
+	presented := id + "." + key
+	k.remember(presented)
+	return presented, k, nil
+}
+
+// Add generates a new key with name and scopes, returning the key. configured are the names of keys in the configuration
+func (s *KeyStore) Add(name string, scopes []string, configured []string) (string, *APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+
+	for _, n := range configured {
+		if _, ok := s.revoked[n]; n == name && !ok {
+			return "", nil, fmt.Errorf("%w: %s", ErrKeyExists, name)
+		}
+	}
+	for _, k := range s.keys {
+		if k.Name == name && k.Expires == nil {
+			return "", nil, fmt.Errorf("%w: %s", ErrKeyExists, name)
+		}
+	}
+
+	key, k, err := s.add(name, scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, k, s.save()
+}
+
+// revoke makes all keys with name stop working at t. The caller must hold s.mu for writing
+func (s *KeyStore) revoke(name string, t time.Time, configured bool) bool {
+	found := false
+	for _, k := range s.keys {
+		if k.Name == name && (k.Expires == nil || k.Expires.After(t)) {
+			expires := t
+			k.mu.Lock()
+			k.Expires = &expires
+			k.mu.Unlock()
+			found = true
+		}
+	}
+	if configured {
+		if prev, ok := s.revoked[name]; !ok || prev.After(t) {
+			s.revoked[name] = t
+		}
+		found = true
+	}
+	return found
+}
+
+// Revoke makes all keys with name stop working after grace. configured is true if name is a key in the configuration
+func (s *KeyStore) Revoke(name string, grace time.Duration, configured bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.revoke(name, time.Now().Add(grace).UTC(), configured) {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	s.prune(time.Now())
+	return s.save()
+}
+
+// Rotate generates a new key for name and makes the existing keys with name stop working after grace.
+// If scopes is empty, the scopes of the existing key are kept. configured are the configured keys with name
+func (s *KeyStore) Rotate(name string, scopes []string, grace time.Duration, configured []*APIKey) (string, *APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+
+	if len(scopes) == 0 {
+		for _, k := range configured {
+			scopes = k.Scopes
+		}
+		for _, k := range s.keys {
+			if k.Name == name {
+				scopes = k.Scopes
+			}
+		}
+	}
+
+	if !s.revoke(name, time.Now().Add(grace).UTC(), len(configured) > 0) {
+		return "", nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+
+	key, k, err := s.add(name, scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, k, s.save()
+}
+
+// KeyInfo describes an API key without its secret
+type KeyInfo struct {
+	Name string `json:"name"`
+	// ID prefixes hashed keys
+	ID     string   `json:"id,omitempty"`
+	Scopes []string `json:"scopes"`
+	// Configured is true if the key is in the configuration file rather than added at runtime
+	Configured bool       `json:"configured"`
+	Created    *time.Time `json:"created,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+}
+
+// NewKey is a newly generated API key. Key is only ever returned once
+type NewKey struct {
+	*KeyInfo
+	Key string `json:"key"`
+}
+
+func newKeyInfo(k *APIKey, configured bool) *KeyInfo {
+	info := &KeyInfo{Name: k.Name, ID: k.ID, Scopes: k.Scopes, Configured: configured}
+	if !k.Created.IsZero() {
+		created := k.Created
+		info.Created = &created
+	}
+	k.mu.Lock()
+	info.Expires = k.Expires
+	k.mu.Unlock()
+	return info
+}
+
+// configuredKeys returns the keys in s.APIKeys with name, or all of them if name is empty
+func (s *Service) configuredKeys(name string) []*APIKey {
+	var keys []*APIKey
+	for _, k := range s.APIKeys {
+		if name == "" || k.Name == name {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// activeAPIKeys returns all keys that currently work
+func (s *Service) activeAPIKeys() []*APIKey {
+	if s.Keys == nil {
+		return s.APIKeys
+	}
+	now := time.Now()
+	keys := make([]*APIKey, 0, len(s.APIKeys))
+	for _, k := range s.APIKeys {
+		if t, ok := s.Keys.revokedAt(k.Name); ok && !now.Before(t) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return append(keys, s.Keys.Keys()...)
+}
+
+// keyStore returns s.Keys or an *HTTPError if it isn't configured
+func (s *Service) keyStore() (*KeyStore, error) {
+	if s.Keys == nil {
+		return nil, &HTTPError{StatusCode: http.StatusServiceUnavailable, Err: errors.New("key store not configured")}
+	}
+	return s.Keys, nil
+}
+
+// readGrace reads the grace query parameter, returning def if it's not set
+func readGrace(r *http.Request, def time.Duration) (time.Duration, error) {
+	str := r.URL.Query().Get("grace")
+	if str == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		return 0, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read grace: invalid duration %q", str)}
+	}
+	return d, nil
+}
+
+type keyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (s *Service) ListKeysHandler(r *http.Request) (interface{}, error) {
+	keys := make([]*KeyInfo, 0)
+	now := time.Now()
+	for _, k := range s.APIKeys {
+		info := newKeyInfo(k, true)
+		if t, ok := s.Keys.revokedAt(k.Name); ok {
+			if !now.Before(t) {
+				continue
+			}
+			info.Expires = &t
+		}
+		keys = append(keys, info)
+	}
+	for _, k := range s.Keys.Keys() {
+		keys = append(keys, newKeyInfo(k, false))
+	}
+	return keys, nil
+}
+
+func (s *Service) CreateKeyHandler(r *http.Request) (interface{}, error) {
+	store, err := s.keyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(keyRequest)
+	if err = readJSON(r, req); err != nil {
+		return nil, err
+	}
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" || len(req.Scopes) == 0 {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("%w: name and scopes are required", ErrInvalidKey)}
+	}
+
+	var configured []string
+	for _, k := range s.APIKeys {
+		configured = append(configured, k.Name)
+	}
+
+	key, k, err := store.Add(req.Name, req.Scopes, configured)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrKeyExists) {
+			code = http.StatusConflict
+		}
+		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not create key: %w", err)}
+	}
+
+	s.audit(r, EventKeyCreated, 0, nil, newKeyInfo(k, false))
+
+	return newCreated(r, "/keys/"+k.Name, &NewKey{KeyInfo: newKeyInfo(k, false), Key: key}), nil
+}
+
+func (s *Service) RotateKeyHandler(r *http.Request) (interface{}, error) {
+	store, err := s.keyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	name := mux.Vars(r)["name"]
+	grace, err := readGrace(r, DefaultKeyRotationGrace)
+	if err != nil {
+		return nil, err
+	}
+
+	req := new(keyRequest)
+	if r.ContentLength != 0 {
+		if err = readJSON(r, req); err != nil {
+			return nil, err
+		}
+	}
+
+	key, k, err := store.Rotate(name, req.Scopes, grace, s.configuredKeys(name))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrKeyNotFound) {
+			code = http.StatusNotFound
+		}
+		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not rotate key: %w", err)}
+	}
+
+	s.audit(r, EventKeyRotated, 0, nil, newKeyInfo(k, false))
+
+	return &NewKey{KeyInfo: newKeyInfo(k, false), Key: key}, nil
+}
+
+func (s *Service) RevokeKeyHandler(r *http.Request) error {
+	store, err := s.keyStore()
+	if err != nil {
+		return err
+	}
+
+	name := mux.Vars(r)["name"]
+	grace, err := readGrace(r, 0)
+	if err != nil {
+		return err
+	}
+
+	if err = store.Revoke(name, grace, len(s.configuredKeys(name)) > 0); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrKeyNotFound) {
+			code = http.StatusNotFound
+		}
+		return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not revoke key: %w", err)}
+	}
+
+	s.audit(r, EventKeyRevoked, 0, nil, map[string]interface{}{"name": name, "grace": grace.String()})
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package infinias
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultListCacheRefresh  = time.Minute
+	DefaultListCacheDebounce = time.Second
+)
+
+// ListCache holds the latest lists of people, with their credentials, and groups, so interactive reads don't wait on
+// Infinias. It's refreshed by WatchListCache on an interval and shortly after mutations made through the Service,
+// so reads may briefly miss a change. A nil *ListCache is valid and empty
+type ListCache struct {
+	mu      sync.RWMutex
+	people  []*Person
+	groups  []*Group
+	updated time.Time
+
+	// shared, if set, holds the lists instead of people and groups
+	shared SharedCache
+	prefix string
+	ttl    time.Duration
+
+	stale chan struct{}
+}
+
+// NewListCache returns a new, empty ListCache
+func NewListCache() *ListCache {
+	return &ListCache{stale: make(chan struct{}, 1)}
+}
+
+// NewSharedListCache returns a new ListCache that keeps its lists under prefix in cache for ttl, so every instance
+// sees the same lists and drops them as soon as any instance makes a change. Errors reading from cache are treated
+// as a miss, and errors writing to it are ignored
+func NewSharedListCache(cache SharedCache, prefix string, ttl time.Duration) *ListCache {
+	return &ListCache{shared: cache, prefix: prefix + "lists:", ttl: ttl, stale: make(chan struct{}, 1)}
+}
+
+// load decodes the shared list named key into v
+func (c *ListCache) load(key string, v interface{}) bool {
+	buf, err := c.shared.Get(c.prefix + key)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(buf, v) == nil
+}
+
+// store encodes v into the shared list named key
+func (c *ListCache) store(key string, v interface{}) {
+	if buf, err := json.Marshal(v); err == nil {
+		c.shared.Set(c.prefix+key, buf, c.ttl)
+	}
+}
+
+// People returns copies of the cached people, or false if people haven't been cached yet
+func (c *ListCache) People() ([]*Person, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.shared != nil {
+		var people []*Person
+		return people, c.load("people", &people) && people != nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.people == nil {
+		return nil, false
+	}
+	people := make([]*Person, len(c.people))
+	for idx, p := range c.people {
+		p2 := *p
+		people[idx] = &p2
+	}
+	return people, true
+}
+
+// Groups returns copies of the cached groups, or false if groups haven't been cached yet
+func (c *ListCache) Groups() ([]*Group, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if c.shared != nil {
+		var groups []*Group
+		return groups, c.load("groups", &groups) && groups != nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.groups == nil {
+		return nil, false
+	}
+	groups := make([]*Group, len(c.groups))
+	for idx, g := range c.groups {
+		g2 := *g
+		groups[idx] = &g2
+	}
+	return groups, true
+}
+
+func (c *ListCache) setPeople(people []*Person) {
+	if c == nil {
+		return
+	}
+	if c.shared != nil {
+		c.store("people", people)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.updated = time.Now()
+		return
+	}
+	cached := make([]*Person, len(people))
+	for idx, p := range people {
+		p2 := *p
+		cached[idx] = &p2
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.people, c.updated = cached, time.Now()
+}
+
+func (c *ListCache) setGroups(groups []*Group) {
+	if c == nil {
+		return
+	}
+	if c.shared != nil {
+		c.store("groups", groups)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.updated = time.Now()
+		return
+	}
+	cached := make([]*Group, len(groups))
+	for idx, g := range groups {
+		g2 := *g
+		cached[idx] = &g2
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups, c.updated = cached, time.Now()
+}
+
+// Updated returns when the cache was last refreshed, or the zero time if it never has been
+func (c *ListCache) Updated() time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.updated
+}
+
+// Invalidate schedules a refresh by WatchListCache. Shared lists are dropped immediately
+func (c *ListCache) Invalidate() {
+	if c == nil {
+		return
+	}
+	if c.shared != nil {
+		c.shared.Delete(c.prefix+"people", c.prefix+"groups")
+	}
+	select {
+	case c.stale <- struct{}{}:
+	default:
+	}
+}
+
+// cachedPeople returns people from s.ListCache, listing them with ListPeople if they haven't been cached yet
+func (s *Service) cachedPeople() ([]*Person, error) {
+	if people, ok := s.ListCache.People(); ok {
+		return people, nil
+	}
+	return s.ListPeople()
+}
+
+// cachedGroups returns groups from s.ListCache, listing them with ListGroups if they haven't been cached yet
+func (s *Service) cachedGroups() ([]*Group, error) {
+	if groups, ok := s.ListCache.Groups(); ok {
+		return groups, nil
+	}
+	return s.ListGroups()
+}
+
+// RefreshListCache replaces s.ListCache with the people and groups currently in Infinias
+func (s *Service) RefreshListCache() error {
+	if _, err := s.ListPeople(); err != nil {
+		return err
+	}
+	_, err := s.ListGroups()
+	return err
+}
+
+// WatchListCache refreshes s.ListCache now, every interval, and debounce after it's invalidated, until stop is called.
+// Invalidations during debounce are coalesced into one refresh
+func (s *Service) WatchListCache(interval, debounce time.Duration) (stop func()) {
+	if s.ListCache == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultListCacheRefresh
+	}
+	if debounce <= 0 {
+		debounce = DefaultListCacheDebounce
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.RefreshListCache(); err != nil {
+				s.logger().Warn("could not refresh list cache", "error", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			case <-s.ListCache.stale:
+				select {
+				case <-done:
+					return
+				case <-time.After(debounce):
+				}
+				select {
+				case <-s.ListCache.stale:
+				default:
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
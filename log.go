@@ -0,0 +1,225 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel parses a level name. An empty string is LevelInfo
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %q", s)
+}
+
+// LevelVar is a Level that can be changed while Loggers using it are in use
+type LevelVar struct {
+	v int32
+}
+
+// NewLevelVar returns a LevelVar set to level
+func NewLevelVar(level Level) *LevelVar {
+	return &LevelVar{v: int32(level)}
+}
+
+func (l *LevelVar) Level() Level {
+	return Level(atomic.LoadInt32(&l.v))
+}
+
+func (l *LevelVar) Set(level Level) {
+	atomic.StoreInt32(&l.v, int32(level))
+}
+
+// Logger is a leveled, structured logger. kv is a list of alternating keys and values
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+	// With returns a Logger that adds kv to every line
+	With(kv ...interface{}) Logger
+}
+
+type textLogger struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level *LevelVar
+	kv    []interface{}
+	json  bool
+}
+
+// NewLogger returns a Logger that writes logfmt-style lines to w for messages at or above level
+func NewLogger(w io.Writer, level Level) Logger {
+	return NewLoggerVar(w, NewLevelVar(level))
+}
+
+// NewLoggerVar is like NewLogger, but the level can be changed later through level
+func NewLoggerVar(w io.Writer, level *LevelVar) Logger {
+	return &textLogger{mu: new(sync.Mutex), w: w, level: level}
+}
+
+// NewJSONLogger is like NewLoggerVar, but writes each line as a JSON object
+func NewJSONLogger(w io.Writer, level *LevelVar) Logger {
+	return &textLogger{mu: new(sync.Mutex), w: w, level: level, json: true}
+}
+
+func formatValue(v interface{}) string {
+	var s string
+	switch t := v.(type) {
+	case error:
+		s = t.Error()
+	case fmt.Stringer:
+		s = t.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\n") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// formatJSON returns v encoded as JSON. Errors and Stringers are encoded as strings
+func formatJSON(v interface{}) string {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		buf, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return string(buf)
+}
+
+func (l *textLogger) log(level Level, msg string, kv []interface{}) {
+	if level < l.level.Level() {
+		return
+	}
+
+	b := new(strings.Builder)
+	now := time.Now().Format(time.RFC3339)
+	if l.json {
+		fmt.Fprintf(b, `{"time":%s,"level":%s,"msg":%s`, formatJSON(now), formatJSON(level), formatJSON(msg))
+	} else {
+		fmt.Fprintf(b, "time=%s level=%s msg=%s", now, level, formatValue(msg))
+	}
+	all := append(append([]interface{}{}, l.kv...), kv...)
+	for i := 0; i < len(all); i += 2 {
+		key, value := fmt.Sprint(all[i]), interface{}(nil)
+		if i+1 == len(all) {
+			key, value = "!BADKEY", all[i]
+		} else {
+			value = all[i+1]
+		}
+		if l.json {
+			fmt.Fprintf(b, ",%s:%s", formatJSON(key), formatJSON(value))
+		} else {
+			fmt.Fprintf(b, " %s=%s", key, formatValue(value))
+		}
+	}
+	if l.json {
+		b.WriteByte('}')
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+func (l *textLogger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+func (l *textLogger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *textLogger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *textLogger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+func (l *textLogger) With(kv ...interface{}) Logger {
+	return &textLogger{mu: l.mu, w: l.w, level: l.level, kv: append(append([]interface{}{}, l.kv...), kv...), json: l.json}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+func (n nopLogger) With(...interface{}) Logger { return n }
+
+// NopLogger is a Logger that discards everything
+var NopLogger Logger = nopLogger{}
+
+const requestIDHeader = "X-Request-ID"
+
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestIDFromContext returns the request ID for the request context, or an empty string if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyRequestID).(string)
+	return id
+}
+
+// WithRequestID assigns each request an ID, reusing a well-formed X-Request-ID header from the client if present.
+// The ID is returned in the X-Request-ID response header
+func (s *Service) WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDRegexp.MatchString(id) {
+			id = newID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyRequestID, id)))
+	})
+}
+
+func (s *Service) logger() Logger {
+	if s.Log == nil {
+		return NopLogger
+	}
+	return s.Log
+}
+
+// requestLogger returns a Logger annotated with the request's ID, method, and URL
+func (s *Service) requestLogger(r *http.Request) Logger {
+	return s.logger().With("request_id", RequestIDFromContext(r.Context()), "method", r.Method, "url", r.URL.String())
+}
@@ -0,0 +1,153 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+var ErrMergeSamePerson = errors.New("can't merge a person into themselves")
+
+// Merge is the result of MergePeople
+type Merge struct {
+	PersonID         int   `json:"person_id"`
+	DuplicateID      int   `json:"duplicate_id"`
+	CredentialsMoved []int `json:"credentials_moved"`
+	GroupsAdded      []int `json:"groups_added"`
+	PictureMoved     bool  `json:"picture_moved"`
+}
+
+// MergePeople moves the credentials, picture, and group memberships of the person with dupID onto the person
+// with keepID, then deletes the duplicate. The added groups are removed again if the credentials can't be moved.
+// The duplicate's picture is only moved if the kept person doesn't have one. If the picture can't be moved or the
+// duplicate can't be deleted, the duplicate is kept and what was moved is returned with the error
+func (s *Service) MergePeople(keepID, dupID int) (*Merge, error) {
+	if keepID == 0 || dupID == 0 {
+		return nil, ErrInvalidID
+	}
+	if keepID == dupID {
+		return nil, ErrMergeSamePerson
+	}
+
+	var keep, dup *api.Person
+	if err := s.parallel(
+		func(s *Service) (err error) {
+			if keep, err = s.APIConn.ReadPerson(keepID); err != nil {
+				return fmt.Errorf("could not read person: %w", err)
+			}
+			return nil
+		},
+		func(s *Service) (err error) {
+			if dup, err = s.APIConn.ReadPerson(dupID); err != nil {
+				return fmt.Errorf("could not read duplicate: %w", err)
+			}
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	m := &Merge{PersonID: keepID, DuplicateID: dupID, GroupsAdded: make([]int, 0)}
+
+	inGroup := make(map[int]bool)
+	for _, g := range keep.Groups {
+		inGroup[g.ID] = true
+	}
+	for _, g := range dup.Groups {
+		if !inGroup[g.ID] {
+			m.GroupsAdded = append(m.GroupsAdded, g.ID)
+		}
+	}
+
+	if len(m.GroupsAdded) > 0 {
+		if err := s.APIConn.UpdatePerson(&api.Person{ID: keepID, GroupsToAdd: m.GroupsAdded}); err != nil {
+			return nil, fmt.Errorf("could not add groups: %w", err)
+		}
+	}
+
+	ids, err := s.DBConn.MoveCredentials(dupID, keepID)
+	if err != nil {
+		if len(m.GroupsAdded) > 0 {
+			if rmErr := s.APIConn.UpdatePerson(&api.Person{ID: keepID, GroupsToRemove: m.GroupsAdded}); rmErr != nil {
+				s.logger().Error("could not remove added groups", "id", keepID, "groups", m.GroupsAdded, "error", rmErr)
+			}
+		}
+		return nil, fmt.Errorf("could not move credentials: %w", err)
+	}
+	m.CredentialsMoved = ids
+	if m.CredentialsMoved == nil {
+		m.CredentialsMoved = make([]int, 0)
+	}
+
+	// the picture store may not be the database, so the picture is moved after the credentials are committed
+	if _, err = s.pictures().Read(keepID); errors.Is(err, photo.ErrNotFound) {
+		buf, err := s.pictures().Read(dupID)
+		if err != nil && !errors.Is(err, photo.ErrNotFound) {
+			return m, fmt.Errorf("could not read duplicate picture: %w", err)
+		}
+		if err == nil {
+			if err = s.pictures().Write(keepID, buf); err != nil {
+				return m, fmt.Errorf("could not move picture: %w", err)
+			}
+			s.Thumbnails.Invalidate(keepID)
+			m.PictureMoved = true
+		}
+	} else if err != nil {
+		return m, fmt.Errorf("could not read picture: %w", err)
+	}
+
+	if err = s.DeletePerson(dupID); err != nil {
+		return m, fmt.Errorf("could not delete duplicate: %w", err)
+	}
+
+	s.notify(EventPersonMerged, m)
+
+	return m, nil
+}
+
+func (s *Service) MergePeopleHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+	dupID, err := readIntVar(r, "dupid", "duplicate id")
+	if err != nil {
+		return nil, err
+	}
+
+	// the duplicate's credentials are moved, so merging is creating credentials on the kept person
+	creds, err := s.ListCredentials(dupID)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list duplicate credentials: %w", err)}
+	}
+	if err = s.requireCredentialsWrite(r, &Person{Credentials: creds}); err != nil {
+		return nil, err
+	}
+
+	before := s.auditPerson(r, id)
+
+	m, err := s.MergePeople(id, dupID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == ErrInvalidID || err == ErrMergeSamePerson {
+			code = http.StatusBadRequest
+		} else if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		h := &HTTPError{StatusCode: code, Err: fmt.Errorf("could not merge people: %w", err)}
+		if m != nil {
+			// the credentials and groups were moved
+			h.Detail = m
+			s.audit(r, EventPersonMerged, id, before, s.auditPerson(r, id))
+		}
+		return nil, h
+	}
+
+	s.audit(r, EventPersonMerged, id, before, s.auditPerson(r, id))
+
+	return m, nil
+}
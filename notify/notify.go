@@ -0,0 +1,124 @@
+// Package notify sends alert messages to chat and email
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const errorBodySize = 512
+
+// Message is an alert message
+type Message struct {
+	Title  string
+	Text   string
+	Fields map[string]string
+}
+
+// fieldNames returns m's field names in sorted order
+func (m *Message) fieldNames() []string {
+	names := make([]string, 0, len(m.Fields))
+	for name := range m.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns m as plain text
+func (m *Message) String() string {
+	var b strings.Builder
+	b.WriteString(m.Text)
+	for _, name := range m.fieldNames() {
+		fmt.Fprintf(&b, "\n%s: %s", name, m.Fields[name])
+	}
+	return b.String()
+}
+
+// Notifier sends messages
+type Notifier interface {
+	Notify(ctx context.Context, m *Message) error
+}
+
+func defaultClient() *http.Client {
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not encode message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	r, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not post message: %w", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		buf, _ := io.ReadAll(io.LimitReader(r.Body, errorBodySize))
+		return fmt.Errorf("could not post message: unexpected status: %s: %s", r.Status, strings.TrimSpace(string(buf)))
+	}
+
+	return nil
+}
+
+// Slack posts messages to a Slack incoming webhook
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewSlack returns a new Slack posting to the incoming webhook url
+func NewSlack(url string) *Slack {
+	return &Slack{URL: url, Client: defaultClient()}
+}
+
+func (s *Slack) Notify(ctx context.Context, m *Message) error {
+	return postJSON(ctx, s.Client, s.URL, map[string]string{"text": "*" + m.Title + "*\n" + m.String()})
+}
+
+// Teams posts messages to a Microsoft Teams incoming webhook
+type Teams struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewTeams returns a new Teams posting to the incoming webhook url
+func NewTeams(url string) *Teams {
+	return &Teams{URL: url, Client: defaultClient()}
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (t *Teams) Notify(ctx context.Context, m *Message) error {
+	facts := make([]*teamsFact, 0, len(m.Fields))
+	for _, name := range m.fieldNames() {
+		facts = append(facts, &teamsFact{Name: name, Value: m.Fields[name]})
+	}
+	return postJSON(ctx, t.Client, t.URL, map[string]interface{}{
+		"@type":    "MessageCard",
+		"@context": "http://schema.org/extensions",
+		"summary":  m.Title,
+		"title":    m.Title,
+		"text":     m.Text,
+		"sections": []interface{}{map[string]interface{}{"facts": facts}},
+	})
+}
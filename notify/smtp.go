@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP emails messages. The connection is upgraded with STARTTLS if the server supports it
+type SMTP struct {
+	// Addr is the server's host:port
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTP) Notify(ctx context.Context, m *Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("could not parse addr: %w", err)
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(m.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.String(), "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	// smtp.SendMail doesn't take a context, so run it in the background and stop waiting if ctx is done
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(s.Addr, auth, s.From, s.To, []byte(b.String()))
+	}()
+
+	select {
+	case err = <-errCh:
+		if err != nil {
+			return fmt.Errorf("could not send email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("could not send email: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,157 @@
+package infinias
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrOccupancyDisabled = errors.New("occupancy tracking isn't enabled")
+
+// OccupancyZone is an area whose occupancy is tracked from the doors people badge into and out of it through
+type OccupancyZone struct {
+	Name       string
+	EntryDoors []int
+	ExitDoors  []int
+}
+
+// ZoneOccupancy is the current state of an OccupancyZone
+type ZoneOccupancy struct {
+	Name string `json:"name"`
+	// Occupancy is the number of people that entered the zone and haven't exited it
+	Occupancy int `json:"occupancy"`
+	Entries   int `json:"entries"`
+	Exits     int `json:"exits"`
+}
+
+// OccupancyReport is the result of OccupancyHandler
+type OccupancyReport struct {
+	// Since is when counting started, either when the service started or when the counts were last reset
+	Since time.Time        `json:"since"`
+	Zones []*ZoneOccupancy `json:"zones"`
+}
+
+type zoneState struct {
+	zone    *OccupancyZone
+	people  map[int]time.Time
+	entries int
+	exits   int
+}
+
+// Occupancy counts the people in zones from access events. A person is in a zone from their last event at one of
+// its entry doors until their next event at one of its exit doors. Events without a person are ignored
+type Occupancy struct {
+	// EventTypes, if set, are the event type names, kinds, or ids that count as passing through a door,
+	// e.g. access_granted. Otherwise every event with a person counts
+	EventTypes []string
+
+	mu    sync.Mutex
+	zones []*zoneState
+	since time.Time
+}
+
+// NewOccupancy returns a new Occupancy for zones
+func NewOccupancy(zones []*OccupancyZone, eventTypes []string) *Occupancy {
+	o := &Occupancy{EventTypes: eventTypes, since: time.Now()}
+	for _, z := range zones {
+		o.zones = append(o.zones, &zoneState{zone: z, people: make(map[int]time.Time)})
+	}
+	return o
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Zones returns o's zones
+func (o *Occupancy) Zones() []*OccupancyZone {
+	zones := make([]*OccupancyZone, len(o.zones))
+	for idx, z := range o.zones {
+		zones[idx] = z.zone
+	}
+	return zones
+}
+
+// Record updates the zones with e
+func (o *Occupancy) Record(e *Event) {
+	if e.PersonID == 0 || e.DoorID == 0 || !eventFilter(o.EventTypes).matches(e) {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, z := range o.zones {
+		if containsInt(z.zone.EntryDoors, e.DoorID) {
+			z.entries++
+			z.people[e.PersonID] = e.Time
+		}
+		if containsInt(z.zone.ExitDoors, e.DoorID) {
+			z.exits++
+			delete(z.people, e.PersonID)
+		}
+	}
+}
+
+// Reset empties all zones and zeroes their counts
+func (o *Occupancy) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, z := range o.zones {
+		z.people = make(map[int]time.Time)
+		z.entries, z.exits = 0, 0
+	}
+	o.since = time.Now()
+}
+
+// Report returns the current state of each zone
+func (o *Occupancy) Report() *OccupancyReport {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := &OccupancyReport{Since: o.since, Zones: make([]*ZoneOccupancy, len(o.zones))}
+	for idx, z := range o.zones {
+		r.Zones[idx] = &ZoneOccupancy{Name: z.zone.Name, Occupancy: len(z.people), Entries: z.entries, Exits: z.exits}
+	}
+	return r
+}
+
+// Watch records events from stream until stop is called
+func (o *Occupancy) Watch(stream *EventStream) (stop func()) {
+	if o == nil || stream == nil {
+		return func() {}
+	}
+
+	ch, unsubscribe := stream.Subscribe()
+	go func() {
+		for e := range ch {
+			o.Record(e)
+		}
+	}()
+
+	return unsubscribe
+}
+
+// OccupancyHandler returns the current occupancy of each zone
+func (s *Service) OccupancyHandler(r *http.Request) (interface{}, error) {
+	if s.Occupancy == nil {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: ErrOccupancyDisabled}
+	}
+	return s.Occupancy.Report(), nil
+}
+
+// ResetOccupancyTask empties all occupancy zones, e.g. overnight, so people that left without badging out aren't
+// counted forever
+func (s *Service) ResetOccupancyTask(ctx context.Context) (interface{}, error) {
+	if s.Occupancy == nil {
+		return nil, ErrOccupancyDisabled
+	}
+	before := s.Occupancy.Report()
+	s.Occupancy.Reset()
+	return before, nil
+}
@@ -0,0 +1,401 @@
+package infinias
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultOIDCScopeClaim = "roles"
+	DefaultOIDCNameClaim  = "sub"
+	oidcKeyRefresh        = time.Hour
+	oidcMinKeyRefresh     = time.Minute
+	oidcLeeway            = time.Minute
+)
+
+var (
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+)
+
+// OIDC validates JWT bearer tokens issued by an OpenID Connect provider
+type OIDC struct {
+	Issuer         string
+	Audience       string
+	RequiredClaims map[string]string
+	ScopeClaim     string
+	NameClaim      string
+	Client         *http.Client
+
+	mu      sync.Mutex
+	jwksURI string
+	keys    map[string]crypto.PublicKey
+	// fetched is when keys were last fetched successfully, and attempted is when the last fetch started
+	fetched   time.Time
+	attempted time.Time
+	// refreshing is closed when the fetch in progress, if any, finishes with refreshErr
+	refreshing chan struct{}
+	refreshErr error
+}
+
+// NewOIDC returns a new OIDC for the given issuer and audience
+func NewOIDC(issuer, audience string) *OIDC {
+	return &OIDC{
+		Issuer:     strings.TrimSuffix(issuer, "/"),
+		Audience:   audience,
+		ScopeClaim: DefaultOIDCScopeClaim,
+		NameClaim:  DefaultOIDCNameClaim,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %s", ErrUnsupportedKeyType, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, k.Kty)
+}
+
+func (o *OIDC) getJSON(u string, v interface{}) error {
+	r, err := o.Client.Get(u)
+	if err != nil {
+		return fmt.Errorf("could not GET %s: %w", u, err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not GET %s: unexpected status: %s", u, r.Status)
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	return nil
+}
+
+// fetchKeys fetches the provider's signing keys from jwksURI, discovering it first if it's empty.
+// It returns the keys and jwksURI
+func (o *OIDC) fetchKeys(jwksURI string) (map[string]crypto.PublicKey, string, error) {
+	if jwksURI == "" {
+		discovery := new(struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		})
+		if err := o.getJSON(o.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+			return nil, "", fmt.Errorf("could not read discovery document: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, "", errors.New("discovery document missing jwks_uri")
+		}
+		jwksURI = discovery.JWKSURI
+	}
+
+	set := new(struct {
+		Keys []*jwk `json:"keys"`
+	})
+	if err := o.getJSON(jwksURI, set); err != nil {
+		return nil, jwksURI, fmt.Errorf("could not read signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, jwksURI, nil
+}
+
+// refresh starts fetching the provider's signing keys, unless a fetch is already in progress, and returns a channel
+// that's closed when it finishes. The keys are only replaced if the fetch succeeds. o.mu must be held
+func (o *OIDC) refresh() <-chan struct{} {
+	if o.refreshing != nil {
+		return o.refreshing
+	}
+
+	done := make(chan struct{})
+	o.refreshing = done
+	o.attempted = time.Now()
+	jwksURI := o.jwksURI
+
+	go func() {
+		keys, jwksURI, err := o.fetchKeys(jwksURI)
+
+		o.mu.Lock()
+		o.jwksURI = jwksURI
+		o.refreshErr = err
+		if err == nil {
+			o.keys = keys
+			o.fetched = time.Now()
+		}
+		o.refreshing = nil
+		o.mu.Unlock()
+
+		close(done)
+	}()
+
+	return done
+}
+
+func (o *OIDC) key(kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	key, ok := o.keys[kid]
+	if ok && time.Since(o.fetched) < oidcKeyRefresh {
+		o.mu.Unlock()
+		return key, nil
+	}
+
+	// stale keys and unknown key ids trigger a refresh, but not more often than oidcMinKeyRefresh,
+	// whether or not it succeeds
+	var done <-chan struct{}
+	if o.refreshing != nil || time.Since(o.attempted) >= oidcMinKeyRefresh {
+		done = o.refresh()
+	}
+	o.mu.Unlock()
+
+	// stale keys are used until the refresh succeeds
+	if ok {
+		return key, nil
+	}
+	if done == nil {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+	}
+
+	<-done
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	if o.refreshErr != nil {
+		return nil, o.refreshErr
+	}
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidToken, alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type mismatch", ErrInvalidToken)
+		}
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(k, hash, digest, sig, nil)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case "ES":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type mismatch", ErrInvalidToken)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("%w: invalid signature length", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidToken, alg)
+}
+
+// claimValues returns a claim as a list of strings. Space-separated strings (like scp) are split
+func claimValues(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		vals := make([]string, 0, len(v))
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				vals = append(vals, s)
+			}
+		}
+		return vals
+	}
+	return nil
+}
+
+func claimTime(claims map[string]interface{}, name string) (time.Time, bool) {
+	f, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify validates token and returns its claims
+func (o *OIDC) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	headerBuf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode header: %v", ErrInvalidToken, err)
+	}
+	header := new(struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	})
+	if err = json.Unmarshal(headerBuf, header); err != nil {
+		return nil, fmt.Errorf("%w: could not parse header: %v", ErrInvalidToken, err)
+	}
+	if len(header.Alg) != 5 {
+		return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidToken, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode signature: %v", ErrInvalidToken, err)
+	}
+
+	key, err := o.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	claimsBuf, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode claims: %v", ErrInvalidToken, err)
+	}
+	claims := make(map[string]interface{})
+	if err = json.Unmarshal(claimsBuf, &claims); err != nil {
+		return nil, fmt.Errorf("%w: could not parse claims: %v", ErrInvalidToken, err)
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != o.Issuer {
+		return nil, fmt.Errorf("%w: invalid issuer %q", ErrInvalidToken, iss)
+	}
+
+	if o.Audience != "" && !contains(claimValues(claims, "aud"), o.Audience) {
+		return nil, fmt.Errorf("%w: invalid audience", ErrInvalidToken)
+	}
+
+	now := time.Now()
+	exp, ok := claimTime(claims, "exp")
+	if !ok || now.After(exp.Add(oidcLeeway)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Add(oidcLeeway).Before(nbf) {
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	}
+
+	for name, val := range o.RequiredClaims {
+		if !contains(claimValues(claims, name), val) {
+			return nil, fmt.Errorf("%w: missing required claim %s", ErrInvalidToken, name)
+		}
+	}
+
+	return claims, nil
+}
+
+// Principal validates token and returns the Principal it represents.
+// Scopes are read from ScopeClaim, which may be a list or space-separated string
+func (o *OIDC) Principal(token string) (*Principal, error) {
+	claims, err := o.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := claims[o.NameClaim].(string)
+	return &Principal{Name: name, Scopes: claimValues(claims, o.ScopeClaim)}, nil
+}
+
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,306 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+const (
+	DefaultPendingWriteAttempts = 10
+	DefaultPendingWriteInterval = 30 * time.Second
+	// maxPendingWriteInterval caps the backoff between attempts
+	maxPendingWriteInterval = 30 * time.Minute
+)
+
+// JobTypePendingWrite is the type of the jobs retrying pending writes
+const JobTypePendingWrite = "pending_write"
+
+// PendingWrite is the picture and credentials of a created person that couldn't be written to the database.
+// They're retried in the background by the job with JobID
+type PendingWrite struct {
+	JobID string `json:"job_id"`
+	// Owner is the name of the principal that created the person, and owns the job
+	Owner       string        `json:"owner,omitempty"`
+	PersonID    int           `json:"person_id"`
+	Image       []byte        `json:"image,omitempty"`
+	Credentials []*Credential `json:"credentials,omitempty"`
+	Attempts    int           `json:"attempts"`
+	LastError   string        `json:"last_error,omitempty"`
+	Created     time.Time     `json:"created"`
+
+	// written is what's been written so far
+	written PendingWriteResult
+}
+
+// PendingWriteResult is the result of a pending write job
+type PendingWriteResult struct {
+	PersonID    int           `json:"person_id"`
+	Picture     bool          `json:"picture"`
+	Credentials []*Credential `json:"credentials,omitempty"`
+	Attempts    int           `json:"attempts"`
+}
+
+// PendingWriteStore holds writes queued for retry, persisted to a file so they survive a restart
+type PendingWriteStore struct {
+	// MaxAttempts is how many times a write is tried before its job fails. Defaults to DefaultPendingWriteAttempts
+	MaxAttempts int
+	// Interval is the wait after the first failed attempt, doubled after each one. Defaults to DefaultPendingWriteInterval
+	Interval time.Duration
+
+	path string
+
+	mu     sync.Mutex
+	writes map[string]*PendingWrite
+}
+
+// NewPendingWriteStore returns a PendingWriteStore persisted to path, loading any existing pending writes
+func NewPendingWriteStore(path string) (*PendingWriteStore, error) {
+	s := &PendingWriteStore{
+		MaxAttempts: DefaultPendingWriteAttempts,
+		Interval:    DefaultPendingWriteInterval,
+		path:        path,
+		writes:      make(map[string]*PendingWrite),
+	}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read pending write store: %w", err)
+	}
+
+	var writes []*PendingWrite
+	if err = json.Unmarshal(buf, &writes); err != nil {
+		return nil, fmt.Errorf("could not decode pending write store: %w", err)
+	}
+	for _, w := range writes {
+		s.writes[w.JobID] = w
+	}
+
+	return s, nil
+}
+
+// save persists s. The caller must hold s.mu
+func (s *PendingWriteStore) save() error {
+	buf, err := json.Marshal(s.list())
+	if err != nil {
+		return fmt.Errorf("could not encode pending write store: %w", err)
+	}
+	if err = os.WriteFile(s.path+".tmp", buf, 0600); err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write pending write store: %w", err)
+	}
+	return nil
+}
+
+// list returns copies of the writes in s, oldest first. The caller must hold s.mu
+func (s *PendingWriteStore) list() []*PendingWrite {
+	writes := make([]*PendingWrite, 0, len(s.writes))
+	for _, w := range s.writes {
+		w2 := *w
+		writes = append(writes, &w2)
+	}
+	sort.Slice(writes, func(i, j int) bool { return writes[i].Created.Before(writes[j].Created) })
+	return writes
+}
+
+// List returns copies of the pending writes, oldest first
+func (s *PendingWriteStore) List() []*PendingWrite {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+// put adds or replaces a copy of w
+func (s *PendingWriteStore) put(w *PendingWrite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w2 := *w
+	s.writes[w.JobID] = &w2
+	return s.save()
+}
+
+// remove removes the write retried by the job with id
+func (s *PendingWriteStore) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.writes[id]; !ok {
+		return nil
+	}
+	delete(s.writes, id)
+	return s.save()
+}
+
+// backoff returns the wait after attempts failed attempts
+func (s *PendingWriteStore) backoff(attempts int) time.Duration {
+	d := s.Interval
+	if d <= 0 {
+		d = DefaultPendingWriteInterval
+	}
+	for i := 1; i < attempts && d < maxPendingWriteInterval; i++ {
+		d *= 2
+	}
+	if d > maxPendingWriteInterval {
+		d = maxPendingWriteInterval
+	}
+	return d
+}
+
+func (s *PendingWriteStore) maxAttempts() int {
+	if s.MaxAttempts <= 0 {
+		return DefaultPendingWriteAttempts
+	}
+	return s.MaxAttempts
+}
+
+// queuesWrites returns true if failed writes are queued for retry by queueWrites
+func (s *Service) queuesWrites() bool {
+	return s.PendingWrites != nil && s.Jobs != nil
+}
+
+// retriable returns true if everything that failed in e can be queued for retry by queueWrites
+func (e *PartialError) retriable() bool {
+	if e.TerminationDate != nil {
+		return false
+	}
+	for _, c := range e.Credentials {
+		if errors.Is(c, db.ErrCredentialExists) {
+			return false
+		}
+	}
+	return true
+}
+
+// queueWrites queues the picture and credentials of p that failed to be written in partial for retry,
+// removing them from partial and setting its JobID. Credentials that already exist aren't retried.
+// It does nothing if s.PendingWrites or s.Jobs isn't set
+func (s *Service) queueWrites(p *Person, partial *PartialError) {
+	if !s.queuesWrites() {
+		return
+	}
+
+	w := &PendingWrite{Owner: principalName(s.context()), PersonID: partial.PersonID, Created: time.Now().UTC()}
+	if partial.Picture != nil {
+		w.Image = p.Image
+		w.LastError = partial.Picture.Error()
+	}
+
+	var failed []*CredentialError
+	for _, c := range partial.Credentials {
+		if errors.Is(c, db.ErrCredentialExists) {
+			failed = append(failed, c)
+			continue
+		}
+		for _, cred := range p.Credentials {
+			if cred.SiteCode == c.SiteCode && cred.CardCode == c.CardCode {
+				w.Credentials = append(w.Credentials, cred)
+				break
+			}
+		}
+		w.LastError = c.Error()
+	}
+
+	if len(w.Image) == 0 && len(w.Credentials) == 0 {
+		return
+	}
+
+	w.JobID = newID()
+	if err := s.PendingWrites.put(w); err != nil {
+		s.logger().Error("could not queue pending write", "person", w.PersonID, "error", err)
+		return
+	}
+
+	partial.Picture = nil
+	partial.Credentials = failed
+	partial.JobID = w.JobID
+	partial.Pending = w
+	s.Jobs.Resubmit(w.JobID, w.Owner, JobTypePendingWrite, s.retryPendingWrite(w))
+}
+
+// ResumePendingWrites restarts the jobs retrying the writes in s.PendingWrites, e.g. after a restart
+func (s *Service) ResumePendingWrites() {
+	if !s.queuesWrites() {
+		return
+	}
+	for _, w := range s.PendingWrites.List() {
+		s.Jobs.Resubmit(w.JobID, w.Owner, JobTypePendingWrite, s.retryPendingWrite(w))
+	}
+}
+
+// writePending writes w's picture and credentials, removing each from w as it's written
+func (s *Service) writePending(w *PendingWrite) error {
+	if len(w.Image) != 0 {
+		if err := s.pictures().Write(w.PersonID, w.Image); err != nil {
+			return fmt.Errorf("could not update picture: %w", err)
+		}
+		w.Image = nil
+		w.written.Picture = true
+		s.Thumbnails.Invalidate(w.PersonID)
+		s.notify(EventPictureUpdated, &pictureEvent{PersonID: w.PersonID})
+	}
+
+	for len(w.Credentials) > 0 {
+		cred := w.Credentials[0]
+		if _, err := s.DBConn.CreateCredential(w.PersonID, (*db.Credential)(cred)); err != nil {
+			return &CredentialError{SiteCode: cred.SiteCode, CardCode: cred.CardCode, Err: err}
+		}
+		w.Credentials = w.Credentials[1:]
+		w.written.Credentials = append(w.written.Credentials, cred)
+		s.notify(EventCredentialCreated, &credentialEvent{PersonID: w.PersonID, Credential: cred})
+	}
+
+	return nil
+}
+
+// retryPendingWrite returns a JobFunc that retries w with backoff until it's written, it fails with an error that won't
+// go away, or it runs out of attempts. If the job is stopped first, w stays queued to be resumed by ResumePendingWrites
+func (s *Service) retryPendingWrite(w *PendingWrite) JobFunc {
+	return func(ctx context.Context) (interface{}, error) {
+		s := s.WithContext(ctx)
+		w := *w
+		maxAttempts := s.PendingWrites.maxAttempts()
+
+		for {
+			ReportProgress(ctx, w.Attempts, maxAttempts)
+			err := s.writePending(&w)
+			if err == nil {
+				if err = s.PendingWrites.remove(w.JobID); err != nil {
+					s.logger().Error("could not remove pending write", "job", w.JobID, "error", err)
+				}
+				w.written.PersonID = w.PersonID
+				w.written.Attempts = w.Attempts + 1
+				return &w.written, nil
+			}
+
+			w.Attempts++
+			w.LastError = err.Error()
+			if errors.Is(err, db.ErrCredentialExists) || w.Attempts >= maxAttempts {
+				if rmErr := s.PendingWrites.remove(w.JobID); rmErr != nil {
+					s.logger().Error("could not remove pending write", "job", w.JobID, "error", rmErr)
+				}
+				return nil, fmt.Errorf("could not write person %d after %d attempts: %w", w.PersonID, w.Attempts, err)
+			}
+			if err = s.PendingWrites.put(&w); err != nil {
+				s.logger().Error("could not save pending write", "job", w.JobID, "error", err)
+			}
+			s.logger().Warn("pending write failed, retrying", "job", w.JobID, "person", w.PersonID, "attempts", w.Attempts, "error", w.LastError)
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(s.PendingWrites.backoff(w.Attempts)):
+			}
+		}
+	}
+}
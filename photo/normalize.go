@@ -0,0 +1,379 @@
+package photo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"strconv"
+	"strings"
+)
+
+const DefaultJPEGQuality = 90
+
+// MinJPEGQuality is the lowest quality images are re-encoded at to fit NormalizeOptions.MaxBytes before they're scaled down
+const MinJPEGQuality = 40
+
+// DefaultMaxPixels is the largest image, in width * height, Normalize decodes if NormalizeOptions.MaxPixels isn't set.
+// Decoded images use 4 bytes per pixel, so this is about 160 MiB
+const DefaultMaxPixels = 40000000
+
+// minCompressDimension is the smallest width or height images are scaled down to to fit NormalizeOptions.MaxBytes
+const minCompressDimension = 64
+
+var ErrInvalidAspectRatio = errors.New("invalid aspect ratio")
+
+// NormalizeOptions controls Normalize. Zero values disable cropping and resizing and use DefaultJPEGQuality
+type NormalizeOptions struct {
+	// AspectRatio, if set, is the width / height the image is center cropped to before it's resized
+	AspectRatio float64
+	// MaxDimension is the maximum width or height of the output image
+	MaxDimension int
+	// Quality is the JPEG quality (1-100)
+	Quality int
+	// MaxBytes, if set, is the maximum size of the output. Larger images are re-encoded at lower qualities,
+	// down to MinJPEGQuality, and then scaled down until they fit
+	MaxBytes int
+	// MaxPixels is the largest input, in width * height, that's decoded. Defaults to DefaultMaxPixels
+	MaxPixels int
+}
+
+// Normalize decodes buf, applies its EXIF orientation, crops it to AspectRatio, scales it to fit within MaxDimension,
+// and re-encodes it as a JPEG
+func Normalize(buf []byte, opts *NormalizeOptions) ([]byte, error) {
+	if opts == nil {
+		opts = new(NormalizeOptions)
+	}
+
+	// check the size before decoding, since a small file can decode to a huge image
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode image: %v", ErrUnsupportedFormat, err)
+	}
+	maxPixels := opts.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = DefaultMaxPixels
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxPixels/config.Height {
+		return nil, fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrDimensionTooLarge, config.Width, config.Height, maxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not decode image: %v", ErrUnsupportedFormat, err)
+	}
+
+	img = Orient(img, Orientation(buf))
+
+	if opts.AspectRatio > 0 {
+		img = Crop(img, opts.AspectRatio)
+	}
+
+	if opts.MaxDimension > 0 {
+		img = Fit(img, opts.MaxDimension)
+	}
+
+	out, err := EncodeJPEG(img, opts.Quality)
+	if err != nil || opts.MaxBytes <= 0 {
+		return out, err
+	}
+
+	return shrink(img, out, opts.Quality, opts.MaxBytes)
+}
+
+// Compress returns buf unchanged if it's at most opts.MaxBytes, and normalizes it with opts otherwise
+func Compress(buf []byte, opts *NormalizeOptions) ([]byte, error) {
+	if opts == nil || opts.MaxBytes <= 0 || len(buf) <= opts.MaxBytes {
+		return buf, nil
+	}
+	return Normalize(buf, opts)
+}
+
+// shrink re-encodes img, which encoded to buf at quality, at lower qualities and then smaller sizes until it's at most maxBytes
+func shrink(img image.Image, buf []byte, quality, maxBytes int) ([]byte, error) {
+	if quality <= 0 || quality > 100 {
+		quality = DefaultJPEGQuality
+	}
+
+	var err error
+	for len(buf) > maxBytes {
+		if quality > MinJPEGQuality {
+			quality -= 10
+			if quality < MinJPEGQuality {
+				quality = MinJPEGQuality
+			}
+		} else {
+			b := img.Bounds()
+			if b.Dx() <= minCompressDimension && b.Dy() <= minCompressDimension {
+				return nil, fmt.Errorf("%w: could not compress to %d bytes", ErrTooLarge, maxBytes)
+			}
+			max := b.Dx()
+			if b.Dy() > max {
+				max = b.Dy()
+			}
+			img = Fit(img, max*3/4)
+		}
+		if buf, err = EncodeJPEG(img, quality); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+// EncodeJPEG encodes img as a JPEG with the given quality, or DefaultJPEGQuality if quality is 0.
+// Transparent areas are flattened onto white
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	if quality <= 0 || quality > 100 {
+		quality = DefaultJPEGQuality
+	}
+
+	// flatten onto white so transparent PNGs don't turn black
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+
+	out := new(bytes.Buffer)
+	if err := jpeg.Encode(out, flat, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("could not encode jpeg: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// Orientation returns the EXIF orientation (1-8) of a JPEG, or 1 if it can't be determined
+func Orientation(buf []byte) int {
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return 1
+	}
+
+	// walk JPEG segments looking for the APP1 Exif segment
+	i := 2
+	for i+4 <= len(buf) {
+		if buf[i] != 0xFF {
+			return 1
+		}
+		marker := buf[i+1]
+		// start of scan: no more metadata
+		if marker == 0xDA {
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(buf[i+2 : i+4]))
+		if size < 2 || i+2+size > len(buf) {
+			return 1
+		}
+		if marker == 0xE1 {
+			if o := exifOrientation(buf[i+4 : i+2+size]); o != 0 {
+				return o
+			}
+		}
+		i += 2 + size
+	}
+
+	return 1
+}
+
+func exifOrientation(seg []byte) int {
+	if len(seg) < 14 || string(seg[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := seg[6:]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// orientation tag
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if o < 1 || o > 8 {
+				return 0
+			}
+			return o
+		}
+	}
+
+	return 0
+}
+
+// Orient transforms img so that it displays upright given its EXIF orientation
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// orientations 5-8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	return dst
+}
+
+// ParseAspectRatio parses an aspect ratio given as width:height, e.g. 3:4, or as a decimal width / height, e.g. 0.75
+func ParseAspectRatio(s string) (float64, error) {
+	var (
+		ratio float64
+		err   error
+	)
+	if parts := strings.SplitN(s, ":", 2); len(parts) == 2 {
+		var w, h float64
+		if w, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err == nil {
+			h, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if err == nil && h > 0 {
+			ratio = w / h
+		}
+	} else {
+		ratio, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+	}
+	if err != nil || !(ratio > 0) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAspectRatio, s)
+	}
+	return ratio, nil
+}
+
+// Crop returns the largest centered region of img with the given width / height ratio
+func Crop(img image.Image, ratio float64) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return img
+	}
+
+	cw, ch := w, int(float64(w)/ratio+0.5)
+	if ch > h {
+		cw, ch = int(float64(h)*ratio+0.5), h
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+	if cw == w && ch == h {
+		return img
+	}
+
+	x0, y0 := b.Min.X+(w-cw)/2, b.Min.Y+(h-ch)/2
+	dst := image.NewRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x0, y0), draw.Src)
+	return dst
+}
+
+// Fit scales img down, preserving aspect ratio, so neither side exceeds max. Smaller images are returned unchanged
+func Fit(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	return Resize(img, w, h)
+}
+
+// Resize scales img to exactly w x h using area averaging, which gives good results when shrinking
+func Resize(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := y * sh / h
+		y1 := (y + 1) * sh / h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := x * sw / w
+			x1 := (x + 1) * sw / w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
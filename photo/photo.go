@@ -0,0 +1,54 @@
+package photo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+var (
+	ErrEmpty             = errors.New("image is empty")
+	ErrTooLarge          = errors.New("image too large")
+	ErrDimensionTooLarge = errors.New("image dimensions too large")
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+)
+
+// SupportedFormats are the image formats accepted by Validate
+var SupportedFormats = []string{"jpeg", "png", "gif"}
+
+// Limits constrains uploaded images. Zero values are unlimited
+type Limits struct {
+	// MaxBytes is the maximum encoded size
+	MaxBytes int
+	// MaxDimension is the maximum width or height in pixels
+	MaxDimension int
+}
+
+// Validate checks that buf is a supported image within limits, returning its format
+func Validate(buf []byte, l *Limits) (string, error) {
+	if len(buf) == 0 {
+		return "", ErrEmpty
+	}
+
+	if l != nil && l.MaxBytes > 0 && len(buf) > l.MaxBytes {
+		return "", fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrTooLarge, len(buf), l.MaxBytes)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return "", ErrUnsupportedFormat
+		}
+		return "", fmt.Errorf("%w: could not decode image: %v", ErrUnsupportedFormat, err)
+	}
+
+	if l != nil && l.MaxDimension > 0 && (config.Width > l.MaxDimension || config.Height > l.MaxDimension) {
+		return "", fmt.Errorf("%w: %dx%d exceeds %dpx", ErrDimensionTooLarge, config.Width, config.Height, l.MaxDimension)
+	}
+
+	return format, nil
+}
@@ -0,0 +1,258 @@
+package photo
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service       = "s3"
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3TimeFormat    = "20060102T150405Z"
+	s3DateFormat    = "20060102"
+	s3EmptyHash     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3ErrorBodySize = 512
+)
+
+// S3Store stores pictures in an S3-compatible bucket, signing requests with AWS Signature Version 4
+type S3Store struct {
+	// Endpoint is the service URL, e.g. https://s3.us-east-1.amazonaws.com or https://minio.example.com:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path instead of the host name, as most S3-compatible servers require
+	PathStyle bool
+	Client    *http.Client
+}
+
+// NewS3Store returns a new S3Store
+func NewS3Store(endpoint, region, bucket, accessKey, secretKey string) *S3Store {
+	return &S3Store{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (s *S3Store) key(id int) string {
+	return s.Prefix + strconv.Itoa(id)
+}
+
+// url returns the URL for key, or the bucket if key is empty
+func (s *S3Store) url(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse endpoint: %w", err)
+	}
+	if s.PathStyle {
+		u.Path = "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	// url.Values.Encode escapes spaces as +, which SigV4 requires as %20
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	return u, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes a path per the SigV4 canonical URI rules
+func s3Escape(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	date := now.Format(s3DateFormat)
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           req.Header.Get("X-Amz-Date"),
+	}
+	sort.Strings(headers)
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + values[h] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	scope := date + "/" + s.Region + "/" + s3Service + "/aws4_request"
+	toSign := strings.Join([]string{s3Algorithm, req.Header.Get("X-Amz-Date"), scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3Algorithm, s.AccessKey, scope, signedHeaders, sig))
+}
+
+func (s *S3Store) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := s.url(key, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+
+	hash := s3EmptyHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		hash = hex.EncodeToString(sum[:])
+	}
+	s.sign(req, hash, time.Now())
+
+	r, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not %s %s: %w", method, u.Path, err)
+	}
+	return r, nil
+}
+
+func s3Error(r *http.Response) error {
+	buf, _ := io.ReadAll(io.LimitReader(r.Body, s3ErrorBodySize))
+	return fmt.Errorf("unexpected status: %s: %s", r.Status, strings.TrimSpace(string(buf)))
+}
+
+func (s *S3Store) Read(id int) ([]byte, error) {
+	r, err := s.do(http.MethodGet, s.key(id), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not read picture: %w", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not read picture: %w", s3Error(r))
+	}
+
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read picture: %w", err)
+	}
+	return buf, nil
+}
+
+func (s *S3Store) Write(id int, buf []byte) error {
+	r, err := s.do(http.MethodPut, s.key(id), nil, buf)
+	if err != nil {
+		return fmt.Errorf("could not write picture: %w", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not write picture: %w", s3Error(r))
+	}
+	return nil
+}
+
+func (s *S3Store) Delete(id int) error {
+	r, err := s.do(http.MethodDelete, s.key(id), nil, nil)
+	if err != nil {
+		return fmt.Errorf("could not delete picture: %w", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusNoContent && r.StatusCode != http.StatusOK && r.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("could not delete picture: %w", s3Error(r))
+	}
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Store) IDs() ([]int, error) {
+	var ids []int
+	query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix}}
+	for {
+		r, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not list pictures: %w", err)
+		}
+
+		if r.StatusCode != http.StatusOK {
+			err = s3Error(r)
+			r.Body.Close()
+			return nil, fmt.Errorf("could not list pictures: %w", err)
+		}
+
+		res := new(s3ListResult)
+		err = xml.NewDecoder(r.Body).Decode(res)
+		r.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode list: %w", err)
+		}
+
+		for _, c := range res.Contents {
+			id, err := strconv.Atoi(strings.TrimPrefix(c.Key, s.Prefix))
+			if err != nil {
+				continue
+			}
+			ids = append(ids, id)
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", res.NextContinuationToken)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,93 @@
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrNotFound = errors.New("picture not found")
+
+// Store stores people's pictures by person id
+type Store interface {
+	// Read returns the picture for id, or ErrNotFound
+	Read(id int) ([]byte, error)
+	Write(id int, buf []byte) error
+	// Delete removes the picture for id. Deleting a missing picture isn't an error
+	Delete(id int) error
+	// IDs returns the ids of all people with pictures
+	IDs() ([]int, error)
+}
+
+const fileStoreExt = ".img"
+
+// FileStore stores pictures as files in a local directory
+type FileStore struct {
+	Dir string
+}
+
+// NewFileStore returns a new FileStore in dir, creating it if it doesn't exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create picture directory: %w", err)
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (s *FileStore) path(id int) string {
+	return filepath.Join(s.Dir, strconv.Itoa(id)+fileStoreExt)
+}
+
+func (s *FileStore) Read(id int) ([]byte, error) {
+	buf, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not read picture: %w", err)
+	}
+	return buf, nil
+}
+
+func (s *FileStore) Write(id int, buf []byte) error {
+	path := s.path(id)
+	if err := os.WriteFile(path+".tmp", buf, 0600); err != nil {
+		return fmt.Errorf("could not write picture: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("could not write picture: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(id int) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not delete picture: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) IDs() ([]int, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list pictures: %w", err)
+	}
+
+	var ids []int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, fileStoreExt) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, fileStoreExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
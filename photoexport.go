@@ -0,0 +1,176 @@
+package infinias
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+// photoExportFilter selects the people included by ExportPhotosHandler. Empty fields match everyone
+type photoExportFilter struct {
+	ids         map[int]bool
+	departments map[string]bool
+}
+
+// splitQuery returns the comma-separated values of all name query parameters
+func splitQuery(r *http.Request, name string) []string {
+	var vals []string
+	for _, v := range r.URL.Query()[name] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				vals = append(vals, s)
+			}
+		}
+	}
+	return vals
+}
+
+func newPhotoExportFilter(r *http.Request) (*photoExportFilter, error) {
+	f := &photoExportFilter{ids: make(map[int]bool), departments: make(map[string]bool)}
+	for _, v := range splitQuery(r, "id") {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read id: %w", err)}
+		}
+		f.ids[id] = true
+	}
+	for _, v := range splitQuery(r, "department") {
+		f.departments[strings.ToLower(v)] = true
+	}
+	return f, nil
+}
+
+func (f *photoExportFilter) matches(p *api.Person) bool {
+	if len(f.ids) > 0 && !f.ids[p.ID] {
+		return false
+	}
+	if len(f.departments) > 0 && !f.departments[strings.ToLower(strings.TrimSpace(p.Department))] {
+		return false
+	}
+	return true
+}
+
+// photoFileName returns a safe file name for p's photo, named by employee ID or, if it has none or hideEmployeeID
+// is true, by person ID
+func photoFileName(p *api.Person, buf []byte, hideEmployeeID bool) string {
+	employeeID := p.EmployeeID
+	if hideEmployeeID {
+		employeeID = ""
+	}
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(employeeID))
+	if strings.Trim(name, "._") == "" {
+		name = fmt.Sprintf("id-%d", p.ID)
+	}
+
+	ext := ".img"
+	if format, err := photo.Validate(buf, nil); err == nil {
+		ext = "." + format
+		if format == "jpeg" {
+			ext = ".jpg"
+		}
+	}
+
+	return name + ext
+}
+
+// ExportPhotosHandler streams a ZIP archive of people's photos named by employee ID.
+// People can be filtered with the id and department query parameters
+func (s *Service) ExportPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	s = s.WithContext(r.Context())
+	errHandler := func(err error) {
+		s.HandleJSON(func(r *http.Request) (interface{}, error) {
+			return nil, err
+		}).ServeHTTP(w, r)
+	}
+
+	hidden := s.hiddenFields(r)
+	if err := hiddenFieldError(hidden, "image"); err != nil {
+		errHandler(err)
+		return
+	}
+
+	filter, err := newPhotoExportFilter(r)
+	if err != nil {
+		errHandler(err)
+		return
+	}
+	// filtering on a hidden department would reveal it
+	if len(filter.departments) > 0 {
+		if err = hiddenFieldError(hidden, "department"); err != nil {
+			errHandler(err)
+			return
+		}
+	}
+
+	people, err := s.APIConn.ListPeople()
+	if err != nil {
+		errHandler(&HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list people: %w", err)})
+		return
+	}
+
+	ids, err := s.pictures().IDs()
+	if err != nil {
+		errHandler(&HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list picture ids: %w", err)})
+		return
+	}
+	hasPicture := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		hasPicture[id] = true
+	}
+
+	sort.Slice(people, func(i, j int) bool { return people[i].ID < people[j].ID })
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="photos.zip"`)
+
+	// errors can't be returned once the archive has started, so they're logged and the archive is left truncated
+	z := zip.NewWriter(w)
+	names := make(map[string]bool)
+	for _, p := range people {
+		if !hasPicture[p.ID] || !filter.matches(p) {
+			continue
+		}
+
+		buf, err := s.pictures().Read(p.ID)
+		if errors.Is(err, photo.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			s.logger().Error("could not export photos", "id", p.ID, "error", fmt.Errorf("could not read picture: %w", err))
+			return
+		}
+
+		name := photoFileName(p, buf, hidden["employee_id"])
+		if names[name] {
+			ext := name[strings.LastIndex(name, "."):]
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), p.ID, ext)
+		}
+		names[name] = true
+
+		// photos are already compressed
+		f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err == nil {
+			_, err = f.Write(buf)
+		}
+		if err != nil {
+			s.logger().Error("could not export photos", "id", p.ID, "error", fmt.Errorf("could not write archive: %w", err))
+			return
+		}
+	}
+
+	if err = z.Close(); err != nil {
+		s.logger().Error("could not export photos", "error", fmt.Errorf("could not write archive: %w", err))
+	}
+}
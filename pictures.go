@@ -0,0 +1,68 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+// dbPictureStore stores pictures in the EAC.PersonImage table, where the Infinias client reads them from
+type dbPictureStore struct {
+	conn *db.Conn
+}
+
+func (s *dbPictureStore) Read(id int) ([]byte, error) {
+	buf, err := s.conn.ReadPicture(id)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, photo.ErrNotFound
+	}
+	return buf, err
+}
+
+func (s *dbPictureStore) Write(id int, buf []byte) error {
+	return s.conn.UpdatePicture(id, buf)
+}
+
+func (s *dbPictureStore) Delete(id int) error {
+	return s.conn.DeletePicture(id)
+}
+
+func (s *dbPictureStore) IDs() ([]int, error) {
+	return s.conn.HasPictureIDs()
+}
+
+// pictures returns the configured picture store, defaulting to the database
+func (s *Service) pictures() photo.Store {
+	if s.Pictures != nil {
+		return s.Pictures
+	}
+	return &dbPictureStore{conn: s.DBConn}
+}
+
+// ReadPicture returns the picture of the person with the given id, or photo.ErrNotFound if they don't have one
+func (s *Service) ReadPicture(id int) ([]byte, error) {
+	buf, err := s.pictures().Read(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not read picture: %w", err)
+	}
+	return buf, nil
+}
+
+// UpdatePicture validates and stores the picture of the person with the given id
+func (s *Service) UpdatePicture(id int, buf []byte) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+	buf, err := s.prepareImage(buf)
+	if err != nil {
+		return err
+	}
+	if err = s.pictures().Write(id, buf); err != nil {
+		return fmt.Errorf("could not update picture: %w", err)
+	}
+	s.Thumbnails.Invalidate(id)
+	s.notify(EventPictureUpdated, &pictureEvent{PersonID: id})
+	return nil
+}
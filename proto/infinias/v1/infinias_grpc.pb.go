@@ -0,0 +1,519 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: proto/infinias/v1/infinias.proto
+
+// Package infinias.v1 mirrors the /api/1.0 HTTP API for people, credentials, pictures, and events.
+// infinias-api serves it on http.listen_addr when http.grpc is enabled. Requests are authorized like /api/1.0,
+// with the same authorization metadata.
+//
+// The Go code in this directory is generated with:
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//       --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//       proto/infinias/v1/infinias.proto
+
+package infiniasv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Infinias_ListPeople_FullMethodName       = "/infinias.v1.Infinias/ListPeople"
+	Infinias_GetPerson_FullMethodName        = "/infinias.v1.Infinias/GetPerson"
+	Infinias_CreatePerson_FullMethodName     = "/infinias.v1.Infinias/CreatePerson"
+	Infinias_UpdatePerson_FullMethodName     = "/infinias.v1.Infinias/UpdatePerson"
+	Infinias_DeletePerson_FullMethodName     = "/infinias.v1.Infinias/DeletePerson"
+	Infinias_ListCredentials_FullMethodName  = "/infinias.v1.Infinias/ListCredentials"
+	Infinias_CreateCredential_FullMethodName = "/infinias.v1.Infinias/CreateCredential"
+	Infinias_DeleteCredential_FullMethodName = "/infinias.v1.Infinias/DeleteCredential"
+	Infinias_GetPicture_FullMethodName       = "/infinias.v1.Infinias/GetPicture"
+	Infinias_UpdatePicture_FullMethodName    = "/infinias.v1.Infinias/UpdatePicture"
+	Infinias_StreamEvents_FullMethodName     = "/infinias.v1.Infinias/StreamEvents"
+)
+
+// InfiniasClient is the client API for Infinias service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InfiniasClient interface {
+	ListPeople(ctx context.Context, in *ListPeopleRequest, opts ...grpc.CallOption) (*ListPeopleResponse, error)
+	GetPerson(ctx context.Context, in *GetPersonRequest, opts ...grpc.CallOption) (*Person, error)
+	CreatePerson(ctx context.Context, in *CreatePersonRequest, opts ...grpc.CallOption) (*Person, error)
+	UpdatePerson(ctx context.Context, in *UpdatePersonRequest, opts ...grpc.CallOption) (*Person, error)
+	DeletePerson(ctx context.Context, in *DeletePersonRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListCredentials(ctx context.Context, in *ListCredentialsRequest, opts ...grpc.CallOption) (*ListCredentialsResponse, error)
+	CreateCredential(ctx context.Context, in *CreateCredentialRequest, opts ...grpc.CallOption) (*Credential, error)
+	DeleteCredential(ctx context.Context, in *DeleteCredentialRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	GetPicture(ctx context.Context, in *GetPictureRequest, opts ...grpc.CallOption) (*Picture, error)
+	UpdatePicture(ctx context.Context, in *UpdatePictureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// StreamEvents streams access control events as they happen, like GET /events/stream
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Infinias_StreamEventsClient, error)
+}
+
+type infiniasClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInfiniasClient(cc grpc.ClientConnInterface) InfiniasClient {
+	return &infiniasClient{cc}
+}
+
+func (c *infiniasClient) ListPeople(ctx context.Context, in *ListPeopleRequest, opts ...grpc.CallOption) (*ListPeopleResponse, error) {
+	out := new(ListPeopleResponse)
+	err := c.cc.Invoke(ctx, Infinias_ListPeople_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) GetPerson(ctx context.Context, in *GetPersonRequest, opts ...grpc.CallOption) (*Person, error) {
+	out := new(Person)
+	err := c.cc.Invoke(ctx, Infinias_GetPerson_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) CreatePerson(ctx context.Context, in *CreatePersonRequest, opts ...grpc.CallOption) (*Person, error) {
+	out := new(Person)
+	err := c.cc.Invoke(ctx, Infinias_CreatePerson_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) UpdatePerson(ctx context.Context, in *UpdatePersonRequest, opts ...grpc.CallOption) (*Person, error) {
+	out := new(Person)
+	err := c.cc.Invoke(ctx, Infinias_UpdatePerson_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) DeletePerson(ctx context.Context, in *DeletePersonRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Infinias_DeletePerson_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) ListCredentials(ctx context.Context, in *ListCredentialsRequest, opts ...grpc.CallOption) (*ListCredentialsResponse, error) {
+	out := new(ListCredentialsResponse)
+	err := c.cc.Invoke(ctx, Infinias_ListCredentials_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) CreateCredential(ctx context.Context, in *CreateCredentialRequest, opts ...grpc.CallOption) (*Credential, error) {
+	out := new(Credential)
+	err := c.cc.Invoke(ctx, Infinias_CreateCredential_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) DeleteCredential(ctx context.Context, in *DeleteCredentialRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Infinias_DeleteCredential_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) GetPicture(ctx context.Context, in *GetPictureRequest, opts ...grpc.CallOption) (*Picture, error) {
+	out := new(Picture)
+	err := c.cc.Invoke(ctx, Infinias_GetPicture_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) UpdatePicture(ctx context.Context, in *UpdatePictureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, Infinias_UpdatePicture_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *infiniasClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Infinias_StreamEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Infinias_ServiceDesc.Streams[0], Infinias_StreamEvents_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &infiniasStreamEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Infinias_StreamEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type infiniasStreamEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *infiniasStreamEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InfiniasServer is the server API for Infinias service.
+// All implementations must embed UnimplementedInfiniasServer
+// for forward compatibility
+type InfiniasServer interface {
+	ListPeople(context.Context, *ListPeopleRequest) (*ListPeopleResponse, error)
+	GetPerson(context.Context, *GetPersonRequest) (*Person, error)
+	CreatePerson(context.Context, *CreatePersonRequest) (*Person, error)
+	UpdatePerson(context.Context, *UpdatePersonRequest) (*Person, error)
+	DeletePerson(context.Context, *DeletePersonRequest) (*emptypb.Empty, error)
+	ListCredentials(context.Context, *ListCredentialsRequest) (*ListCredentialsResponse, error)
+	CreateCredential(context.Context, *CreateCredentialRequest) (*Credential, error)
+	DeleteCredential(context.Context, *DeleteCredentialRequest) (*emptypb.Empty, error)
+	GetPicture(context.Context, *GetPictureRequest) (*Picture, error)
+	UpdatePicture(context.Context, *UpdatePictureRequest) (*emptypb.Empty, error)
+	// StreamEvents streams access control events as they happen, like GET /events/stream
+	StreamEvents(*StreamEventsRequest, Infinias_StreamEventsServer) error
+	mustEmbedUnimplementedInfiniasServer()
+}
+
+// UnimplementedInfiniasServer must be embedded to have forward compatible implementations.
+type UnimplementedInfiniasServer struct {
+}
+
+func (UnimplementedInfiniasServer) ListPeople(context.Context, *ListPeopleRequest) (*ListPeopleResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPeople not implemented")
+}
+func (UnimplementedInfiniasServer) GetPerson(context.Context, *GetPersonRequest) (*Person, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPerson not implemented")
+}
+func (UnimplementedInfiniasServer) CreatePerson(context.Context, *CreatePersonRequest) (*Person, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePerson not implemented")
+}
+func (UnimplementedInfiniasServer) UpdatePerson(context.Context, *UpdatePersonRequest) (*Person, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePerson not implemented")
+}
+func (UnimplementedInfiniasServer) DeletePerson(context.Context, *DeletePersonRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePerson not implemented")
+}
+func (UnimplementedInfiniasServer) ListCredentials(context.Context, *ListCredentialsRequest) (*ListCredentialsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCredentials not implemented")
+}
+func (UnimplementedInfiniasServer) CreateCredential(context.Context, *CreateCredentialRequest) (*Credential, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCredential not implemented")
+}
+func (UnimplementedInfiniasServer) DeleteCredential(context.Context, *DeleteCredentialRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteCredential not implemented")
+}
+func (UnimplementedInfiniasServer) GetPicture(context.Context, *GetPictureRequest) (*Picture, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPicture not implemented")
+}
+func (UnimplementedInfiniasServer) UpdatePicture(context.Context, *UpdatePictureRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePicture not implemented")
+}
+func (UnimplementedInfiniasServer) StreamEvents(*StreamEventsRequest, Infinias_StreamEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedInfiniasServer) mustEmbedUnimplementedInfiniasServer() {}
+
+// UnsafeInfiniasServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InfiniasServer will
+// result in compilation errors.
+type UnsafeInfiniasServer interface {
+	mustEmbedUnimplementedInfiniasServer()
+}
+
+func RegisterInfiniasServer(s grpc.ServiceRegistrar, srv InfiniasServer) {
+	s.RegisterService(&Infinias_ServiceDesc, srv)
+}
+
+func _Infinias_ListPeople_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPeopleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).ListPeople(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_ListPeople_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).ListPeople(ctx, req.(*ListPeopleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_GetPerson_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPersonRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).GetPerson(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_GetPerson_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).GetPerson(ctx, req.(*GetPersonRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_CreatePerson_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePersonRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).CreatePerson(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_CreatePerson_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).CreatePerson(ctx, req.(*CreatePersonRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_UpdatePerson_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePersonRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).UpdatePerson(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_UpdatePerson_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).UpdatePerson(ctx, req.(*UpdatePersonRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_DeletePerson_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePersonRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).DeletePerson(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_DeletePerson_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).DeletePerson(ctx, req.(*DeletePersonRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_ListCredentials_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCredentialsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).ListCredentials(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_ListCredentials_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).ListCredentials(ctx, req.(*ListCredentialsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_CreateCredential_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCredentialRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).CreateCredential(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_CreateCredential_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).CreateCredential(ctx, req.(*CreateCredentialRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_DeleteCredential_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteCredentialRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).DeleteCredential(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_DeleteCredential_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).DeleteCredential(ctx, req.(*DeleteCredentialRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_GetPicture_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPictureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).GetPicture(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_GetPicture_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).GetPicture(ctx, req.(*GetPictureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_UpdatePicture_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdatePictureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InfiniasServer).UpdatePicture(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Infinias_UpdatePicture_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InfiniasServer).UpdatePicture(ctx, req.(*UpdatePictureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Infinias_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InfiniasServer).StreamEvents(m, &infiniasStreamEventsServer{stream})
+}
+
+type Infinias_StreamEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type infiniasStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *infiniasStreamEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Infinias_ServiceDesc is the grpc.ServiceDesc for Infinias service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Infinias_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "infinias.v1.Infinias",
+	HandlerType: (*InfiniasServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListPeople",
+			Handler:    _Infinias_ListPeople_Handler,
+		},
+		{
+			MethodName: "GetPerson",
+			Handler:    _Infinias_GetPerson_Handler,
+		},
+		{
+			MethodName: "CreatePerson",
+			Handler:    _Infinias_CreatePerson_Handler,
+		},
+		{
+			MethodName: "UpdatePerson",
+			Handler:    _Infinias_UpdatePerson_Handler,
+		},
+		{
+			MethodName: "DeletePerson",
+			Handler:    _Infinias_DeletePerson_Handler,
+		},
+		{
+			MethodName: "ListCredentials",
+			Handler:    _Infinias_ListCredentials_Handler,
+		},
+		{
+			MethodName: "CreateCredential",
+			Handler:    _Infinias_CreateCredential_Handler,
+		},
+		{
+			MethodName: "DeleteCredential",
+			Handler:    _Infinias_DeleteCredential_Handler,
+		},
+		{
+			MethodName: "GetPicture",
+			Handler:    _Infinias_GetPicture_Handler,
+		},
+		{
+			MethodName: "UpdatePicture",
+			Handler:    _Infinias_UpdatePicture_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _Infinias_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/infinias/v1/infinias.proto",
+}
@@ -0,0 +1,187 @@
+package infinias
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultPublisherQueueSize = 1000
+
+// Broker publishes messages to a message broker, e.g. Kafka or NATS
+type Broker interface {
+	// Publish sends value, an event of type typ, to the broker. key, if not empty, is the id of the person the
+	// event is about, so the broker can keep each person's events in order
+	Publish(typ, key string, value []byte) error
+}
+
+type publishedEvent struct {
+	typ   string
+	key   string
+	value []byte
+}
+
+// Publisher publishes events to a Broker in the background, in the same format as webhooks, so data pipelines
+// don't need to poll the API. Events are dropped if the broker falls more than the queue size behind
+type Publisher struct {
+	Broker Broker
+	// Events are the event types to publish, including EventAccessEvent. If empty, all events are published
+	Events []string
+	Log    Logger
+
+	queue   chan *publishedEvent
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewPublisher returns a new Publisher that queues up to queueSize events. Stop must be called to stop it
+func NewPublisher(broker Broker, events []string, queueSize int, log Logger) *Publisher {
+	if queueSize <= 0 {
+		queueSize = DefaultPublisherQueueSize
+	}
+	if log == nil {
+		log = NopLogger
+	}
+	p := &Publisher{
+		Broker:  broker,
+		Events:  events,
+		Log:     log,
+		queue:   make(chan *publishedEvent, queueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *Publisher) run() {
+	defer close(p.stopped)
+	for {
+		select {
+		case e := <-p.queue:
+			p.publish(e)
+		case <-p.done:
+			// publish what was queued before Stop
+			for {
+				select {
+				case e := <-p.queue:
+					p.publish(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Publisher) publish(e *publishedEvent) {
+	if err := p.Broker.Publish(e.typ, e.key, e.value); err != nil {
+		p.Log.Warn("could not publish event", "event_type", e.typ, "error", err)
+	}
+}
+
+// Stop publishes queued events and stops the Publisher. Events sent after Stop are dropped
+func (p *Publisher) Stop() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() { close(p.done) })
+	<-p.stopped
+}
+
+func (p *Publisher) wants(typ string) bool {
+	if len(p.Events) == 0 {
+		return true
+	}
+	for _, e := range p.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// eventKey returns the id of the person data is about, or "" if it isn't about a person
+func eventKey(data interface{}) string {
+	var id int
+	switch d := data.(type) {
+	case *Person:
+		id = d.ID
+	case *personDeletedEvent:
+		id = d.ID
+	case *credentialEvent:
+		id = d.PersonID
+	case *pictureEvent:
+		id = d.PersonID
+	case *groupMembershipEvent:
+		id = d.PersonID
+	case *Deactivation:
+		id = d.PersonID
+	case *Merge:
+		id = d.PersonID
+	case *Event:
+		id = d.PersonID
+	}
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
+
+// Send queues an event of the given type for publishing. It never blocks
+func (p *Publisher) Send(typ string, data interface{}) {
+	p.send(typ, data, false)
+}
+
+// send queues an event. If wait is true and the queue is full, it waits for room instead of dropping the event
+func (p *Publisher) send(typ string, data interface{}, wait bool) {
+	if p == nil || !p.wants(typ) {
+		return
+	}
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	body, err := json.Marshal(&WebhookEvent{ID: newID(), Type: typ, Time: time.Now().UTC(), Data: data})
+	if err != nil {
+		p.Log.Error("could not encode published event", "event_type", typ, "error", err)
+		return
+	}
+
+	e := &publishedEvent{typ: typ, key: eventKey(data), value: body}
+	if wait {
+		select {
+		case p.queue <- e:
+		case <-p.done:
+			p.Log.Warn("could not publish event: publisher is stopped", "event_type", typ)
+		}
+		return
+	}
+
+	select {
+	case p.queue <- e:
+	default:
+		p.Log.Warn("could not publish event: queue is full", "event_type", typ)
+	}
+}
+
+// Watch publishes an EventAccessEvent for each event from stream until stop is called. Access events wait for room
+// in the queue instead of being dropped, so the stream's cursor doesn't move past unpublished events
+func (p *Publisher) Watch(stream *EventStream) (stop func()) {
+	if p == nil || stream == nil || !p.wants(EventAccessEvent) {
+		return func() {}
+	}
+
+	ch, unsubscribe := stream.Subscribe()
+	go func() {
+		for e := range ch {
+			p.send(EventAccessEvent, e, true)
+		}
+	}()
+
+	return unsubscribe
+}
@@ -0,0 +1,191 @@
+package infinias
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RolePrefix marks a scope that grants a role, e.g. role:badge-viewer
+const RolePrefix = "role:"
+
+var (
+	ErrInvalidRole = errors.New("invalid role")
+	ErrFieldHidden = errors.New("field hidden")
+)
+
+// Role is a named set of routes a principal may use in addition to those its scopes allow, and person fields it
+// never sees. Principals are given a role with a scope of RolePrefix followed by its name
+type Role struct {
+	Name string
+	// Routes are the allowed routes as a method and path template, e.g. "GET /people/{id}". Either may be *, and
+	// a path ending in /* also matches every path under it
+	Routes []string
+	// HiddenFields are person fields, e.g. card_code, removed from every object in JSON responses, so they're also
+	// hidden in credentials, visitors, and exports. Hiding image also denies thumbnails and photo exports
+	HiddenFields []string
+
+	routes []roleRoute
+}
+
+type roleRoute struct {
+	method string
+	path   string
+}
+
+// NewRole returns a new Role, or an error if a route or hidden field is invalid
+func NewRole(name string, routes, hiddenFields []string) (*Role, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidRole)
+	}
+	role := &Role{Name: name, Routes: routes, HiddenFields: hiddenFields}
+	for _, r := range routes {
+		parts := strings.Fields(r)
+		if len(parts) != 2 || (parts[1] != "*" && !strings.HasPrefix(parts[1], "/")) {
+			return nil, fmt.Errorf("%w: route must be a method and path: %q", ErrInvalidRole, r)
+		}
+		role.routes = append(role.routes, roleRoute{method: strings.ToUpper(parts[0]), path: parts[1]})
+	}
+	for _, f := range hiddenFields {
+		if !personFields[f] || f == "id" {
+			return nil, fmt.Errorf("%w: unknown hidden field: %q", ErrInvalidRole, f)
+		}
+	}
+	return role, nil
+}
+
+// allows returns true if the role allows method requests to the route with the path template tmpl
+func (role *Role) allows(method, tmpl string) bool {
+	for _, r := range role.routes {
+		if r.method != "*" && r.method != method {
+			continue
+		}
+		if r.path == "*" || r.path == tmpl || (strings.HasSuffix(r.path, "/*") && strings.HasPrefix(tmpl, r.path[:len(r.path)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// principalRoles returns the roles in s.Roles granted to p. Unknown roles are ignored
+func (s *Service) principalRoles(p *Principal) []*Role {
+	var roles []*Role
+	for _, scope := range p.Scopes {
+		if !strings.HasPrefix(scope, RolePrefix) {
+			continue
+		}
+		if role, ok := s.Roles[strings.TrimPrefix(scope, RolePrefix)]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// roleAllows returns true if one of p's roles allows r
+func (s *Service) roleAllows(r *http.Request, p *Principal) bool {
+	tmpl := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			tmpl = t
+		}
+	}
+	for _, role := range s.principalRoles(p) {
+		if role.allows(r.Method, tmpl) {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenFields returns the person fields hidden from the request's principal, which are those hidden by every one
+// of its roles, or nil if none are
+func (s *Service) hiddenFields(r *http.Request) map[string]bool {
+	p := PrincipalFromContext(r.Context())
+	if p == nil {
+		return nil
+	}
+	roles := s.principalRoles(p)
+	if len(roles) == 0 {
+		return nil
+	}
+
+	var hidden map[string]bool
+	for idx, role := range roles {
+		fields := make(map[string]bool, len(role.HiddenFields))
+		for _, f := range role.HiddenFields {
+			if idx == 0 || hidden[f] {
+				fields[f] = true
+			}
+		}
+		hidden = fields
+	}
+	if len(hidden) == 0 {
+		return nil
+	}
+	return hidden
+}
+
+// hiddenFieldError returns a 403 *HTTPError if field is in hidden
+func hiddenFieldError(hidden map[string]bool, field string) error {
+	if hidden[field] {
+		return &HTTPError{StatusCode: http.StatusForbidden, Err: fmt.Errorf("%w: %s", ErrFieldHidden, field)}
+	}
+	return nil
+}
+
+// errorDetail returns h.Detail with the fields hidden from the request's principal removed, so errors like credential
+// conflicts don't reveal them
+func (s *Service) errorDetail(r *http.Request, h *HTTPError) interface{} {
+	hidden := s.hiddenFields(r)
+	if h.Detail == nil || hidden == nil {
+		return h.Detail
+	}
+	detail, err := hideFields(h.Detail, hidden)
+	if err != nil {
+		s.requestLogger(r).Error("could not hide fields in error detail", "error", err)
+		return nil
+	}
+	return detail
+}
+
+// hideFields returns v with the hidden fields removed from every JSON object in it
+func hideFields(v interface{}, hidden map[string]bool) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode response: %w", err)
+	}
+
+	var decoded interface{}
+	d := json.NewDecoder(bytes.NewReader(buf))
+	// keep numbers exactly as they were encoded
+	d.UseNumber()
+	if err = d.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+
+	var hide func(v interface{})
+	hide = func(v interface{}) {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			for k, child := range val {
+				if hidden[k] {
+					delete(val, k)
+					continue
+				}
+				hide(child)
+			}
+		case []interface{}:
+			for _, child := range val {
+				hide(child)
+			}
+		}
+	}
+	hide(decoded)
+
+	return decoded, nil
+}
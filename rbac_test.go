@@ -0,0 +1,185 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHideFields(t *testing.T) {
+	role, err := NewRole("viewer", []string{"GET *"}, []string{"card_code", "employee_id"})
+	if err != nil {
+		t.Fatalf("could not create role: %v", err)
+	}
+	s := &Service{Roles: map[string]*Role{role.Name: role}}
+
+	person := func(id int) *Person {
+		return &Person{ID: id, FirstName: "Alice", LastName: "Smith", EmployeeID: "E1", SiteCode: 10, CardCode: 100,
+			Credentials: []*Credential{{ID: id, Active: true, SiteCode: 10, CardCode: 200}}}
+	}
+	// every object, including nested credentials, keeps only the fields that aren't hidden
+	wantPerson := func(id int) map[string]interface{} {
+		return map[string]interface{}{
+			"id": json.Number(fmt.Sprint(id)), "first_name": "Alice", "last_name": "Smith", "department": "",
+			"site_code": json.Number("10"), "has_image": false,
+			"credentials": []interface{}{map[string]interface{}{"id": json.Number(fmt.Sprint(id)), "active": true, "site_code": json.Number("10")}},
+		}
+	}
+	wantList := []interface{}{wantPerson(1), wantPerson(2)}
+
+	tests := []struct {
+		name    string
+		version string
+		target  string
+		resp    func(r *http.Request) interface{}
+		want    interface{}
+	}{
+		{"v1 single", APIVersion1, "/people/1", func(r *http.Request) interface{} { return person(1) }, wantPerson(1)},
+		{"v1 list", APIVersion1, "/people", func(r *http.Request) interface{} { return []*Person{person(1), person(2)} }, wantList},
+		{"v1 created", APIVersion1, "/people", func(r *http.Request) interface{} { return newCreated(r, "/people/1", person(1)) }, wantPerson(1)},
+		{"v2 single", APIVersion2, "/people/1", func(r *http.Request) interface{} { return person(1) }, map[string]interface{}{"data": wantPerson(1)}},
+		{"v2 list", APIVersion2, "/people", func(r *http.Request) interface{} { return []*Person{person(1), person(2)} },
+			map[string]interface{}{"data": wantList, "meta": map[string]interface{}{
+				"page": json.Number("1"), "per_page": json.Number("100"), "total": json.Number("2"), "total_pages": json.Number("1"),
+			}}},
+		{"v2 cursor", APIVersion2, "/people?cursor=&per_page=1", func(r *http.Request) interface{} { return []*Person{person(1), person(2)} },
+			map[string]interface{}{"data": wantList[:1], "meta": map[string]interface{}{
+				"per_page": json.Number("1"), "total": json.Number("2"), "next_cursor": encodeCursor(&pageCursor{ID: 1}),
+			}}},
+		{"v2 created", APIVersion2, "/people", func(r *http.Request) interface{} { return newCreated(r, "/people/1", person(1)) },
+			map[string]interface{}{"data": wantPerson(1)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			ctx := context.WithValue(r.Context(), contextKeyPrincipal, &Principal{Name: "viewer", Scopes: []string{RolePrefix + role.Name}})
+			r = r.WithContext(context.WithValue(ctx, contextKeyAPIVersion, test.version))
+
+			w := httptest.NewRecorder()
+			s.HandleJSON(func(r *http.Request) (interface{}, error) {
+				return test.resp(r), nil
+			}).ServeHTTP(w, r)
+
+			var have interface{}
+			d := json.NewDecoder(w.Body)
+			d.UseNumber()
+			if err := d.Decode(&have); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if !reflect.DeepEqual(have, test.want) {
+				t.Errorf("want %v, have %v", test.want, have)
+			}
+		})
+	}
+
+	// fields are only hidden from principals with the role
+	r := httptest.NewRequest(http.MethodGet, "/people/1", nil)
+	r = r.WithContext(context.WithValue(r.Context(), contextKeyPrincipal, &Principal{Name: "reader", Scopes: []string{ScopeReadOnly}}))
+	w := httptest.NewRecorder()
+	s.HandleJSON(func(r *http.Request) (interface{}, error) {
+		return person(1), nil
+	}).ServeHTTP(w, r)
+	have := new(Person)
+	if err = json.NewDecoder(w.Body).Decode(have); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if !reflect.DeepEqual(have, person(1)) {
+		t.Errorf("want %+v, have %+v", person(1), have)
+	}
+}
+
+func TestHideFieldsInErrors(t *testing.T) {
+	role, err := NewRole("viewer", []string{"* *"}, []string{"card_code", "employee_id", "last_name"})
+	if err != nil {
+		t.Fatalf("could not create role: %v", err)
+	}
+	s := &Service{Roles: map[string]*Role{role.Name: role}}
+
+	conflict := &HTTPError{StatusCode: http.StatusConflict, Err: errors.New("credential exists"), Detail: &CredentialConflict{
+		SiteCode: 10, CardCode: 100, CredentialID: 5,
+		Owner: &CredentialOwner{ID: 2, FirstName: "Bob", LastName: "Jones", EmployeeID: "E2"},
+	}}
+	partial := partialFailure(&PartialError{PersonID: 1, Credentials: []*CredentialError{{SiteCode: 10, CardCode: 100, Err: errors.New("failed")}}})
+
+	tests := []struct {
+		name string
+		err  error
+		want map[string]interface{}
+	}{
+		{"credential conflict", conflict, map[string]interface{}{
+			"site_code": json.Number("10"), "credential_id": json.Number("5"),
+			"owner": map[string]interface{}{"id": json.Number("2"), "first_name": "Bob"},
+		}},
+		{"partial failure", partial, map[string]interface{}{
+			"person_id":   json.Number("1"),
+			"credentials": []interface{}{map[string]interface{}{"site_code": json.Number("10"), "error": "failed"}},
+		}},
+	}
+	for _, test := range tests {
+		for _, version := range []string{APIVersion1, APIVersion2} {
+			t.Run(test.name+" "+version, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "/people", nil)
+				ctx := context.WithValue(r.Context(), contextKeyPrincipal, &Principal{Name: "viewer", Scopes: []string{RolePrefix + role.Name}})
+				r = r.WithContext(context.WithValue(ctx, contextKeyAPIVersion, version))
+
+				w := httptest.NewRecorder()
+				s.HandleJSON(func(r *http.Request) (interface{}, error) {
+					return nil, test.err
+				}).ServeHTTP(w, r)
+
+				var have struct {
+					Detail interface{} `json:"detail"`
+					Error  struct {
+						Detail interface{} `json:"detail"`
+					} `json:"error"`
+				}
+				d := json.NewDecoder(w.Body)
+				d.UseNumber()
+				if err := d.Decode(&have); err != nil {
+					t.Fatalf("could not decode response: %v", err)
+				}
+				detail := have.Detail
+				if version == APIVersion2 {
+					detail = have.Error.Detail
+				}
+				if !reflect.DeepEqual(detail, test.want) {
+					t.Errorf("want %v, have %v", test.want, detail)
+				}
+			})
+		}
+	}
+}
+
+func TestHiddenFieldFilters(t *testing.T) {
+	role, err := NewRole("viewer", []string{"GET *"}, []string{"has_image", "credentials", "employee_id"})
+	if err != nil {
+		t.Fatalf("could not create role: %v", err)
+	}
+	s := &Service{Roles: map[string]*Role{role.Name: role}}
+
+	tests := []struct {
+		name    string
+		target  string
+		handler func(r *http.Request) (interface{}, error)
+	}{
+		{"has_image", "/people?has_image=true", s.ListPeopleHandler},
+		{"has_credential", "/people?has_credential=false", s.ListPeopleHandler},
+		{"sort", "/people?sort=-has_image", s.ListPeopleHandler},
+		{"employee id", "/people/employee/E1", s.ReadPersonByEmployeeIDHandler},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyPrincipal, &Principal{Name: "viewer", Scopes: []string{RolePrefix + role.Name}}))
+			_, err := test.handler(r)
+			if !errors.Is(err, ErrFieldHidden) || HTTPErrorCode(err) != http.StatusForbidden {
+				t.Errorf("want 403 %v, have %v", ErrFieldHidden, err)
+			}
+		})
+	}
+}
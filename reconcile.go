@@ -0,0 +1,135 @@
+package infinias
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Discrepancy types reported by Reconcile
+const (
+	// DiscrepancyMissingInDB is a person returned by the API without a row in EAC.Person
+	DiscrepancyMissingInDB = "missing_in_db"
+	// DiscrepancyMissingInAPI is a row in EAC.Person the API doesn't return
+	DiscrepancyMissingInAPI = "missing_in_api"
+	// DiscrepancyGhostCredential is a credential that belongs to a person that doesn't exist
+	DiscrepancyGhostCredential = "ghost_credential"
+	// DiscrepancyUnassignedCredential is a credential that doesn't belong to anyone
+	DiscrepancyUnassignedCredential = "unassigned_credential"
+	// DiscrepancyMissingWiegand is a credential without a wiegand row
+	DiscrepancyMissingWiegand = "missing_wiegand"
+	// DiscrepancyBadgeMismatch is a person whose badge in the API doesn't match any of their credentials in the database
+	DiscrepancyBadgeMismatch = "badge_mismatch"
+)
+
+// Discrepancy is a difference between the Infinias API and the EAC tables
+type Discrepancy struct {
+	Type         string `json:"type"`
+	PersonID     int    `json:"person_id,omitempty"`
+	CredentialID int    `json:"credential_id,omitempty"`
+	Description  string `json:"description"`
+}
+
+// ReconciliationReport is the result of Reconcile
+type ReconciliationReport struct {
+	Time          time.Time      `json:"time"`
+	APIPeople     int            `json:"api_people"`
+	DBPeople      int            `json:"db_people"`
+	DBCredentials int            `json:"db_credentials"`
+	Discrepancies []*Discrepancy `json:"discrepancies"`
+}
+
+// Reconcile compares people and credentials returned by the Infinias API with the EAC tables
+func (s *Service) Reconcile() (*ReconciliationReport, error) {
+	apiPeople, err := s.APIConn.ListPeople()
+	if err != nil {
+		return nil, fmt.Errorf("could not list api people: %w", err)
+	}
+
+	dbIDs, err := s.DBConn.ListPersonIDs()
+	if err != nil {
+		return nil, fmt.Errorf("could not list db people: %w", err)
+	}
+
+	creds, err := s.DBConn.ListCredentialRows()
+	if err != nil {
+		return nil, fmt.Errorf("could not list db credentials: %w", err)
+	}
+
+	report := &ReconciliationReport{
+		Time:          time.Now().UTC(),
+		APIPeople:     len(apiPeople),
+		DBPeople:      len(dbIDs),
+		DBCredentials: len(creds),
+		Discrepancies: make([]*Discrepancy, 0),
+	}
+	add := func(typ string, personID, credID int, format string, args ...interface{}) {
+		report.Discrepancies = append(report.Discrepancies, &Discrepancy{
+			Type:         typ,
+			PersonID:     personID,
+			CredentialID: credID,
+			Description:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	inDB := make(map[int]struct{})
+	for _, id := range dbIDs {
+		inDB[id] = struct{}{}
+	}
+	inAPI := make(map[int]struct{})
+	for _, p := range apiPeople {
+		inAPI[p.ID] = struct{}{}
+	}
+
+	type badge struct{ site, card int }
+	badges := make(map[int]map[badge]struct{})
+	for _, c := range creds {
+		// credentials with a null PersonId aren't anyone's, so their wiegand rows and badges aren't checked
+		if !c.HasPerson {
+			add(DiscrepancyUnassignedCredential, 0, c.ID, "credential %d doesn't belong to a person", c.ID)
+			continue
+		}
+		if _, ok := inDB[c.PersonID]; !ok {
+			add(DiscrepancyGhostCredential, c.PersonID, c.ID, "credential %d belongs to person %d, who doesn't exist", c.ID, c.PersonID)
+		}
+		if !c.HasWiegand {
+			add(DiscrepancyMissingWiegand, c.PersonID, c.ID, "credential %d has no wiegand row", c.ID)
+			continue
+		}
+		if badges[c.PersonID] == nil {
+			badges[c.PersonID] = make(map[badge]struct{})
+		}
+		badges[c.PersonID][badge{c.SiteCode, c.CardCode}] = struct{}{}
+	}
+
+	for _, p := range apiPeople {
+		if _, ok := inDB[p.ID]; !ok {
+			add(DiscrepancyMissingInDB, p.ID, 0, "person %d (%s %s) is returned by the api but not in EAC.Person", p.ID, p.FirstName, p.LastName)
+			continue
+		}
+		if p.SiteCode == 0 && p.CardCode == 0 {
+			continue
+		}
+		if _, ok := badges[p.ID][badge{p.SiteCode, p.CardCode}]; !ok {
+			add(DiscrepancyBadgeMismatch, p.ID, 0, "person %d has badge %d-%d in the api but no matching credential in the database", p.ID, p.SiteCode, p.CardCode)
+		}
+	}
+
+	for _, id := range dbIDs {
+		if _, ok := inAPI[id]; !ok {
+			add(DiscrepancyMissingInAPI, id, 0, "person %d is in EAC.Person but not returned by the api", id)
+		}
+	}
+
+	return report, nil
+}
+
+// ReconcileHandler returns a ReconciliationReport
+func (s *Service) ReconcileHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	report, err := s.Reconcile()
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not reconcile: %w", err)}
+	}
+	return report, nil
+}
@@ -0,0 +1,377 @@
+package infinias
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+// DoorAccess is a door a person can use, and the schedule and group that grant it
+type DoorAccess struct {
+	DoorID     int    `json:"door_id"`
+	Door       string `json:"door"`
+	ScheduleID int    `json:"schedule_id,omitempty"`
+	Schedule   string `json:"schedule,omitempty"`
+	GroupID    int    `json:"group_id"`
+	Group      string `json:"group"`
+}
+
+// PersonAccessReport lists the doors a person can use
+type PersonAccessReport struct {
+	PersonID  int           `json:"person_id"`
+	FirstName string        `json:"first_name"`
+	LastName  string        `json:"last_name"`
+	Access    []*DoorAccess `json:"access"`
+}
+
+// PersonAccess is a person who can use a door, and the schedule and group that grant it
+type PersonAccess struct {
+	PersonID   int    `json:"person_id"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	ScheduleID int    `json:"schedule_id,omitempty"`
+	Schedule   string `json:"schedule,omitempty"`
+	GroupID    int    `json:"group_id"`
+	Group      string `json:"group"`
+}
+
+// DoorAccessReport lists the people who can use a door
+type DoorAccessReport struct {
+	DoorID int             `json:"door_id"`
+	Door   string          `json:"door"`
+	Access []*PersonAccess `json:"access"`
+}
+
+// AccessReportByPerson groups access grants by person
+func AccessReportByPerson(access []*db.Access) []*PersonAccessReport {
+	reports := make([]*PersonAccessReport, 0)
+	idx := make(map[int]*PersonAccessReport)
+	for _, a := range access {
+		r, ok := idx[a.PersonID]
+		if !ok {
+			r = &PersonAccessReport{PersonID: a.PersonID, FirstName: a.FirstName, LastName: a.LastName}
+			idx[a.PersonID] = r
+			reports = append(reports, r)
+		}
+		r.Access = append(r.Access, &DoorAccess{
+			DoorID:     a.DoorID,
+			Door:       a.Door,
+			ScheduleID: a.ScheduleID,
+			Schedule:   a.Schedule,
+			GroupID:    a.GroupID,
+			Group:      a.Group,
+		})
+	}
+	return reports
+}
+
+// AccessReportByDoor groups access grants by door
+func AccessReportByDoor(access []*db.Access) []*DoorAccessReport {
+	reports := make([]*DoorAccessReport, 0)
+	idx := make(map[int]*DoorAccessReport)
+	for _, a := range access {
+		r, ok := idx[a.DoorID]
+		if !ok {
+			r = &DoorAccessReport{DoorID: a.DoorID, Door: a.Door}
+			idx[a.DoorID] = r
+			reports = append(reports, r)
+		}
+		r.Access = append(r.Access, &PersonAccess{
+			PersonID:   a.PersonID,
+			FirstName:  a.FirstName,
+			LastName:   a.LastName,
+			ScheduleID: a.ScheduleID,
+			Schedule:   a.Schedule,
+			GroupID:    a.GroupID,
+			Group:      a.Group,
+		})
+	}
+	return reports
+}
+
+// ListAccessFromAPI returns the same access grants as db.Conn.ListAccess, computed from the Infinias API's access
+// privileges and group memberships. It reads each person's details, so it's much slower than the database
+func (s *Service) ListAccessFromAPI(q *db.AccessQuery) ([]*db.Access, error) {
+	privileges, err := s.APIConn.ListAccessPrivileges()
+	if err != nil {
+		return nil, fmt.Errorf("could not list access privileges: %w", err)
+	}
+	byGroup := make(map[int][]*api.AccessPrivilege)
+	for _, p := range privileges {
+		if q.DoorID == 0 || p.DoorID == q.DoorID {
+			byGroup[p.GroupID] = append(byGroup[p.GroupID], p)
+		}
+	}
+
+	var ids []int
+	if q.PersonID != 0 {
+		ids = []int{q.PersonID}
+	} else {
+		people, err := s.APIConn.ListPeople()
+		if err != nil {
+			return nil, fmt.Errorf("could not list people: %w", err)
+		}
+		for _, p := range people {
+			ids = append(ids, p.ID)
+		}
+	}
+
+	access := make([]*db.Access, 0)
+	for _, id := range ids {
+		p, err := s.APIConn.ReadPerson(id)
+		if err != nil {
+			if api.IsNotFoundError(err) && q.PersonID == 0 {
+				// deleted since it was listed
+				continue
+			}
+			return nil, fmt.Errorf("could not read person %d: %w", id, err)
+		}
+		for _, g := range p.Groups {
+			for _, priv := range byGroup[g.ID] {
+				access = append(access, &db.Access{
+					PersonID:   p.ID,
+					FirstName:  p.FirstName,
+					LastName:   p.LastName,
+					GroupID:    g.ID,
+					Group:      priv.Group,
+					DoorID:     priv.DoorID,
+					Door:       priv.Door,
+					ScheduleID: priv.ScheduleID,
+					Schedule:   priv.Schedule,
+				})
+			}
+		}
+	}
+
+	// match ListAccess's order
+	sort.SliceStable(access, func(i, j int) bool {
+		a, b := access[i], access[j]
+		if a.LastName != b.LastName {
+			return a.LastName < b.LastName
+		}
+		if a.FirstName != b.FirstName {
+			return a.FirstName < b.FirstName
+		}
+		if a.PersonID != b.PersonID {
+			return a.PersonID < b.PersonID
+		}
+		if a.Door != b.Door {
+			return a.Door < b.Door
+		}
+		return a.DoorID < b.DoorID
+	})
+
+	return access, nil
+}
+
+// AccessReportHandler returns the effective access matrix, grouped by person (the default) or by door with by=door.
+// The report can be limited with person_id and door_id. With source=api, it's computed from the Infinias API instead
+// of the database
+func (s *Service) AccessReportHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	by := r.URL.Query().Get("by")
+	if by != "" && by != "person" && by != "door" {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read by: must be person or door: %q", by)}
+	}
+	source := r.URL.Query().Get("source")
+	if source != "" && source != "db" && source != "api" {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read source: must be db or api: %q", source)}
+	}
+
+	query := new(db.AccessQuery)
+
+	var err error
+	if query.PersonID, err = readIntQuery(r, "person_id"); err != nil {
+		return nil, err
+	}
+	if query.DoorID, err = readIntQuery(r, "door_id"); err != nil {
+		return nil, err
+	}
+
+	var access []*db.Access
+	if source == "api" {
+		access, err = s.ListAccessFromAPI(query)
+	} else {
+		access, err = s.DBConn.ListAccess(query)
+	}
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list access: %w", err)}
+	}
+
+	if by == "door" {
+		return AccessReportByDoor(access), nil
+	}
+
+	return AccessReportByPerson(access), nil
+}
+
+// DefaultExpiringWithin is the window of ExpiringReportHandler if within isn't given
+const DefaultExpiringWithin = 30 * 24 * time.Hour
+
+// ExpiringCredential is a credential that expires soon, and its owner
+type ExpiringCredential struct {
+	PersonID   int         `json:"person_id"`
+	FirstName  string      `json:"first_name"`
+	LastName   string      `json:"last_name"`
+	Department string      `json:"department,omitempty"`
+	Credential *Credential `json:"credential"`
+}
+
+// parseWithin parses a duration like time.ParseDuration, also allowing whole days like 30d
+func parseWithin(str string) (time.Duration, error) {
+	if days := strings.TrimSuffix(str, "d"); days != str {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(str)
+}
+
+// ExpiringReportHandler returns active credentials that expire within the window given by within, e.g. 30d or 72h,
+// soonest first. within defaults to DefaultExpiringWithin
+func (s *Service) ExpiringReportHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	within := DefaultExpiringWithin
+	if str := r.URL.Query().Get("within"); str != "" {
+		d, err := parseWithin(str)
+		if err != nil || d <= 0 {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read within: invalid duration %q", str)}
+		}
+		within = d
+	}
+
+	creds, err := s.DBConn.ListExpiringCredentials(time.Now().Add(within))
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list expiring credentials: %w", err)}
+	}
+
+	report := make([]*ExpiringCredential, len(creds))
+	for idx, c := range creds {
+		cred := c.Credential
+		report[idx] = &ExpiringCredential{
+			PersonID:   c.PersonID,
+			FirstName:  c.FirstName,
+			LastName:   c.LastName,
+			Department: c.Department,
+			Credential: (*Credential)(&cred),
+		}
+	}
+
+	return report, nil
+}
+
+// DefaultInactiveDays is the number of days without activity of InactiveReportHandler if days isn't given
+const DefaultInactiveDays = 90
+
+// InactivePerson is a badge holder without recent access activity
+type InactivePerson struct {
+	PersonID   int    `json:"person_id"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Department string `json:"department,omitempty"`
+	// LastActivity is the time of the person's last access event, or nil if they have none
+	LastActivity *time.Time `json:"last_activity"`
+}
+
+// InactiveReportHandler returns people with an active credential and no access events in the last days days,
+// longest inactive first. days defaults to DefaultInactiveDays
+func (s *Service) InactiveReportHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	days, err := readIntQuery(r, "days")
+	if err != nil {
+		return nil, err
+	}
+	if days == 0 {
+		days = DefaultInactiveDays
+	}
+
+	people, err := s.DBConn.ListInactivePeople(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list inactive people: %w", err)}
+	}
+
+	report := make([]*InactivePerson, len(people))
+	for idx, p := range people {
+		report[idx] = (*InactivePerson)(p)
+	}
+
+	return report, nil
+}
+
+// DefaultMusterWindow is how far back MusterReportHandler looks for events if within isn't given
+const DefaultMusterWindow = 24 * time.Hour
+
+// MusterPerson is a person whose last event was at an entry door of a zone
+type MusterPerson struct {
+	PersonID   int       `json:"person_id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Department string    `json:"department,omitempty"`
+	DoorID     int       `json:"door_id"`
+	Door       string    `json:"door"`
+	Time       time.Time `json:"time"`
+}
+
+// MusterZone lists the people in an occupancy zone
+type MusterZone struct {
+	Name   string          `json:"name"`
+	Count  int             `json:"count"`
+	People []*MusterPerson `json:"people"`
+}
+
+// MusterReport is the result of MusterReportHandler
+type MusterReport struct {
+	Generated time.Time     `json:"generated"`
+	Since     time.Time     `json:"since"`
+	Zones     []*MusterZone `json:"zones"`
+}
+
+// MusterReportHandler returns everyone whose last event at an occupancy zone's doors, within the window given by within,
+// e.g. 12h or 1d, was at one of its entry doors, grouped by zone. within defaults to DefaultMusterWindow.
+// Unlike OccupancyHandler, it reads the event tables directly, so it doesn't depend on how long the service has been running
+func (s *Service) MusterReportHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	if s.Occupancy == nil {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: ErrOccupancyDisabled}
+	}
+
+	within := DefaultMusterWindow
+	if str := r.URL.Query().Get("within"); str != "" {
+		d, err := parseWithin(str)
+		if err != nil || d <= 0 {
+			return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read within: invalid duration %q", str)}
+		}
+		within = d
+	}
+
+	zones := s.Occupancy.Zones()
+	now := time.Now()
+	report := &MusterReport{Generated: now, Since: now.Add(-within), Zones: make([]*MusterZone, len(zones))}
+	for idx, z := range zones {
+		// each zone is queried separately so people in nested zones are listed in each of them
+		doors := append(append([]int(nil), z.EntryDoors...), z.ExitDoors...)
+		events, err := s.DBConn.ListLastDoorEvents(doors, s.Occupancy.EventTypes, report.Since)
+		if err != nil {
+			return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not list last door events for %s: %w", z.Name, err)}
+		}
+
+		mz := &MusterZone{Name: z.Name, People: make([]*MusterPerson, 0)}
+		for _, e := range events {
+			if containsInt(z.EntryDoors, e.DoorID) {
+				mz.People = append(mz.People, (*MusterPerson)(e))
+			}
+		}
+		mz.Count = len(mz.People)
+		report.Zones[idx] = mz
+	}
+
+	return report, nil
+}
@@ -0,0 +1,219 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+// TaskFunc is a scheduled task. Its result is reported in the task's status
+type TaskFunc func(ctx context.Context) (interface{}, error)
+
+// TaskStatus is the state of a scheduled task
+type TaskStatus struct {
+	Name         string      `json:"name"`
+	Schedule     string      `json:"schedule"`
+	Running      bool        `json:"running"`
+	NextRun      time.Time   `json:"next_run"`
+	LastRun      *time.Time  `json:"last_run,omitempty"`
+	LastDuration string      `json:"last_duration,omitempty"`
+	LastError    string      `json:"last_error,omitempty"`
+	LastResult   interface{} `json:"last_result,omitempty"`
+}
+
+type scheduledTask struct {
+	cron   *Cron
+	fn     TaskFunc
+	status TaskStatus
+}
+
+// Scheduler runs tasks on cron schedules. A task never overlaps with itself
+type Scheduler struct {
+	Log Logger
+
+	mu     sync.Mutex
+	tasks  []*scheduledTask
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler returns a new Scheduler
+func NewScheduler(log Logger) *Scheduler {
+	if log == nil {
+		log = NopLogger
+	}
+	return &Scheduler{Log: log}
+}
+
+// Add schedules fn to run on the given cron expression. Tasks must be added before Start is called
+func (s *Scheduler) Add(name, expr string, fn TaskFunc) error {
+	c, err := ParseCron(expr)
+	if err != nil {
+		return fmt.Errorf("could not parse schedule for %s: %w", name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = append(s.tasks, &scheduledTask{cron: c, fn: fn, status: TaskStatus{Name: name, Schedule: expr}})
+	return nil
+}
+
+// Start starts running tasks in the background
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go s.loop(ctx, t)
+	}
+}
+
+// Stop stops scheduling tasks and waits for running tasks to return
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, t *scheduledTask) {
+	defer s.wg.Done()
+	for {
+		next := t.cron.Next(time.Now())
+		if next.IsZero() {
+			s.Log.Warn("scheduled task will never run", "task", t.status.Name, "schedule", t.status.Schedule)
+			return
+		}
+
+		s.mu.Lock()
+		t.status.NextRun = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.run(ctx, t)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, t *scheduledTask) {
+	start := time.Now()
+	s.mu.Lock()
+	t.status.Running = true
+	s.mu.Unlock()
+
+	s.Log.Info("running scheduled task", "task", t.status.Name)
+	res, err := t.fn(ctx)
+	duration := time.Since(start)
+
+	s.mu.Lock()
+	t.status.Running = false
+	t.status.LastRun = &start
+	t.status.LastDuration = duration.String()
+	t.status.LastResult = res
+	t.status.LastError = ""
+	if err != nil {
+		t.status.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		s.Log.Error("scheduled task failed", "task", t.status.Name, "duration", duration, "error", err)
+		return
+	}
+	s.Log.Info("scheduled task finished", "task", t.status.Name, "duration", duration)
+}
+
+// Status returns the status of all tasks
+func (s *Scheduler) Status() []*TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]*TaskStatus, len(s.tasks))
+	for idx, t := range s.tasks {
+		status := t.status
+		statuses[idx] = &status
+	}
+	return statuses
+}
+
+// ScheduleHandler returns the status of all scheduled tasks
+func (s *Service) ScheduleHandler(r *http.Request) (interface{}, error) {
+	if s.Scheduler == nil {
+		return make([]*TaskStatus, 0), nil
+	}
+	return s.Scheduler.Status(), nil
+}
+
+// DirectorySyncTask returns a task that syncs people from the named directory
+func (s *Service) DirectorySyncTask(name string) TaskFunc {
+	return func(ctx context.Context) (interface{}, error) {
+		return s.SyncDirectory(ctx, name)
+	}
+}
+
+// ExpireCredentialsTask deactivates or deletes credentials whose expiration has passed with ProcessExpiredCredentials
+func (s *Service) ExpireCredentialsTask(ctx context.Context) (interface{}, error) {
+	return s.ProcessExpiredCredentials(ctx)
+}
+
+// CleanupOrphansTask deletes pictures and credentials left behind by deleted people
+func (s *Service) CleanupOrphansTask(ctx context.Context) (interface{}, error) {
+	s = s.WithContext(ctx)
+	o, err := s.DBConn.DeleteOrphans()
+	if err != nil {
+		return nil, fmt.Errorf("could not delete orphans: %w", err)
+	}
+	return map[string]int64{
+		"pictures":            o.Pictures,
+		"wiegand_credentials": o.WiegandCredentials,
+		"credentials":         o.Credentials,
+	}, nil
+}
+
+// AccessReportTask returns a task that writes the access report, grouped by person, to a timestamped JSON file in dir
+func (s *Service) AccessReportTask(dir string) TaskFunc {
+	return func(ctx context.Context) (interface{}, error) {
+		access, err := s.WithContext(ctx).DBConn.ListAccess(new(db.AccessQuery))
+		if err != nil {
+			return nil, fmt.Errorf("could not list access: %w", err)
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("access-report-%s.json", time.Now().Format("20060102-150405")))
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not create report: %w", err)
+		}
+
+		if err = json.NewEncoder(f).Encode(AccessReportByPerson(access)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not write report: %w", err)
+		}
+
+		if err = f.Close(); err != nil {
+			return nil, fmt.Errorf("could not write report: %w", err)
+		}
+
+		return map[string]string{"path": path}, nil
+	}
+}
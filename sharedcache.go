@@ -0,0 +1,133 @@
+package infinias
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sharedIdempotencyLockTTL is how long a key stays in progress, so a crashed instance doesn't block retries for the full TTL
+const sharedIdempotencyLockTTL = 5 * time.Minute
+
+// ErrCacheMiss is returned by SharedCache.Get for missing keys
+var ErrCacheMiss = errors.New("cache miss")
+
+// SharedCache is a key-value store shared between instances of the service, e.g. Redis
+type SharedCache interface {
+	// Get returns the value of key, or ErrCacheMiss if it doesn't exist
+	Get(key string) ([]byte, error)
+	// Set sets key to value. If ttl is positive, key expires after ttl
+	Set(key string, value []byte, ttl time.Duration) error
+	// SetNX sets key to value only if it doesn't exist, returning true if it was set
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	Delete(keys ...string) error
+}
+
+// SharedIdempotencyStore is an IdempotencyStore in a SharedCache, so a retried request is replayed
+// whichever instance it reaches
+type SharedIdempotencyStore struct {
+	cache  SharedCache
+	prefix string
+
+	mu  sync.Mutex
+	ttl time.Duration
+}
+
+// NewSharedIdempotencyStore returns a new SharedIdempotencyStore that keeps responses under prefix in cache for ttl
+func NewSharedIdempotencyStore(cache SharedCache, prefix string, ttl time.Duration) *SharedIdempotencyStore {
+	return &SharedIdempotencyStore{cache: cache, prefix: prefix + "idempotency:", ttl: ttl}
+}
+
+// SetTTL changes how long new responses are kept
+func (m *SharedIdempotencyStore) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ttl = ttl
+}
+
+func (m *SharedIdempotencyStore) getTTL() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ttl
+}
+
+type sharedIdempotencyEntry struct {
+	Fingerprint string              `json:"fingerprint"`
+	Response    *IdempotentResponse `json:"response,omitempty"`
+}
+
+func (m *SharedIdempotencyStore) read(key string) (*sharedIdempotencyEntry, error) {
+	buf, err := m.cache.Get(m.prefix + key)
+	if err != nil {
+		return nil, err
+	}
+	e := new(sharedIdempotencyEntry)
+	if err = json.Unmarshal(buf, e); err != nil {
+		return nil, fmt.Errorf("could not decode idempotency entry: %w", err)
+	}
+	return e, nil
+}
+
+func (m *SharedIdempotencyStore) Begin(key, fingerprint string) (*IdempotentResponse, error) {
+	buf, err := json.Marshal(&sharedIdempotencyEntry{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode idempotency entry: %w", err)
+	}
+
+	// the key can expire between SetNX and Get, so try again once
+	for i := 0; i < 2; i++ {
+		ok, err := m.cache.SetNX(m.prefix+key, buf, sharedIdempotencyLockTTL)
+		if err != nil {
+			return nil, fmt.Errorf("could not store idempotency key: %w", err)
+		}
+		if ok {
+			return nil, nil
+		}
+
+		e, err := m.read(key)
+		if errors.Is(err, ErrCacheMiss) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e.Fingerprint != fingerprint {
+			return nil, ErrIdempotencyMismatch
+		}
+		if e.Response == nil {
+			return nil, ErrIdempotencyInProgress
+		}
+		return e.Response, nil
+	}
+
+	return nil, ErrIdempotencyInProgress
+}
+
+func (m *SharedIdempotencyStore) Complete(key string, resp *IdempotentResponse) error {
+	e, err := m.read(key)
+	if errors.Is(err, ErrCacheMiss) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	e.Response = resp
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not encode idempotency entry: %w", err)
+	}
+	if err = m.cache.Set(m.prefix+key, buf, m.getTTL()); err != nil {
+		return fmt.Errorf("could not store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (m *SharedIdempotencyStore) Abort(key string) error {
+	if err := m.cache.Delete(m.prefix + key); err != nil {
+		return fmt.Errorf("could not delete idempotency key: %w", err)
+	}
+	return nil
+}
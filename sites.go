@@ -0,0 +1,78 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrInvalidSiteName = errors.New("invalid site name")
+
+var siteNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// SiteRouter serves several Infinias backends, one Service per site, selected by the path segment after the API version,
+// e.g. /api/1.0/{site}/people, /api/2.0/{site}/people, or /api/{site}/people. Other requests are served by the default Service
+type SiteRouter struct {
+	def   http.Handler
+	sites map[string]http.Handler
+}
+
+// NewSiteRouter returns a SiteRouter for def and sites, keyed by site name.
+// Site names can't be API versions or the first path segment of a route, e.g. people
+func NewSiteRouter(def *Service, sites map[string]*Service) (*SiteRouter, error) {
+	r := &SiteRouter{def: def.VersionedHandler(), sites: make(map[string]http.Handler, len(sites))}
+	for name, s := range sites {
+		if !siteNameRegexp.MatchString(name) || isReservedSiteName(name) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSiteName, name)
+		}
+		r.sites[name] = s.VersionedHandler()
+	}
+	return r, nil
+}
+
+// isReservedSiteName returns true if name would shadow an API version or route
+func isReservedSiteName(name string) bool {
+	if name == "1.0" || name == "2.0" {
+		return true
+	}
+	reserved := false
+	// routes don't depend on the Service's fields, so an empty one is enough to list them
+	new(Service).router().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if tmpl, err := route.GetPathTemplate(); err == nil && strings.Split(strings.TrimPrefix(tmpl, "/"), "/")[0] == name {
+			reserved = true
+		}
+		return nil
+	})
+	return reserved
+}
+
+func (sr *SiteRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	idx := 1
+	if len(parts) > 1 && (parts[1] == "1.0" || parts[1] == "2.0") {
+		idx = 2
+	}
+	if parts[0] != "api" || len(parts) <= idx {
+		sr.def.ServeHTTP(w, r)
+		return
+	}
+
+	h, ok := sr.sites[parts[idx]]
+	if !ok {
+		sr.def.ServeHTTP(w, r)
+		return
+	}
+
+	// strip the site so the site's Service sees a normal API path
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path = "/" + strings.Join(append(parts[:idx:idx], parts[idx+1:]...), "/")
+	u.RawPath = ""
+	r2.URL = &u
+	h.ServeHTTP(w, r2)
+}
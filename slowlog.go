@@ -0,0 +1,115 @@
+package infinias
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSlowQueryLength is the longest statement logged by SlowLog
+const maxSlowQueryLength = 500
+
+// SlowLog logs and counts HTTP requests and SQL statements that take longer than their thresholds.
+// A zero threshold disables logging for that kind. A nil *SlowLog is valid and logs nothing
+type SlowLog struct {
+	RequestThreshold time.Duration
+	QueryThreshold   time.Duration
+	Log              Logger
+
+	requests uint64
+	queries  uint64
+}
+
+// NewSlowLog returns a new SlowLog
+func NewSlowLog(requestThreshold, queryThreshold time.Duration, logger Logger) *SlowLog {
+	return &SlowLog{RequestThreshold: requestThreshold, QueryThreshold: queryThreshold, Log: logger}
+}
+
+func (l *SlowLog) logger() Logger {
+	if l.Log == nil {
+		return NopLogger
+	}
+	return l.Log
+}
+
+// Counts returns the number of slow requests and statements logged
+func (l *SlowLog) Counts() (requests, queries uint64) {
+	if l == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&l.requests), atomic.LoadUint64(&l.queries)
+}
+
+type slowRouteKey struct{}
+
+// slowRoute is the request a statement was executed for
+type slowRoute struct {
+	route    string
+	personID string
+}
+
+// routeInfo returns the route template and person ID of r, if any
+func routeInfo(r *http.Request) *slowRoute {
+	info := &slowRoute{route: r.URL.Path}
+	if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+		info.route = tmpl
+		if strings.HasPrefix(tmpl, "/people/{id}") {
+			info.personID = mux.Vars(r)["id"]
+		}
+	}
+	return info
+}
+
+// Middleware logs requests that take longer than l.RequestThreshold. It must be used on a mux.Router so routes are known
+func (l *SlowLog) Middleware(next http.Handler) http.Handler {
+	if l == nil || (l.RequestThreshold <= 0 && l.QueryThreshold <= 0) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := routeInfo(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), slowRouteKey{}, info)))
+
+		d := time.Since(start)
+		if l.RequestThreshold <= 0 || d < l.RequestThreshold {
+			return
+		}
+		atomic.AddUint64(&l.requests, 1)
+		kv := []interface{}{"method", r.Method, "route", info.route, "duration", d, "request_id", RequestIDFromContext(r.Context())}
+		if info.personID != "" {
+			kv = append(kv, "person_id", info.personID)
+		}
+		l.logger().Warn("slow request", kv...)
+	})
+}
+
+// ObserveQuery logs statements that take longer than l.QueryThreshold. It's a tracing.QueryObserver
+func (l *SlowLog) ObserveQuery(ctx context.Context, op, query string, d time.Duration, err error) {
+	if l == nil || l.QueryThreshold <= 0 || d < l.QueryThreshold {
+		return
+	}
+	atomic.AddUint64(&l.queries, 1)
+
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > maxSlowQueryLength {
+		query = query[:maxSlowQueryLength] + "..."
+	}
+	kv := []interface{}{"op", op, "duration", d, "query", query}
+	if id := RequestIDFromContext(ctx); id != "" {
+		kv = append(kv, "request_id", id)
+	}
+	if info, ok := ctx.Value(slowRouteKey{}).(*slowRoute); ok {
+		kv = append(kv, "route", info.route)
+		if info.personID != "" {
+			kv = append(kv, "person_id", info.personID)
+		}
+	}
+	if err != nil {
+		kv = append(kv, "error", err)
+	}
+	l.logger().Warn("slow query", kv...)
+}
@@ -0,0 +1,100 @@
+package infinias
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownSortField = errors.New("unknown sort field")
+
+// personSortFields compares two people by a single field, returning <0, 0, or >0
+var personSortFields = map[string]func(a, b *Person) int{
+	"id":          func(a, b *Person) int { return a.ID - b.ID },
+	"first_name":  func(a, b *Person) int { return compareFold(a.FirstName, b.FirstName) },
+	"last_name":   func(a, b *Person) int { return compareFold(a.LastName, b.LastName) },
+	"employee_id": func(a, b *Person) int { return compareFold(a.EmployeeID, b.EmployeeID) },
+	"department":  func(a, b *Person) int { return compareFold(a.Department, b.Department) },
+	"site_code":   func(a, b *Person) int { return a.SiteCode - b.SiteCode },
+	"card_code":   func(a, b *Person) int { return a.CardCode - b.CardCode },
+	"has_image": func(a, b *Person) int {
+		if a.HasImage == b.HasImage {
+			return 0
+		}
+		if b.HasImage {
+			return -1
+		}
+		return 1
+	},
+}
+
+func compareFold(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// SortKey is a field to sort by
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// ParseSort parses a comma-separated list of person fields, each optionally prefixed with - for descending order
+func ParseSort(str string) ([]*SortKey, error) {
+	var keys []*SortKey
+	for _, f := range strings.Split(str, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		key := &SortKey{Field: f}
+		if strings.HasPrefix(f, "-") {
+			key.Field, key.Descending = f[1:], true
+		}
+		if _, ok := personSortFields[key.Field]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownSortField, key.Field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseSortQuery parses a sort query parameter with ParseSort, returning an *HTTPError. Fields in hidden are rejected
+// with a 403, since the order of results would reveal them
+func parseSortQuery(str string, hidden map[string]bool) ([]*SortKey, error) {
+	keys, err := ParseSort(str)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read sort: %w", err)}
+	}
+	for _, k := range keys {
+		if err = hiddenFieldError(hidden, k.Field); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// SortPeople sorts people by keys in order. Ties are broken by id so results are stable across requests
+func SortPeople(people []*Person, keys []*SortKey) {
+	if len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(people, func(i, j int) bool {
+		return comparePeople(people[i], people[j], keys) < 0
+	})
+}
+
+// comparePeople compares a and b by keys in order, breaking ties by id
+func comparePeople(a, b *Person, keys []*SortKey) int {
+	for _, k := range keys {
+		c := personSortFields[k.Field](a, b)
+		if k.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return a.ID - b.ID
+}
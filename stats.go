@@ -0,0 +1,134 @@
+package infinias
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// GroupCount is the number of members of a group
+type GroupCount struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	People int    `json:"people"`
+}
+
+// CredentialStats counts credentials by status. Credentials are counted once, in the first status that applies:
+// inactive, pending (before its activation), expired, then active
+type CredentialStats struct {
+	Total    int `json:"total"`
+	Active   int `json:"active"`
+	Inactive int `json:"inactive"`
+	Pending  int `json:"pending"`
+	Expired  int `json:"expired"`
+}
+
+// Stats summarizes the people in Infinias
+type Stats struct {
+	People int `json:"people"`
+	// Departments counts people by department. People without a department are counted under ""
+	Departments map[string]int `json:"departments"`
+	Groups      []*GroupCount  `json:"groups"`
+	// NoGroup is the number of people that aren't in any group
+	NoGroup        int              `json:"no_group"`
+	WithPicture    int              `json:"with_picture"`
+	WithoutPicture int              `json:"without_picture"`
+	Credentials    *CredentialStats `json:"credentials"`
+	// NoCredential is the number of people without any credentials
+	NoCredential int `json:"no_credential"`
+}
+
+// hasWorkingCredential returns true if any of p's credentials is active at now
+func (p *Person) hasWorkingCredential(now time.Time) bool {
+	for _, c := range p.Credentials {
+		if credentialStatus(c, now) == "active" {
+			return true
+		}
+	}
+	return false
+}
+
+// credentialStatus returns the status c is counted under in CredentialStats at now
+func credentialStatus(c *Credential, now time.Time) string {
+	switch {
+	case !c.Active:
+		return "inactive"
+	case c.Activation != nil && now.Before(*c.Activation):
+		return "pending"
+	case c.Expiration != nil && !now.Before(*c.Expiration):
+		return "expired"
+	}
+	return "active"
+}
+
+// Stats returns counts of people by department, group, credential status, and picture presence
+func (s *Service) Stats() (*Stats, error) {
+	people, err := s.cachedPeople()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.cachedGroups()
+	if err != nil {
+		return nil, err
+	}
+	memberships, err := s.DBConn.ListGroupMemberships()
+	if err != nil {
+		return nil, fmt.Errorf("could not list group memberships: %w", err)
+	}
+
+	now := time.Now()
+	st := &Stats{People: len(people), Departments: make(map[string]int), Credentials: new(CredentialStats)}
+	groupCounts := make(map[int]int)
+	for _, p := range people {
+		st.Departments[p.Department]++
+
+		if p.HasImage {
+			st.WithPicture++
+		} else {
+			st.WithoutPicture++
+		}
+
+		if len(memberships[p.ID]) == 0 {
+			st.NoGroup++
+		}
+		for _, id := range memberships[p.ID] {
+			groupCounts[id]++
+		}
+
+		if len(p.Credentials) == 0 {
+			st.NoCredential++
+		}
+		for _, c := range p.Credentials {
+			st.Credentials.Total++
+			switch credentialStatus(c, now) {
+			case "inactive":
+				st.Credentials.Inactive++
+			case "pending":
+				st.Credentials.Pending++
+			case "expired":
+				st.Credentials.Expired++
+			default:
+				st.Credentials.Active++
+			}
+		}
+	}
+
+	st.Groups = make([]*GroupCount, len(groups))
+	for idx, g := range groups {
+		st.Groups[idx] = &GroupCount{ID: g.ID, Name: g.Name, People: groupCounts[g.ID]}
+	}
+	sort.Slice(st.Groups, func(i, j int) bool { return st.Groups[i].ID < st.Groups[j].ID })
+
+	return st, nil
+}
+
+// StatsHandler returns Stats
+func (s *Service) StatsHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	st, err := s.Stats()
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not compute stats: %w", err)}
+	}
+	return st, nil
+}
@@ -1,14 +1,22 @@
 package infinias
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/korylprince/go-infinias-api/api"
 	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/photo"
 )
 
-var ErrInvalidID = errors.New("invalid id")
+var (
+	ErrInvalidID        = errors.New("invalid id")
+	ErrInvalidGroupName = errors.New("invalid group name")
+)
 
 type Person struct {
 	ID          int           `json:"id"`
@@ -22,21 +30,331 @@ type Person struct {
 	HasImage    bool          `json:"has_image"`
 	GroupsToAdd []int         `json:"groups_to_add,omitempty"`
 	Credentials []*Credential `json:"credentials,omitempty"`
+	// Card, if set, is converted to SiteCode and CardCode when the person is saved
+	Card *CardInput `json:"card,omitempty"`
+	// TerminationDate, if set, is when the person is deactivated by WatchTerminations. Requires Service.Terminations
+	TerminationDate *time.Time `json:"termination_date,omitempty"`
 }
 
 type Group struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
 }
 
 type Service struct {
-	APIConn *api.Conn
-	DBConn  *db.Conn
-	Log     func(string)
-	APIKey  string
+	APIConn     *api.Conn
+	DBConn      *db.Conn
+	Log         Logger
+	APIKeys     []*APIKey
+	OIDC        *OIDC
+	ClientCerts []*ClientCert
+	Audit       AuditLog
+	MaxBodySize int64
+	Idempotency IdempotencyStore
+	ImageLimits *photo.Limits
+	// ImageNormalization, if set, converts uploaded images to upright, resized JPEGs before storing them
+	ImageNormalization *photo.NormalizeOptions
+	Webhooks           *Webhooks
+	Events             *EventStream
+	Validation         *PersonValidation
+	Thumbnails         *ThumbnailCache
+	Directories        map[string]*Directory
+	Scheduler          *Scheduler
+	Jobs               *JobManager
+	Alerts             *Alerts
+	// ImageCompression, if set and ImageNormalization isn't, normalizes uploaded images larger than its MaxBytes
+	ImageCompression *photo.NormalizeOptions
+	// Pictures stores people's pictures. If nil, pictures are stored in the database
+	Pictures photo.Store
+	// EmployeeIndex, if set, is used to look up people by employee ID
+	EmployeeIndex *EmployeeIndex
+	// Breaker, if set, is the circuit breaker for APIConn. Lists are served from the last good result while it's open
+	Breaker *Breaker
+	// AuthLimiter, if set, throttles failed authorization attempts
+	AuthLimiter *AuthLimiter
+	// Keys, if set, holds API keys added at runtime and revocations of keys in APIKeys
+	Keys *KeyStore
+	// SlowLog, if set, logs slow requests. Slow statements are only logged if DBConn was opened with SlowLog.ObserveQuery
+	SlowLog *SlowLog
+	// Watchdog, if set, is reported by HealthHandler
+	Watchdog *Watchdog
+	// Terminations, if set, holds people's termination dates
+	Terminations *TerminationStore
+	// Occupancy, if set, counts the people in zones from access events
+	Occupancy *Occupancy
+	// Visitors, if set, holds visitors and the credential pool they're assigned from
+	Visitors *VisitorStore
+	// TemporaryCredentials, if set, holds credentials that are deleted instead of deactivated when they expire
+	TemporaryCredentials *TemporaryCredentialStore
+	// APIBudget, if set, limits calls to APIConn. It must be the budget APIConn's transport was created with
+	APIBudget *APIBudget
+	// ListCache, if set, serves interactive people and group lists
+	ListCache *ListCache
+	// Publisher, if set, publishes the events sent to webhooks to a message broker
+	Publisher *Publisher
+	// AuditRetention, if set, is how long Audit keeps entries. Older entries are deleted by PruneAudit
+	AuditRetention time.Duration
+	// Roles are the roles principals can be given with a RolePrefix scope, by name
+	Roles map[string]*Role
+	// Usage, if set, counts requests by principal
+	Usage *Usage
+	// PendingWrites, if set, holds pictures and credentials of created people that are being retried after failing
+	PendingWrites *PendingWriteStore
+	// RollbackFailedCreates, if set, deletes created people whose picture, credentials, or termination date couldn't be
+	// saved and weren't queued in PendingWrites, so failed creates don't leave people without their badges
+	RollbackFailedCreates bool
+
+	ctx context.Context
+}
+
+// WithContext returns a shallow copy of s whose API and database connections make requests with ctx
+func (s *Service) WithContext(ctx context.Context) *Service {
+	s2 := *s
+	s2.ctx = ctx
+	if s.APIConn != nil {
+		s2.APIConn = s.APIConn.WithContext(ctx)
+	}
+	if s.DBConn != nil {
+		s2.DBConn = s.DBConn.WithContext(ctx)
+	}
+	return &s2
+}
+
+func (s *Service) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// parallel runs fns concurrently and returns the first error. Each fn is passed a copy of s bound to a context
+// that's canceled as soon as any fn fails
+func (s *Service) parallel(fns ...func(s *Service) error) error {
+	ctx, cancel := context.WithCancel(s.context())
+	defer cancel()
+	s = s.WithContext(ctx)
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	wg.Add(len(fns))
+	for _, fn := range fns {
+		go func(fn func(s *Service) error) {
+			defer wg.Done()
+			if err := fn(s); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(fn)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// prepareImage validates buf and normalizes it if configured, returning the image to store
+func (s *Service) prepareImage(buf []byte) ([]byte, error) {
+	if _, err := photo.Validate(buf, s.ImageLimits); err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+
+	if s.ImageNormalization == nil {
+		out, err := photo.Compress(buf, s.ImageCompression)
+		if err != nil {
+			return nil, fmt.Errorf("could not compress image: %w", err)
+		}
+		return out, nil
+	}
+
+	out, err := photo.Normalize(buf, s.ImageNormalization)
+	if err != nil {
+		return nil, fmt.Errorf("could not normalize image: %w", err)
+	}
+
+	return out, nil
+}
+
+// CredentialError is an additional credential that couldn't be created
+type CredentialError struct {
+	SiteCode int
+	CardCode int
+	Err      error
+}
+
+func (e *CredentialError) Error() string {
+	return fmt.Sprintf("could not create credential (%d-%d): %v", e.SiteCode, e.CardCode, e.Err)
+}
+
+func (e *CredentialError) Unwrap() error {
+	return e.Err
 }
 
+// PartialError is returned by CreatePerson and UpdatePerson when the person was saved, but writing the picture,
+// creating some of the additional credentials, or saving the termination date failed
+type PartialError struct {
+	PersonID        int
+	Picture         error
+	Credentials     []*CredentialError
+	TerminationDate error
+	// JobID, if set, is the job retrying the picture and credentials that were queued in Pending
+	JobID   string
+	Pending *PendingWrite
+
+	// createdCredentials are the ids of the additional credentials that were created
+	createdCredentials []int
+}
+
+// failed returns true if anything that failed wasn't queued for retry
+func (e *PartialError) failed() bool {
+	return e.Picture != nil || len(e.Credentials) > 0 || e.TerminationDate != nil
+}
+
+// messages returns the messages of what failed
+func (e *PartialError) messages() []string {
+	var msgs []string
+	if e.Picture != nil {
+		msgs = append(msgs, e.Picture.Error())
+	}
+	for _, c := range e.Credentials {
+		msgs = append(msgs, c.Error())
+	}
+	if e.TerminationDate != nil {
+		msgs = append(msgs, e.TerminationDate.Error())
+	}
+	if e.JobID != "" {
+		msgs = append(msgs, fmt.Sprintf("writes were queued for retry by job %s", e.JobID))
+	}
+	return msgs
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("person %d was saved, but: %s", e.PersonID, strings.Join(e.messages(), "; "))
+}
+
+// Unwrap returns the picture error, the first credential error if the picture was written,
+// or the termination date error if both were saved
+func (e *PartialError) Unwrap() error {
+	if e.Picture != nil {
+		return e.Picture
+	}
+	if len(e.Credentials) > 0 {
+		return e.Credentials[0]
+	}
+	return e.TerminationDate
+}
+
+// RolledBackError is returned by CreatePerson when s.RollbackFailedCreates is set and the person was created, but
+// writing the picture, creating some of the additional credentials, or saving the termination date failed, so the
+// person was deleted again. Partial is what failed
+type RolledBackError struct {
+	Partial *PartialError
+}
+
+func (e *RolledBackError) Error() string {
+	return fmt.Sprintf("person was deleted after it was created: %s", strings.Join(e.Partial.messages(), "; "))
+}
+
+// Unwrap returns what Partial unwraps to, so a rolled back person isn't mistaken for a saved one
+func (e *RolledBackError) Unwrap() error {
+	return e.Partial.Unwrap()
+}
+
+// credentialChanged returns true if saving cred would change stored, the existing credential with the same codes
+func credentialChanged(stored *db.Credential, cred *Credential) bool {
+	timeChanged := func(stored, t *time.Time, clear bool) bool {
+		if clear {
+			return stored != nil
+		}
+		return t != nil && (stored == nil || !stored.Equal(*t))
+	}
+	return stored.Active != cred.Active ||
+		timeChanged(stored.Activation, cred.Activation, cred.ClearActivation) ||
+		timeChanged(stored.Expiration, cred.Expiration, cred.ClearExpiration)
+}
+
+// savePictureAndCredentials writes p's picture, if any, creates or updates its additional credentials that are new or
+// changed, and saves its termination date, if any, for the person with id. Each is attempted even if the others fail.
+// If any fail, a *PartialError is returned
+func (s *Service) savePictureAndCredentials(id int, p *Person) error {
+	partial := &PartialError{PersonID: id}
+
+	if len(p.Image) != 0 {
+		if err := s.pictures().Write(id, p.Image); err != nil {
+			partial.Picture = fmt.Errorf("could not update picture: %w", err)
+		} else {
+			s.Thumbnails.Invalidate(id)
+			s.notify(EventPictureUpdated, &pictureEvent{PersonID: id})
+		}
+	}
+
+	// if the stored credentials can't be listed, every credential is written
+	stored := make(map[[2]int]*db.Credential)
+	if len(p.Credentials) > 0 {
+		creds, err := s.DBConn.ListCredentials(id)
+		if err != nil {
+			s.logger().Warn("could not list credentials", "id", id, "error", err)
+		}
+		for _, c := range creds {
+			stored[[2]int{c.SiteCode, c.CardCode}] = c
+		}
+	}
+
+	for _, cred := range p.Credentials {
+		if cred.SiteCode == p.SiteCode && cred.CardCode == p.CardCode {
+			continue
+		}
+		existing, ok := stored[[2]int{cred.SiteCode, cred.CardCode}]
+		if ok && !credentialChanged(existing, cred) {
+			continue
+		}
+
+		credID, err := s.DBConn.CreateCredential(id, (*db.Credential)(cred))
+		if err != nil {
+			partial.Credentials = append(partial.Credentials, &CredentialError{SiteCode: cred.SiteCode, CardCode: cred.CardCode, Err: err})
+			continue
+		}
+		if !ok {
+			partial.createdCredentials = append(partial.createdCredentials, credID)
+		}
+		s.notify(EventCredentialCreated, &credentialEvent{PersonID: id, Credential: cred})
+	}
+
+	if p.TerminationDate != nil {
+		if err := s.Terminations.Set(id, *p.TerminationDate); err != nil {
+			partial.TerminationDate = fmt.Errorf("could not save termination date: %w", err)
+		}
+	}
+
+	if partial.failed() {
+		return partial
+	}
+	return nil
+}
+
+// CreatePerson creates p and returns its id. If the person is created but its picture or credentials aren't,
+// the id is returned with a *PartialError. If s.PendingWrites is set, the picture and credentials that failed are
+// queued for retry, and the *PartialError's JobID is set. Otherwise, if s.RollbackFailedCreates is set, the person
+// is deleted and a *RolledBackError is returned
 func (s *Service) CreatePerson(p *Person) (int, error) {
+	if err := p.resolveCard(); err != nil {
+		return 0, err
+	}
+	if err := s.ValidatePerson(p); err != nil {
+		return 0, err
+	}
+	if p.Image != nil {
+		buf, err := s.prepareImage(p.Image)
+		if err != nil {
+			return 0, err
+		}
+		p.Image = buf
+	}
+
 	id, err := s.APIConn.CreatePerson(&api.Person{
 		FirstName:   p.FirstName,
 		LastName:    p.LastName,
@@ -50,43 +368,80 @@ func (s *Service) CreatePerson(p *Person) (int, error) {
 		return 0, fmt.Errorf("could not create person: %w", err)
 	}
 
-	if p.Image == nil {
-		return id, nil
-	}
+	s.EmployeeIndex.Set(id, p.EmployeeID)
 
-	if err = s.DBConn.UpdatePicture(id, p.Image); err != nil {
-		return 0, fmt.Errorf("could not update picture: %w", err)
-	}
+	created := *p
+	created.ID = id
+	s.notify(EventPersonCreated, newPersonEvent(&created))
 
-	for _, cred := range p.Credentials {
-		if cred.SiteCode == p.SiteCode && cred.CardCode == p.CardCode {
-			continue
-		}
+	err = s.savePictureAndCredentials(id, p)
+	partial := new(PartialError)
+	if !errors.As(err, &partial) {
+		return id, err
+	}
 
-		if _, err := s.DBConn.CreateCredential(id, (*db.Credential)(cred)); err != nil {
-			return 0, fmt.Errorf("could not create credential (%d-%d): %w", cred.SiteCode, cred.CardCode, err)
+	if s.RollbackFailedCreates && !(s.queuesWrites() && partial.retriable()) {
+		if rbErr := s.rollbackCreate(id, partial); rbErr != nil {
+			s.logger().Error("could not roll back created person", "id", id, "credentials", partial.createdCredentials, "error", rbErr)
+			return id, partial
 		}
+		return 0, &RolledBackError{Partial: partial}
 	}
 
-	return id, nil
+	s.queueWrites(p, partial)
+	return id, partial
 }
 
-func (s *Service) ReadPerson(id int) (*Person, error) {
-	p, err := s.APIConn.ReadPerson(id)
-	if err != nil {
-		return nil, fmt.Errorf("could not read person: %w", err)
+// rollbackCreate deletes the person with id, then the additional credentials in partial that were created for it.
+// The person is deleted first so a failure never leaves it without the credentials it was created with.
+// Credentials are deleted by id alone, since deleting the person may have detached them from it.
+// An error is only returned if the person wasn't deleted. Credentials that couldn't be deleted are logged
+func (s *Service) rollbackCreate(id int, partial *PartialError) error {
+	if err := s.DeletePerson(id); err != nil {
+		return err
 	}
 
-	buf, err := s.DBConn.ReadPicture(id)
-	if err != nil {
-		if err != db.ErrNotFound {
-			return nil, fmt.Errorf("could not read picture: %w", err)
+	var failed []int
+	var lastErr error
+	for _, credID := range partial.createdCredentials {
+		if err := s.DBConn.DeleteCredentialByID(credID); err != nil && !errors.Is(err, db.ErrNotFound) {
+			failed = append(failed, credID)
+			lastErr = err
 		}
 	}
+	if len(failed) > 0 {
+		s.logger().Error("could not delete credentials of rolled back person", "id", id, "credentials", failed, "error", lastErr)
+	}
+	return nil
+}
 
-	creds, err := s.DBConn.ListCredentials(id)
+func (s *Service) ReadPerson(id int) (*Person, error) {
+	var (
+		p     *api.Person
+		buf   []byte
+		creds []*db.Credential
+	)
+	err := s.parallel(func(s *Service) error {
+		var err error
+		if p, err = s.APIConn.ReadPerson(id); err != nil {
+			return fmt.Errorf("could not read person: %w", err)
+		}
+		return nil
+	}, func(s *Service) error {
+		var err error
+		if buf, err = s.pictures().Read(id); err != nil && !errors.Is(err, photo.ErrNotFound) {
+			return fmt.Errorf("could not read picture: %w", err)
+		}
+		return nil
+	}, func(s *Service) error {
+		var err error
+		if creds, err = s.DBConn.ListCredentials(id); err != nil {
+			return fmt.Errorf("could not read credentials: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not read credentials: %w", err)
+		return nil, err
 	}
 
 	newcreds := make([]*Credential, len(creds))
@@ -95,23 +450,38 @@ func (s *Service) ReadPerson(id int) (*Person, error) {
 	}
 
 	return &Person{
-		ID:          p.ID,
-		FirstName:   p.FirstName,
-		LastName:    p.LastName,
-		EmployeeID:  p.EmployeeID,
-		Department:  p.Department,
-		SiteCode:    p.SiteCode,
-		CardCode:    p.CardCode,
-		HasImage:    len(buf) != 0,
-		Image:       buf,
-		Credentials: newcreds,
+		ID:              p.ID,
+		FirstName:       p.FirstName,
+		LastName:        p.LastName,
+		EmployeeID:      p.EmployeeID,
+		Department:      p.Department,
+		SiteCode:        p.SiteCode,
+		CardCode:        p.CardCode,
+		HasImage:        len(buf) != 0,
+		Image:           buf,
+		Credentials:     newcreds,
+		TerminationDate: s.Terminations.Get(p.ID),
 	}, nil
 }
 
+// UpdatePerson updates p. If the person is updated but its picture or credentials aren't, a *PartialError is returned
 func (s *Service) UpdatePerson(p *Person) error {
 	if p.ID == 0 {
 		return ErrInvalidID
 	}
+	if err := p.resolveCard(); err != nil {
+		return err
+	}
+	if err := s.ValidatePersonUpdate(p); err != nil {
+		return err
+	}
+	if len(p.Image) != 0 {
+		buf, err := s.prepareImage(p.Image)
+		if err != nil {
+			return err
+		}
+		p.Image = buf
+	}
 	if err := s.APIConn.UpdatePerson(&api.Person{
 		ID:          p.ID,
 		FirstName:   p.FirstName,
@@ -125,41 +495,93 @@ func (s *Service) UpdatePerson(p *Person) error {
 		return fmt.Errorf("could not update person: %w", err)
 	}
 
-	if len(p.Image) == 0 {
-		return nil
-	}
-
-	if err := s.DBConn.UpdatePicture(p.ID, p.Image); err != nil {
-		return fmt.Errorf("could not update picture: %w", err)
+	// empty fields aren't changed by the API
+	if p.EmployeeID != "" {
+		s.EmployeeIndex.Set(p.ID, p.EmployeeID)
 	}
 
-	for _, cred := range p.Credentials {
-		if cred.SiteCode == p.SiteCode && cred.CardCode == p.CardCode {
-			continue
-		}
-
-		if _, err := s.DBConn.CreateCredential(p.ID, (*db.Credential)(cred)); err != nil {
-			return fmt.Errorf("could not create credential (%d-%d): %w", cred.SiteCode, cred.CardCode, err)
-		}
-	}
+	s.notify(EventPersonUpdated, newPersonEvent(p))
 
-	return nil
+	return s.savePictureAndCredentials(p.ID, p)
 }
 
 func (s *Service) DeletePerson(id int) error {
 	if err := s.APIConn.DeletePerson(id); err != nil {
 		return fmt.Errorf("could not delete person: %w", err)
 	}
+	s.EmployeeIndex.Delete(id)
+	if err := s.pictures().Delete(id); err != nil {
+		s.logger().Warn("could not delete picture", "id", id, "error", err)
+	}
+	if err := s.Terminations.Delete(id); err != nil {
+		s.logger().Warn("could not delete termination date", "id", id, "error", err)
+	}
+	s.Thumbnails.Invalidate(id)
+	s.notify(EventPersonDeleted, &personDeletedEvent{ID: id})
 	return nil
 }
 
+// Deactivation is the result of DeactivatePerson
+type Deactivation struct {
+	PersonID               int   `json:"person_id"`
+	CredentialsDeactivated []int `json:"credentials_deactivated"`
+	GroupsRemoved          []int `json:"groups_removed,omitempty"`
+}
+
+// DeactivatePerson deactivates all of a person's credentials and, if removeGroups is true, removes them from all groups.
+// Groups are removed first, outside the transaction that deactivates the credentials so Infinias isn't kept waiting
+// on its locks, and added back if the credentials can't be deactivated
+func (s *Service) DeactivatePerson(id int, removeGroups bool) (*Deactivation, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+
+	p, err := s.APIConn.ReadPerson(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not read person: %w", err)
+	}
+
+	d := &Deactivation{PersonID: id}
+	if removeGroups && len(p.Groups) > 0 {
+		for _, g := range p.Groups {
+			d.GroupsRemoved = append(d.GroupsRemoved, g.ID)
+		}
+		if err = s.APIConn.UpdatePerson(&api.Person{ID: id, GroupsToRemove: d.GroupsRemoved}); err != nil {
+			return nil, fmt.Errorf("could not remove groups: %w", err)
+		}
+	}
+
+	ids, err := s.DBConn.DeactivateCredentials(id)
+	if err != nil {
+		if len(d.GroupsRemoved) > 0 {
+			if addErr := s.APIConn.UpdatePerson(&api.Person{ID: id, GroupsToAdd: d.GroupsRemoved}); addErr != nil {
+				s.logger().Error("could not add back removed groups", "id", id, "groups", d.GroupsRemoved, "error", addErr)
+			}
+		}
+		return nil, fmt.Errorf("could not deactivate credentials: %w", err)
+	}
+
+	d.CredentialsDeactivated = ids
+	if d.CredentialsDeactivated == nil {
+		d.CredentialsDeactivated = make([]int, 0)
+	}
+
+	s.notify(EventPersonDeactivated, d)
+
+	return d, nil
+}
+
 func (s *Service) ListPeople() ([]*Person, error) {
 	apiPeople, err := s.APIConn.ListPeople()
+	if v, ok := s.Breaker.recall("people", err); ok {
+		apiPeople, err = v.([]*api.Person), nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not list people: %w", err)
 	}
+	s.Breaker.remember("people", apiPeople)
 
-	ids, err := s.DBConn.HasPictureIDs()
+	ids, err := s.pictures().IDs()
 	if err != nil {
 		return nil, fmt.Errorf("could not list picture ids: %w", err)
 	}
@@ -192,29 +614,99 @@ func (s *Service) ListPeople() ([]*Person, error) {
 		}
 
 		people[idx] = &Person{
-			ID:          p.ID,
-			FirstName:   p.FirstName,
-			LastName:    p.LastName,
-			EmployeeID:  p.EmployeeID,
-			Department:  depts[p.ID],
-			SiteCode:    p.SiteCode,
-			CardCode:    p.CardCode,
-			HasImage:    ok,
-			Credentials: newcreds,
+			ID:              p.ID,
+			FirstName:       p.FirstName,
+			LastName:        p.LastName,
+			EmployeeID:      p.EmployeeID,
+			Department:      depts[p.ID],
+			SiteCode:        p.SiteCode,
+			CardCode:        p.CardCode,
+			HasImage:        ok,
+			Credentials:     newcreds,
+			TerminationDate: s.Terminations.Get(p.ID),
 		}
 	}
 
+	s.EmployeeIndex.Replace(people)
+	s.ListCache.setPeople(people)
+
 	return people, nil
 }
 
 func (s *Service) ListGroups() ([]*Group, error) {
 	apiGroups, err := s.APIConn.ListGroups()
+	if v, ok := s.Breaker.recall("groups", err); ok {
+		apiGroups, err = v.([]*api.Group), nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("could not list groups: %w", err)
 	}
+	s.Breaker.remember("groups", apiGroups)
 
 	groups := make([]*Group, len(apiGroups))
 	for idx, g := range apiGroups {
+		groups[idx] = &Group{
+			ID:          g.ID,
+			Name:        g.Name,
+			Description: g.Description,
+		}
+	}
+	s.ListCache.setGroups(groups)
+
+	return groups, nil
+}
+
+func (s *Service) CreateGroup(g *Group) (int, error) {
+	if g.Name == "" {
+		return 0, ErrInvalidGroupName
+	}
+
+	id, err := s.APIConn.CreateGroup(&api.Group{Name: g.Name, Description: g.Description})
+	if err != nil {
+		return 0, fmt.Errorf("could not create group: %w", err)
+	}
+
+	s.notify(EventGroupCreated, &Group{ID: id, Name: g.Name, Description: g.Description})
+
+	return id, nil
+}
+
+func (s *Service) UpdateGroup(g *Group) error {
+	if g.ID == 0 {
+		return ErrInvalidID
+	}
+
+	if err := s.APIConn.UpdateGroup(&api.Group{ID: g.ID, Name: g.Name, Description: g.Description}); err != nil {
+		return fmt.Errorf("could not update group: %w", err)
+	}
+
+	s.notify(EventGroupUpdated, g)
+
+	return nil
+}
+
+func (s *Service) DeleteGroup(id int) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+
+	if err := s.APIConn.DeleteGroup(id); err != nil {
+		return fmt.Errorf("could not delete group: %w", err)
+	}
+
+	s.notify(EventGroupDeleted, &Group{ID: id})
+
+	return nil
+}
+
+func (s *Service) ListPersonGroups(id int) ([]*Group, error) {
+	p, err := s.APIConn.ReadPerson(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not read person: %w", err)
+	}
+
+	groups := make([]*Group, len(p.Groups))
+	for idx, g := range p.Groups {
 		groups[idx] = &Group{
 			ID:   g.ID,
 			Name: g.Name,
@@ -224,19 +716,56 @@ func (s *Service) ListGroups() ([]*Group, error) {
 	return groups, nil
 }
 
+func (s *Service) AddPersonGroup(id, groupID int) error {
+	if id == 0 || groupID == 0 {
+		return ErrInvalidID
+	}
+	if err := s.APIConn.UpdatePerson(&api.Person{ID: id, GroupsToAdd: []int{groupID}}); err != nil {
+		return fmt.Errorf("could not add group: %w", err)
+	}
+
+	s.notify(EventGroupMembershipAdded, &groupMembershipEvent{PersonID: id, GroupID: groupID})
+
+	return nil
+}
+
+func (s *Service) RemovePersonGroup(id, groupID int) error {
+	if id == 0 || groupID == 0 {
+		return ErrInvalidID
+	}
+	if err := s.APIConn.UpdatePerson(&api.Person{ID: id, GroupsToRemove: []int{groupID}}); err != nil {
+		return fmt.Errorf("could not remove group: %w", err)
+	}
+
+	s.notify(EventGroupMembershipRemoved, &groupMembershipEvent{PersonID: id, GroupID: groupID})
+
+	return nil
+}
+
 type Credential struct {
-	ID       int  `json:"id,omitempty"`
-	Active   bool `json:"active"`
-	SiteCode int  `json:"site_code"`
-	CardCode int  `json:"card_code"`
+	ID         int        `json:"id,omitempty"`
+	Active     bool       `json:"active"`
+	SiteCode   int        `json:"site_code"`
+	CardCode   int        `json:"card_code"`
+	Activation *time.Time `json:"activation,omitempty"`
+	Expiration *time.Time `json:"expiration,omitempty"`
+	// ClearActivation and ClearExpiration remove Activation and Expiration when updating an existing credential
+	ClearActivation bool `json:"clear_activation,omitempty"`
+	ClearExpiration bool `json:"clear_expiration,omitempty"`
 }
 
 func (s *Service) CreateCredential(id int, cred *Credential) (int, error) {
+	if err := s.ValidateCredential(cred); err != nil {
+		return 0, err
+	}
 	credID, err := s.DBConn.CreateCredential(id, (*db.Credential)(cred))
 	if err != nil {
 		return 0, err
 	}
 
+	cred.ID = credID
+	s.notify(EventCredentialCreated, &credentialEvent{PersonID: id, Credential: cred})
+
 	return credID, nil
 }
 
@@ -245,6 +774,8 @@ func (s *Service) DeleteCredential(id, credID int) error {
 		return err
 	}
 
+	s.notify(EventCredentialDeleted, &credentialEvent{PersonID: id, Credential: &Credential{ID: credID}})
+
 	return nil
 }
 
@@ -0,0 +1,37 @@
+package infinias
+
+import (
+	"testing"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+func TestCredentialChanged(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(24 * time.Hour)
+	t1Offset := t1.In(time.FixedZone("", 3600))
+	stored := &db.Credential{ID: 1, Active: true, SiteCode: 10, CardCode: 20, Activation: &t1}
+
+	tests := []struct {
+		name string
+		cred *Credential
+		want bool
+	}{
+		{"same", &Credential{Active: true, SiteCode: 10, CardCode: 20}, false},
+		{"same activation", &Credential{Active: true, SiteCode: 10, CardCode: 20, Activation: &t1}, false},
+		{"same activation in another zone", &Credential{Active: true, SiteCode: 10, CardCode: 20, Activation: &t1Offset}, false},
+		{"clear missing expiration", &Credential{Active: true, SiteCode: 10, CardCode: 20, ClearExpiration: true}, false},
+		{"inactive", &Credential{SiteCode: 10, CardCode: 20}, true},
+		{"new activation", &Credential{Active: true, SiteCode: 10, CardCode: 20, Activation: &t2}, true},
+		{"new expiration", &Credential{Active: true, SiteCode: 10, CardCode: 20, Expiration: &t2}, true},
+		{"clear activation", &Credential{Active: true, SiteCode: 10, CardCode: 20, ClearActivation: true}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if have := credentialChanged(stored, test.cred); have != test.want {
+				t.Errorf("want %t, have %t", test.want, have)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package infinias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/korylprince/go-infinias-api/directory"
+)
+
+var ErrDirectoryNotFound = errors.New("directory not found")
+
+// Directory is a configured directory source people are synced from
+type Directory struct {
+	Source directory.Source
+	// DeactivateMissing deactivates people whose employee ID is no longer in the directory
+	DeactivateMissing bool
+}
+
+// SyncResult is the result of SyncPeople
+type SyncResult struct {
+	Created     []int `json:"created"`
+	Updated     []int `json:"updated"`
+	Deactivated []int `json:"deactivated"`
+	Unchanged   int   `json:"unchanged"`
+	// Skipped is the number of directory users without an employee ID
+	Skipped int      `json:"skipped"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func normalizeEmployeeID(id string) string {
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// SyncPeople creates or updates people to match users, matching them by employee ID.
+// If deactivateMissing is true, people with an employee ID not in users have their credentials deactivated.
+// Errors for individual people are collected in the result instead of stopping the sync
+func (s *Service) SyncPeople(ctx context.Context, users []*directory.User, deactivateMissing bool) (*SyncResult, error) {
+	s = s.WithContext(ctx)
+	people, err := s.ListPeople()
+	if err != nil {
+		return nil, err
+	}
+
+	byEmployeeID := make(map[string]*Person)
+	for _, p := range people {
+		if id := normalizeEmployeeID(p.EmployeeID); id != "" {
+			byEmployeeID[id] = p
+		}
+	}
+
+	res := &SyncResult{Created: make([]int, 0), Updated: make([]int, 0), Deactivated: make([]int, 0)}
+	seen := make(map[string]struct{})
+	for idx, u := range users {
+		ReportProgress(ctx, idx, len(users))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		key := normalizeEmployeeID(u.EmployeeID)
+		if key == "" {
+			res.Skipped++
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			res.Errors = append(res.Errors, fmt.Sprintf("employee %s: duplicate employee id in directory", u.EmployeeID))
+			continue
+		}
+		seen[key] = struct{}{}
+
+		p, ok := byEmployeeID[key]
+		if !ok {
+			id, err := s.CreatePerson(&Person{
+				FirstName:  u.FirstName,
+				LastName:   u.LastName,
+				EmployeeID: strings.TrimSpace(u.EmployeeID),
+				Department: u.Department,
+			})
+			if err != nil {
+				res.Errors = append(res.Errors, fmt.Sprintf("employee %s: %v", u.EmployeeID, err))
+				continue
+			}
+			res.Created = append(res.Created, id)
+			continue
+		}
+
+		if p.FirstName == u.FirstName && p.LastName == u.LastName && p.Department == u.Department {
+			res.Unchanged++
+			continue
+		}
+
+		if err := s.UpdatePerson(&Person{
+			ID:         p.ID,
+			FirstName:  u.FirstName,
+			LastName:   u.LastName,
+			EmployeeID: p.EmployeeID,
+			Department: u.Department,
+		}); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("employee %s: %v", u.EmployeeID, err))
+			continue
+		}
+		res.Updated = append(res.Updated, p.ID)
+	}
+
+	ReportProgress(ctx, len(users), len(users))
+
+	if !deactivateMissing {
+		return res, nil
+	}
+
+	for key, p := range byEmployeeID {
+		if _, ok := seen[key]; ok || !hasActiveCredential(p) {
+			continue
+		}
+		if _, err := s.DeactivatePerson(p.ID, false); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("employee %s: %v", p.EmployeeID, err))
+			continue
+		}
+		res.Deactivated = append(res.Deactivated, p.ID)
+	}
+	sort.Ints(res.Deactivated)
+
+	return res, nil
+}
+
+func hasActiveCredential(p *Person) bool {
+	for _, c := range p.Credentials {
+		if c.Active {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncDirectory reads users from the named directory and syncs them with SyncPeople
+func (s *Service) SyncDirectory(ctx context.Context, name string) (*SyncResult, error) {
+	s = s.WithContext(ctx)
+	d, ok := s.Directories[name]
+	if !ok {
+		return nil, ErrDirectoryNotFound
+	}
+
+	users, err := d.Source.Users(ctx)
+	if err != nil {
+		err = &DirectoryError{Name: name, Err: err}
+		s.alert(AlertSyncFailed, "Directory sync failed", "Sync from %s failed: %v", name, err)
+		return nil, err
+	}
+
+	res, err := s.SyncPeople(ctx, users, d.DeactivateMissing)
+	if err != nil {
+		s.alert(AlertSyncFailed, "Directory sync failed", "Sync from %s failed: %v", name, err)
+		return nil, err
+	}
+
+	if len(res.Errors) > 0 {
+		s.alert(AlertSyncFailed, "Directory sync had errors", "Sync from %s had %d errors:\n%s", name, len(res.Errors), strings.Join(res.Errors, "\n"))
+	}
+
+	return res, nil
+}
+
+// DirectoryError is returned when a directory can't be read
+type DirectoryError struct {
+	Name string
+	Err  error
+}
+
+func (e *DirectoryError) Error() string {
+	return fmt.Sprintf("could not read directory %s: %v", e.Name, e.Err)
+}
+
+func (e *DirectoryError) Unwrap() error {
+	return e.Err
+}
+
+// SyncDirectoryHandler starts a job syncing people from the named directory
+func (s *Service) SyncDirectoryHandler(r *http.Request) (interface{}, error) {
+	name := mux.Vars(r)["name"]
+	if _, ok := s.Directories[name]; !ok {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: ErrDirectoryNotFound}
+	}
+
+	return s.submitJob(r, "directory_sync", func(ctx context.Context) (interface{}, error) {
+		return s.SyncDirectory(ctx, name)
+	})
+}
@@ -0,0 +1,156 @@
+// Package syslog is a minimal RFC 5424 syslog client that sends messages over UDP, TCP, or TLS
+package syslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultTimeout = 5 * time.Second
+
+// Severity is a syslog message severity
+type Severity int
+
+const (
+	Emergency Severity = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Informational
+	Debug
+)
+
+// FacilityLocal0 is the facility used if Writer.Facility is zero. Facility 0, kernel messages, can't be used
+const FacilityLocal0 = 16
+
+// Writer sends syslog messages to a server. Messages over TCP and TLS are framed with octet counting (RFC 6587).
+// It reconnects as needed and is safe for concurrent use
+type Writer struct {
+	// Network is udp, tcp, or tls
+	Network string
+	// Addr is the server's host:port
+	Addr string
+	// TLS configures tls connections
+	TLS *tls.Config
+	// Facility defaults to FacilityLocal0
+	Facility int
+	// Hostname defaults to the local hostname
+	Hostname string
+	AppName  string
+	// Timeout limits dialing and each write. Defaults to DefaultTimeout
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// New returns a new Writer. The connection is made when the first message is written
+func New(network, addr, appName string) (*Writer, error) {
+	switch network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unknown network: %q", network)
+	}
+	hostname, _ := os.Hostname()
+	return &Writer{Network: network, Addr: addr, TLS: new(tls.Config), Hostname: hostname, AppName: appName, Timeout: DefaultTimeout}, nil
+}
+
+func (w *Writer) timeout() time.Duration {
+	if w.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return w.Timeout
+}
+
+func (w *Writer) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: w.timeout()}
+	var conn net.Conn
+	var err error
+	if w.Network == "tls" {
+		conn, err = tls.DialWithDialer(d, "tcp", w.Addr, w.TLS)
+	} else {
+		conn, err = d.Dial(w.Network, w.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to syslog: %w", err)
+	}
+	return conn, nil
+}
+
+// field returns s as an RFC 5424 header field: printable ASCII without spaces, or "-" if empty
+func field(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// format returns msg as an RFC 5424 message
+func (w *Writer) format(sev Severity, msgID string, t time.Time, msg string) string {
+	facility := w.Facility
+	if facility == 0 {
+		facility = FacilityLocal0
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		facility*8+int(sev), t.UTC().Format(time.RFC3339Nano), field(w.Hostname, 255), field(w.AppName, 48),
+		os.Getpid(), field(msgID, 32), msg,
+	)
+}
+
+// Write sends msg with the given severity, message id, and time. A failed write is retried once on a new connection
+func (w *Writer) Write(sev Severity, msgID string, t time.Time, msg string) error {
+	line := w.format(sev, msgID, t, msg)
+	if w.Network != "udp" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if w.conn, err = w.dial(); err != nil {
+				return err
+			}
+		}
+		if err = w.conn.SetWriteDeadline(time.Now().Add(w.timeout())); err == nil {
+			if _, err = w.conn.Write([]byte(line)); err == nil {
+				return nil
+			}
+		}
+		// the server may have closed an idle connection
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	return fmt.Errorf("could not write to syslog: %w", err)
+}
+
+// Close closes the connection, if any
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,298 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+// DefaultCredentialExpiryInterval is how often WatchCredentialExpiry processes expired credentials if no interval is given
+const DefaultCredentialExpiryInterval = time.Minute
+
+const (
+	// temporaryCredentialRetryMin and temporaryCredentialRetryMax bound how long a failed deletion waits to be retried
+	temporaryCredentialRetryMin = time.Minute
+	temporaryCredentialRetryMax = 6 * time.Hour
+)
+
+var ErrTemporaryCredentialsDisabled = errors.New("deleting credentials on expiry isn't enabled")
+
+const (
+	// ExpiryActionDeactivate deactivates a temporary credential when it expires
+	ExpiryActionDeactivate = "deactivate"
+	// ExpiryActionDelete deletes a temporary credential when it expires
+	ExpiryActionDelete = "delete"
+)
+
+// CredentialRequest is the body of CreateCredentialHandler. Setting TTL or ExpiresAt makes the credential temporary
+type CredentialRequest struct {
+	Credential
+	// TTL is how long the credential is valid for from now, e.g. 8h or 30d
+	TTL string `json:"ttl,omitempty"`
+	// ExpiresAt is when the credential expires. It's the same as Expiration
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// OnExpiry is deactivate (the default) or delete
+	OnExpiry string `json:"on_expiry,omitempty"`
+}
+
+// resolve sets c.Expiration from c.TTL or c.ExpiresAt and returns the credential to create
+func (c *CredentialRequest) resolve(now time.Time, store *TemporaryCredentialStore) (*Credential, error) {
+	e := new(ValidationError)
+	set := 0
+	if c.Expiration != nil {
+		set++
+	}
+	if c.ExpiresAt != nil {
+		set++
+		c.Expiration = c.ExpiresAt
+	}
+	if c.TTL = strings.TrimSpace(c.TTL); c.TTL != "" {
+		set++
+		if ttl, err := parseWithin(c.TTL); err != nil || ttl <= 0 {
+			e.add("ttl", "must be a positive duration, e.g. 8h or 30d")
+		} else {
+			exp := now.Add(ttl)
+			c.Expiration = &exp
+		}
+	}
+	if set > 1 {
+		e.add("ttl", "only one of ttl, expires_at, and expiration may be set")
+	}
+
+	switch c.OnExpiry = strings.ToLower(strings.TrimSpace(c.OnExpiry)); c.OnExpiry {
+	case "", ExpiryActionDeactivate:
+	case ExpiryActionDelete:
+		if c.Expiration == nil && len(e.Fields) == 0 {
+			e.add("on_expiry", "requires ttl or expires_at")
+		} else if store == nil {
+			e.add("on_expiry", ErrTemporaryCredentialsDisabled.Error())
+		}
+	default:
+		e.add("on_expiry", "must be deactivate or delete")
+	}
+
+	if c.Expiration != nil && !c.Expiration.After(now) && e.Fields["ttl"] == "" {
+		e.add("expires_at", "must be in the future")
+	}
+
+	if len(e.Fields) > 0 {
+		return nil, e
+	}
+	return &c.Credential, nil
+}
+
+// TemporaryCredential is a credential that's deleted once ExpiresAt passes.
+// Attempts and RetryAt are set after deleting it fails, and it's retried with exponential backoff
+type TemporaryCredential struct {
+	PersonID     int        `json:"person_id"`
+	CredentialID int        `json:"credential_id"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	Attempts     int        `json:"attempts,omitempty"`
+	RetryAt      *time.Time `json:"retry_at,omitempty"`
+}
+
+// TemporaryCredentialStore holds credentials that are deleted when they expire, persisted to a file.
+// Credentials that are only deactivated don't need to be stored, since their expiration is in the database
+type TemporaryCredentialStore struct {
+	path string
+
+	mu          sync.RWMutex
+	credentials map[int]*TemporaryCredential
+}
+
+// NewTemporaryCredentialStore returns a TemporaryCredentialStore persisted to path, loading any existing credentials
+func NewTemporaryCredentialStore(path string) (*TemporaryCredentialStore, error) {
+	s := &TemporaryCredentialStore{path: path, credentials: make(map[int]*TemporaryCredential)}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read temporary credential store: %w", err)
+	}
+
+	var credentials []*TemporaryCredential
+	if err = json.Unmarshal(buf, &credentials); err != nil {
+		return nil, fmt.Errorf("could not decode temporary credential store: %w", err)
+	}
+	for _, c := range credentials {
+		s.credentials[c.CredentialID] = c
+	}
+
+	return s, nil
+}
+
+// save persists s. The caller must hold s.mu
+func (s *TemporaryCredentialStore) save() error {
+	credentials := make([]*TemporaryCredential, 0, len(s.credentials))
+	for _, c := range s.credentials {
+		credentials = append(credentials, c)
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].CredentialID < credentials[j].CredentialID })
+
+	buf, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode temporary credential store: %w", err)
+	}
+	if err = os.WriteFile(s.path+".tmp", buf, 0600); err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write temporary credential store: %w", err)
+	}
+	return nil
+}
+
+// Add schedules c for deletion
+func (s *TemporaryCredentialStore) Add(c *TemporaryCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c2 := *c
+	s.credentials[c.CredentialID] = &c2
+	return s.save()
+}
+
+// Delete removes the credential with credID, if any
+func (s *TemporaryCredentialStore) Delete(credID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.credentials[credID]; !ok {
+		return nil
+	}
+	delete(s.credentials, credID)
+	return s.save()
+}
+
+// retry records a failed deletion of c at now and schedules the next attempt
+func (s *TemporaryCredentialStore) retry(c *TemporaryCredential, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.credentials[c.CredentialID]
+	if !ok {
+		return nil
+	}
+
+	cur.Attempts++
+	backoff := temporaryCredentialRetryMin
+	for i := 1; i < cur.Attempts && backoff < temporaryCredentialRetryMax; i++ {
+		backoff *= 2
+	}
+	retryAt := now.Add(min(backoff, temporaryCredentialRetryMax))
+	cur.RetryAt = &retryAt
+	return s.save()
+}
+
+// Due returns the credentials whose expiration is at or before now and that aren't waiting to be retried,
+// sorted by credential id
+func (s *TemporaryCredentialStore) Due(now time.Time) []*TemporaryCredential {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var due []*TemporaryCredential
+	for _, c := range s.credentials {
+		if !c.ExpiresAt.After(now) && (c.RetryAt == nil || !c.RetryAt.After(now)) {
+			c2 := *c
+			due = append(due, &c2)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].CredentialID < due[j].CredentialID })
+	return due
+}
+
+// CredentialExpiryResult is the result of ProcessExpiredCredentials
+type CredentialExpiryResult struct {
+	Deactivated []int    `json:"credentials_expired"`
+	Deleted     []int    `json:"credentials_deleted"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// ProcessExpiredCredentials deactivates active credentials whose expiration has passed, then deletes expired
+// credentials in s.TemporaryCredentials. Each change is recorded in the audit log.
+// Credentials that no longer exist are removed from s.TemporaryCredentials.
+// Errors deleting individual credentials are collected in the result and retried with exponential backoff
+func (s *Service) ProcessExpiredCredentials(ctx context.Context) (*CredentialExpiryResult, error) {
+	s = s.WithContext(ctx)
+	expired, err := s.DBConn.ExpireCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("could not expire credentials: %w", err)
+	}
+
+	res := &CredentialExpiryResult{Deactivated: make([]int, len(expired)), Deleted: make([]int, 0)}
+	for idx, e := range expired {
+		res.Deactivated[idx] = e.CredentialID
+		cred := &Credential{ID: e.CredentialID}
+		s.notify(EventCredentialExpired, &credentialEvent{PersonID: e.PersonID, Credential: cred})
+		s.auditSystem(EventCredentialExpired, e.PersonID, nil, cred)
+	}
+
+	if s.TemporaryCredentials == nil {
+		return res, nil
+	}
+
+	for _, c := range s.TemporaryCredentials.Due(time.Now()) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := s.DeleteCredential(c.PersonID, c.CredentialID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			res.Errors = append(res.Errors, fmt.Sprintf("credential %d: %v", c.CredentialID, err))
+			if err = s.TemporaryCredentials.retry(c, time.Now()); err != nil {
+				res.Errors = append(res.Errors, fmt.Sprintf("credential %d: %v", c.CredentialID, err))
+			}
+			continue
+		}
+		if err == nil {
+			s.auditSystem(EventCredentialDeleted, c.PersonID, &Credential{ID: c.CredentialID}, nil)
+		}
+		res.Deleted = append(res.Deleted, c.CredentialID)
+
+		if err := s.TemporaryCredentials.Delete(c.CredentialID); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("credential %d: %v", c.CredentialID, err))
+		}
+	}
+
+	return res, nil
+}
+
+// WatchCredentialExpiry runs ProcessExpiredCredentials now and then every interval until stop is called
+func (s *Service) WatchCredentialExpiry(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultCredentialExpiryInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if res, err := s.ProcessExpiredCredentials(ctx); err != nil {
+				if ctx.Err() == nil {
+					s.logger().Warn("could not process expired credentials", "error", err)
+				}
+			} else {
+				if len(res.Deactivated) > 0 || len(res.Deleted) > 0 {
+					s.logger().Info("processed expired credentials", "deactivated", res.Deactivated, "deleted", res.Deleted)
+				}
+				for _, e := range res.Errors {
+					s.logger().Warn("could not delete expired credential", "error", e)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(cancel) }
+}
@@ -0,0 +1,57 @@
+package infinias
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTemporaryCredentialRetry(t *testing.T) {
+	s, err := NewTemporaryCredentialStore(filepath.Join(t.TempDir(), "tempcreds.json"))
+	if err != nil {
+		t.Fatalf("could not create store: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &TemporaryCredential{PersonID: 1, CredentialID: 2, ExpiresAt: now.Add(-time.Hour)}
+	if err = s.Add(c); err != nil {
+		t.Fatalf("could not add credential: %v", err)
+	}
+
+	tests := []struct {
+		backoff time.Duration
+	}{
+		{time.Minute},
+		{2 * time.Minute},
+		{4 * time.Minute},
+		{8 * time.Minute},
+	}
+	for _, test := range tests {
+		if err = s.retry(c, now); err != nil {
+			t.Fatalf("could not retry credential: %v", err)
+		}
+		if have := len(s.Due(now.Add(test.backoff - time.Second))); have != 0 {
+			t.Errorf("want no credentials due before %v, have %d", test.backoff, have)
+		}
+		if have := len(s.Due(now.Add(test.backoff))); have != 1 {
+			t.Errorf("want credential due after %v, have %d", test.backoff, have)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if err = s.retry(c, now); err != nil {
+			t.Fatalf("could not retry credential: %v", err)
+		}
+	}
+	if have := len(s.Due(now.Add(temporaryCredentialRetryMax))); have != 1 {
+		t.Errorf("want backoff capped at %v", temporaryCredentialRetryMax)
+	}
+
+	// attempts are persisted
+	s2, err := NewTemporaryCredentialStore(s.path)
+	if err != nil {
+		t.Fatalf("could not load store: %v", err)
+	}
+	if have := s2.credentials[2].Attempts; have != 104 {
+		t.Errorf("want 104 attempts, have %d", have)
+	}
+}
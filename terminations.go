@@ -0,0 +1,273 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/api"
+)
+
+// DefaultTerminationCheckInterval is how often WatchTerminations checks for passed termination dates if no interval is given
+const DefaultTerminationCheckInterval = time.Hour
+
+var ErrTerminationsDisabled = errors.New("terminations aren't enabled")
+
+// Termination is a person's termination date. Processed is set once the person has been deactivated
+type Termination struct {
+	PersonID  int        `json:"person_id"`
+	Date      time.Time  `json:"date"`
+	Processed *time.Time `json:"processed,omitempty"`
+}
+
+// TerminationStore holds people's termination dates, persisted to a file
+type TerminationStore struct {
+	// RemoveGroups removes people from all groups when they're deactivated
+	RemoveGroups bool
+
+	path string
+
+	mu           sync.RWMutex
+	terminations map[int]*Termination
+}
+
+// NewTerminationStore returns a TerminationStore persisted to path, loading any existing termination dates
+func NewTerminationStore(path string) (*TerminationStore, error) {
+	s := &TerminationStore{path: path, terminations: make(map[int]*Termination)}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read termination store: %w", err)
+	}
+
+	var terminations []*Termination
+	if err = json.Unmarshal(buf, &terminations); err != nil {
+		return nil, fmt.Errorf("could not decode termination store: %w", err)
+	}
+	for _, t := range terminations {
+		s.terminations[t.PersonID] = t
+	}
+
+	return s, nil
+}
+
+// save persists s. The caller must hold s.mu
+func (s *TerminationStore) save() error {
+	buf, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode termination store: %w", err)
+	}
+	if err = os.WriteFile(s.path+".tmp", buf, 0600); err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write termination store: %w", err)
+	}
+	return nil
+}
+
+// list returns copies of the terminations in s, sorted by person id. The caller must hold s.mu
+func (s *TerminationStore) list() []*Termination {
+	terminations := make([]*Termination, 0, len(s.terminations))
+	for _, t := range s.terminations {
+		t2 := *t
+		terminations = append(terminations, &t2)
+	}
+	sort.Slice(terminations, func(i, j int) bool { return terminations[i].PersonID < terminations[j].PersonID })
+	return terminations
+}
+
+// Get returns the termination date of the person with id, or nil if there isn't one
+func (s *TerminationStore) Get(id int) *time.Time {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.terminations[id]
+	if !ok {
+		return nil
+	}
+	date := t.Date
+	return &date
+}
+
+// Set sets the termination date of the person with id. Changing the date of a processed termination schedules it again
+func (s *TerminationStore) Set(id int, date time.Time) error {
+	if s == nil {
+		return ErrTerminationsDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.terminations[id]; ok && t.Date.Equal(date) {
+		return nil
+	}
+	s.terminations[id] = &Termination{PersonID: id, Date: date}
+	return s.save()
+}
+
+// Delete removes the termination date of the person with id, if any
+func (s *TerminationStore) Delete(id int) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.terminations[id]; !ok {
+		return nil
+	}
+	delete(s.terminations, id)
+	return s.save()
+}
+
+// All returns all terminations, sorted by person id
+func (s *TerminationStore) All() []*Termination {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list()
+}
+
+// Due returns the unprocessed terminations whose date is at or before now, sorted by person id
+func (s *TerminationStore) Due(now time.Time) []*Termination {
+	var due []*Termination
+	for _, t := range s.All() {
+		if t.Processed == nil && !t.Date.After(now) {
+			due = append(due, t)
+		}
+	}
+	return due
+}
+
+// markProcessed records that t was processed at now.
+// It's a no-op if the date was changed or removed since t was returned by Due
+func (s *TerminationStore) markProcessed(t *Termination, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.terminations[t.PersonID]
+	if !ok || !cur.Date.Equal(t.Date) {
+		return nil
+	}
+	cur.Processed = &now
+	return s.save()
+}
+
+// ProcessTerminations deactivates everyone whose termination date has passed with DeactivatePerson,
+// removing them from all groups if s.Terminations.RemoveGroups is set.
+// Termination dates of people that no longer exist are removed.
+// Errors for individual people are collected in the result and retried on the next call
+func (s *Service) ProcessTerminations(ctx context.Context) (*BulkDeactivationResult, error) {
+	if s.Terminations == nil {
+		return nil, ErrTerminationsDisabled
+	}
+	s = s.WithContext(ctx)
+
+	res := new(BulkDeactivationResult)
+	for _, t := range s.Terminations.Due(time.Now()) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		d, err := s.DeactivatePerson(t.PersonID, s.Terminations.RemoveGroups)
+		if api.IsNotFoundError(err) {
+			if err = s.Terminations.Delete(t.PersonID); err != nil {
+				res.Errors = append(res.Errors, fmt.Sprintf("person %d: %v", t.PersonID, err))
+			}
+			continue
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("person %d: %v", t.PersonID, err))
+			continue
+		}
+		res.PeopleDeactivated++
+		res.CredentialsDeactivated += len(d.CredentialsDeactivated)
+		res.GroupsRemoved += len(d.GroupsRemoved)
+
+		if err = s.Terminations.markProcessed(t, time.Now()); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("person %d: %v", t.PersonID, err))
+		}
+	}
+
+	return res, nil
+}
+
+// WatchTerminations runs ProcessTerminations now and then every interval until stop is called
+func (s *Service) WatchTerminations(interval time.Duration) (stop func()) {
+	if s.Terminations == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultTerminationCheckInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if res, err := s.ProcessTerminations(ctx); err != nil {
+				if ctx.Err() == nil {
+					s.logger().Warn("could not process terminations", "error", err)
+				}
+			} else {
+				if res.PeopleDeactivated > 0 {
+					s.logger().Info("processed terminations", "people", res.PeopleDeactivated, "credentials", res.CredentialsDeactivated, "groups", res.GroupsRemoved)
+				}
+				for _, e := range res.Errors {
+					s.logger().Warn("could not process termination", "error", e)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(cancel) }
+}
+
+// ClearTerminationDateHandler removes a person's termination date
+func (s *Service) ClearTerminationDateHandler(r *http.Request) error {
+	s = s.WithContext(r.Context())
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return err
+	}
+	if s.Terminations == nil {
+		return &HTTPError{StatusCode: http.StatusNotFound, Err: ErrTerminationsDisabled}
+	}
+
+	p, err := s.ReadPerson(id)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if api.IsNotFoundError(err) {
+			code = http.StatusNotFound
+		}
+		return &HTTPError{StatusCode: code, Err: fmt.Errorf("could not clear termination date: %w", err)}
+	}
+
+	before := newPersonEvent(p)
+	if err = s.Terminations.Delete(id); err != nil {
+		return &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not clear termination date: %w", err)}
+	}
+	p.TerminationDate = nil
+	after := newPersonEvent(p)
+	s.notify(EventPersonUpdated, after)
+	s.audit(r, EventPersonUpdated, id, before, after)
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package infinias
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+const (
+	DefaultThumbnailSize        = 128
+	MinThumbnailSize            = 16
+	MaxThumbnailSize            = 1024
+	DefaultThumbnailCacheSize   = 1000
+	DefaultThumbnailCacheTTL    = 10 * time.Minute
+	thumbnailCacheControlMaxAge = 300
+)
+
+var ErrNoPicture = errors.New("person has no picture")
+
+type thumbnailKey struct {
+	id   int
+	size int
+}
+
+type thumbnail struct {
+	key     thumbnailKey
+	buf     []byte
+	etag    string
+	expires time.Time
+}
+
+// ThumbnailCache is an in-memory LRU cache of resized pictures
+type ThumbnailCache struct {
+	MaxEntries int
+	TTL        time.Duration
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[thumbnailKey]*list.Element
+}
+
+// NewThumbnailCache returns a new ThumbnailCache holding up to maxEntries thumbnails for ttl
+func NewThumbnailCache(maxEntries int, ttl time.Duration) *ThumbnailCache {
+	return &ThumbnailCache{MaxEntries: maxEntries, TTL: ttl, order: list.New(), entries: make(map[thumbnailKey]*list.Element)}
+}
+
+// SetTTL changes how long thumbnails are cached. Thumbnails already cached keep their expiration
+func (c *ThumbnailCache) SetTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.TTL = ttl
+}
+
+func (c *ThumbnailCache) get(key thumbnailKey) *thumbnail {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	t := elem.Value.(*thumbnail)
+	if time.Now().After(t.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return t
+}
+
+func (c *ThumbnailCache) put(t *thumbnail) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.expires = time.Now().Add(c.TTL)
+	if elem, ok := c.entries[t.key]; ok {
+		elem.Value = t
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[t.key] = c.order.PushFront(t)
+	for c.MaxEntries > 0 && c.order.Len() > c.MaxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*thumbnail).key)
+	}
+}
+
+// Invalidate removes all cached thumbnails for the person with the given id
+func (c *ThumbnailCache) Invalidate(id int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if key.id == id {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Thumbnail returns a JPEG of the person's picture scaled to fit within size x size, and its ETag
+func (s *Service) Thumbnail(id, size int) ([]byte, string, error) {
+	key := thumbnailKey{id: id, size: size}
+	if t := s.Thumbnails.get(key); t != nil {
+		return t.buf, t.etag, nil
+	}
+
+	src, err := s.pictures().Read(id)
+	if err != nil {
+		if errors.Is(err, photo.ErrNotFound) {
+			return nil, "", ErrNoPicture
+		}
+		return nil, "", fmt.Errorf("could not read picture: %w", err)
+	}
+	if len(src) == 0 {
+		return nil, "", ErrNoPicture
+	}
+
+	buf, err := photo.Normalize(src, &photo.NormalizeOptions{MaxDimension: size})
+	if err != nil {
+		return nil, "", fmt.Errorf("could not resize picture: %w", err)
+	}
+
+	sum := sha256.Sum256(buf)
+	t := &thumbnail{key: key, buf: buf, etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+	s.Thumbnails.put(t)
+
+	return t.buf, t.etag, nil
+}
+
+// ThumbnailHandler writes a resized JPEG of a person's picture. The size parameter sets the maximum width and height
+func (s *Service) ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	s = s.WithContext(r.Context())
+	errHandler := func(err error) {
+		s.HandleJSON(func(r *http.Request) (interface{}, error) {
+			return nil, err
+		}).ServeHTTP(w, r)
+	}
+
+	if err := hiddenFieldError(s.hiddenFields(r), "image"); err != nil {
+		errHandler(err)
+		return
+	}
+
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		errHandler(err)
+		return
+	}
+
+	size := DefaultThumbnailSize
+	if str := r.URL.Query().Get("size"); str != "" {
+		if size, err = strconv.Atoi(str); err != nil || size < MinThumbnailSize || size > MaxThumbnailSize {
+			errHandler(&HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read size: must be between %d and %d: %q", MinThumbnailSize, MaxThumbnailSize, str)})
+			return
+		}
+	}
+
+	buf, etag, err := s.Thumbnail(id, size)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrNoPicture) {
+			code = http.StatusNotFound
+		}
+		errHandler(&HTTPError{StatusCode: code, Err: fmt.Errorf("could not create thumbnail: %w", err)})
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", thumbnailCacheControlMaxAge))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
@@ -0,0 +1,227 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryObserver is called after each statement is executed. For queries, d doesn't include reading the rows
+type QueryObserver func(ctx context.Context, op, query string, d time.Duration, err error)
+
+// OpenDB opens a database like sql.Open, starting a client span for each statement executed and calling observers after it's done
+func (t *Tracer) OpenDB(driverName, dsn string, observers ...QueryObserver) (*sql.DB, error) {
+	base, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil && len(observers) == 0 {
+		return base, nil
+	}
+
+	d := base.Driver()
+	if err = base.Close(); err != nil {
+		return nil, fmt.Errorf("could not close base database: %w", err)
+	}
+
+	c := &connector{tracer: t, observers: observers, driver: d, dsn: dsn, system: driverName}
+	if dc, ok := d.(driver.DriverContext); ok {
+		if c.base, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+
+	return sql.OpenDB(c), nil
+}
+
+type connector struct {
+	tracer    *Tracer
+	observers []QueryObserver
+	driver    driver.Driver
+	base      driver.Connector
+	dsn       string
+	system    string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var (
+		conn driver.Conn
+		err  error
+	)
+	if c.base != nil {
+		conn, err = c.base.Connect(ctx)
+	} else {
+		conn, err = c.driver.Open(c.dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, c: c}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// start starts a span for the statement. finish must be called with the statement's error when it's done
+func (c *connector) start(ctx context.Context, op, query string) (_ context.Context, finish func(err error)) {
+	start := time.Now()
+	ctx, span := c.tracer.start(ctx, "db."+op, trace.SpanKindClient,
+		attribute.String("db.system", c.system),
+		attribute.String("db.statement", query),
+	)
+	return ctx, func(err error) {
+		setError(span, err)
+		span.End()
+		d := time.Since(start)
+		for _, o := range c.observers {
+			o(ctx, op, query, d, err)
+		}
+	}
+}
+
+type tracedConn struct {
+	driver.Conn
+	c *connector
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, c: c.c, query: query}, nil
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() //nolint:staticcheck // fallback for drivers without BeginTx
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, finish := c.c.start(ctx, "exec", query)
+	res, err := e.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		finish(err)
+	}
+	return res, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, finish := c.c.start(ctx, "query", query)
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		finish(err)
+	}
+	return rows, err
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+type tracedStmt struct {
+	driver.Stmt
+	c     *connector
+	query string
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, finish := s.c.start(ctx, "exec", s.query)
+
+	var (
+		res driver.Result
+		err error
+	)
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			res, err = s.Stmt.Exec(values) //nolint:staticcheck // fallback for drivers without ExecContext
+		}
+	}
+	finish(err)
+	return res, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, finish := s.c.start(ctx, "query", s.query)
+
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = s.Stmt.Query(values) //nolint:staticcheck // fallback for drivers without QueryContext
+		}
+	}
+	finish(err)
+	return rows, err
+}
+
+func (s *tracedStmt) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for idx, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("driver doesn't support named parameter %s", arg.Name)
+		}
+		values[idx] = arg.Value
+	}
+	return values, nil
+}
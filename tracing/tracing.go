@@ -0,0 +1,78 @@
+// Package tracing traces HTTP requests, outgoing HTTP requests, and SQL statements with OpenTelemetry, propagates
+// them with W3C Trace Context headers, and exports them with OTLP/HTTP
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	instrumentationName = "github.com/korylprince/go-infinias-api/tracing"
+	otlpTracesPath      = "/v1/traces"
+)
+
+// propagator reads and writes traceparent headers
+var propagator = propagation.TraceContext{}
+
+// Tracer starts spans. A nil *Tracer is valid and starts no spans
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// New returns a new Tracer that exports spans in batches to the OTLP/HTTP collector at endpoint, its base URL,
+// e.g. http://localhost:4318. sampleRatio is the fraction of new traces that are recorded. Traces started by a caller
+// follow the caller's decision. Shutdown must be called to export the remaining spans
+func New(endpoint, serviceName string, headers map[string]string, sampleRatio float64) (*Tracer, error) {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+otlpTracesPath),
+		otlptracehttp.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not create exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	return &Tracer{provider: provider, tracer: provider.Tracer(instrumentationName)}, nil
+}
+
+// Shutdown exports any remaining spans and stops the Tracer
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+// start starts a span as a child of the span in ctx, or of a remote parent extracted into ctx, or as a new trace
+func (t *Tracer) start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// setError marks span as failed if err isn't nil
+func setError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
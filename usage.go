@@ -0,0 +1,161 @@
+package infinias
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// anonymousClient is the name requests are counted under when authentication is disabled
+const anonymousClient = "anonymous"
+
+// Usage counts requests by API key or other principal, so stale integrations and noisy clients can be found.
+// Counts are kept in memory and reset on restart. A nil *Usage is valid and counts nothing
+type Usage struct {
+	mu      sync.Mutex
+	since   time.Time
+	clients map[string]*ClientUsage
+}
+
+// NewUsage returns a new, empty Usage
+func NewUsage() *Usage {
+	return &Usage{since: time.Now().UTC(), clients: make(map[string]*ClientUsage)}
+}
+
+// ClientUsage is the usage of a single API key or other principal
+type ClientUsage struct {
+	Name     string `json:"name"`
+	Requests uint64 `json:"requests"`
+	// Errors counts responses with a 4xx or 5xx status
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// LastUsed is nil for configured keys that haven't been used since Since
+	LastUsed *time.Time `json:"last_used"`
+	// Statuses counts responses by status code
+	Statuses map[int]uint64 `json:"statuses,omitempty"`
+	// Routes counts requests by method and route, e.g. "POST /people"
+	Routes map[string]uint64 `json:"routes,omitempty"`
+}
+
+// UsageReport is the usage of every client since Since
+type UsageReport struct {
+	Since   time.Time      `json:"since"`
+	Clients []*ClientUsage `json:"clients"`
+}
+
+type usageRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *usageRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *usageRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *usageRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware counts each request under its principal. It must be used on a mux.Router so routes are known
+func (u *Usage) Middleware(next http.Handler) http.Handler {
+	if u == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &usageRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		name := anonymousClient
+		if p := PrincipalFromContext(r.Context()); p != nil {
+			name = p.Name
+		}
+		route := r.URL.Path
+		if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			route = tmpl
+		}
+		u.record(name, r.Method+" "+route, rec.status)
+	})
+}
+
+func (u *Usage) record(name, route string, status int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	c, ok := u.clients[name]
+	if !ok {
+		c = &ClientUsage{Name: name, Statuses: make(map[int]uint64), Routes: make(map[string]uint64)}
+		u.clients[name] = c
+	}
+	now := time.Now().UTC()
+	c.Requests++
+	if status >= http.StatusBadRequest {
+		c.Errors++
+	}
+	c.LastUsed = &now
+	c.Statuses[status]++
+	c.Routes[route]++
+}
+
+// Report returns copies of the counts, sorted by name, with an empty entry for each of names that hasn't been used
+func (u *Usage) Report(names []string) *UsageReport {
+	if u == nil {
+		return &UsageReport{Clients: make([]*ClientUsage, 0)}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	report := &UsageReport{Since: u.since, Clients: make([]*ClientUsage, 0, len(u.clients))}
+	seen := make(map[string]bool)
+	for name, c := range u.clients {
+		c2 := *c
+		c2.Statuses = make(map[int]uint64, len(c.Statuses))
+		for k, v := range c.Statuses {
+			c2.Statuses[k] = v
+		}
+		c2.Routes = make(map[string]uint64, len(c.Routes))
+		for k, v := range c.Routes {
+			c2.Routes[k] = v
+		}
+		c2.ErrorRate = float64(c.Errors) / float64(c.Requests)
+		report.Clients = append(report.Clients, &c2)
+		seen[name] = true
+	}
+	for _, name := range names {
+		if !seen[name] {
+			report.Clients = append(report.Clients, &ClientUsage{Name: name})
+			seen[name] = true
+		}
+	}
+
+	sort.Slice(report.Clients, func(i, j int) bool { return report.Clients[i].Name < report.Clients[j].Name })
+	return report
+}
+
+// UsageHandler returns request counts, error rates, and last used times by API key or other principal.
+// Active API keys that haven't been used since the service started are included with no requests
+func (s *Service) UsageHandler(r *http.Request) (interface{}, error) {
+	var names []string
+	for _, k := range s.activeAPIKeys() {
+		names = append(names, k.Name)
+	}
+	return s.Usage.Report(names), nil
+}
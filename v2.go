@@ -0,0 +1,315 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/korylprince/go-infinias-api/api"
+	"github.com/korylprince/go-infinias-api/db"
+	"github.com/korylprince/go-infinias-api/photo"
+)
+
+const (
+	APIVersion1 = "1.0"
+	APIVersion2 = "2.0"
+
+	// apiVersionHeader selects the API version for requests to the unversioned /api/ prefix, and is echoed in responses
+	apiVersionHeader = "API-Version"
+	// apiVersionMediaType is an Accept media type prefix that selects the API version, e.g. application/vnd.infinias.v2+json
+	apiVersionMediaType = "application/vnd.infinias.v"
+
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported api version")
+
+func withAPIVersion(version string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, version)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyAPIVersion, version)))
+	})
+}
+
+// APIVersionFromContext returns the API version for the request context. Requests without a version are APIVersion1
+func APIVersionFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(contextKeyAPIVersion).(string); ok {
+		return v
+	}
+	return APIVersion1
+}
+
+// HandlerV2 returns the /api/2.0 handler. It serves the same routes as Handler, but wraps responses in a
+// {"data": ..., "meta": ...} envelope, paginates lists, and returns machine-readable error codes
+func (s *Service) HandlerV2() http.Handler {
+	return withAPIVersion(APIVersion2, s.Handler())
+}
+
+// negotiateVersion returns the API version requested by the API-Version or Accept headers, or APIVersion1 if none is requested
+func negotiateVersion(r *http.Request) (string, error) {
+	if v := r.Header.Get(apiVersionHeader); v != "" {
+		switch v {
+		case "1", APIVersion1:
+			return APIVersion1, nil
+		case "2", APIVersion2:
+			return APIVersion2, nil
+		}
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedVersion, v)
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		accept = strings.TrimSpace(strings.Split(accept, ";")[0])
+		if !strings.HasPrefix(accept, apiVersionMediaType) {
+			continue
+		}
+		switch strings.TrimSuffix(strings.TrimPrefix(accept, apiVersionMediaType), "+json") {
+		case "1":
+			return APIVersion1, nil
+		case "2":
+			return APIVersion2, nil
+		}
+	}
+
+	return APIVersion1, nil
+}
+
+// VersionedHandler serves Handler at /api/1.0/ and HandlerV2 at /api/2.0/.
+// Requests to /api/ without a version are routed by the API-Version or Accept headers, defaulting to 1.0
+func (s *Service) VersionedHandler() http.Handler {
+	v1 := withAPIVersion(APIVersion1, http.StripPrefix("/api/1.0", s.Handler()))
+	v2 := http.StripPrefix("/api/2.0", s.HandlerV2())
+	v1Negotiated := http.StripPrefix("/api", withAPIVersion(APIVersion1, s.Handler()))
+	v2Negotiated := http.StripPrefix("/api", s.HandlerV2())
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/1.0/", v1)
+	mux.Handle("/api/2.0/", v2)
+	mux.Handle("/api/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, err := negotiateVersion(r)
+		if err != nil {
+			s.HandleJSON(func(r *http.Request) (interface{}, error) {
+				return nil, &HTTPError{StatusCode: http.StatusNotAcceptable, Err: err}
+			}).ServeHTTP(w, r)
+			return
+		}
+		if version == APIVersion2 {
+			v2Negotiated.ServeHTTP(w, r)
+			return
+		}
+		v1Negotiated.ServeHTTP(w, r)
+	}))
+
+	return mux
+}
+
+type v2Error struct {
+	Status    int         `json:"status"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id,omitempty"`
+	Detail    interface{} `json:"detail,omitempty"`
+}
+
+type v2Meta struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+type v2Response struct {
+	Data interface{} `json:"data,omitempty"`
+	// Meta is a *v2Meta or *v2CursorMeta
+	Meta  interface{} `json:"meta,omitempty"`
+	Error *v2Error    `json:"error,omitempty"`
+}
+
+// errorCode returns a stable, machine-readable code for err
+func errorCode(err error, status int) string {
+	switch {
+	case errors.Is(err, ErrInvalidAuthorization):
+		return "unauthorized"
+	case errors.Is(err, ErrInsufficientScope):
+		return "insufficient_scope"
+	case errors.Is(err, ErrInvalidID):
+		return "invalid_id"
+	case errors.Is(err, ErrInvalidGroupName):
+		return "invalid_group_name"
+	case errors.As(err, new(*ValidationError)):
+		return "validation_failed"
+	case errors.Is(err, ErrNoPicture):
+		return "no_picture"
+	case errors.Is(err, ErrGroupNotFound):
+		return "group_not_found"
+	case errors.Is(err, ErrUnknownField):
+		return "unknown_field"
+	case errors.Is(err, ErrUnknownSortField):
+		return "unknown_sort_field"
+	case errors.Is(err, ErrInvalidCursor):
+		return "invalid_cursor"
+	case errors.Is(err, ErrDirectoryNotFound):
+		return "directory_not_found"
+	case errors.As(err, new(*DirectoryError)):
+		return "directory_unavailable"
+	case errors.Is(err, ErrJobNotFound):
+		return "job_not_found"
+	case errors.Is(err, ErrPreconditionFailed):
+		return "precondition_failed"
+	case errors.Is(err, ErrUnsupportedVersion):
+		return "unsupported_version"
+	case errors.Is(err, ErrAuthLocked):
+		return "auth_locked"
+	case errors.Is(err, ErrBreakerOpen):
+		return "infinias_unavailable"
+	case errors.Is(err, ErrIdempotencyInProgress):
+		return "idempotency_in_progress"
+	case errors.Is(err, ErrIdempotencyMismatch):
+		return "idempotency_mismatch"
+	case errors.Is(err, db.ErrCredentialExists), api.IsBadgeExistsError(err):
+		return "credential_exists"
+	case errors.Is(err, db.ErrNotFound), api.IsNotFoundError(err):
+		return "not_found"
+	case errors.Is(err, photo.ErrTooLarge):
+		return "image_too_large"
+	case errors.Is(err, photo.ErrUnsupportedFormat):
+		return "unsupported_image_format"
+	case errors.Is(err, photo.ErrDimensionTooLarge), errors.Is(err, photo.ErrEmpty):
+		return "invalid_image"
+	}
+
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusNotAcceptable:
+		return "not_acceptable"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusPreconditionFailed:
+		return "precondition_failed"
+	case http.StatusRequestEntityTooLarge:
+		return "body_too_large"
+	case http.StatusUnsupportedMediaType:
+		return "unsupported_media_type"
+	case http.StatusUnprocessableEntity:
+		return "unprocessable_entity"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	case http.StatusServiceUnavailable:
+		return "service_unavailable"
+	}
+
+	return "internal_error"
+}
+
+// paginate returns the requested page of data if it is a slice. Pages are selected by number with page,
+// or with the opaque cursor returned in the previous page's meta.next_cursor. An empty cursor selects the first page.
+// Cursor pages of people can't be sorted by fields in hidden
+func paginate(r *http.Request, data interface{}, hidden map[string]bool) (interface{}, interface{}, error) {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Slice {
+		return data, nil, nil
+	}
+
+	meta := &v2Meta{Page: 1, PerPage: DefaultPageSize, Total: v.Len()}
+	q := r.URL.Query()
+	if str := q.Get("page"); str != "" {
+		page, err := strconv.Atoi(str)
+		if err != nil || page < 1 {
+			return nil, nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read page: invalid page: %q", str)}
+		}
+		meta.Page = page
+	}
+	if str := q.Get("per_page"); str != "" {
+		perPage, err := strconv.Atoi(str)
+		if err != nil || perPage < 1 || perPage > MaxPageSize {
+			return nil, nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("could not read per_page: must be between 1 and %d: %q", MaxPageSize, str)}
+		}
+		meta.PerPage = perPage
+	}
+
+	if q.Has("cursor") {
+		if q.Get("page") != "" {
+			return nil, nil, &HTTPError{StatusCode: http.StatusBadRequest, Err: fmt.Errorf("%w: page and cursor can't both be set", ErrInvalidCursor)}
+		}
+		return paginateCursor(r, v, meta.PerPage, hidden)
+	}
+
+	meta.TotalPages = (meta.Total + meta.PerPage - 1) / meta.PerPage
+
+	start := (meta.Page - 1) * meta.PerPage
+	if start > meta.Total {
+		start = meta.Total
+	}
+	end := start + meta.PerPage
+	if end > meta.Total {
+		end = meta.Total
+	}
+
+	return v.Slice(start, end).Interface(), meta, nil
+}
+
+// writeV2 writes a response in the v2 envelope
+func (s *Service) writeV2(w http.ResponseWriter, r *http.Request, code int, resp interface{}, err error) {
+	out := new(v2Response)
+
+	if err == nil {
+		// bare ok responses have no content in v2
+		if jr, ok := resp.(*jsonResponse); ok && jr.Code == http.StatusOK {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// fields are selected after paginating, so cursors are built from whole people
+		var fields map[string]bool
+		if sel, ok := resp.(*selection); ok {
+			resp, fields = sel.body, sel.fields
+		}
+
+		hidden := s.hiddenFields(r)
+		if code == http.StatusOK {
+			out.Data, out.Meta, err = paginate(r, resp, hidden)
+		} else {
+			out.Data = resp
+		}
+
+		if err == nil && fields != nil {
+			out.Data, err = selectFields(out.Data, fields)
+		}
+
+		if err == nil && hidden != nil {
+			out.Data, err = hideFields(out.Data, hidden)
+		}
+
+		if err != nil {
+			code = HTTPErrorCode(err)
+			s.requestLogger(r).Info("request failed", "status", code, "error", err)
+		}
+	}
+
+	if err != nil {
+		out.Data, out.Meta = nil, nil
+		out.Error = &v2Error{
+			Status:    code,
+			Code:      errorCode(err, code),
+			Message:   err.Error(),
+			RequestID: RequestIDFromContext(r.Context()),
+		}
+		if h := new(HTTPError); errors.As(err, &h) {
+			out.Error.Detail = s.errorDetail(r, h)
+		}
+	}
+
+	w.WriteHeader(code)
+	if err = json.NewEncoder(w).Encode(out); err != nil {
+		s.requestLogger(r).Error("could not encode response", "error", err)
+	}
+}
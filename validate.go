@@ -0,0 +1,176 @@
+package infinias
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/korylprince/go-infinias-api/cardutil"
+)
+
+// Default ranges for 26-bit Wiegand credentials
+const (
+	DefaultMaxSiteCode = 255
+	DefaultMaxCardCode = 65535
+)
+
+// PersonValidation configures ValidatePerson. Zero values use the defaults
+type PersonValidation struct {
+	// EmployeeIDPattern, if set, must match non-empty employee IDs
+	EmployeeIDPattern *regexp.Regexp
+	MaxSiteCode       int
+	MaxCardCode       int
+	// CardFormats, if set and MaxSiteCode and MaxCardCode aren't, are the formats credentials can use.
+	// Site and card codes must fit together in at least one of them
+	CardFormats []*cardutil.Format
+}
+
+// ValidationError maps JSON field names to what's wrong with them
+type ValidationError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for idx, name := range names {
+		msgs[idx] = fmt.Sprintf("%s: %s", name, e.Fields[name])
+	}
+
+	return "invalid fields: " + strings.Join(msgs, ", ")
+}
+
+func (e *ValidationError) add(field, msg string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[field] = msg
+}
+
+func (v *PersonValidation) maxSiteCode() int {
+	if v == nil || v.MaxSiteCode == 0 {
+		return DefaultMaxSiteCode
+	}
+	return v.MaxSiteCode
+}
+
+func (v *PersonValidation) maxCardCode() int {
+	if v == nil || v.MaxCardCode == 0 {
+		return DefaultMaxCardCode
+	}
+	return v.MaxCardCode
+}
+
+// checkFormats checks that siteCode and cardCode fit one of v.CardFormats
+func (v *PersonValidation) checkFormats(e *ValidationError, prefix string, siteCode, cardCode int) {
+	names := make([]string, len(v.CardFormats))
+	siteFits := false
+	for idx, f := range v.CardFormats {
+		names[idx] = f.Name
+		if siteCode >= 0 && cardCode >= 0 && f.Fits(uint64(siteCode), uint64(cardCode)) {
+			return
+		}
+		if siteCode >= 0 && uint64(siteCode) <= f.MaxFacility() {
+			siteFits = true
+		}
+	}
+
+	field := "site_code"
+	if siteFits {
+		field = "card_code"
+	}
+	e.add(prefix+field, fmt.Sprintf("site and card code must fit one of the card formats %s", strings.Join(names, ", ")))
+}
+
+func (v *PersonValidation) checkCodes(e *ValidationError, prefix string, siteCode, cardCode int) {
+	if v != nil && len(v.CardFormats) > 0 && v.MaxSiteCode == 0 && v.MaxCardCode == 0 {
+		v.checkFormats(e, prefix, siteCode, cardCode)
+		return
+	}
+	if max := v.maxSiteCode(); siteCode < 0 || siteCode > max {
+		e.add(prefix+"site_code", fmt.Sprintf("must be between 0 and %d", max))
+	}
+	if max := v.maxCardCode(); cardCode < 0 || cardCode > max {
+		e.add(prefix+"card_code", fmt.Sprintf("must be between 0 and %d", max))
+	}
+}
+
+func checkWindow(e *ValidationError, prefix string, cred *Credential) {
+	if cred.Activation != nil && cred.Expiration != nil && !cred.Expiration.After(*cred.Activation) {
+		e.add(prefix+"expiration", "must be after activation")
+	}
+	if cred.Activation != nil && cred.ClearActivation {
+		e.add(prefix+"clear_activation", "can't be set with activation")
+	}
+	if cred.Expiration != nil && cred.ClearExpiration {
+		e.add(prefix+"clear_expiration", "can't be set with expiration")
+	}
+}
+
+// ValidatePerson returns a *ValidationError if p, a person to be created, has missing or invalid fields
+func (s *Service) ValidatePerson(p *Person) error {
+	return s.validatePerson(p, true)
+}
+
+// ValidatePersonUpdate returns a *ValidationError if p, an update to a person, has invalid fields.
+// Empty fields aren't changed by an update, so none are required
+func (s *Service) ValidatePersonUpdate(p *Person) error {
+	return s.validatePerson(p, false)
+}
+
+func (s *Service) validatePerson(p *Person, create bool) error {
+	v := s.Validation
+	e := new(ValidationError)
+
+	if create && strings.TrimSpace(p.FirstName) == "" {
+		e.add("first_name", "required")
+	}
+	if create && strings.TrimSpace(p.LastName) == "" {
+		e.add("last_name", "required")
+	}
+	if p.EmployeeID != "" && v != nil && v.EmployeeIDPattern != nil && !v.EmployeeIDPattern.MatchString(p.EmployeeID) {
+		e.add("employee_id", fmt.Sprintf("must match %s", v.EmployeeIDPattern.String()))
+	}
+
+	v.checkCodes(e, "", p.SiteCode, p.CardCode)
+	for idx, cred := range p.Credentials {
+		prefix := fmt.Sprintf("credentials[%d].", idx)
+		v.checkCodes(e, prefix, cred.SiteCode, cred.CardCode)
+		checkWindow(e, prefix, cred)
+	}
+
+	for idx, id := range p.GroupsToAdd {
+		if id <= 0 {
+			e.add(fmt.Sprintf("groups_to_add[%d]", idx), "must be a valid group id")
+		}
+	}
+
+	if p.TerminationDate != nil && s.Terminations == nil {
+		e.add("termination_date", ErrTerminationsDisabled.Error())
+	} else if p.TerminationDate != nil && p.TerminationDate.IsZero() {
+		e.add("termination_date", "must be a valid time")
+	}
+
+	if len(e.Fields) > 0 {
+		return e
+	}
+
+	return nil
+}
+
+// ValidateCredential returns a *ValidationError if cred's site or card code are out of range
+func (s *Service) ValidateCredential(cred *Credential) error {
+	e := new(ValidationError)
+	s.Validation.checkCodes(e, "", cred.SiteCode, cred.CardCode)
+	checkWindow(e, "", cred)
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package infinias
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// Build metadata, set at build time with -ldflags "-X github.com/korylprince/go-infinias-api.Version=v1.2.3", etc.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// BuildInfo describes the running build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Build returns the running build's BuildInfo
+func Build() *BuildInfo {
+	return &BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+}
+
+func (b *BuildInfo) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", b.Version, b.Commit, b.BuildDate, b.GoVersion)
+}
+
+// VersionHandler returns the running build's BuildInfo
+func (s *Service) VersionHandler(r *http.Request) (interface{}, error) {
+	return Build(), nil
+}
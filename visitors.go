@@ -0,0 +1,440 @@
+package infinias
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/db"
+)
+
+// DefaultVisitorCheckInterval is how often WatchVisitors checks for expired visitors if no interval is given
+const DefaultVisitorCheckInterval = 5 * time.Minute
+
+var (
+	ErrVisitorsDisabled     = errors.New("visitors aren't enabled")
+	ErrVisitorNotFound      = errors.New("visitor not found")
+	ErrVisitorPoolExhausted = errors.New("no visitor credentials are available")
+)
+
+// Visitor is a person given a temporary credential from the visitor pool
+type Visitor struct {
+	// ID is the visitor's person id
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Company   string `json:"company,omitempty"`
+	// Sponsor is who is responsible for the visitor
+	Sponsor string `json:"sponsor"`
+	// Host is who the visitor is visiting, if not the sponsor
+	Host string `json:"host,omitempty"`
+	// ValidFrom defaults to now
+	ValidFrom    time.Time `json:"valid_from"`
+	ValidUntil   time.Time `json:"valid_until"`
+	CredentialID int       `json:"credential_id"`
+	SiteCode     int       `json:"site_code"`
+	CardCode     int       `json:"card_code"`
+	Created      time.Time `json:"created"`
+	// Expired is when the visitor's credential was removed, either after ValidUntil or when they were checked out
+	Expired *time.Time `json:"expired,omitempty"`
+}
+
+// VisitorStore holds visitors, persisted to a file, and how their credentials are assigned
+type VisitorStore struct {
+	// SiteCode and FirstCard through LastCard are the pool visitor credentials are assigned from
+	SiteCode  int
+	FirstCard int
+	LastCard  int
+	// GroupID, if set, is the group visitors are added to while their credential is valid
+	GroupID int
+	// Department, if set, is the department of visitors' people
+	Department string
+
+	path string
+
+	mu       sync.RWMutex
+	visitors map[int]*Visitor
+}
+
+// NewVisitorStore returns a VisitorStore persisted to path, loading any existing visitors
+func NewVisitorStore(path string) (*VisitorStore, error) {
+	s := &VisitorStore{path: path, visitors: make(map[int]*Visitor)}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read visitor store: %w", err)
+	}
+
+	var visitors []*Visitor
+	if err = json.Unmarshal(buf, &visitors); err != nil {
+		return nil, fmt.Errorf("could not decode visitor store: %w", err)
+	}
+	for _, v := range visitors {
+		s.visitors[v.ID] = v
+	}
+
+	return s, nil
+}
+
+// save persists s. The caller must hold s.mu
+func (s *VisitorStore) save() error {
+	buf, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode visitor store: %w", err)
+	}
+	if err = os.WriteFile(s.path+".tmp", buf, 0600); err == nil {
+		err = os.Rename(s.path+".tmp", s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write visitor store: %w", err)
+	}
+	return nil
+}
+
+// list returns copies of the visitors in s, sorted by id. The caller must hold s.mu
+func (s *VisitorStore) list() []*Visitor {
+	visitors := make([]*Visitor, 0, len(s.visitors))
+	for _, v := range s.visitors {
+		v2 := *v
+		visitors = append(visitors, &v2)
+	}
+	sort.Slice(visitors, func(i, j int) bool { return visitors[i].ID < visitors[j].ID })
+	return visitors
+}
+
+// Get returns the visitor with id
+func (s *VisitorStore) Get(id int) (*Visitor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.visitors[id]
+	if !ok {
+		return nil, ErrVisitorNotFound
+	}
+	v2 := *v
+	return &v2, nil
+}
+
+// All returns all visitors, sorted by id
+func (s *VisitorStore) All() []*Visitor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list()
+}
+
+// put adds or replaces v
+func (s *VisitorStore) put(v *Visitor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v2 := *v
+	s.visitors[v.ID] = &v2
+	return s.save()
+}
+
+// assigned returns the card codes held by unexpired visitors
+func (s *VisitorStore) assigned() map[int]bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cards := make(map[int]bool)
+	for _, v := range s.visitors {
+		if v.Expired == nil {
+			cards[v.CardCode] = true
+		}
+	}
+	return cards
+}
+
+func (v *Visitor) validate(now time.Time) error {
+	v.FirstName, v.LastName = strings.TrimSpace(v.FirstName), strings.TrimSpace(v.LastName)
+	v.Sponsor, v.Host = strings.TrimSpace(v.Sponsor), strings.TrimSpace(v.Host)
+	e := new(ValidationError)
+	if v.FirstName == "" {
+		e.add("first_name", "required")
+	}
+	if v.LastName == "" {
+		e.add("last_name", "required")
+	}
+	if v.Sponsor == "" {
+		e.add("sponsor", "required")
+	}
+	if v.ValidFrom.IsZero() {
+		v.ValidFrom = now
+	}
+	if v.ValidUntil.IsZero() {
+		e.add("valid_until", "required")
+	} else if !v.ValidUntil.After(v.ValidFrom) || !v.ValidUntil.After(now) {
+		e.add("valid_until", "must be after valid_from and in the future")
+	}
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// CreateVisitor creates a person for v and assigns them the first free credential in the visitor pool, valid from
+// v.ValidFrom until v.ValidUntil. If the pool is exhausted, the person is deleted and ErrVisitorPoolExhausted is returned
+func (s *Service) CreateVisitor(v *Visitor) (*Visitor, error) {
+	if s.Visitors == nil {
+		return nil, ErrVisitorsDisabled
+	}
+	now := time.Now()
+	if err := v.validate(now); err != nil {
+		return nil, err
+	}
+
+	p := &Person{FirstName: v.FirstName, LastName: v.LastName, Department: s.Visitors.Department}
+	if s.Visitors.GroupID != 0 {
+		p.GroupsToAdd = []int{s.Visitors.GroupID}
+	}
+	id, err := s.CreatePerson(p)
+	if err != nil {
+		return nil, err
+	}
+
+	created := *v
+	created.ID, created.Created, created.Expired = id, now, nil
+	assigned := s.Visitors.assigned()
+	for card := s.Visitors.FirstCard; card <= s.Visitors.LastCard; card++ {
+		if assigned[card] {
+			continue
+		}
+		cred := &Credential{Active: true, SiteCode: s.Visitors.SiteCode, CardCode: card, Activation: &created.ValidFrom, Expiration: &created.ValidUntil}
+		credID, err := s.CreateCredential(id, cred)
+		if errors.Is(err, db.ErrCredentialExists) {
+			continue
+		}
+		if err != nil {
+			s.deleteVisitorPerson(id)
+			return nil, fmt.Errorf("could not create credential: %w", err)
+		}
+		created.CredentialID, created.SiteCode, created.CardCode = credID, cred.SiteCode, cred.CardCode
+		break
+	}
+	if created.CredentialID == 0 {
+		s.deleteVisitorPerson(id)
+		return nil, ErrVisitorPoolExhausted
+	}
+
+	if err = s.Visitors.put(&created); err != nil {
+		return nil, err
+	}
+	s.notify(EventVisitorCreated, &created)
+
+	return &created, nil
+}
+
+// deleteVisitorPerson deletes the person of a visitor that couldn't be created
+func (s *Service) deleteVisitorPerson(id int) {
+	if err := s.DeletePerson(id); err != nil {
+		s.logger().Warn("could not delete person of failed visitor", "id", id, "error", err)
+	}
+}
+
+// ExpireVisitor removes a visitor's credential, returning it to the pool, and removes them from the visitor group.
+// It's a no-op for visitors that have already expired
+func (s *Service) ExpireVisitor(id int) (*Visitor, error) {
+	if s.Visitors == nil {
+		return nil, ErrVisitorsDisabled
+	}
+	v, err := s.Visitors.Get(id)
+	if err != nil || v.Expired != nil {
+		return v, err
+	}
+
+	// the credential may have already been deleted
+	if err = s.DeleteCredential(id, v.CredentialID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("could not delete credential: %w", err)
+	}
+	if s.Visitors.GroupID != 0 {
+		if err = s.RemovePersonGroup(id, s.Visitors.GroupID); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	v.Expired = &now
+	if err = s.Visitors.put(v); err != nil {
+		return nil, err
+	}
+	s.notify(EventVisitorExpired, v)
+
+	return v, nil
+}
+
+// ExpireVisitors expires every visitor whose ValidUntil has passed with ExpireVisitor.
+// Errors for individual visitors are collected in the result and retried on the next call
+func (s *Service) ExpireVisitors(ctx context.Context) (*VisitorExpiration, error) {
+	if s.Visitors == nil {
+		return nil, ErrVisitorsDisabled
+	}
+	s = s.WithContext(ctx)
+
+	now := time.Now()
+	res := &VisitorExpiration{Expired: make([]int, 0)}
+	for _, v := range s.Visitors.All() {
+		if v.Expired != nil || v.ValidUntil.After(now) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := s.ExpireVisitor(v.ID); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("visitor %d: %v", v.ID, err))
+			continue
+		}
+		res.Expired = append(res.Expired, v.ID)
+	}
+
+	return res, nil
+}
+
+// VisitorExpiration is the result of ExpireVisitors
+type VisitorExpiration struct {
+	Expired []int    `json:"expired"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// WatchVisitors runs ExpireVisitors now and then every interval until stop is called
+func (s *Service) WatchVisitors(interval time.Duration) (stop func()) {
+	if s.Visitors == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultVisitorCheckInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if res, err := s.ExpireVisitors(ctx); err != nil {
+				if ctx.Err() == nil {
+					s.logger().Warn("could not expire visitors", "error", err)
+				}
+			} else {
+				if len(res.Expired) > 0 {
+					s.logger().Info("expired visitors", "visitors", res.Expired)
+				}
+				for _, e := range res.Errors {
+					s.logger().Warn("could not expire visitor", "error", e)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(cancel) }
+}
+
+// visitorsEnabled returns an *HTTPError if s.Visitors isn't set
+func (s *Service) visitorsEnabled() error {
+	if s.Visitors == nil {
+		return &HTTPError{StatusCode: http.StatusNotFound, Err: ErrVisitorsDisabled}
+	}
+	return nil
+}
+
+// CreateVisitorHandler creates a visitor with a credential from the visitor pool
+func (s *Service) CreateVisitorHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	if err := s.visitorsEnabled(); err != nil {
+		return nil, err
+	}
+	v := new(Visitor)
+	if err := readJSON(r, v); err != nil {
+		return nil, err
+	}
+
+	created, err := s.CreateVisitor(v)
+	if err != nil {
+		if v := validationError(err); v != nil {
+			return nil, v
+		}
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrVisitorPoolExhausted) {
+			code = http.StatusConflict
+		}
+		return nil, &HTTPError{StatusCode: code, Err: fmt.Errorf("could not create visitor: %w", err)}
+	}
+
+	s.audit(r, EventVisitorCreated, created.ID, nil, created)
+
+	return newCreated(r, fmt.Sprintf("/visitors/%d", created.ID), created), nil
+}
+
+// ListVisitorsHandler returns all visitors, or only unexpired ones if active is true
+func (s *Service) ListVisitorsHandler(r *http.Request) (interface{}, error) {
+	if err := s.visitorsEnabled(); err != nil {
+		return nil, err
+	}
+	active, err := readBoolQuery(r, "active")
+	if err != nil {
+		return nil, err
+	}
+
+	visitors := make([]*Visitor, 0)
+	for _, v := range s.Visitors.All() {
+		if !active || v.Expired == nil {
+			visitors = append(visitors, v)
+		}
+	}
+	return visitors, nil
+}
+
+// ReadVisitorHandler returns a visitor
+func (s *Service) ReadVisitorHandler(r *http.Request) (interface{}, error) {
+	if err := s.visitorsEnabled(); err != nil {
+		return nil, err
+	}
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := s.Visitors.Get(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: err}
+	}
+	return v, nil
+}
+
+// CheckOutVisitorHandler expires a visitor before their ValidUntil
+func (s *Service) CheckOutVisitorHandler(r *http.Request) (interface{}, error) {
+	s = s.WithContext(r.Context())
+	if err := s.visitorsEnabled(); err != nil {
+		return nil, err
+	}
+	id, err := readIntVar(r, "id", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := s.Visitors.Get(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusNotFound, Err: err}
+	}
+
+	v, err := s.ExpireVisitor(id)
+	if err != nil {
+		return nil, &HTTPError{StatusCode: http.StatusInternalServerError, Err: fmt.Errorf("could not check out visitor: %w", err)}
+	}
+
+	s.audit(r, EventVisitorExpired, id, before, v)
+
+	return v, nil
+}
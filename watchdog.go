@@ -0,0 +1,127 @@
+package infinias
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrWatchdogTripped = errors.New("watchdog tripped")
+
+// WatchdogCheck is a health check run by a Watchdog. Check should only return an error if the component is
+// unresponsive, not if a remote service is down, since restarting won't fix that
+type WatchdogCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// WatchdogStatus is the state of a Watchdog
+type WatchdogStatus struct {
+	// Failures is the total number of failed checks
+	Failures uint64 `json:"failures"`
+	// Restarts is the number of times the watchdog restarted the server
+	Restarts    uint64    `json:"restarts"`
+	LastFailure string    `json:"last_failure,omitempty"`
+	LastCheck   time.Time `json:"last_check"`
+}
+
+// Watchdog periodically runs checks and reports when one fails MaxFailures times in a row.
+// A check fails if it returns an error or doesn't return within Timeout
+type Watchdog struct {
+	Interval    time.Duration
+	Timeout     time.Duration
+	MaxFailures int
+	Log         Logger
+	// Restarts is reported by Status. Watchdogs are recreated on restart, so it's set by the caller
+	Restarts uint64
+
+	checks []WatchdogCheck
+
+	mu          sync.Mutex
+	failures    uint64
+	lastFailure string
+	lastCheck   time.Time
+}
+
+// NewWatchdog returns a new Watchdog
+func NewWatchdog(interval, timeout time.Duration, maxFailures int, logger Logger, checks ...WatchdogCheck) *Watchdog {
+	return &Watchdog{Interval: interval, Timeout: timeout, MaxFailures: maxFailures, Log: logger, checks: checks}
+}
+
+func (w *Watchdog) logger() Logger {
+	if w.Log == nil {
+		return NopLogger
+	}
+	return w.Log
+}
+
+// check runs c, returning an error if it fails or times out. A check that ignores ctx is abandoned after Timeout
+func (w *Watchdog) check(ctx context.Context, c WatchdogCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Check(ctx)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("no response after %v", w.Timeout)
+	}
+}
+
+// Run runs the checks every Interval until ctx is canceled. It returns an error wrapping ErrWatchdogTripped
+// when a check fails MaxFailures times in a row
+func (w *Watchdog) Run(ctx context.Context) error {
+	consecutive := make(map[string]int)
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+
+		for _, c := range w.checks {
+			err := w.check(ctx, c)
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			w.mu.Lock()
+			w.lastCheck = time.Now()
+			if err != nil {
+				w.failures++
+				w.lastFailure = c.Name
+			}
+			w.mu.Unlock()
+
+			if err == nil {
+				consecutive[c.Name] = 0
+				continue
+			}
+
+			consecutive[c.Name]++
+			w.logger().Warn("watchdog check failed", "check", c.Name, "error", err, "consecutive", consecutive[c.Name])
+			if consecutive[c.Name] >= w.MaxFailures {
+				return fmt.Errorf("%w: %s check failed %d times: %v", ErrWatchdogTripped, c.Name, consecutive[c.Name], err)
+			}
+		}
+	}
+}
+
+// Status returns the state of w, or nil if w is nil
+func (w *Watchdog) Status() *WatchdogStatus {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return &WatchdogStatus{Failures: w.failures, Restarts: w.Restarts, LastFailure: w.lastFailure, LastCheck: w.lastCheck}
+}
@@ -0,0 +1,294 @@
+package infinias
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/korylprince/go-infinias-api/notify"
+)
+
+const (
+	EventPersonCreated     = "person.created"
+	EventPersonUpdated     = "person.updated"
+	EventPersonDeleted     = "person.deleted"
+	EventPersonDeactivated = "person.deactivated"
+	EventPersonMerged      = "person.merged"
+	EventCredentialCreated = "credential.created"
+	EventCredentialDeleted = "credential.deleted"
+	EventCredentialExpired = "credential.expired"
+	EventPictureUpdated    = "picture.updated"
+
+	EventGroupMembershipAdded   = "group_membership.added"
+	EventGroupMembershipRemoved = "group_membership.removed"
+
+	EventGroupCreated = "group.created"
+	EventGroupUpdated = "group.updated"
+	EventGroupDeleted = "group.deleted"
+
+	EventDepartmentRenamed = "department.renamed"
+
+	EventDoorLocked   = "door.locked"
+	EventDoorUnlocked = "door.unlocked"
+	EventDoorPulsed   = "door.pulsed"
+
+	EventKeyCreated = "key.created"
+	EventKeyRotated = "key.rotated"
+	EventKeyRevoked = "key.revoked"
+
+	EventVisitorCreated = "visitor.created"
+	EventVisitorExpired = "visitor.expired"
+
+	// EventAccessEvent is sent for each access event read by EventStream. It's only sent to targets that list it
+	EventAccessEvent = "access.event"
+)
+
+const (
+	DefaultWebhookMaxAttempts = 5
+	DefaultWebhookBackoff     = 2 * time.Second
+	DefaultWebhookLogSize     = 500
+	webhookSignatureHeader    = "X-Webhook-Signature"
+	webhookEventHeader        = "X-Webhook-Event"
+	webhookIDHeader           = "X-Webhook-ID"
+	// webhookTimestampSignatureHeader holds a signature of the send time and body, e.g. t=1700000000,v1=<hex>, so
+	// receivers can reject requests replayed outside a window. See client.VerifyWebhook
+	webhookTimestampSignatureHeader = "X-Signature"
+)
+
+// WebhookTarget is a URL that receives notifications. If Events is empty, all events except EventAccessEvent are sent
+type WebhookTarget struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+func (t *WebhookTarget) wants(typ string) bool {
+	if len(t.Events) == 0 {
+		return typ != EventAccessEvent
+	}
+	for _, e := range t.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the JSON body sent to webhook targets
+type WebhookEvent struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// WebhookDelivery is a record of a single delivery attempt
+type WebhookDelivery struct {
+	EventID    string        `json:"event_id"`
+	EventType  string        `json:"event_type"`
+	URL        string        `json:"url"`
+	Attempt    int           `json:"attempt"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+}
+
+type credentialEvent struct {
+	PersonID   int         `json:"person_id"`
+	Credential *Credential `json:"credential"`
+}
+
+type pictureEvent struct {
+	PersonID int `json:"person_id"`
+}
+
+type groupMembershipEvent struct {
+	PersonID int `json:"person_id"`
+	GroupID  int `json:"group_id"`
+}
+
+type personDeletedEvent struct {
+	ID int `json:"id"`
+}
+
+func newPersonEvent(p *Person) *Person {
+	e := *p
+	e.HasImage = p.HasImage || len(p.Image) != 0
+	e.Image = nil
+	e.GroupsToAdd = nil
+	return &e
+}
+
+// Webhooks sends signed event notifications to configured targets
+type Webhooks struct {
+	Targets     []*WebhookTarget
+	Client      *http.Client
+	MaxAttempts int
+	Backoff     time.Duration
+	LogSize     int
+	Log         Logger
+	// Alerts, if set, is sent AlertRetryExhausted when a delivery fails every attempt
+	Alerts *Alerts
+
+	mu         sync.Mutex
+	deliveries []*WebhookDelivery
+}
+
+// NewWebhooks returns a new Webhooks with default retry settings
+func NewWebhooks(targets []*WebhookTarget, log Logger) *Webhooks {
+	if log == nil {
+		log = NopLogger
+	}
+	return &Webhooks{
+		Targets:     targets,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+		MaxAttempts: DefaultWebhookMaxAttempts,
+		Backoff:     DefaultWebhookBackoff,
+		LogSize:     DefaultWebhookLogSize,
+		Log:         log,
+	}
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Send asynchronously delivers an event of the given type to all interested targets
+func (w *Webhooks) Send(typ string, data interface{}) {
+	event := &WebhookEvent{ID: newID(), Type: typ, Time: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.Log.Error("could not encode webhook event", "event_type", typ, "error", err)
+		return
+	}
+
+	for _, t := range w.Targets {
+		if !t.wants(typ) {
+			continue
+		}
+		go w.deliver(t, event, body)
+	}
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signTimestamp returns the X-Signature header for body sent at t: the HMAC-SHA256 of the unix time, a period, and body
+func signTimestamp(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhooks) post(t *WebhookTarget, event *WebhookEvent, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event.Type)
+	req.Header.Set(webhookIDHeader, event.ID)
+	if t.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, sign(t.Secret, body))
+		// signed for each attempt, so retries aren't rejected as replays
+		req.Header.Set(webhookTimestampSignatureHeader, signTimestamp(t.Secret, time.Now(), body))
+	}
+
+	r, err := w.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not POST event: %w", err)
+	}
+	r.Body.Close()
+
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return r.StatusCode, fmt.Errorf("unexpected status: %s", r.Status)
+	}
+
+	return r.StatusCode, nil
+}
+
+func (w *Webhooks) deliver(t *WebhookTarget, event *WebhookEvent, body []byte) {
+	backoff := w.Backoff
+	for attempt := 1; attempt <= w.MaxAttempts; attempt++ {
+		start := time.Now()
+		code, err := w.post(t, event, body)
+		d := &WebhookDelivery{
+			EventID:    event.ID,
+			EventType:  event.Type,
+			URL:        t.URL,
+			Attempt:    attempt,
+			StatusCode: code,
+			Time:       start.UTC(),
+			Duration:   time.Since(start),
+		}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		w.record(d)
+
+		if err == nil {
+			return
+		}
+
+		w.Log.Warn("webhook delivery failed", "event_id", event.ID, "event_type", event.Type, "url", t.URL, "attempt", attempt, "max_attempts", w.MaxAttempts, "error", err)
+
+		if attempt < w.MaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+
+		w.Alerts.Send(AlertRetryExhausted, &notify.Message{
+			Title:  "Webhook delivery failed",
+			Text:   fmt.Sprintf("Delivery of %s to %s failed after %d attempts: %v", event.Type, t.URL, w.MaxAttempts, err),
+			Fields: map[string]string{"event_id": event.ID, "event_type": event.Type, "url": t.URL},
+		})
+	}
+}
+
+func (w *Webhooks) record(d *WebhookDelivery) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.deliveries = append(w.deliveries, d)
+	if w.LogSize > 0 && len(w.deliveries) > w.LogSize {
+		w.deliveries = w.deliveries[len(w.deliveries)-w.LogSize:]
+	}
+}
+
+// Deliveries returns the most recent delivery attempts, newest first
+func (w *Webhooks) Deliveries() []*WebhookDelivery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	deliveries := make([]*WebhookDelivery, len(w.deliveries))
+	for idx, d := range w.deliveries {
+		deliveries[len(w.deliveries)-1-idx] = d
+	}
+	return deliveries
+}
+
+func (s *Service) notify(typ string, data interface{}) {
+	s.ListCache.Invalidate()
+	s.Publisher.Send(typ, data)
+	if s.Webhooks == nil {
+		return
+	}
+	s.Webhooks.Send(typ, data)
+}